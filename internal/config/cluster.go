@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// DefaultCluster 默认集群名，使用NOMAD_HTTP_API_ADDR和CONSUL_ADDR
+const DefaultCluster = "default"
+
+// Cluster 集群配置，每个集群有独立的nomad和consul
+type Cluster struct {
+	Name           string `json:"name"`
+	NomadNamespace string `json:"nomad_namespace"`
+	NomadRegion    string `json:"nomad_region"`
+	Datacenter     string `json:"datacenter"`
+	Err            error  `json:"-"` // 初始化失败原因，失败的集群不会被使用
+	nomad          *NomadClient
+	consul         *ConsulClient
+}
+
+var (
+	clusters     = map[string]*Cluster{}
+	clustersLock sync.RWMutex
+)
+
+// Nomad 集群的nomad客户端，默认集群返回全局的NomadCli
+func (c *Cluster) Nomad() *nomadapi.Client {
+	if c.Name == DefaultCluster {
+		return NomadCli
+	}
+	if c.nomad == nil {
+		return nil
+	}
+	return c.nomad.GetClient()
+}
+
+// Consul 集群的consul客户端，默认集群返回全局的ConsulCli
+func (c *Cluster) Consul() *consulapi.Client {
+	if c.Name == DefaultCluster {
+		return ConsulCli
+	}
+	if c.consul == nil {
+		return nil
+	}
+	return c.consul.GetClient()
+}
+
+// clusterEnv 读取集群配置，如CLUSTER_SEA_NOMAD_ADDR
+func clusterEnv(name, key string) string {
+	return strings.TrimSpace(os.Getenv(fmt.Sprintf("CLUSTER_%s_%s", strings.ToUpper(name), key)))
+}
+
+func splitAddresses(s string) []string {
+	var addresses []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses
+}
+
+// InitClusters 初始化集群配置，需要在InitNomad和InitConsul之后执行
+// CLUSTERS为逗号分隔的集群名，每个集群通过CLUSTER_<NAME>_*配置地址、token、namespace和region
+// 其他集群连接失败只记录错误，不影响启动
+func InitClusters() {
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+	clusters = map[string]*Cluster{
+		DefaultCluster: {Name: DefaultCluster, Datacenter: os.Getenv("CONSUL_DATACENTER")},
+	}
+	retryInterval, _ := strconv.Atoi(os.Getenv("NOMAD_RETRY_INTERVAL"))
+	if retryInterval <= 0 {
+		retryInterval = 5
+	}
+	maxRetries, _ := strconv.Atoi(os.Getenv("NOMAD_MAX_RETRIES"))
+	if maxRetries <= 0 {
+		maxRetries = 10
+	}
+	for _, name := range strings.Split(os.Getenv("CLUSTERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == DefaultCluster {
+			continue
+		}
+		c := &Cluster{
+			Name:           name,
+			NomadNamespace: clusterEnv(name, "NOMAD_NAMESPACE"),
+			NomadRegion:    clusterEnv(name, "NOMAD_REGION"),
+			Datacenter:     clusterEnv(name, "CONSUL_DATACENTER"),
+		}
+		clusters[name] = c
+		nomadClient, err := NewNomadClientWithOptions(splitAddresses(clusterEnv(name, "NOMAD_ADDR")), c.NomadNamespace, c.NomadRegion,
+			time.Duration(retryInterval)*time.Second, maxRetries)
+		if err != nil {
+			c.Err = fmt.Errorf("nomad: %v", err)
+			slog.Error("failed to init cluster nomad", "cluster", name, "error", err)
+			continue
+		}
+		consulClient, err := NewConsulClientWithConfig(splitAddresses(clusterEnv(name, "CONSUL_ADDR")), &consulapi.Config{
+			Token:      clusterEnv(name, "CONSUL_TOKEN"),
+			Scheme:     clusterEnv(name, "CONSUL_SCHEME"),
+			Datacenter: c.Datacenter,
+		}, time.Duration(retryInterval)*time.Second, maxRetries)
+		if err != nil {
+			nomadClient.Close()
+			c.Err = fmt.Errorf("consul: %v", err)
+			slog.Error("failed to init cluster consul", "cluster", name, "error", err)
+			continue
+		}
+		c.nomad = nomadClient
+		c.consul = consulClient
+		slog.Info("cluster initialized", "cluster", name, "nomad", nomadClient.GetCurrentAddress(), "consul", consulClient.GetCurrentAddress())
+	}
+}
+
+// GetCluster 按名称获取集群，名称为空时返回默认集群
+func GetCluster(name string) (*Cluster, error) {
+	if name == "" {
+		name = DefaultCluster
+	}
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	c, ok := clusters[name]
+	if !ok {
+		if name == DefaultCluster {
+			// 未执行InitClusters时默认集群仍然可用
+			return &Cluster{Name: DefaultCluster}, nil
+		}
+		return nil, fmt.Errorf("cluster %s not found", name)
+	}
+	if c.Err != nil {
+		return nil, fmt.Errorf("cluster %s is unavailable: %v", name, c.Err)
+	}
+	return c, nil
+}
+
+// Clusters 所有集群，包括初始化失败的集群，按名称排序，默认集群在最前
+func Clusters() []*Cluster {
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	list := make([]*Cluster, 0, len(clusters))
+	for _, c := range clusters {
+		list = append(list, c)
+	}
+	if len(list) == 0 {
+		list = append(list, &Cluster{Name: DefaultCluster})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name == DefaultCluster || list[j].Name == DefaultCluster {
+			return list[i].Name == DefaultCluster
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// HasCluster 集群是否已配置，空名称表示默认集群
+func HasCluster(name string) bool {
+	if name == "" || name == DefaultCluster {
+		return true
+	}
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	_, ok := clusters[name]
+	return ok
+}
@@ -34,6 +34,13 @@ type ConsulClient struct {
 
 // NewConsulClient new client with multiple addresses support
 func NewConsulClient(addresses []string, retryInterval time.Duration, maxRetries int) (*ConsulClient, error) {
+	return NewConsulClientWithConfig(addresses, &consulapi.Config{
+		Token: os.Getenv("CONSUL_TOKEN"),
+	}, retryInterval, maxRetries)
+}
+
+// NewConsulClientWithConfig 使用指定的token、scheme和datacenter构建consul客户端
+func NewConsulClientWithConfig(addresses []string, cfg *consulapi.Config, retryInterval time.Duration, maxRetries int) (*ConsulClient, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := &ConsulClient{
 		addresses:     addresses,
@@ -42,9 +49,7 @@ func NewConsulClient(addresses []string, retryInterval time.Duration, maxRetries
 		maxRetries:    maxRetries,
 		ctx:           ctx,
 		cancelFunc:    cancel,
-		config: &consulapi.Config{
-			Token: os.Getenv("CONSUL_TOKEN"),
-		},
+		config:        cfg,
 	}
 	if err := c.connect(); err != nil {
 		return nil, fmt.Errorf("connect consul client error: %v", err)
@@ -26,6 +26,8 @@ var (
 
 type NomadClient struct {
 	addresses        []string
+	namespace        string // nomad namespace，为空时使用default
+	region           string
 	currentIndex     int
 	maxRetries       int
 	retryInterval    time.Duration
@@ -38,9 +40,16 @@ type NomadClient struct {
 
 // NewNomadClient 构建nomad客户端
 func NewNomadClient(addresses []string, retryInterval time.Duration, maxRetries int) (*NomadClient, error) {
+	return NewNomadClientWithOptions(addresses, "", "", retryInterval, maxRetries)
+}
+
+// NewNomadClientWithOptions 构建指定namespace和region的nomad客户端
+func NewNomadClientWithOptions(addresses []string, namespace, region string, retryInterval time.Duration, maxRetries int) (*NomadClient, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	n := &NomadClient{
 		addresses:     addresses,
+		namespace:     namespace,
+		region:        region,
 		currentIndex:  0,
 		maxRetries:    maxRetries,
 		retryInterval: retryInterval,
@@ -71,7 +80,9 @@ func (n *NomadClient) connect() error {
 		slog.Debug("Trying to connect to Nomad node", "address", address, "attempt", i+1, "total", numAddresses)
 
 		config := &nomadapi.Config{
-			Address: address,
+			Address:   address,
+			Namespace: n.namespace,
+			Region:    n.region,
 		}
 		client, err := nomadapi.NewClient(config)
 		if err != nil {
@@ -0,0 +1,86 @@
+package boardhandler
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/tools/pkg"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// ClusterHealth 单个集群的连接状态和统计
+type ClusterHealth struct {
+	Name           string            `json:"name"`
+	NomadNamespace string            `json:"nomad_namespace"`
+	NomadRegion    string            `json:"nomad_region"`
+	Datacenter     string            `json:"datacenter"`
+	NomadLeader    string            `json:"nomad_leader"`
+	ConsulLeader   string            `json:"consul_leader"`
+	Healthy        bool              `json:"healthy"`
+	Error          string            `json:"error,omitempty"`
+	GameServers    int64             `json:"game_servers"`
+	Stats          NomadClusterStats `json:"stats"`
+}
+
+// Handler_ClusterHealth 展示每个集群的nomad、consul连接状态和节点、任务统计
+func Handler_ClusterHealth(c fiber.Ctx) error {
+	counts := gameServerCountByCluster()
+	items := make([]ClusterHealth, 0)
+	for _, cluster := range config.Clusters() {
+		h := ClusterHealth{
+			Name:           cluster.Name,
+			NomadNamespace: cluster.NomadNamespace,
+			NomadRegion:    cluster.NomadRegion,
+			Datacenter:     cluster.Datacenter,
+			GameServers:    counts[cluster.Name],
+		}
+		if cluster.Err != nil {
+			h.Error = cluster.Err.Error()
+			items = append(items, h)
+			continue
+		}
+		nomadCli, consulCli := cluster.Nomad(), cluster.Consul()
+		if nomadCli == nil || consulCli == nil {
+			h.Error = "client is not initialized"
+			items = append(items, h)
+			continue
+		}
+		leader, err := nomadCli.Status().Leader()
+		if err != nil {
+			h.Error = "nomad: " + err.Error()
+			items = append(items, h)
+			continue
+		}
+		h.NomadLeader = leader
+		if leader, err = consulCli.Status().Leader(); err != nil {
+			h.Error = "consul: " + err.Error()
+			items = append(items, h)
+			continue
+		}
+		h.ConsulLeader = leader
+		h.Healthy = true
+		h.Stats = getRealClusterStats(nomadCli)
+		items = append(items, h)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": items,
+	})
+}
+
+// gameServerCountByCluster 按所属集群统计游戏服数量
+func gameServerCountByCluster() map[string]int64 {
+	var rows []struct {
+		ServerID       string
+		Cluster        string
+		ChannelCluster string
+	}
+	config.DB.Table("games as g").
+		Select("g.server_id, g.cluster, c.cluster as channel_cluster").
+		Joins("LEFT JOIN channels c ON c.id = g.channel_id").
+		Where("g.deleted_at IS NULL").
+		Scan(&rows)
+	counts := make(map[string]int64)
+	for _, row := range rows {
+		counts[pkg.ResolveCluster(row.Cluster, row.ChannelCluster)]++
+	}
+	return counts
+}
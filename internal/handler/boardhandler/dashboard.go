@@ -94,9 +94,17 @@ type RecentActivity struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// Handler_DashboardStats 综合统计，集群统计按cluster参数选择集群
 func Handler_DashboardStats(c fiber.Ctx) error {
 	// 获取图表类型参数
 	chartType := c.Query("chartType", "pie")
+	cluster, err := config.GetCluster(c.Query("cluster"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  1,
+			"message": err.Error(),
+		})
+	}
 
 	var chartData map[string]interface{}
 
@@ -126,7 +134,7 @@ func Handler_DashboardStats(c fiber.Ctx) error {
 		}
 	case "line":
 		// 折线图数据 - 集群统计
-		clusterStats := getRealClusterStats()
+		clusterStats := getRealClusterStats(cluster.Nomad())
 		chartData = map[string]interface{}{
 			"xAxis": map[string]interface{}{
 				"type": "category",
@@ -331,7 +339,7 @@ func Handler_DashboardStats(c fiber.Ctx) error {
 		var stats DashboardStats
 		stats.ResourceStats = getResourceStats()
 		stats.TaskStats = getTaskStats()
-		stats.ClusterStats = getRealClusterStats()
+		stats.ClusterStats = getRealClusterStats(cluster.Nomad())
 		stats.RecentActivities = getRecentActivities()
 
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
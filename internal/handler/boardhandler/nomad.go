@@ -1,8 +1,6 @@
 package boardhandler
 
 import (
-	"saurfang/internal/config"
-
 	nomadapi "github.com/hashicorp/nomad/api"
 )
 
@@ -17,11 +15,11 @@ type NomadClusterStats struct {
 }
 
 // getRealClusterStats 获取真实的Nomad集群统计
-func getRealClusterStats() NomadClusterStats {
+func getRealClusterStats(client *nomadapi.Client) NomadClusterStats {
 	var stats NomadClusterStats
 
 	// 创建Nomad客户端
-	if config.NomadCli == nil {
+	if client == nil {
 		return NomadClusterStats{
 			TotalNodes:   0,
 			OnlineNodes:  0,
@@ -33,7 +31,7 @@ func getRealClusterStats() NomadClusterStats {
 	}
 
 	// 获取节点列表
-	nodes, _, err := config.NomadCli.Nodes().List(&nomadapi.QueryOptions{})
+	nodes, _, err := client.Nodes().List(&nomadapi.QueryOptions{})
 	if err == nil {
 		stats.TotalNodes = int64(len(nodes))
 		for _, node := range nodes {
@@ -46,7 +44,7 @@ func getRealClusterStats() NomadClusterStats {
 	}
 
 	// 获取任务列表
-	jobs, _, err := config.NomadCli.Jobs().List(&nomadapi.QueryOptions{})
+	jobs, _, err := client.Jobs().List(&nomadapi.QueryOptions{})
 	if err == nil {
 		stats.TotalJobs = int64(len(jobs))
 		for _, job := range jobs {
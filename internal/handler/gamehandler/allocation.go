@@ -0,0 +1,157 @@
+package gamehandler
+
+import (
+	"errors"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gamehost"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/placement"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// AllocationHandler 服务器ID分配规则和主机端口登记
+type AllocationHandler struct {
+	base.BaseGormRepository[gameserver.ServerIDRule]
+}
+
+func NewAllocationHandler() *AllocationHandler {
+	return &AllocationHandler{
+		BaseGormRepository: base.BaseGormRepository[gameserver.ServerIDRule]{DB: config.DB},
+	}
+}
+
+// Handler_CreateServerIDRule 创建渠道的服务器ID分配规则
+func (a *AllocationHandler) Handler_CreateServerIDRule(c fiber.Ctx) error {
+	var rule gameserver.ServerIDRule
+	if err := c.Bind().Body(&rule); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if rule.ChannelID == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "channel_id is required", "", fiber.Map{})
+	}
+	if err := a.Create(&rule); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create server id rule", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_UpdateServerIDRule 更新服务器ID分配规则 "/idrule/update/:id"
+func (a *AllocationHandler) Handler_UpdateServerIDRule(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var rule gameserver.ServerIDRule
+	if err := c.Bind().Body(&rule); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	rule.ID = uint(id)
+	if err := a.Update(rule.ID, &rule); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update server id rule", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_DeleteServerIDRule 删除服务器ID分配规则 "/idrule/delete/:id"
+func (a *AllocationHandler) Handler_DeleteServerIDRule(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	if err := a.Delete(uint(id)); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete server id rule", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListServerIDRule 展示服务器ID分配规则
+func (a *AllocationHandler) Handler_ListServerIDRule(c fiber.Ctx) error {
+	rules, err := a.List()
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list server id rule", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": rules,
+	})
+}
+
+// Handler_NextServerID 预览渠道下一个可用的服务器ID "/idrule/:channel_id/next"
+func (a *AllocationHandler) Handler_NextServerID(c fiber.Ctx) error {
+	channelID, _ := strconv.Atoi(c.Params("channel_id"))
+	serverID, err := pkg.AllocateServerID(uint(channelID))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to allocate server id", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"server_id": serverID,
+	})
+}
+
+// Handler_ListPortReservation 展示端口登记 "?host_id=1&server_id=10001"
+func (a *AllocationHandler) Handler_ListPortReservation(c fiber.Ctx) error {
+	query := a.DB.Model(&gamehost.PortReservation{}).Preload("Host")
+	if hostID := c.Query("host_id"); hostID != "" {
+		query = query.Where("host_id = ?", hostID)
+	}
+	if serverID := c.Query("server_id"); serverID != "" {
+		query = query.Where("server_id = ?", serverID)
+	}
+	var reservations []gamehost.PortReservation
+	if err := query.Order("host_id, start_port").Find(&reservations).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list port reservation", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": reservations,
+	})
+}
+
+// Handler_ReservePorts 为游戏服预留主机端口范围
+func (a *AllocationHandler) Handler_ReservePorts(c fiber.Ctx) error {
+	var reservation gamehost.PortReservation
+	if err := c.Bind().Body(&reservation); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if err := pkg.ReservePorts(&reservation); err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, pkg.ErrPortConflict) {
+			status = fiber.StatusConflict
+		}
+		return pkg.NewAppResponse(c, status, 1, "failed to reserve ports", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", reservation)
+}
+
+// Handler_ReleasePorts 释放端口登记 "/ports/release/:id"
+func (a *AllocationHandler) Handler_ReleasePorts(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	if err := a.DB.Delete(&gamehost.PortReservation{}, id).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to release ports", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_CheckPorts 检查主机端口范围是否可用 "?host_id=1&server_id=10001&start=7001&end=7010"
+func (a *AllocationHandler) Handler_CheckPorts(c fiber.Ctx) error {
+	hostID, _ := strconv.Atoi(c.Query("host_id"))
+	start, _ := strconv.Atoi(c.Query("start"))
+	end, _ := strconv.Atoi(c.Query("end", c.Query("start")))
+	conflicts, err := pkg.FindPortConflicts([]uint{uint(hostID)}, c.Query("server_id"), start, end)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to check ports", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"available": len(conflicts) == 0,
+		"conflicts": conflicts,
+	})
+}
+
+// Handler_PlanPlacement 为新游戏服推荐主机，传入setting时返回注入constraint后的配置
+func (a *AllocationHandler) Handler_PlanPlacement(c fiber.Ctx) error {
+	var req placement.PlacementRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	result, err := pkg.PlanPlacement(&req)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to plan placement", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", result)
+}
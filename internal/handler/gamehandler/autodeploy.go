@@ -0,0 +1,113 @@
+package gamehandler
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/models/autodeploy"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/task"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type AutoDeployHandler struct {
+	base.BaseGormRepository[autodeploy.AutoDeployRecord]
+}
+
+func NewAutoDeployHandler() *AutoDeployHandler {
+	return &AutoDeployHandler{
+		BaseGormRepository: base.BaseGormRepository[autodeploy.AutoDeployRecord]{DB: config.DB},
+	}
+}
+
+// Handler_ListAutoDeployRecords 分页展示自动发布记录
+func (a *AutoDeployHandler) Handler_ListAutoDeployRecords(c fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.Query("perPage", "10"))
+	if err != nil {
+		pageSize = 10
+	}
+	query := a.DB.Model(&autodeploy.AutoDeployRecord{})
+	if serverID := c.Query("server_id"); serverID != "" {
+		query = query.Where("server_id = ?", serverID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to count auto deploy records", err.Error(), fiber.Map{})
+	}
+	var records []autodeploy.AutoDeployRecord
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&records).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list auto deploy records", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": records,
+		"total": total,
+	})
+}
+
+// Handler_SetAutoDeploy 开启或关闭游戏服的自动发布 "?enabled=true"
+func (a *AutoDeployHandler) Handler_SetAutoDeploy(c fiber.Ctx) error {
+	serverID := c.Params("server_id")
+	enabled := c.Query("enabled") == "true"
+	res := a.DB.Model(&gameserver.Games{}).Where("server_id = ?", serverID).Update("auto_deploy", enabled)
+	if res.Error != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to set auto deploy", res.Error.Error(), fiber.Map{})
+	}
+	if res.RowsAffected == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "server not found", "", fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_SetCustomTaskAutoDeploy 开启或关闭自定义任务的自动发布 "?enabled=true"
+func (a *AutoDeployHandler) Handler_SetCustomTaskAutoDeploy(c fiber.Ctx) error {
+	enabled := c.Query("enabled") == "true"
+	res := a.DB.Model(&task.CustomTask{}).Where("id = ?", c.Params("task_id")).Update("auto_deploy", enabled)
+	if res.Error != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to set auto deploy", res.Error.Error(), fiber.Map{})
+	}
+	if res.RowsAffected == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "custom task not found", "", fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListSuspensions 展示自动发布的暂停状态
+func (a *AutoDeployHandler) Handler_ListSuspensions(c fiber.Ctx) error {
+	suspensions, err := pkg.ListAutoDeploySuspensions()
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list suspensions", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": suspensions,
+	})
+}
+
+// Handler_SuspendAutoDeploy 暂停自动发布 "?target=global&minutes=30"
+// target为global时全局暂停，否则为服务器ID；minutes为空时不会自动恢复
+func (a *AutoDeployHandler) Handler_SuspendAutoDeploy(c fiber.Ctx) error {
+	target := c.Query("target", autodeploy.SuspendGlobal)
+	minutes, _ := strconv.Atoi(c.Query("minutes", "0"))
+	if err := pkg.SuspendAutoDeploy(target, time.Duration(minutes)*time.Minute); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to suspend auto deploy", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ResumeAutoDeploy 恢复自动发布 "?target=global"
+func (a *AutoDeployHandler) Handler_ResumeAutoDeploy(c fiber.Ctx) error {
+	target := c.Query("target", autodeploy.SuspendGlobal)
+	if err := pkg.ResumeAutoDeploy(target); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to resume auto deploy", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
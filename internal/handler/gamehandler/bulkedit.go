@@ -0,0 +1,183 @@
+package gamehandler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/models/serverconfig"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/ntfy"
+	"saurfang/internal/tools/pkg"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Handler_BulkEditPreview 批量修改配置预览，返回每个游戏服的diff，每次只修改一个集群 "?cluster=xxx"
+func (s *ServerConfigHandler) Handler_BulkEditPreview(c fiber.Ctx) error {
+	var payload serverconfig.BulkEditPayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	h, err := s.clusterHandler(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), fiber.Map{})
+	}
+	results, err := h.buildBulkEdit(&payload)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to preview bulk edit", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": results,
+		"total": len(results),
+	})
+}
+
+// Handler_BulkEditCommit 批量修改配置提交，使用单个consul事务原子写入，每次最多64个游戏服，可选滚动发布 "?cluster=xxx"
+func (s *ServerConfigHandler) Handler_BulkEditCommit(c fiber.Ctx) error {
+	var payload serverconfig.BulkEditPayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	h, err := s.clusterHandler(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), fiber.Map{})
+	}
+	results, err := h.buildBulkEdit(&payload)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to commit bulk edit", err.Error(), fiber.Map{})
+	}
+	// 任意一个游戏服修改失败则全部不提交
+	var pairs []*consulapi.KVPair
+	var changed []string
+	for _, r := range results {
+		if r.Error != "" {
+			return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "bulk edit has errors, nothing committed", fmt.Sprintf("%s: %s", r.ServerID, r.Error), fiber.Map{
+				"items": results,
+			})
+		}
+		if !r.Changed {
+			continue
+		}
+		pairs = append(pairs, &consulapi.KVPair{Key: r.Key, Value: []byte(r.Setting), ModifyIndex: r.ModifyIndex})
+		changed = append(changed, r.ServerID)
+	}
+	if len(pairs) == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusOK, 0, "nothing changed", "", fiber.Map{"items": results})
+	}
+	// 超过单个consul事务的操作数时无法保证原子性
+	if len(pairs) > base.ConsulTxnMaxOps {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "too many servers in one bulk edit", fmt.Sprintf("%d servers changed, at most %d per commit, narrow the selector", len(pairs), base.ConsulTxnMaxOps), fiber.Map{})
+	}
+	if err := pkg.CheckRequestFreeze(c, changed, "bulk edit server config"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	// 提交和滚动发布期间锁定涉及的游戏服，发布结束后释放
+	lease, err := pkg.LockServers(changed, c.Get("X-Request-User"), "bulk edit")
+	if err != nil {
+		return lockErrorResponse(c, err)
+	}
+	if err := h.CASUpdateNomadJobs(pairs); err != nil {
+		lease.Release()
+		return pkg.NewAppResponse(c, fiber.StatusConflict, 1, "failed to commit bulk edit", err.Error(), fiber.Map{})
+	}
+	ntfy.PublishNotification(notify.EventTypeConfigChange, "bulk edit server config", changed, nil, len(changed), 0)
+	if payload.Redeploy.Enabled {
+		go func(serverIDs []string, opt serverconfig.RedeployOption) {
+			defer lease.Release()
+			successJobs, failedJobs := pkg.RollingRedeploy(serverIDs, opt.BatchSize, time.Duration(opt.Interval)*time.Second)
+			ntfy.PublishNotification(notify.EventTypeGameOps, "bulk edit redeploy", successJobs, failedJobs, len(successJobs), len(failedJobs))
+		}(changed, payload.Redeploy)
+	} else {
+		lease.Release()
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items":    results,
+		"changed":  len(changed),
+		"redeploy": payload.Redeploy.Enabled,
+	})
+}
+
+// buildBulkEdit 根据条件选择游戏服并计算修改后的配置
+func (s *ServerConfigHandler) buildBulkEdit(payload *serverconfig.BulkEditPayload) ([]serverconfig.BulkEditResult, error) {
+	sel := payload.Selector
+	if sel.ChannelID == 0 && len(sel.ServerIDs) == 0 && sel.KeyRegex == "" {
+		return nil, errors.New("at least one selector is required")
+	}
+	if payload.Patch == nil && payload.Replace == nil {
+		return nil, errors.New("patch or replace is required")
+	}
+	var keyRe *regexp.Regexp
+	if sel.KeyRegex != "" {
+		re, err := regexp.Compile(sel.KeyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key_regex: %v", err)
+		}
+		keyRe = re
+	}
+	var channelServers []string
+	if sel.ChannelID > 0 {
+		if err := config.DB.Model(&gameserver.Games{}).Where("channel_id = ?", sel.ChannelID).Pluck("server_id", &channelServers).Error; err != nil {
+			return nil, err
+		}
+	}
+	pairs, err := s.ListNomadJobPairs()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]serverconfig.BulkEditResult, 0)
+	for _, pair := range pairs {
+		serverID := tools.RemoveNamespace(pair.Key, s.Ns)
+		if sel.ChannelID > 0 && !slices.Contains(channelServers, serverID) {
+			continue
+		}
+		if len(sel.ServerIDs) > 0 && !slices.Contains(sel.ServerIDs, serverID) {
+			continue
+		}
+		if keyRe != nil && !keyRe.MatchString(serverID) {
+			continue
+		}
+		results = append(results, s.applyBulkEdit(pair, serverID, payload))
+	}
+	return results, nil
+}
+
+// applyBulkEdit 对单个配置应用patch和文本替换
+func (s *ServerConfigHandler) applyBulkEdit(pair *consulapi.KVPair, serverID string, payload *serverconfig.BulkEditPayload) serverconfig.BulkEditResult {
+	result := serverconfig.BulkEditResult{
+		ServerID:    serverID,
+		Key:         pair.Key,
+		ModifyIndex: pair.ModifyIndex,
+	}
+	before := strings.ReplaceAll(string(pair.Value), "\r", "")
+	after, err := tools.PatchJobHCL(before, payload.Patch)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if after, err = tools.ReplaceText(after, payload.Replace); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if after == before {
+		return result
+	}
+	// 修改后的配置必须仍然是合法的nomad job
+	if s.Nomad != nil {
+		if _, err := s.Nomad.Jobs().ParseHCL(after, true); err != nil {
+			result.Error = fmt.Sprintf("patched job is invalid: %v", err)
+			return result
+		}
+	}
+	result.Changed = true
+	result.Setting = after
+	result.Diff = tools.UnifiedDiff(pair.Key, before, after)
+	return result
+}
@@ -2,6 +2,7 @@ package gamehandler
 
 import (
 	"fmt"
+	"saurfang/internal/config"
 	"saurfang/internal/models/amis"
 	"saurfang/internal/models/gamechannel"
 	"saurfang/internal/repository/base"
@@ -21,6 +22,9 @@ func (s *ChannelHandler) Handler_CreateChannel(c fiber.Ctx) error {
 	if err := c.Bind().Body(&channel); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
 	}
+	if !config.HasCluster(channel.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "cluster not found", channel.Cluster, fiber.Map{})
+	}
 	if err := s.Create(&channel); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create channel", err.Error(), fiber.Map{})
 	}
@@ -43,6 +47,9 @@ func (s *ChannelHandler) Handler_UpdateChannel(c fiber.Ctx) error {
 	if err := c.Bind().Body(&channel); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
 	}
+	if !config.HasCluster(channel.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "cluster not found", channel.Cluster, fiber.Map{})
+	}
 	channel.ID = uint(id)
 	if err := s.Update(channel.ID, &channel); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update channel", err.Error(), fiber.Map{})
@@ -0,0 +1,272 @@
+package gamehandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"saurfang/internal/config"
+	"saurfang/internal/models/crossserver"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+// CrossServerHandler 跨服分组
+type CrossServerHandler struct {
+	base.BaseGormRepository[crossserver.CrossSeason]
+}
+
+func NewCrossServerHandler() *CrossServerHandler {
+	return &CrossServerHandler{
+		BaseGormRepository: base.BaseGormRepository[crossserver.CrossSeason]{DB: config.DB},
+	}
+}
+
+// Handler_CreateCrossSeason 创建赛季
+func (h *CrossServerHandler) Handler_CreateCrossSeason(c fiber.Ctx) error {
+	var season crossserver.CrossSeason
+	if err := c.Bind().Body(&season); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	season.ID = 0
+	season.Status = crossserver.StatusDraft
+	season.PublishedAt = nil
+	if err := h.Create(&season); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create cross season", err.Error(), fiber.Map{})
+	}
+	pkg.AuditCrossSeason(season.ID, crossserver.ActionCreate, c.Get("X-Request-User"), season.Name)
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", season)
+}
+
+// Handler_UpdateCrossSeason 更新赛季信息 "/cross/season/update/:id"，已发布的赛季不能修改
+func (h *CrossServerHandler) Handler_UpdateCrossSeason(c fiber.Ctx) error {
+	season, err := h.editableSeason(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to update cross season", err.Error(), fiber.Map{})
+	}
+	var payload crossserver.CrossSeason
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if err := h.DB.Model(season).Updates(map[string]any{
+		"name":         payload.Name,
+		"effective_at": payload.EffectiveAt,
+		"expire_at":    payload.ExpireAt,
+		"comment":      payload.Comment,
+	}).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update cross season", err.Error(), fiber.Map{})
+	}
+	pkg.AuditCrossSeason(season.ID, crossserver.ActionUpdate, c.Get("X-Request-User"),
+		fmt.Sprintf("name=%s effective_at=%s", payload.Name, payload.EffectiveAt.Format(time.RFC3339)))
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_DeleteCrossSeason 删除赛季 "/cross/season/delete/:id"，已发布的赛季不能删除
+func (h *CrossServerHandler) Handler_DeleteCrossSeason(c fiber.Ctx) error {
+	season, err := h.editableSeason(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to delete cross season", err.Error(), fiber.Map{})
+	}
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("season_id = ?", season.ID).Delete(&crossserver.CrossGroup{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(season).Error
+	})
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete cross season", err.Error(), fiber.Map{})
+	}
+	pkg.AuditCrossSeason(season.ID, crossserver.ActionDelete, c.Get("X-Request-User"), season.Name)
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListCrossSeason 展示赛季
+func (h *CrossServerHandler) Handler_ListCrossSeason(c fiber.Ctx) error {
+	var seasons []crossserver.CrossSeason
+	if err := h.DB.Order("effective_at DESC").Find(&seasons).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list cross season", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": seasons,
+	})
+}
+
+// Handler_ShowCrossGroups 展示赛季的分组，返回发布到consul的数据格式 "/cross/season/:id/groups"
+func (h *CrossServerHandler) Handler_ShowCrossGroups(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var season crossserver.CrossSeason
+	if err := h.DB.First(&season, id).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "cross season not found", err.Error(), fiber.Map{})
+	}
+	var rows []crossserver.CrossGroup
+	if err := h.DB.Where("season_id = ?", season.ID).Find(&rows).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list cross groups", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", pkg.BuildCrossMapping(&season, rows))
+}
+
+// Handler_SetCrossGroups 设置赛季的全部分组 "/cross/season/:id/groups"
+func (h *CrossServerHandler) Handler_SetCrossGroups(c fiber.Ctx) error {
+	season, err := h.editableSeason(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to set cross groups", err.Error(), fiber.Map{})
+	}
+	var groups []crossserver.GroupServers
+	if err := c.Bind().Body(&groups); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if err := h.saveGroups(season.ID, groups); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to set cross groups", err.Error(), fiber.Map{})
+	}
+	detail, _ := json.Marshal(groups)
+	pkg.AuditCrossSeason(season.ID, crossserver.ActionGroups, c.Get("X-Request-User"), string(detail))
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_RebalanceCrossGroups 按开服时间和服务器规模重新分组 "/cross/season/:id/rebalance"
+// dry_run为true时只返回分组结果
+func (h *CrossServerHandler) Handler_RebalanceCrossGroups(c fiber.Ctx) error {
+	season, err := h.editableSeason(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to rebalance cross groups", err.Error(), fiber.Map{})
+	}
+	var payload crossserver.RebalancePayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	query := h.DB.Model(&gameserver.Games{})
+	if payload.ChannelID > 0 {
+		query = query.Where("channel_id = ?", payload.ChannelID)
+	}
+	if len(payload.ServerIDs) > 0 {
+		query = query.Where("server_id IN ?", payload.ServerIDs)
+	}
+	var servers []crossserver.ServerInfo
+	if err := query.Select("server_id", "open_at", "population").Scan(&servers).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to query servers", err.Error(), fiber.Map{})
+	}
+	// 没有匹配的服务器时保存会清空全部分组
+	if len(servers) == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "no servers matched", "", fiber.Map{})
+	}
+	groupCount := payload.GroupCount
+	if groupCount <= 0 && payload.GroupSize > 0 {
+		groupCount = (len(servers) + payload.GroupSize - 1) / payload.GroupSize
+	}
+	if groupCount <= 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "group_count or group_size is required", "", fiber.Map{})
+	}
+	groups := pkg.RebalanceCrossGroups(servers, groupCount)
+	if payload.DryRun {
+		return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{"groups": groups})
+	}
+	if err := h.saveGroups(season.ID, groups); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to rebalance cross groups", err.Error(), fiber.Map{})
+	}
+	pkg.AuditCrossSeason(season.ID, crossserver.ActionRebalance, c.Get("X-Request-User"),
+		fmt.Sprintf("%d servers into %d groups", len(servers), len(groups)))
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{"groups": groups})
+}
+
+// Handler_ScheduleCrossSeason 赛季到达生效时间后自动发布 "/cross/season/:id/schedule"
+func (h *CrossServerHandler) Handler_ScheduleCrossSeason(c fiber.Ctx) error {
+	season, err := h.editableSeason(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to schedule cross season", err.Error(), fiber.Map{})
+	}
+	var count int64
+	if err := h.DB.Model(&crossserver.CrossGroup{}).Where("season_id = ?", season.ID).Count(&count).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to schedule cross season", err.Error(), fiber.Map{})
+	}
+	if count == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "season has no groups", "", fiber.Map{})
+	}
+	if err := h.DB.Model(season).Update("status", crossserver.StatusScheduled).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to schedule cross season", err.Error(), fiber.Map{})
+	}
+	pkg.AuditCrossSeason(season.ID, crossserver.ActionSchedule, c.Get("X-Request-User"), season.EffectiveAt.Format(time.RFC3339))
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_PublishCrossSeason 立即发布赛季分组到consul，已过期或已结束的赛季不能发布 "/cross/season/:id/publish"
+func (h *CrossServerHandler) Handler_PublishCrossSeason(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	if err := pkg.PublishCrossSeason(uint(id), c.Get("X-Request-User")); err != nil {
+		if errors.Is(err, pkg.ErrSeasonNotActive) {
+			return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to publish cross season", err.Error(), fiber.Map{})
+		}
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to publish cross season", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListCrossAudit 展示跨服分组操作记录 "?season_id=1"
+func (h *CrossServerHandler) Handler_ListCrossAudit(c fiber.Ctx) error {
+	query := h.DB.Model(&crossserver.CrossGroupAudit{})
+	if seasonID := c.Query("season_id"); seasonID != "" {
+		query = query.Where("season_id = ?", seasonID)
+	}
+	var audits []crossserver.CrossGroupAudit
+	if err := query.Order("id DESC").Limit(500).Find(&audits).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list cross audit", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": audits,
+	})
+}
+
+// editableSeason 查询可以编辑的赛季，已发布或已过期的不能修改
+func (h *CrossServerHandler) editableSeason(c fiber.Ctx) (*crossserver.CrossSeason, error) {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var season crossserver.CrossSeason
+	if err := h.DB.First(&season, id).Error; err != nil {
+		return nil, err
+	}
+	if season.Status == crossserver.StatusPublished || season.Status == crossserver.StatusExpired {
+		return nil, fmt.Errorf("season is %s and can not be modified", season.Status)
+	}
+	return &season, nil
+}
+
+// saveGroups 替换赛季的全部分组，服务器不能重复且必须存在
+func (h *CrossServerHandler) saveGroups(seasonID uint, groups []crossserver.GroupServers) error {
+	var rows []crossserver.CrossGroup
+	seen := make(map[string]int)
+	var serverIDs []string
+	for _, g := range groups {
+		if g.Group <= 0 {
+			return errors.New("group number must be positive")
+		}
+		for _, serverID := range g.Servers {
+			if other, ok := seen[serverID]; ok {
+				return fmt.Errorf("server %s is in group %d and %d", serverID, other, g.Group)
+			}
+			seen[serverID] = g.Group
+			serverIDs = append(serverIDs, serverID)
+			rows = append(rows, crossserver.CrossGroup{SeasonID: seasonID, GroupNo: g.Group, ServerID: serverID})
+		}
+	}
+	if len(serverIDs) > 0 {
+		var count int64
+		if err := h.DB.Model(&gameserver.Games{}).Where("server_id IN ?", serverIDs).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(serverIDs) {
+			return fmt.Errorf("%d servers not found", len(serverIDs)-int(count))
+		}
+	}
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("season_id = ?", seasonID).Delete(&crossserver.CrossGroup{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Create(&rows).Error
+	})
+}
@@ -0,0 +1,223 @@
+package gamehandler
+
+import (
+	"errors"
+	"saurfang/internal/config"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/models/environment"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// EnvironmentHandler 发布环境和晋级
+type EnvironmentHandler struct {
+	base.BaseGormRepository[environment.Environment]
+}
+
+func NewEnvironmentHandler() *EnvironmentHandler {
+	return &EnvironmentHandler{
+		BaseGormRepository: base.BaseGormRepository[environment.Environment]{DB: config.DB},
+	}
+}
+
+// Handler_CreateEnvironment 创建环境
+func (e *EnvironmentHandler) Handler_CreateEnvironment(c fiber.Ctx) error {
+	var env environment.Environment
+	if err := c.Bind().Body(&env); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if env.Name == "" || env.ConsulPrefix == "" {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "name and consul_prefix are required", "", fiber.Map{})
+	}
+	if env.Cluster != "" && !config.HasCluster(env.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "cluster not found", env.Cluster, fiber.Map{})
+	}
+	if err := e.Create(&env); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create environment", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_UpdateEnvironment 更新环境 "/env/update/:id"
+func (e *EnvironmentHandler) Handler_UpdateEnvironment(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var env environment.Environment
+	if err := c.Bind().Body(&env); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if env.Cluster != "" && !config.HasCluster(env.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "cluster not found", env.Cluster, fiber.Map{})
+	}
+	if err := e.DB.Model(&environment.Environment{}).Where("id = ?", id).Updates(map[string]any{
+		"name":             env.Name,
+		"stage":            env.Stage,
+		"consul_prefix":    env.ConsulPrefix,
+		"cluster":          env.Cluster,
+		"nomad_namespace":  env.NomadNamespace,
+		"nomad_region":     env.NomadRegion,
+		"datasource_id":    env.DatasourceID,
+		"require_approval": env.RequireApproval,
+	}).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update environment", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_DeleteEnvironment 删除环境 "/env/delete/:id"
+func (e *EnvironmentHandler) Handler_DeleteEnvironment(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	if err := e.Delete(uint(id)); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete environment", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListEnvironment 按顺序展示环境
+func (e *EnvironmentHandler) Handler_ListEnvironment(c fiber.Ctx) error {
+	var envs []environment.Environment
+	if err := e.DB.Order("stage").Find(&envs).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list environment", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": envs,
+	})
+}
+
+// Handler_PreviewPromotion 预览晋级到下一个环境的差异，不做修改
+func (e *EnvironmentHandler) Handler_PreviewPromotion(c fiber.Ctx) error {
+	var payload environment.PromotePayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	p, target, err := pkg.PreparePromotion(&payload, c.Get("X-Request-User"))
+	if err != nil {
+		return pkg.NewAppResponse(c, promotionErrorStatus(err), 1, "failed to preview promotion", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"target_env":       target.Name,
+		"require_approval": target.RequireApproval,
+		"diff":             p.Diff,
+		"parent_id":        p.ParentID,
+	})
+}
+
+// Handler_Promote 晋级到下一个环境，目标环境需要审批时只创建待审批记录
+func (e *EnvironmentHandler) Handler_Promote(c fiber.Ctx) error {
+	var payload environment.PromotePayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	p, target, err := pkg.PreparePromotion(&payload, c.Get("X-Request-User"))
+	if err != nil {
+		return pkg.NewAppResponse(c, promotionErrorStatus(err), 1, "failed to promote", err.Error(), fiber.Map{})
+	}
+	p.RequesterID = pkg.RequestUserID(c)
+	if !target.RequireApproval {
+		p.Status = environment.StatusApproved
+	}
+	if err := e.DB.Create(p).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to save promotion", err.Error(), fiber.Map{})
+	}
+	if !target.RequireApproval {
+		if err := pkg.ApplyPromotion(p); err != nil {
+			return pkg.NewAppResponse(c, fiber.StatusConflict, 1, "failed to apply promotion", err.Error(), p)
+		}
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", p)
+}
+
+// Handler_ListPromotion 展示晋级记录 "?status=pending&kind=config&artifact=xxx"
+func (e *EnvironmentHandler) Handler_ListPromotion(c fiber.Ctx) error {
+	query := e.DB.Model(&environment.Promotion{}).Omit("content", "diff")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if artifact := c.Query("artifact"); artifact != "" {
+		query = query.Where("artifact = ?", artifact)
+	}
+	var promotions []environment.Promotion
+	if err := query.Order("id DESC").Limit(500).Find(&promotions).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list promotion", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": promotions,
+	})
+}
+
+// Handler_ShowPromotion 展示晋级详情和差异 "/env/promotion/:id"
+func (e *EnvironmentHandler) Handler_ShowPromotion(c fiber.Ctx) error {
+	var p environment.Promotion
+	if err := e.DB.First(&p, c.Params("id")).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "promotion not found", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", p)
+}
+
+// decidePromotion 将待审批的晋级更新为审批结果，需要审批权限且不能是申请人，避免重复审批
+func (e *EnvironmentHandler) decidePromotion(c fiber.Ctx, status string) (*environment.Promotion, error) {
+	var p environment.Promotion
+	if err := e.DB.First(&p, c.Params("id")).Error; err != nil {
+		return nil, pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "promotion not found", err.Error(), fiber.Map{})
+	}
+	operator, operatorID := c.Get("X-Request-User"), pkg.RequestUserID(c)
+	if !pkg.CanDecidePromotion(&p, operator, operatorID) {
+		return nil, pkg.NewAppResponse(c, fiber.StatusForbidden, 1, "permission denied", "deciding a promotion requires permission "+approval.ApprovePermission+" and cannot be done by the requester", fiber.Map{})
+	}
+	res := e.DB.Model(&environment.Promotion{}).Where("id = ? AND status = ?", p.ID, environment.StatusPending).
+		Updates(map[string]any{"status": status, "approver": operator, "approver_id": operatorID})
+	if res.Error != nil {
+		return nil, pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to decide promotion", res.Error.Error(), fiber.Map{})
+	}
+	if res.RowsAffected == 0 {
+		return nil, pkg.NewAppResponse(c, fiber.StatusConflict, 1, "promotion is not pending", p.Status, fiber.Map{})
+	}
+	p.Status, p.Approver, p.ApproverID = status, operator, operatorID
+	return &p, nil
+}
+
+// Handler_ApprovePromotion 审批通过并执行，申请人不能审批自己的晋级 "/env/promotion/:id/approve"
+func (e *EnvironmentHandler) Handler_ApprovePromotion(c fiber.Ctx) error {
+	p, err := e.decidePromotion(c, environment.StatusApproved)
+	if p == nil {
+		return err
+	}
+	if err := pkg.ApplyPromotion(p); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusConflict, 1, "failed to apply promotion", err.Error(), p)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", p)
+}
+
+// Handler_RejectPromotion 拒绝晋级，申请人不能拒绝自己的晋级 "/env/promotion/:id/reject"
+func (e *EnvironmentHandler) Handler_RejectPromotion(c fiber.Ctx) error {
+	p, err := e.decidePromotion(c, environment.StatusRejected)
+	if p == nil {
+		return err
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ShowPromotionLineage 展示晋级血缘，从最早的环境到当前 "/env/promotion/:id/lineage"
+func (e *EnvironmentHandler) Handler_ShowPromotionLineage(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	chain, err := pkg.PromotionLineage(uint(id))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "failed to show lineage", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": chain,
+	})
+}
+
+// promotionErrorStatus 已经是最后一个环境时返回400
+func promotionErrorStatus(err error) int {
+	if errors.Is(err, pkg.ErrNoNextEnvironment) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
@@ -0,0 +1,224 @@
+package gamehandler
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/models/gmcommand"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"text/template"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// GMCommandHandler GM命令
+type GMCommandHandler struct {
+	base.BaseGormRepository[gmcommand.GMTemplate]
+}
+
+func NewGMCommandHandler() *GMCommandHandler {
+	return &GMCommandHandler{
+		BaseGormRepository: base.BaseGormRepository[gmcommand.GMTemplate]{DB: config.DB},
+	}
+}
+
+// Handler_ListGMEndpoint 展示GM端点 "?server_id=xxx"
+func (g *GMCommandHandler) Handler_ListGMEndpoint(c fiber.Ctx) error {
+	query := g.DB.Model(&gmcommand.GMEndpoint{})
+	if serverID := c.Query("server_id"); serverID != "" {
+		query = query.Where("server_id LIKE ?", "%"+serverID+"%")
+	}
+	var endpoints []gmcommand.GMEndpoint
+	if err := query.Order("server_id").Find(&endpoints).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list gm endpoint", err.Error(), fiber.Map{})
+	}
+	for i := range endpoints {
+		endpoints[i].HasToken = endpoints[i].Token != ""
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": endpoints,
+	})
+}
+
+// Handler_CreateGMEndpoint 手动登记GM端点
+func (g *GMCommandHandler) Handler_CreateGMEndpoint(c fiber.Ctx) error {
+	var payload gmcommand.GMEndpointPayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	endpoint := payload.GMEndpoint
+	endpoint.Token = payload.Token
+	if endpoint.ServerID == "" || endpoint.Address == "" || endpoint.Port <= 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "server_id, address and port are required", "", fiber.Map{})
+	}
+	if endpoint.Protocol == "" {
+		endpoint.Protocol = gmcommand.ProtocolHTTP
+	}
+	endpoint.Source = gmcommand.SourceManual
+	if err := g.DB.Create(&endpoint).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create gm endpoint", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_UpdateGMEndpoint 更新GM端点，更新后视为手动登记，不再被自动发现覆盖 "/gm/endpoint/update/:id"
+func (g *GMCommandHandler) Handler_UpdateGMEndpoint(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var payload gmcommand.GMEndpointPayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	updates := map[string]any{
+		"protocol": payload.Protocol,
+		"address":  payload.Address,
+		"port":     payload.Port,
+		"path":     payload.Path,
+		"source":   gmcommand.SourceManual,
+	}
+	// 列表不返回token，未填写时保留原token
+	if payload.Token != "" {
+		updates["token"] = payload.Token
+	}
+	if err := g.DB.Model(&gmcommand.GMEndpoint{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update gm endpoint", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_DeleteGMEndpoint 删除GM端点 "/gm/endpoint/delete/:id"
+func (g *GMCommandHandler) Handler_DeleteGMEndpoint(c fiber.Ctx) error {
+	if err := g.DB.Delete(&gmcommand.GMEndpoint{}, c.Params("id")).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete gm endpoint", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_DiscoverGMEndpoint 从游戏服配置和consul服务目录发现GM端点
+func (g *GMCommandHandler) Handler_DiscoverGMEndpoint(c fiber.Ctx) error {
+	count, err := pkg.DiscoverGMEndpoints()
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to discover gm endpoint", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"count": count,
+	})
+}
+
+// Handler_CreateGMTemplate 创建GM命令模板，设置了权限时同时登记到权限列表，便于分配给角色
+func (g *GMCommandHandler) Handler_CreateGMTemplate(c fiber.Ctx) error {
+	var tpl gmcommand.GMTemplate
+	if err := c.Bind().Body(&tpl); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if _, err := template.New(tpl.Name).Parse(tpl.Content); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid template content", err.Error(), fiber.Map{})
+	}
+	if err := g.Create(&tpl); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create gm template", err.Error(), fiber.Map{})
+	}
+	registerGMPermission(tpl.Permission)
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_UpdateGMTemplate 更新GM命令模板 "/gm/template/update/:id"
+func (g *GMCommandHandler) Handler_UpdateGMTemplate(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var tpl gmcommand.GMTemplate
+	if err := c.Bind().Body(&tpl); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if _, err := template.New(tpl.Name).Parse(tpl.Content); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid template content", err.Error(), fiber.Map{})
+	}
+	if err := g.DB.Model(&gmcommand.GMTemplate{}).Where("id = ?", id).Updates(map[string]any{
+		"name":        tpl.Name,
+		"description": tpl.Description,
+		"content":     tpl.Content,
+		"params":      tpl.Params,
+		"permission":  tpl.Permission,
+		"timeout":     tpl.Timeout,
+	}).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update gm template", err.Error(), fiber.Map{})
+	}
+	registerGMPermission(tpl.Permission)
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_DeleteGMTemplate 删除GM命令模板 "/gm/template/delete/:id"
+func (g *GMCommandHandler) Handler_DeleteGMTemplate(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	if err := g.Delete(uint(id)); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete gm template", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListGMTemplate 展示GM命令模板
+func (g *GMCommandHandler) Handler_ListGMTemplate(c fiber.Ctx) error {
+	templates, err := g.List()
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list gm template", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": templates,
+	})
+}
+
+// Handler_ExecuteGMCommand 向选择的服务器执行GM命令，返回每个服务器的结果
+func (g *GMCommandHandler) Handler_ExecuteGMCommand(c fiber.Ctx) error {
+	var payload gmcommand.ExecutePayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	tpl, err := g.ListByID(payload.TemplateID)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "gm template not found", err.Error(), fiber.Map{})
+	}
+	operator := c.Get("X-Request-User")
+	if tpl.Permission != "" && !pkg.UserHasPermission(operator, tpl.Permission) {
+		return pkg.NewAppResponse(c, fiber.StatusForbidden, 1, "permission denied", "permission "+tpl.Permission+" is required", fiber.Map{})
+	}
+	execution, results, err := pkg.ExecuteGMCommand(tpl, payload.ServerIDs, payload.Params, operator, gmcommand.TriggerManual)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to execute gm command", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"execution": execution,
+		"items":     results,
+	})
+}
+
+// Handler_ListGMExecution 展示GM命令执行记录 "?template_id=1"
+func (g *GMCommandHandler) Handler_ListGMExecution(c fiber.Ctx) error {
+	query := g.DB.Model(&gmcommand.GMExecution{})
+	if templateID := c.Query("template_id"); templateID != "" {
+		query = query.Where("template_id = ?", templateID)
+	}
+	var executions []gmcommand.GMExecution
+	if err := query.Order("id DESC").Limit(200).Find(&executions).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list gm execution", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": executions,
+	})
+}
+
+// Handler_ShowGMExecution 展示一次执行中每个服务器的结果 "/gm/execution/:id"
+func (g *GMCommandHandler) Handler_ShowGMExecution(c fiber.Ctx) error {
+	var results []gmcommand.GMExecutionResult
+	if err := g.DB.Where("execution_id = ?", c.Params("id")).Order("id").Find(&results).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to show gm execution", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": results,
+	})
+}
+
+// registerGMPermission 登记模板需要的权限
+func registerGMPermission(permission string) {
+	if permission == "" {
+		return
+	}
+	tools.InitPermissionsItems(&tools.PermissionData{Name: permission, Group: "GM命令"})
+}
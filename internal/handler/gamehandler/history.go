@@ -0,0 +1,86 @@
+package gamehandler
+
+import (
+	"fmt"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// parseTimeQuery 解析时间参数，支持unix秒和"2006-01-02 15:04:05"，为空时返回def
+func parseTimeQuery(c fiber.Ctx, key string, def time.Time) (time.Time, error) {
+	v := c.Query(key)
+	if v == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.ParseInLocation(time.DateTime, v, time.Local)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %s", key, v)
+	}
+	return t, nil
+}
+
+// Handler_ShowServerOperations 展示游戏服的操作记录 "/logic/:server_id/operations?page=1&perPage=20&operation=start&status=failed"
+func (l *LogicServerHandler) Handler_ShowServerOperations(c fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.Query("perPage"))
+	if err != nil || pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	query := l.DB.Model(&gameserver.GameOperation{}).Where("server_id = ?", c.Params("server_id"))
+	for _, field := range []string{"operation", "status", "trigger"} {
+		if v := c.Query(field); v != "" {
+			query = query.Where("`"+field+"` = ?", v)
+		}
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "count game operations error", err.Error(), fiber.Map{})
+	}
+	var ops []gameserver.GameOperation
+	if err := query.Order("started_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&ops).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "list game operations error", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": ops,
+		"total": total,
+	})
+}
+
+// Handler_ShowServerTimeline 游戏服时间线，包含操作、配置版本和状态变化，默认最近24小时 "/logic/:server_id/timeline?since=&until=&limit=200"
+func (l *LogicServerHandler) Handler_ShowServerTimeline(c fiber.Ctx) error {
+	now := time.Now()
+	until, err := parseTimeQuery(c, "until", now)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid time range", err.Error(), fiber.Map{})
+	}
+	since, err := parseTimeQuery(c, "since", until.Add(-24*time.Hour))
+	if err != nil || !since.Before(until) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid time range", "since must be before until", fiber.Map{})
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "200"))
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	items, err := pkg.GameTimeline(c.Params("server_id"), since, until, limit)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "get timeline error", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": items,
+		"since": since,
+		"until": until,
+	})
+}
@@ -0,0 +1,574 @@
+package gamehandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"saurfang/internal/models/gamechannel"
+	"saurfang/internal/models/gamehost"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/ntfy"
+	"saurfang/internal/tools/pkg"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// serverRecordHeader 表格导入导出的列
+var serverRecordHeader = []string{"name", "server_id", "channel", "server_dir", "hosts", "auto_deploy", "template", "vars", "setting"}
+
+// importPlan 单行导入的执行计划
+type importPlan struct {
+	record    gameserver.ServerRecord
+	result    *gameserver.ImportRowResult
+	gameID    uint
+	channelID *uint
+	hostIDs   []uint
+	setting   string
+	ports     []int
+}
+
+// Handler_ImportLogicServer 批量导入逻辑服和配置 "/logic/import?dry_run=true&overwrite=false"
+// 上传字段为file，支持csv、xlsx、json；任意一行校验失败则全部不写入
+func (l *LogicServerHandler) Handler_ImportLogicServer(c fiber.Ctx) error {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	format := strings.ToLower(c.Query("format", strings.TrimPrefix(filepath.Ext(fh.Filename), ".")))
+	f, err := fh.Open()
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to read file", err.Error(), fiber.Map{})
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to read file", err.Error(), fiber.Map{})
+	}
+	records, err := parseServerRecords(format, data)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to parse file", err.Error(), fiber.Map{})
+	}
+	dryRun := c.Query("dry_run") == "true"
+	report, plans, err := l.validateImport(records, c.Query("overwrite") == "true")
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to validate import", err.Error(), fiber.Map{})
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", report)
+	}
+	if report.Invalid > 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "import has errors, nothing committed", fmt.Sprintf("%d invalid rows", report.Invalid), report)
+	}
+	var imported []string
+	for _, p := range plans {
+		if p.result.Action != gameserver.ImportActionSkip {
+			imported = append(imported, p.record.ServerID)
+		}
+	}
+	if err := pkg.CheckRequestFreeze(c, imported, "import logic servers"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	lease, err := pkg.LockServers(imported, c.Get("X-Request-User"), "import logic servers")
+	if err != nil {
+		return lockErrorResponse(c, err)
+	}
+	defer lease.Release()
+	if err := l.commitImport(plans); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to import logic servers", err.Error(), report)
+	}
+	report.Committed = true
+	for _, p := range plans {
+		if p.result.Config {
+			if err := pkg.SyncConfigPorts(p.record.ServerID, append([]uint{}, p.hostIDs...), p.ports); err != nil {
+				slog.Error("failed to sync config ports", "server_id", p.record.ServerID, "error", err)
+			}
+		}
+	}
+	ntfy.PublishNotification(notify.EventTypeConfigChange, fmt.Sprintf("import logic servers %s", fh.Filename), imported, nil, len(imported), 0)
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", report)
+}
+
+// Handler_ExportLogicServer 导出逻辑服和配置 "/logic/export?format=csv&channelId=1"
+func (l *LogicServerHandler) Handler_ExportLogicServer(c fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format", tools.TableFormatCSV))
+	if !slices.Contains([]string{tools.TableFormatCSV, tools.TableFormatXLSX, "json"}, format) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "unsupported format", format, fiber.Map{})
+	}
+	channelID, _ := strconv.Atoi(c.Query("channelId", "0"))
+	records, err := l.exportServerRecords(uint(channelID))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to export logic servers", err.Error(), fiber.Map{})
+	}
+	var buf bytes.Buffer
+	if format == "json" {
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to export logic servers", err.Error(), fiber.Map{})
+		}
+	} else {
+		rows := make([][]string, 0, len(records))
+		for _, r := range records {
+			rows = append(rows, []string{
+				r.Name, r.ServerID, r.Channel, r.ServerDir, strings.Join(r.Hosts, ";"),
+				strconv.FormatBool(r.AutoDeploy), r.Template, formatVars(r.Vars), r.Setting,
+			})
+		}
+		if err := tools.WriteTable(format, &buf, serverRecordHeader, rows); err != nil {
+			return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to export logic servers", err.Error(), fiber.Map{})
+		}
+	}
+	c.Attachment(fmt.Sprintf("logic_servers_%s.%s", time.Now().Format("20060102150405"), format))
+	return c.Send(buf.Bytes())
+}
+
+// validateImport 校验导入数据并生成执行计划
+func (l *LogicServerHandler) validateImport(records []gameserver.ServerRecord, overwrite bool) (*gameserver.ImportReport, []*importPlan, error) {
+	var channels []gamechannel.Channels
+	if err := l.DB.Find(&channels).Error; err != nil {
+		return nil, nil, err
+	}
+	channelIDs := make(map[string]uint)
+	for _, ch := range channels {
+		channelIDs[ch.Name] = ch.ID
+		channelIDs[strconv.Itoa(int(ch.ID))] = ch.ID
+	}
+	var hosts []gamehost.Hosts
+	if err := l.DB.Find(&hosts).Error; err != nil {
+		return nil, nil, err
+	}
+	hostIDs := make(map[string]uint)
+	for _, h := range hosts {
+		hostIDs[h.Hostname] = h.ID
+		hostIDs[h.PrivateIP] = h.ID
+	}
+	var games []gameserver.Games
+	if err := l.DB.Find(&games).Error; err != nil {
+		return nil, nil, err
+	}
+	existing := make(map[string]uint)
+	for _, g := range games {
+		existing[g.ServerID] = g.ID
+	}
+	configs := make(map[string]bool)
+	if l.Consul != nil {
+		pairs, err := l.ListNomadJobPairs()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, pair := range pairs {
+			configs[tools.RemoveNamespace(pair.Key, l.Ns)] = true
+		}
+	}
+	templates := make(map[string]*template.Template)
+	// 文件内同一主机的端口占用 hostID -> port -> serverID
+	filePorts := make(map[uint]map[int]string)
+
+	report := &gameserver.ImportReport{Total: len(records)}
+	plans := make([]*importPlan, 0, len(records))
+	seen := make(map[string]int)
+	for _, record := range records {
+		plan := &importPlan{
+			record: record,
+			result: &gameserver.ImportRowResult{Line: record.Line, ServerID: record.ServerID, Action: gameserver.ImportActionCreate},
+		}
+		addErr := func(format string, args ...any) {
+			plan.result.Errors = append(plan.result.Errors, fmt.Sprintf(format, args...))
+		}
+		if record.Name == "" {
+			addErr("name is required")
+		}
+		if record.ServerID == "" {
+			addErr("server_id is required")
+		} else if line, ok := seen[record.ServerID]; ok {
+			addErr("duplicate server_id, first defined at line %d", line)
+		} else {
+			seen[record.ServerID] = record.Line
+		}
+		if id, ok := existing[record.ServerID]; ok && record.ServerID != "" {
+			plan.gameID = id
+			plan.result.Action = gameserver.ImportActionUpdate
+			if !overwrite {
+				plan.result.Action = gameserver.ImportActionSkip
+			}
+		}
+		if record.Channel != "" {
+			if id, ok := channelIDs[record.Channel]; ok {
+				plan.channelID = &id
+			} else {
+				addErr("channel %q not found", record.Channel)
+			}
+		}
+		for _, h := range record.Hosts {
+			if id, ok := hostIDs[h]; ok {
+				plan.hostIDs = append(plan.hostIDs, id)
+			} else {
+				addErr("host %q not found", h)
+			}
+		}
+		// 已存在且不覆盖的逻辑服不处理配置
+		if plan.result.Action != gameserver.ImportActionSkip {
+			setting, err := l.renderImportSetting(templates, record)
+			if err != nil {
+				addErr("%v", err)
+			}
+			if setting != "" {
+				if configs[record.ServerID] && !overwrite {
+					addErr("config for server %s already exists", record.ServerID)
+				} else if l.Nomad != nil {
+					if _, err := l.Nomad.Jobs().ParseHCL(setting, true); err != nil {
+						addErr("invalid job config: %v", err)
+					}
+				}
+				if ports, err := tools.ExtractJobPorts(setting); err != nil {
+					addErr("%v", err)
+				} else {
+					plan.ports = ports
+					if err := pkg.CheckServerPorts(record.ServerID, append([]uint{}, plan.hostIDs...), ports); err != nil {
+						addErr("%v", err)
+					}
+					for _, hostID := range plan.hostIDs {
+						if filePorts[hostID] == nil {
+							filePorts[hostID] = make(map[int]string)
+						}
+						for _, port := range ports {
+							if other, ok := filePorts[hostID][port]; ok && other != record.ServerID {
+								addErr("port %d on host %d is also used by %s in this file", port, hostID, other)
+							}
+							filePorts[hostID][port] = record.ServerID
+						}
+					}
+				}
+				plan.setting = setting
+				plan.result.Config = true
+			}
+		}
+		switch {
+		case len(plan.result.Errors) > 0:
+			report.Invalid++
+		case plan.result.Action == gameserver.ImportActionCreate:
+			report.Created++
+		case plan.result.Action == gameserver.ImportActionUpdate:
+			report.Updated++
+		default:
+			report.Skipped++
+		}
+		plans = append(plans, plan)
+	}
+	for _, p := range plans {
+		report.Rows = append(report.Rows, *p.result)
+	}
+	return report, plans, nil
+}
+
+// renderImportSetting 生成导入行的nomad job配置，setting优先于template
+func (l *LogicServerHandler) renderImportSetting(cache map[string]*template.Template, record gameserver.ServerRecord) (string, error) {
+	if record.Setting != "" {
+		return strings.ReplaceAll(record.Setting, "\r", ""), nil
+	}
+	if record.Template == "" {
+		return "", nil
+	}
+	tmpl, ok := cache[record.Template]
+	if !ok {
+		if l.Consul == nil {
+			return "", errors.New("consul is not configured")
+		}
+		key := tools.AddNamespace(record.Template, os.Getenv("GAME_CONFIG_TEMPLATE_NAMESPACE"))
+		pair, _, err := l.Consul.KV().Get(key, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to get template %q: %v", record.Template, err)
+		}
+		if pair == nil {
+			return "", fmt.Errorf("template %q not found", record.Template)
+		}
+		tmpl, err = template.New(record.Template).Option("missingkey=error").Parse(strings.ReplaceAll(string(pair.Value), "\r", ""))
+		if err != nil {
+			return "", fmt.Errorf("invalid template %q: %v", record.Template, err)
+		}
+		cache[record.Template] = tmpl
+	}
+	vars := record.Vars
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]any{
+		"Name":      record.Name,
+		"ServerID":  record.ServerID,
+		"Channel":   record.Channel,
+		"ServerDir": record.ServerDir,
+		"Vars":      vars,
+	}); err != nil {
+		return "", fmt.Errorf("failed to render template %q: %v", record.Template, err)
+	}
+	return buf.String(), nil
+}
+
+// commitImport 数据库事务写入逻辑服和主机关系，consul写入成功后才提交数据库事务，提交失败时恢复consul配置
+func (l *LogicServerHandler) commitImport(plans []*importPlan) error {
+	tx := l.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	var pairs []*consulapi.KVPair
+	for _, p := range plans {
+		if p.result.Action == gameserver.ImportActionSkip {
+			continue
+		}
+		game := gameserver.Games{
+			Name:       p.record.Name,
+			ServerID:   p.record.ServerID,
+			ChannelID:  p.channelID,
+			ServerDir:  p.record.ServerDir,
+			AutoDeploy: p.record.AutoDeploy,
+		}
+		if p.gameID == 0 {
+			if err := tx.Create(&game).Error; err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to create %s: %v", p.record.ServerID, err)
+			}
+			p.gameID = game.ID
+		} else {
+			if err := tx.Model(&gameserver.Games{}).Where("id = ?", p.gameID).Updates(map[string]any{
+				"name":        game.Name,
+				"channel_id":  game.ChannelID,
+				"server_dir":  game.ServerDir,
+				"auto_deploy": game.AutoDeploy,
+			}).Error; err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to update %s: %v", p.record.ServerID, err)
+			}
+			if err := tx.Exec("DELETE FROM game_hosts WHERE game_id = ?", p.gameID).Error; err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to update hosts of %s: %v", p.record.ServerID, err)
+			}
+		}
+		for _, hostID := range p.hostIDs {
+			if err := tx.Exec("INSERT INTO game_hosts (game_id, host_id) VALUES (?, ?)", p.gameID, hostID).Error; err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to assign hosts to %s: %v", p.record.ServerID, err)
+			}
+		}
+		if p.setting != "" {
+			pairs = append(pairs, &consulapi.KVPair{
+				Key:   tools.AddNamespace(p.record.ServerID, l.Ns),
+				Value: []byte(p.setting),
+			})
+		}
+	}
+	if len(pairs) == 0 {
+		return tx.Commit().Error
+	}
+	previous, err := l.setImportConfigs(pairs)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		keys := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			keys = append(keys, pair.Key)
+		}
+		l.restoreImportConfigs(keys, previous)
+		return err
+	}
+	return nil
+}
+
+// setImportConfigs 写入consul配置，新key使用CAS index 0防止覆盖并发创建的配置
+// 返回写入前的配置用于回滚，部分批次写入后失败时恢复已写入的key
+func (l *LogicServerHandler) setImportConfigs(pairs []*consulapi.KVPair) (map[string][]byte, error) {
+	current, err := l.ListNomadJobPairs()
+	if err != nil {
+		return nil, err
+	}
+	indexes := make(map[string]uint64, len(current))
+	previous := make(map[string][]byte, len(current))
+	for _, pair := range current {
+		indexes[pair.Key] = pair.ModifyIndex
+		previous[pair.Key] = pair.Value
+	}
+	for _, pair := range pairs {
+		pair.ModifyIndex = indexes[pair.Key]
+	}
+	if err := l.CASUpdateNomadJobs(pairs); err != nil {
+		var partial *base.CASBatchError
+		if errors.As(err, &partial) {
+			l.restoreImportConfigs(partial.Committed, previous)
+		}
+		return nil, err
+	}
+	return previous, nil
+}
+
+// restoreImportConfigs 数据库回滚后恢复已写入的consul配置，导入前不存在的key直接删除
+func (l *LogicServerHandler) restoreImportConfigs(keys []string, previous map[string][]byte) {
+	kv := l.Consul.KV()
+	for _, key := range keys {
+		var err error
+		if value, ok := previous[key]; ok {
+			_, err = kv.Put(&consulapi.KVPair{Key: key, Value: value}, nil)
+		} else {
+			_, err = kv.Delete(key, nil)
+		}
+		if err != nil {
+			slog.Error("failed to restore config after import rollback", "key", key, "error", err)
+		}
+	}
+}
+
+// exportServerRecords 查询逻辑服、主机和consul配置
+func (l *LogicServerHandler) exportServerRecords(channelID uint) ([]gameserver.ServerRecord, error) {
+	query := l.DB.Model(&gameserver.Games{}).Preload("Channel")
+	if channelID > 0 {
+		query = query.Where("channel_id = ?", channelID)
+	}
+	var games []gameserver.Games
+	if err := query.Order("id").Find(&games).Error; err != nil {
+		return nil, err
+	}
+	var links []struct {
+		GameID    uint
+		PrivateIP string
+	}
+	if err := l.DB.Raw("SELECT gh.game_id, h.private_ip FROM game_hosts gh JOIN hosts h ON gh.host_id = h.id").Scan(&links).Error; err != nil {
+		return nil, err
+	}
+	hosts := make(map[uint][]string)
+	for _, link := range links {
+		hosts[link.GameID] = append(hosts[link.GameID], link.PrivateIP)
+	}
+	settings := make(map[string]string)
+	if l.Consul != nil {
+		pairs, err := l.ListNomadJobPairs()
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			settings[tools.RemoveNamespace(pair.Key, l.Ns)] = strings.ReplaceAll(string(pair.Value), "\r", "")
+		}
+	}
+	records := make([]gameserver.ServerRecord, 0, len(games))
+	for _, g := range games {
+		record := gameserver.ServerRecord{
+			Name:       g.Name,
+			ServerID:   g.ServerID,
+			ServerDir:  g.ServerDir,
+			Hosts:      hosts[g.ID],
+			Setting:    settings[g.ServerID],
+			AutoDeploy: g.AutoDeploy,
+		}
+		if g.Channel != nil {
+			record.Channel = g.Channel.Name
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// parseServerRecords 解析导入文件
+func parseServerRecords(format string, data []byte) ([]gameserver.ServerRecord, error) {
+	if format == "json" {
+		var records []gameserver.ServerRecord
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+		for i := range records {
+			records[i].Line = i + 1
+		}
+		return records, nil
+	}
+	header, rows, lines, err := tools.ReadTable(format, data)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]gameserver.ServerRecord, 0, len(rows))
+	for i, row := range rows {
+		get := func(col string) string {
+			idx := slices.Index(header, col)
+			if idx < 0 || idx >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[idx])
+		}
+		// 跳过空行
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		autoDeploy, _ := strconv.ParseBool(get("auto_deploy"))
+		records = append(records, gameserver.ServerRecord{
+			Name:       get("name"),
+			ServerID:   get("server_id"),
+			Channel:    get("channel"),
+			ServerDir:  get("server_dir"),
+			Hosts:      splitList(get("hosts")),
+			AutoDeploy: autoDeploy,
+			Template:   get("template"),
+			Vars:       parseVars(get("vars")),
+			Setting:    get("setting"),
+			Line:       lines[i],
+		})
+	}
+	return records, nil
+}
+
+// splitList 按";"或","分隔
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseVars 解析"k=v;k=v"格式的变量
+func parseVars(s string) map[string]string {
+	items := strings.Split(s, ";")
+	vars := make(map[string]string, len(items))
+	for _, item := range items {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			continue
+		}
+		vars[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	return vars
+}
+
+// formatVars 变量格式化为"k=v;k=v"，按key排序
+func formatVars(vars map[string]string) string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, k+"="+vars[k])
+	}
+	return strings.Join(items, ";")
+}
@@ -1,8 +1,13 @@
 package gamehandler
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
 	"saurfang/internal/models/amis"
+	"saurfang/internal/models/gamechannel"
 	"saurfang/internal/models/gameserver"
 	"saurfang/internal/models/serverconfig"
 	"saurfang/internal/repository/base"
@@ -12,6 +17,7 @@ import (
 	"strings"
 
 	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
 )
 
 type LogicServerHandler struct {
@@ -19,16 +25,104 @@ type LogicServerHandler struct {
 	base.NomadJobRepository
 }
 
-// Handler_CreateLogicServer 创建游戏逻辑服
+// Handler_CreateLogicServer 创建游戏逻辑服 "?host_ids=1,2"
+// 同时分配主机时检查游戏服已有配置中的端口是否与主机上其他游戏服冲突
 func (l *LogicServerHandler) Handler_CreateLogicServer(c fiber.Ctx) error {
 	var server gameserver.Games
 	if err := c.Bind().Body(&server); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
 	}
-	if err := l.Create(&server); err != nil {
-		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create logic server", err.Error(), fiber.Map{})
+	if !config.HasCluster(server.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "cluster not found", server.Cluster, fiber.Map{})
 	}
-	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+	var hostIDs []uint
+	for _, id := range strings.Split(c.Query("host_ids"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		uid, err := strconv.Atoi(id)
+		if err != nil {
+			return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid host_ids", err.Error(), fiber.Map{})
+		}
+		hostIDs = append(hostIDs, uint(uid))
+	}
+	if server.ServerID == "" && server.ChannelID == nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to create logic server", "server_id or channel_id is required", fiber.Map{})
+	}
+	var ports []int
+	status := fiber.StatusInternalServerError
+	// 服务器ID和端口的检查、创建和端口登记在同一个事务中，games.server_id的唯一索引兜底
+	err := l.DB.Transaction(func(tx *gorm.DB) error {
+		if server.ServerID != "" {
+			if err := pkg.CheckServerIDUniqueTx(tx, server.ServerID); err != nil {
+				if errors.Is(err, pkg.ErrServerIDExists) {
+					status = fiber.StatusConflict
+				}
+				return err
+			}
+		} else {
+			// 未填写服务器ID时按渠道规则自动分配，序号与创建在同一个事务中推进
+			serverID, err := pkg.AllocateServerIDTx(tx, *server.ChannelID)
+			if err != nil {
+				if errors.Is(err, pkg.ErrNoServerIDRule) {
+					status = fiber.StatusBadRequest
+				}
+				return fmt.Errorf("failed to allocate server id: %v", err)
+			}
+			server.ServerID = serverID
+		}
+		if len(hostIDs) > 0 {
+			var err error
+			if ports, err = l.existingConfigPorts(&server); err != nil {
+				status = fiber.StatusBadRequest
+				return err
+			}
+			if err := pkg.CheckServerPortsTx(tx, server.ServerID, hostIDs, ports); err != nil {
+				if errors.Is(err, pkg.ErrPortConflict) {
+					status = fiber.StatusConflict
+				}
+				return err
+			}
+		}
+		if err := tx.Create(&server).Error; err != nil {
+			return err
+		}
+		for _, hostID := range hostIDs {
+			if err := tx.Exec("INSERT INTO game_hosts (game_id, host_id) VALUES (?, ?)", server.ID, hostID).Error; err != nil {
+				return err
+			}
+		}
+		if len(ports) > 0 {
+			return pkg.SyncConfigPortsTx(tx, server.ServerID, hostIDs, ports)
+		}
+		return nil
+	})
+	if err != nil {
+		return pkg.NewAppResponse(c, status, 1, "failed to create logic server", err.Error(), fiber.Map{})
+	}
+	pkg.TriggerServerListPublish()
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"server_id": server.ServerID,
+	})
+}
+
+// existingConfigPorts 新建逻辑服在其集群的consul中已有配置时，取配置中的端口
+func (l *LogicServerHandler) existingConfigPorts(server *gameserver.Games) ([]int, error) {
+	var channelCluster string
+	if server.ChannelID != nil {
+		if err := l.DB.Model(&gamechannel.Channels{}).Where("id = ?", *server.ChannelID).Pluck("cluster", &channelCluster).Error; err != nil {
+			return nil, err
+		}
+	}
+	cluster, err := config.GetCluster(pkg.ResolveCluster(server.Cluster, channelCluster))
+	if err != nil {
+		return nil, err
+	}
+	pair, _, err := cluster.Consul().KV().Get(tools.AddNamespace(server.ServerID, os.Getenv("GAME_NOMAD_JOB_NAMESPACE")), nil)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+	return tools.ExtractJobPorts(string(pair.Value))
 }
 
 // Handler_DeleteLogicServer 删除逻辑服 “/delete"
@@ -40,6 +134,7 @@ func (l *LogicServerHandler) Handler_DeleteLogicServer(c fiber.Ctx) error {
 	if err := l.Delete(uint(id)); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete logic server", err.Error(), fiber.Map{})
 	}
+	pkg.TriggerServerListPublish()
 	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
 }
 
@@ -77,10 +172,14 @@ func (l *LogicServerHandler) Handler_UpdateLogicServer(c fiber.Ctx) error {
 	if err := c.Bind().Body(&servers); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
 	}
+	if !config.HasCluster(servers.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "cluster not found", servers.Cluster, fiber.Map{})
+	}
 	servers.ID = uint(id)
 	if err := l.Update(servers.ID, &servers); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update logic server", err.Error(), fiber.Map{})
 	}
+	pkg.TriggerServerListPublish()
 	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
 }
 
@@ -138,6 +237,19 @@ func (l *LogicServerHandler) Handler_ShowLogicServer(c fiber.Ctx) error {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list logic servers", err.Error(), fiber.Map{})
 	}
 
+	// 附加consul健康状态，consul不可用时不影响列表
+	if config.ConsulCli != nil {
+		health, err := pkg.CollectServiceHealth()
+		if err != nil {
+			slog.Warn("failed to collect service health", "error", err)
+		}
+		if health != nil {
+			for i := range data {
+				data[i].Health = pkg.GameServerHealth(health[data[i].ServerID])
+			}
+		}
+	}
+
 	// 计算分页信息
 	totalPages := (int(total) + pageSize - 1) / pageSize
 
@@ -149,6 +261,50 @@ func (l *LogicServerHandler) Handler_ShowLogicServer(c fiber.Ctx) error {
 		"totalPages": totalPages,
 	})
 }
+
+// Handler_ShowServerHealth 展示游戏服在consul中的服务和健康检查 "/health?channelId=1&server_id=xxx&status=critical"
+func (l *LogicServerHandler) Handler_ShowServerHealth(c fiber.Ctx) error {
+	query := l.DB.Model(&gameserver.Games{})
+	if channelID := c.Query("channelId"); channelID != "" {
+		query = query.Where("channel_id = ?", channelID)
+	}
+	if serverID := c.Query("server_id"); serverID != "" {
+		query = query.Where("server_id = ?", serverID)
+	}
+	var games []gameserver.Games
+	if err := query.Order("server_id").Find(&games).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list logic servers", err.Error(), fiber.Map{})
+	}
+	// 部分集群失败时返回其他集群的结果，失败的集群在errors中
+	health, err := pkg.CollectServiceHealth()
+	var clusterErrs pkg.ClusterErrors
+	if health == nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to collect service health", err.Error(), fiber.Map{})
+	}
+	errors.As(err, &clusterErrs)
+	status := c.Query("status")
+	items := make([]gameserver.GameHealth, 0, len(games))
+	for _, g := range games {
+		services := health[g.ServerID]
+		if services == nil {
+			services = []gameserver.ServiceHealth{}
+		}
+		item := gameserver.GameHealth{ServerID: g.ServerID, Name: g.Name, Status: pkg.GameServerHealth(services), Services: services}
+		if status != "" && item.Status != status {
+			continue
+		}
+		items = append(items, item)
+	}
+	errs := make(map[string]string, len(clusterErrs))
+	for name, e := range clusterErrs {
+		errs[name] = e.Error()
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items":  items,
+		"errors": errs,
+	})
+}
+
 func (l *LogicServerHandler) Handler_ShowLogicServerTree(c fiber.Ctx) error {
 	servers, err := l.List()
 	if err != nil {
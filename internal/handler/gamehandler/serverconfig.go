@@ -1,6 +1,9 @@
 package gamehandler
 
 import (
+	"errors"
+	"log/slog"
+	"os"
 	"saurfang/internal/config"
 	"saurfang/internal/models/serverconfig"
 	"saurfang/internal/repository/base"
@@ -23,13 +26,48 @@ func NewServerConfigHandler(cli *consulapi.Client, ns string) *ServerConfigHandl
 	}
 }
 
+// forCluster 读写集群的consul，配置模板只存放在默认集群
+func (s *ServerConfigHandler) forCluster(cluster *config.Cluster) *ServerConfigHandler {
+	if s.Ns == os.Getenv("GAME_CONFIG_TEMPLATE_NAMESPACE") {
+		return s
+	}
+	return &ServerConfigHandler{
+		base.NomadJobRepository{Consul: cluster.Consul(), Nomad: cluster.Nomad(), Ns: s.Ns},
+	}
+}
+
+// forServer 游戏服配置存放在游戏服所属集群
+func (s *ServerConfigHandler) forServer(serverID string) (*ServerConfigHandler, error) {
+	cluster, err := pkg.ClusterOfServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+	return s.forCluster(cluster), nil
+}
+
+// clusterHandler 按请求参数cluster选择集群，为空时使用默认集群
+func (s *ServerConfigHandler) clusterHandler(c fiber.Ctx) (*ServerConfigHandler, error) {
+	cluster, err := config.GetCluster(c.Query("cluster"))
+	if err != nil {
+		return nil, err
+	}
+	return s.forCluster(cluster), nil
+}
+
 // Handler_CreateServerConfig 创建逻辑服配置
 func (s *ServerConfigHandler) Handler_CreateServerConfig(c fiber.Ctx) error {
 	var gcdto serverconfig.GameConfig
 	if err := c.Bind().Body(&gcdto); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
 	}
-	if err := s.CreateNomadJob(gcdto.Key, gcdto.Setting); err != nil {
+	if err := pkg.CheckRequestFreeze(c, []string{gcdto.Key}, "create server config"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	h, err := s.forServer(gcdto.Key)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to create server config", err.Error(), fiber.Map{})
+	}
+	if err := h.CreateNomadJob(gcdto.Key, gcdto.Setting); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create server config", err.Error(), fiber.Map{})
 	}
 	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
@@ -38,7 +76,19 @@ func (s *ServerConfigHandler) Handler_CreateServerConfig(c fiber.Ctx) error {
 // Handler_DeleteServerConfig 删除逻辑服配置
 func (s *ServerConfigHandler) Handler_DeleteServerConfig(c fiber.Ctx) error {
 	key := c.Query("key")
-	if err := s.DeleteNomadJob(key); err != nil {
+	if err := pkg.CheckRequestFreeze(c, []string{tools.RemoveNamespace(key, s.Ns)}, "delete server config"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	h, err := s.forServer(tools.RemoveNamespace(key, s.Ns))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to delete server config", err.Error(), fiber.Map{})
+	}
+	lease, err := pkg.LockServer(tools.RemoveNamespace(key, s.Ns), c.Get("X-Request-User"), "delete server config")
+	if err != nil {
+		return lockErrorResponse(c, err)
+	}
+	defer lease.Release()
+	if err := h.DeleteNomadJob(key); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete server config", err.Error(), fiber.Map{})
 	}
 	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
@@ -50,15 +100,37 @@ func (s *ServerConfigHandler) Handler_UpdateServerConfig(c fiber.Ctx) error {
 	if err := c.Bind().Body(&payload); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
 	}
-	if err := s.UpdateNomadJob(payload.Key, payload.Setting); err != nil {
+	serverID := tools.RemoveNamespace(payload.Key, s.Ns)
+	if err := pkg.CheckRequestFreeze(c, []string{serverID}, "update server config"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	h, err := s.forServer(serverID)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to update server config", err.Error(), fiber.Map{})
+	}
+	lease, err := pkg.LockServer(serverID, c.Get("X-Request-User"), "update server config")
+	if err != nil {
+		return lockErrorResponse(c, err)
+	}
+	defer lease.Release()
+	ports, err := s.checkConfigPorts(serverID, payload.Setting)
+	if err != nil {
+		return pkg.NewAppResponse(c, portErrorStatus(err), 1, "failed to update server config", err.Error(), fiber.Map{})
+	}
+	if err := h.UpdateNomadJob(payload.Key, payload.Setting); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update server config", err.Error(), fiber.Map{})
 	}
+	s.syncConfigPorts(serverID, ports)
 	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
 }
 
-// Handler_ListServerConfig 展示配置
+// Handler_ListServerConfig 展示配置 "?cluster=xxx"
 func (s *ServerConfigHandler) Handler_ListServerConfig(c fiber.Ctx) error {
-	data, err := s.ShowNomadJob()
+	h, err := s.clusterHandler(c)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), fiber.Map{})
+	}
+	data, err := h.ShowNomadJob()
 	if err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list server config", err.Error(), fiber.Map{})
 	}
@@ -69,7 +141,11 @@ func (s *ServerConfigHandler) Handler_ListServerConfig(c fiber.Ctx) error {
 func (s *ServerConfigHandler) Handler_ListNomadJobByKey(c fiber.Ctx) error {
 	var res serverconfig.GameConfig
 	key := tools.AddNamespace(c.Params("server_id"), s.Ns)
-	kv := s.Consul.KV()
+	h, err := s.forServer(c.Params("server_id"))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to list server config", err.Error(), fiber.Map{})
+	}
+	kv := h.Consul.KV()
 	pair, _, err := kv.Get(key, nil)
 	if err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list server config", err.Error(), fiber.Map{})
@@ -88,8 +164,59 @@ func (s *ServerConfigHandler) Handler_CreateNomadJob(c fiber.Ctx) error {
 	if err := c.Bind().Body(&payload); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to create nomad job", err.Error(), fiber.Map{})
 	}
-	if err := s.CreateNomadJob(payload.Key, payload.Setting); err != nil {
+	if err := pkg.CheckRequestFreeze(c, []string{payload.Key}, "create nomad job"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	h, err := s.forServer(payload.Key)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "failed to create nomad job", err.Error(), fiber.Map{})
+	}
+	ports, err := s.checkConfigPorts(payload.Key, payload.Setting)
+	if err != nil {
+		return pkg.NewAppResponse(c, portErrorStatus(err), 1, "failed to create nomad job", err.Error(), fiber.Map{})
+	}
+	if err := h.CreateNomadJob(payload.Key, payload.Setting); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create nomad job", err.Error(), fiber.Map{})
 	}
+	s.syncConfigPorts(payload.Key, ports)
 	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
 }
+
+// checkConfigPorts 游戏服配置检查端口是否与同主机的其他游戏服冲突，其他前缀的配置不检查
+func (s *ServerConfigHandler) checkConfigPorts(serverID, setting string) ([]int, error) {
+	if s.Ns != os.Getenv("GAME_NOMAD_JOB_NAMESPACE") {
+		return nil, nil
+	}
+	ports, err := tools.ExtractJobPorts(setting)
+	if err != nil {
+		return nil, err
+	}
+	return ports, pkg.CheckServerPorts(serverID, nil, ports)
+}
+
+// portErrorStatus 端口冲突返回409，配置无法解析等其他错误返回400
+func portErrorStatus(err error) int {
+	if errors.Is(err, pkg.ErrPortConflict) {
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
+
+// lockErrorResponse 加锁失败的响应，被其他操作持有时返回409和持有者
+func lockErrorResponse(c fiber.Ctx, err error) error {
+	var conflict *pkg.LockConflictError
+	if errors.As(err, &conflict) {
+		return pkg.NewAppResponse(c, fiber.StatusConflict, 1, conflict.Error(), conflict.Error(), conflict.Info)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "acquire lock error", err.Error(), nil)
+}
+
+// syncConfigPorts 配置保存成功后登记端口
+func (s *ServerConfigHandler) syncConfigPorts(serverID string, ports []int) {
+	if s.Ns != os.Getenv("GAME_NOMAD_JOB_NAMESPACE") {
+		return
+	}
+	if err := pkg.SyncConfigPorts(serverID, nil, ports); err != nil {
+		slog.Error("failed to sync config ports", "server_id", serverID, "error", err)
+	}
+}
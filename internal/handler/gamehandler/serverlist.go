@@ -0,0 +1,150 @@
+package gamehandler
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/models/serverlist"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm/clause"
+)
+
+// ServerListHandler 客户端服务器列表发布
+type ServerListHandler struct {
+	base.BaseGormRepository[serverlist.ServerListSetting]
+}
+
+func NewServerListHandler() *ServerListHandler {
+	return &ServerListHandler{
+		BaseGormRepository: base.BaseGormRepository[serverlist.ServerListSetting]{DB: config.DB},
+	}
+}
+
+// Handler_CreateServerListSetting 创建渠道服务器列表发布配置
+func (h *ServerListHandler) Handler_CreateServerListSetting(c fiber.Ctx) error {
+	var setting serverlist.ServerListSetting
+	if err := c.Bind().Body(&setting); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if setting.ChannelID == 0 || setting.DatasourceID == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "channel_id and datasource_id are required", "", fiber.Map{})
+	}
+	if err := h.Create(&setting); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to create server list setting", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_UpdateServerListSetting 更新发布配置 "/serverlist/setting/update/:id"
+func (h *ServerListHandler) Handler_UpdateServerListSetting(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var setting serverlist.ServerListSetting
+	if err := c.Bind().Body(&setting); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	if err := h.DB.Model(&serverlist.ServerListSetting{}).Where("id = ?", id).Updates(map[string]any{
+		"datasource_id": setting.DatasourceID,
+		"path":          setting.Path,
+		"enabled":       setting.Enabled,
+		"keep_versions": setting.KeepVersions,
+	}).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to update server list setting", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_DeleteServerListSetting 删除发布配置 "/serverlist/setting/delete/:id"
+func (h *ServerListHandler) Handler_DeleteServerListSetting(c fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	if err := h.Delete(uint(id)); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to delete server list setting", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListServerListSetting 展示发布配置
+func (h *ServerListHandler) Handler_ListServerListSetting(c fiber.Ctx) error {
+	settings, err := h.List()
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list server list setting", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": settings,
+	})
+}
+
+// Handler_ListServerListFlag 展示服务器的列表展示设置 "?channelId=1"
+func (h *ServerListHandler) Handler_ListServerListFlag(c fiber.Ctx) error {
+	query := h.DB.Model(&serverlist.ServerListFlag{})
+	if channelID := c.Query("channelId"); channelID != "" {
+		query = query.Where("server_id IN (?)", h.DB.Table("games").Select("server_id").Where("channel_id = ?", channelID))
+	}
+	var flags []serverlist.ServerListFlag
+	if err := query.Find(&flags).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list server list flag", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": flags,
+	})
+}
+
+// Handler_SetServerListFlag 设置服务器的维护、推荐、新服等标记 "/serverlist/flag/:server_id"
+func (h *ServerListHandler) Handler_SetServerListFlag(c fiber.Ctx) error {
+	var flag serverlist.ServerListFlag
+	if err := c.Bind().Body(&flag); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "request error", err.Error(), fiber.Map{})
+	}
+	flag.ID = 0
+	flag.ServerID = c.Params("server_id")
+	if err := h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "server_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"maintenance", "recommended", "new", "hidden", "address", "port", "sort_order", "updated_at"}),
+	}).Create(&flag).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to set server list flag", err.Error(), fiber.Map{})
+	}
+	pkg.TriggerServerListPublish()
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_PreviewServerList 预览渠道的服务器列表 "/serverlist/:channel_id/preview"
+func (h *ServerListHandler) Handler_PreviewServerList(c fiber.Ctx) error {
+	channelID, _ := strconv.Atoi(c.Params("channel_id"))
+	list, err := pkg.RenderServerList(uint(channelID))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to render server list", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", list)
+}
+
+// Handler_PublishServerList 立即发布渠道的服务器列表 "/serverlist/:channel_id/publish"
+func (h *ServerListHandler) Handler_PublishServerList(c fiber.Ctx) error {
+	channelID, _ := strconv.Atoi(c.Params("channel_id"))
+	version, err := pkg.PublishServerList(uint(channelID), serverlist.TriggerManual, true)
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to publish server list", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", version)
+}
+
+// Handler_ListServerListVersion 展示渠道已发布的版本 "/serverlist/:channel_id/versions"
+func (h *ServerListHandler) Handler_ListServerListVersion(c fiber.Ctx) error {
+	var versions []serverlist.ServerListVersion
+	if err := h.DB.Omit("content").Where("channel_id = ? AND pending = ?", c.Params("channel_id"), false).
+		Order("id DESC").Find(&versions).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "failed to list server list versions", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": versions,
+	})
+}
+
+// Handler_ShowServerListVersion 展示历史版本内容 "/serverlist/version/:id"
+func (h *ServerListHandler) Handler_ShowServerListVersion(c fiber.Ctx) error {
+	var version serverlist.ServerListVersion
+	if err := h.DB.Where("pending = ?", false).First(&version, c.Params("id")).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "version not found", err.Error(), fiber.Map{})
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", version)
+}
@@ -0,0 +1,239 @@
+package nomadhandler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"path"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// serverHandler 按游戏服所属集群选择nomad客户端
+func (n *NomadHandler) serverHandler(ctx fiber.Ctx) (*NomadHandler, error) {
+	c, err := pkg.ClusterOfServer(ctx.Params("server_id"))
+	if err != nil {
+		return nil, err
+	}
+	return n.forCluster(c), nil
+}
+
+// serverAllocation 查询游戏服job下的分配，分配不属于该游戏服时返回错误
+func (n *NomadHandler) serverAllocation(ctx fiber.Ctx) (*NomadHandler, *nomadapi.Allocation, error) {
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	alloc, err := pkg.GameAllocation(h.Nomad, ctx.Params("server_id"), ctx.Params("alloc_id"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, alloc, nil
+}
+
+// Handler_ListServerAllocations 列出游戏服job的所有分配及task状态和事件 "/server/:server_id/allocations"
+func (n *NomadHandler) Handler_ListServerAllocations(ctx fiber.Ctx) error {
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	items, err := pkg.GameAllocations(h.Nomad, ctx.Params("server_id"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list allocations error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": items,
+	})
+}
+
+// Handler_RestartAllocation 重启分配，指定task时只重启该task "/server/:server_id/alloc/:alloc_id/restart?task=xxx"
+func (n *NomadHandler) Handler_RestartAllocation(ctx fiber.Ctx) error {
+	h, alloc, err := n.serverAllocation(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get allocation error", err.Error(), nil)
+	}
+	if err := pkg.CheckRequestFreeze(ctx, []string{alloc.JobID}, "restart allocation"); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	lease, err := pkg.LockServer(alloc.JobID, ctx.Get("X-Request-User"), "restart allocation")
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	task := ctx.Query("task")
+	if task == "" {
+		err = h.Nomad.Allocations().RestartAllTasks(alloc, nil)
+	} else {
+		if task, err = pkg.AllocTask(alloc, task); err != nil {
+			return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid task", err.Error(), nil)
+		}
+		err = h.Nomad.Allocations().Restart(alloc, task, nil)
+	}
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "restart allocation error", err.Error(), nil)
+	}
+	slog.Info("allocation restarted", "server_id", alloc.JobID, "alloc_id", alloc.ID, "task", task, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_StopAllocation 停止分配，nomad会重新调度新的分配 "/server/:server_id/alloc/:alloc_id/stop"
+func (n *NomadHandler) Handler_StopAllocation(ctx fiber.Ctx) error {
+	h, alloc, err := n.serverAllocation(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get allocation error", err.Error(), nil)
+	}
+	if err := pkg.CheckRequestFreeze(ctx, []string{alloc.JobID}, "stop allocation"); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	lease, err := pkg.LockServer(alloc.JobID, ctx.Get("X-Request-User"), "stop allocation")
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	resp, err := h.Nomad.Allocations().Stop(alloc, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "stop allocation error", err.Error(), nil)
+	}
+	slog.Info("allocation stopped", "server_id", alloc.JobID, "alloc_id", alloc.ID, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"eval_id": resp.EvalID,
+	})
+}
+
+// Handler_StreamAllocationLogs 通过SSE推送task日志 "/server/:server_id/alloc/:alloc_id/logs?task=xxx&type=stdout&follow=true&origin=end&offset=0"
+// origin为end时offset表示从末尾往前的字节数，默认输出最后16KB
+func (n *NomadHandler) Handler_StreamAllocationLogs(ctx fiber.Ctx) error {
+	h, alloc, err := n.serverAllocation(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get allocation error", err.Error(), nil)
+	}
+	task, err := pkg.AllocTask(alloc, ctx.Query("task"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid task", err.Error(), nil)
+	}
+	logType, err := pkg.LogType(ctx.Query("type"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid log type", err.Error(), nil)
+	}
+	origin := ctx.Query("origin", "end")
+	if origin != "start" && origin != "end" {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid origin", "origin must be start or end", nil)
+	}
+	var offset int64
+	if v := ctx.Query("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid offset", "offset must be a non-negative integer", nil)
+		}
+	} else if origin == "end" {
+		offset = 16 * 1024
+	}
+	follow := ctx.Query("follow") == "true"
+
+	n.setSSEHeaders(ctx)
+	// 流式写入在handler返回后执行，不能再使用ctx，query参数需要复制
+	task, logType, origin = strings.Clone(task), strings.Clone(logType), strings.Clone(origin)
+	return ctx.SendStreamWriter(func(w *bufio.Writer) {
+		cancel := make(chan struct{})
+		defer close(cancel)
+		// 写入或flush失败说明客户端已断开，关闭cancel停止nomad日志流
+		write := func(message string) bool {
+			if _, err := w.WriteString(message); err != nil {
+				slog.Error("Failed to write SSE message", "error", err)
+				return false
+			}
+			if err := w.Flush(); err != nil {
+				slog.Error("Failed to flush response", "error", err)
+				return false
+			}
+			return true
+		}
+		frames, errCh := h.Nomad.AllocFS().Logs(alloc, follow, task, logType, origin, offset, cancel, nil)
+		for {
+			select {
+			case frame, ok := <-frames:
+				if !ok {
+					write("data: [√] log stream finished\n\n")
+					return
+				}
+				if frame == nil || frame.IsHeartbeat() {
+					continue
+				}
+				// SSE消息不能包含换行，按行拆分输出
+				scanner := bufio.NewScanner(bytes.NewReader(frame.Data))
+				scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+				for scanner.Scan() {
+					if !write(fmt.Sprintf("data: %s\n\n", scanner.Text())) {
+						return
+					}
+				}
+			case err := <-errCh:
+				if err != nil {
+					write(fmt.Sprintf("data: [X] %s\n\n", err.Error()))
+				}
+				return
+			}
+		}
+	})
+}
+
+// Handler_BrowseAllocationFS 浏览分配文件系统，目录返回文件列表，文件返回文件信息 "/server/:server_id/alloc/:alloc_id/fs?path=/alloc/logs"
+func (n *NomadHandler) Handler_BrowseAllocationFS(ctx fiber.Ctx) error {
+	h, alloc, err := n.serverAllocation(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get allocation error", err.Error(), nil)
+	}
+	p, err := pkg.AllocPath(ctx.Query("path"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid path", err.Error(), nil)
+	}
+	stat, _, err := h.Nomad.AllocFS().Stat(alloc, p, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "stat file error", err.Error(), nil)
+	}
+	if !stat.IsDir {
+		return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+			"path": p,
+			"file": stat,
+		})
+	}
+	files, _, err := h.Nomad.AllocFS().List(alloc, p, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list files error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"path":  p,
+		"items": files,
+	})
+}
+
+// Handler_DownloadAllocationFile 下载分配文件系统中的文件 "/server/:server_id/alloc/:alloc_id/fs/download?path=/alloc/logs/game.stdout.0"
+func (n *NomadHandler) Handler_DownloadAllocationFile(ctx fiber.Ctx) error {
+	h, alloc, err := n.serverAllocation(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get allocation error", err.Error(), nil)
+	}
+	p, err := pkg.AllocPath(ctx.Query("path"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid path", err.Error(), nil)
+	}
+	stat, _, err := h.Nomad.AllocFS().Stat(alloc, p, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "stat file error", err.Error(), nil)
+	}
+	if stat.IsDir {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "path is a directory", "", nil)
+	}
+	reader, err := h.Nomad.AllocFS().Cat(alloc, p, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "read file error", err.Error(), nil)
+	}
+	slog.Info("allocation file downloaded", "server_id", alloc.JobID, "alloc_id", alloc.ID, "path", p, "user", ctx.Get("X-Request-User"))
+	ctx.Attachment(path.Base(p))
+	// SendStream在响应结束后关闭reader
+	return ctx.SendStream(reader, int(stat.Size))
+}
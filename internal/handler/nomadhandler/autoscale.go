@@ -0,0 +1,184 @@
+package nomadhandler
+
+import (
+	"errors"
+	"log/slog"
+	"saurfang/internal/config"
+	"saurfang/internal/models/autoscale"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+// findScalingPolicy 按路由参数id查询伸缩策略
+func findScalingPolicy(ctx fiber.Ctx) (*autoscale.ScalingPolicy, error) {
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return nil, err
+	}
+	var policy autoscale.ScalingPolicy
+	if err := config.DB.First(&policy, id).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// policyError 策略不存在时返回404
+func policyError(ctx fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "scaling policy not found", "", nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get scaling policy error", err.Error(), nil)
+}
+
+// Handler_ListScalingPolicy 展示伸缩策略 "?cluster=xxx&job_id=xxx"
+func (n *NomadHandler) Handler_ListScalingPolicy(ctx fiber.Ctx) error {
+	query := config.DB.Model(&autoscale.ScalingPolicy{})
+	if cluster := ctx.Query("cluster"); cluster != "" {
+		query = query.Where("cluster = ?", cluster)
+	}
+	if jobID := ctx.Query("job_id"); jobID != "" {
+		query = query.Where("job_id LIKE ?", "%"+jobID+"%")
+	}
+	var policies []autoscale.ScalingPolicy
+	if err := query.Order("job_id, `group`").Find(&policies).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list scaling policy error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": policies,
+	})
+}
+
+// Handler_CreateScalingPolicy 创建伸缩策略
+func (n *NomadHandler) Handler_CreateScalingPolicy(ctx fiber.Ctx) error {
+	var policy autoscale.ScalingPolicy
+	if err := ctx.Bind().Body(&policy); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	if err := pkg.ValidateScalingPolicy(&policy); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid scaling policy", err.Error(), nil)
+	}
+	policy.ID = 0
+	policy.OverrideCount, policy.OverrideUntil, policy.LastScaleAt, policy.LastEvaluatedAt = nil, nil, nil, nil
+	policy.LastDecision = ""
+	if err := config.DB.Create(&policy).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "create scaling policy error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", policy)
+}
+
+// Handler_UpdateScalingPolicy 更新伸缩策略，不修改覆盖和评估状态 "/autoscale/policy/update/:id"
+func (n *NomadHandler) Handler_UpdateScalingPolicy(ctx fiber.Ctx) error {
+	policy, err := findScalingPolicy(ctx)
+	if err != nil {
+		return policyError(ctx, err)
+	}
+	var payload autoscale.ScalingPolicy
+	if err := ctx.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	policy.Name, policy.Cluster, policy.JobID, policy.Group = payload.Name, payload.Cluster, payload.JobID, payload.Group
+	policy.Min, policy.Max, policy.Cooldown, policy.Enabled = payload.Min, payload.Max, payload.Cooldown, payload.Enabled
+	policy.Schedules, policy.Rules = payload.Schedules, payload.Rules
+	if err := pkg.ValidateScalingPolicy(policy); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid scaling policy", err.Error(), nil)
+	}
+	if err := config.DB.Save(policy).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "update scaling policy error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", policy)
+}
+
+// Handler_DeleteScalingPolicy 删除伸缩策略，伸缩记录保留 "/autoscale/policy/delete/:id"
+func (n *NomadHandler) Handler_DeleteScalingPolicy(ctx fiber.Ctx) error {
+	id, _ := strconv.Atoi(ctx.Params("id"))
+	if err := config.DB.Delete(&autoscale.ScalingPolicy{}, id).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "delete scaling policy error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_PreviewScalingDecision 按当前状态评估策略但不执行 "/autoscale/policy/:id/decision"
+func (n *NomadHandler) Handler_PreviewScalingDecision(ctx fiber.Ctx) error {
+	policy, err := findScalingPolicy(ctx)
+	if err != nil {
+		return policyError(ctx, err)
+	}
+	decision, err := pkg.EvaluateScalingPolicy(policy, false, "", "")
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "evaluate scaling policy error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", decision)
+}
+
+// Handler_OverrideScalingPolicy 手动覆盖目标数量并立即执行，覆盖期间不执行计划和指标规则 "/autoscale/policy/:id/override"
+func (n *NomadHandler) Handler_OverrideScalingPolicy(ctx fiber.Ctx) error {
+	policy, err := findScalingPolicy(ctx)
+	if err != nil {
+		return policyError(ctx, err)
+	}
+	var payload autoscale.OverridePayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	duration, err := time.ParseDuration(payload.Duration)
+	if err != nil || duration <= 0 {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid duration", "duration must be positive, such as 30m or 2h", nil)
+	}
+	if payload.Count < policy.Min || payload.Count > policy.Max {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid count", "count must be between min and max", nil)
+	}
+	until := time.Now().Add(duration)
+	policy.OverrideCount, policy.OverrideUntil = &payload.Count, &until
+	if err := config.DB.Model(policy).Updates(map[string]any{"override_count": payload.Count, "override_until": until}).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "override scaling policy error", err.Error(), nil)
+	}
+	slog.Info("scaling policy overridden", "policy_id", policy.ID, "count", payload.Count, "until", until, "user", ctx.Get("X-Request-User"))
+	decision, err := pkg.EvaluateScalingPolicy(policy, true, ctx.Get("X-Request-User"), pkg.RequestFreezeOverride(ctx))
+	if err != nil {
+		var frozen *pkg.FreezeError
+		if errors.As(err, &frozen) {
+			return pkg.RefuseFrozen(ctx, err)
+		}
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "apply override error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", decision)
+}
+
+// Handler_ClearScalingOverride 取消手动覆盖，下次评估恢复按计划和指标伸缩 "/autoscale/policy/:id/override"
+func (n *NomadHandler) Handler_ClearScalingOverride(ctx fiber.Ctx) error {
+	policy, err := findScalingPolicy(ctx)
+	if err != nil {
+		return policyError(ctx, err)
+	}
+	if err := config.DB.Model(policy).Updates(map[string]any{"override_count": nil, "override_until": nil}).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "clear override error", err.Error(), nil)
+	}
+	slog.Info("scaling override cleared", "policy_id", policy.ID, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListScalingEvents 展示伸缩记录 "?policy_id=1&job_id=xxx&limit=100"
+func (n *NomadHandler) Handler_ListScalingEvents(ctx fiber.Ctx) error {
+	query := config.DB.Model(&autoscale.ScalingEvent{})
+	if policyID := ctx.Query("policy_id"); policyID != "" {
+		query = query.Where("policy_id = ?", policyID)
+	}
+	if jobID := ctx.Query("job_id"); jobID != "" {
+		query = query.Where("job_id = ?", jobID)
+	}
+	limit, _ := strconv.Atoi(ctx.Query("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	var events []autoscale.ScalingEvent
+	if err := query.Order("id DESC").Limit(limit).Find(&events).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list scaling events error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": events,
+	})
+}
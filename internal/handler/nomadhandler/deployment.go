@@ -0,0 +1,229 @@
+package nomadhandler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/tools/pkg"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// defaultDeploymentTimeout 跟踪deployment的默认超时时间
+const defaultDeploymentTimeout = 10 * time.Minute
+
+// deploymentTimeout 解析timeout参数，为空时使用默认值
+func deploymentTimeout(v string) (time.Duration, error) {
+	if v == "" {
+		return defaultDeploymentTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %s", v)
+	}
+	return d, nil
+}
+
+// followDeployment 跟踪deployment并推送进度，失败时发送通知并按需回滚，deployment成功或没有deployment时返回true
+// lockRevert不为空时回滚前用它锁定游戏服，调用方已持有锁时为空
+func followDeployment(client *nomadapi.Client, serverID, deploymentID string, autoRevert bool, lockRevert func() (*pkg.Lease, error), timeout time.Duration, messageChan chan string) bool {
+	watchCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	promoteNotified := false
+	d, err := pkg.WatchDeployment(watchCtx, client, deploymentID, func(d *nomadapi.Deployment) {
+		messageChan <- fmt.Sprintf("data: [√] key: %s %s\n\n", serverID, pkg.DeploymentSummary(d))
+		if !promoteNotified && pkg.DeploymentNeedsPromotion(d) {
+			promoteNotified = true
+			messageChan <- fmt.Sprintf("data: [!] key: %s canaries are healthy, waiting for promotion. deployment: %s\n\n", serverID, d.ID)
+		}
+	})
+	if err != nil {
+		messageChan <- fmt.Sprintf("data: [X] watch deployment failed. key: %s deployment: %s, error: %v\n\n", serverID, deploymentID, err)
+		return false
+	}
+	if d.Status == nomadapi.DeploymentStatusSuccessful {
+		messageChan <- fmt.Sprintf("data: [√] deployment successful. key: %s deployment: %s\n\n", serverID, d.ID)
+		return true
+	}
+	cluster, err := pkg.ClusterOfServer(serverID)
+	if err != nil {
+		messageChan <- fmt.Sprintf("data: [X] deployment %s. key: %s, resolve cluster failed: %v\n\n", d.Status, serverID, err)
+		return false
+	}
+	if autoRevert && lockRevert != nil && d.Status == nomadapi.DeploymentStatusFailed {
+		lease, err := lockRevert()
+		if err != nil {
+			messageChan <- fmt.Sprintf("data: [X] auto revert skipped. key: %s, error: %v\n\n", serverID, err)
+			autoRevert = false
+		}
+		defer lease.Release()
+	}
+	messageChan <- fmt.Sprintf("data: [X] %s\n\n", pkg.HandleFailedDeployment(cluster, serverID, d, autoRevert))
+	return false
+}
+
+// followEvalDeployment 等待eval创建deployment后跟踪，job没有update配置时直接返回true，调用方需持有游戏服的锁
+func followEvalDeployment(client *nomadapi.Client, serverID, evalID string, autoRevert bool, timeout time.Duration, messageChan chan string) bool {
+	evalCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	deploymentID, err := pkg.EvalDeployment(evalCtx, client, evalID)
+	cancel()
+	if err != nil {
+		messageChan <- fmt.Sprintf("data: [X] wait eval failed. key: %s eval: %s, error: %v\n\n", serverID, evalID, err)
+		return false
+	}
+	if deploymentID == "" {
+		messageChan <- fmt.Sprintf("data: [!] no deployment created. key: %s eval: %s\n\n", serverID, evalID)
+		return true
+	}
+	return followDeployment(client, serverID, deploymentID, autoRevert, nil, timeout, messageChan)
+}
+
+// Handler_ShowServerDeployment 展示游戏服job最近一次deployment "/server/:server_id/deployment"
+func (n *NomadHandler) Handler_ShowServerDeployment(ctx fiber.Ctx) error {
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	d, _, err := h.Nomad.Jobs().LatestDeployment(ctx.Params("server_id"), nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "get deployment error", err.Error(), nil)
+	}
+	if d == nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "deployment not found", "", nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"deployment":      d,
+		"summary":         pkg.DeploymentSummary(d),
+		"needs_promotion": pkg.DeploymentNeedsPromotion(d),
+	})
+}
+
+// Handler_WatchServerDeployment 通过SSE跟踪游戏服job最近一次deployment "/server/:server_id/deployment/watch?auto_revert=true&timeout=10m"
+func (n *NomadHandler) Handler_WatchServerDeployment(ctx fiber.Ctx) error {
+	timeout, err := deploymentTimeout(ctx.Query("timeout"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid timeout", err.Error(), nil)
+	}
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	serverID := strings.Clone(ctx.Params("server_id"))
+	d, _, err := h.Nomad.Jobs().LatestDeployment(serverID, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "get deployment error", err.Error(), nil)
+	}
+	if d == nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "deployment not found", "", nil)
+	}
+	autoRevert := ctx.Query("auto_revert") == "true"
+	var lockRevert func() (*pkg.Lease, error)
+	if autoRevert {
+		if err := pkg.CheckRequestFreeze(ctx, []string{serverID}, "auto revert"); err != nil {
+			return pkg.RefuseFrozen(ctx, err)
+		}
+		// 跟踪期间不锁定，避免阻塞提升金丝雀等操作，只在回滚时锁定
+		operator := strings.Clone(ctx.Get("X-Request-User"))
+		lockRevert = func() (*pkg.Lease, error) {
+			return pkg.LockServer(serverID, operator, "auto revert")
+		}
+	}
+	n.setSSEHeaders(ctx)
+	messageChan := make(chan string, 100)
+	go func() {
+		defer close(messageChan)
+		followDeployment(h.Nomad, serverID, d.ID, autoRevert, lockRevert, timeout, messageChan)
+	}()
+	// 流式写入在handler返回后执行，客户端断开后继续消费消息，保证失败通知和回滚执行完成
+	return ctx.SendStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			go func() {
+				for range messageChan {
+				}
+			}()
+		}()
+		for message := range messageChan {
+			if _, err := w.WriteString(message); err != nil {
+				slog.Error("Failed to write SSE message", "error", err)
+				return
+			}
+			if err := w.Flush(); err != nil {
+				slog.Error("Failed to flush response", "error", err)
+				return
+			}
+		}
+	})
+}
+
+// Handler_PromoteDeployment 提升金丝雀 "/server/:server_id/deployment/:deployment_id/promote"
+func (n *NomadHandler) Handler_PromoteDeployment(ctx fiber.Ctx) error {
+	var payload nomadjob.PromotePayload
+	if len(ctx.Body()) > 0 {
+		if err := ctx.Bind().Body(&payload); err != nil {
+			return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+		}
+	}
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	d, err := pkg.ServerDeployment(h.Nomad, ctx.Params("server_id"), ctx.Params("deployment_id"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get deployment error", err.Error(), nil)
+	}
+	if err := pkg.CheckRequestFreeze(ctx, []string{d.JobID}, "promote deployment"); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	lease, err := pkg.LockServer(d.JobID, ctx.Get("X-Request-User"), "promote deployment")
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	var resp *nomadapi.DeploymentUpdateResponse
+	if len(payload.Groups) == 0 {
+		resp, _, err = h.Nomad.Deployments().PromoteAll(d.ID, nil)
+	} else {
+		resp, _, err = h.Nomad.Deployments().PromoteGroups(d.ID, payload.Groups, nil)
+	}
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "promote deployment error", err.Error(), nil)
+	}
+	slog.Info("deployment promoted", "server_id", d.JobID, "deployment_id", d.ID, "groups", payload.Groups, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"eval_id": resp.EvalID,
+	})
+}
+
+// Handler_FailDeployment 将deployment标记为失败，job配置了auto_revert时nomad会自动回滚 "/server/:server_id/deployment/:deployment_id/fail"
+func (n *NomadHandler) Handler_FailDeployment(ctx fiber.Ctx) error {
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	d, err := pkg.ServerDeployment(h.Nomad, ctx.Params("server_id"), ctx.Params("deployment_id"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get deployment error", err.Error(), nil)
+	}
+	if err := pkg.CheckRequestFreeze(ctx, []string{d.JobID}, "fail deployment"); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	lease, err := pkg.LockServer(d.JobID, ctx.Get("X-Request-User"), "fail deployment")
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	resp, _, err := h.Nomad.Deployments().Fail(d.ID, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "fail deployment error", err.Error(), nil)
+	}
+	slog.Info("deployment failed manually", "server_id", d.JobID, "deployment_id", d.ID, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"eval_id":          resp.EvalID,
+		"reverted_version": resp.RevertedJobVersion,
+	})
+}
@@ -0,0 +1,94 @@
+package nomadhandler
+
+import (
+	"errors"
+	"fmt"
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Handler_ListJobVersions 列出游戏服job的历史版本和版本之间的差异 "/server/:server_id/versions"
+func (n *NomadHandler) Handler_ListJobVersions(ctx fiber.Ctx) error {
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	items, err := pkg.JobVersions(h.Nomad, ctx.Params("server_id"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list job versions error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": items,
+	})
+}
+
+// Handler_ShowJobVersion 展示指定版本注册时的hcl原文 "/server/:server_id/versions/:version"
+func (n *NomadHandler) Handler_ShowJobVersion(ctx fiber.Ctx) error {
+	version, err := strconv.ParseUint(ctx.Params("version"), 10, 64)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid version", err.Error(), nil)
+	}
+	h, err := n.serverHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	source, err := pkg.JobVersionSource(h.Nomad, ctx.Params("server_id"), version)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "job version source not found", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"version": version,
+		"setting": source,
+	})
+}
+
+// Handler_RevertJobVersion 回滚游戏服job到指定版本 "/server/:server_id/versions/:version/revert"
+func (n *NomadHandler) Handler_RevertJobVersion(ctx fiber.Ctx) error {
+	var payload nomadjob.RevertPayload
+	if len(ctx.Body()) > 0 {
+		if err := ctx.Bind().Body(&payload); err != nil {
+			return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+		}
+	}
+	version, err := strconv.ParseUint(ctx.Params("version"), 10, 64)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid version", err.Error(), nil)
+	}
+	serverID := ctx.Params("server_id")
+	cluster, err := pkg.ClusterOfServer(serverID)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	name := fmt.Sprintf("revert %s to version %d by %s", serverID, version, ctx.Get("X-Request-User"))
+	if err := pkg.CheckRequestFreeze(ctx, []string{serverID}, "revert"); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	lease, err := pkg.LockServer(serverID, ctx.Get("X-Request-User"), "revert")
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	rec := pkg.NewGameOperation(serverID, "revert", pkg.RequestTrigger(ctx), ctx.Get("X-Request-User"), 0)
+	evalID, err := pkg.RevertGameJob(cluster, serverID, version, payload.SyncConfig)
+	if err != nil && evalID == "" {
+		pkg.FinishGameOperation(rec, "", err)
+		ntfy.PublishNotification(notify.EventTypeGameDeploy, name, nil, []string{serverID}, 0, 1)
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "revert job error", err.Error(), nil)
+	}
+	pkg.FinishGameOperation(rec, evalID, nil)
+	ntfy.PublishNotification(notify.EventTypeGameDeploy, name, []string{serverID}, nil, 1, 0)
+	data := fiber.Map{
+		"eval_id":       evalID,
+		"config_synced": payload.SyncConfig && err == nil,
+	}
+	if err != nil {
+		data["warning"] = err.Error()
+		data["config_conflict"] = errors.Is(err, pkg.ErrRevertConfigConflict)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", data)
+}
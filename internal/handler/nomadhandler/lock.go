@@ -0,0 +1,54 @@
+package nomadhandler
+
+import (
+	"errors"
+	"log/slog"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/tools/pkg"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// lockErrorResponse 加锁失败时的响应，被其他操作持有时返回409
+func lockErrorResponse(ctx fiber.Ctx, err error) error {
+	var conflict *pkg.LockConflictError
+	if errors.As(err, &conflict) {
+		return pkg.NewAppResponse(ctx, fiber.StatusConflict, 1, conflict.Error(), conflict.Error(), conflict.Info)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "acquire lock error", err.Error(), nil)
+}
+
+// Handler_ListLocks 展示当前的操作锁
+func (n *NomadHandler) Handler_ListLocks(ctx fiber.Ctx) error {
+	locks, err := pkg.ListLocks()
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list locks error", err.Error(), nil)
+	}
+	if locks == nil {
+		locks = []gameserver.LockInfo{}
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": locks,
+	})
+}
+
+// Handler_ForceReleaseLock 强制释放卡住的锁 "/lock/:kind/:id"，kind为server、channel或global
+func (n *NomadHandler) Handler_ForceReleaseLock(ctx fiber.Ctx) error {
+	kind, id := ctx.Params("kind"), ctx.Params("id")
+	if kind != gameserver.LockServer && kind != gameserver.LockChannel && kind != gameserver.LockGlobal {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid kind", "kind must be server, channel or global", nil)
+	}
+	operator := ctx.Get("X-Request-User")
+	if !pkg.UserHasPermission(operator, gameserver.ForceReleaseLockPermission) {
+		return pkg.NewAppResponse(ctx, fiber.StatusForbidden, 1, "permission denied", "force releasing a lock requires permission "+gameserver.ForceReleaseLockPermission, nil)
+	}
+	info, err := pkg.ForceReleaseLock(kind, id)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "release lock error", err.Error(), nil)
+	}
+	if info == nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "lock not found", "", nil)
+	}
+	slog.Warn("lock force released", "kind", kind, "id", id, "owner", info.Owner, "operation", info.Operation, "user", operator)
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", info)
+}
@@ -3,12 +3,17 @@ package nomadhandler
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"saurfang/internal/config"
 	"saurfang/internal/models/amis"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/models/autoscale"
 	"saurfang/internal/models/nomadjob"
 	"saurfang/internal/models/notify"
+	"saurfang/internal/models/operation"
 	"saurfang/internal/models/task"
 	"saurfang/internal/repository/base"
 	"saurfang/internal/tools"
@@ -40,9 +45,29 @@ func NewNomadHandler(cli *consulapi.Client, ns string) *NomadHandler {
 	}
 }
 
-// Handler_ListNomadNodes 列出所有node信息
+// forCluster 使用集群的nomad和consul，consul key前缀不变
+func (n *NomadHandler) forCluster(c *config.Cluster) *NomadHandler {
+	return &NomadHandler{
+		base.NomadJobRepository{Consul: c.Consul(), Nomad: c.Nomad(), Ns: n.Ns},
+	}
+}
+
+// clusterHandler 按请求参数cluster选择集群，为空时使用默认集群
+func (n *NomadHandler) clusterHandler(ctx fiber.Ctx) (*NomadHandler, error) {
+	c, err := config.GetCluster(ctx.Query("cluster"))
+	if err != nil {
+		return nil, err
+	}
+	return n.forCluster(c), nil
+}
+
+// Handler_ListNomadNodes 列出所有node信息 "?cluster=xxx"
 func (n *NomadHandler) Handler_ListNomadNodes(ctx fiber.Ctx) error {
-	nodes, _, err := config.NomadCli.Nodes().List(&nomadapi.QueryOptions{})
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	nodes, _, err := h.Nomad.Nodes().List(&nomadapi.QueryOptions{})
 	if err != nil {
 		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list nomad nodes error", err.Error(), nil)
 	}
@@ -51,7 +76,11 @@ func (n *NomadHandler) Handler_ListNomadNodes(ctx fiber.Ctx) error {
 
 // Handler_ListNomadNodesForSelect 选择nomad node key:name(status) value:name
 func (n *NomadHandler) Handler_ListNomadNodesForSelect(ctx fiber.Ctx) error {
-	nodes, _, err := n.Nomad.Nodes().List(&nomadapi.QueryOptions{})
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	nodes, _, err := h.Nomad.Nodes().List(&nomadapi.QueryOptions{})
 	if err != nil {
 		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list nomad nodes error", err.Error(), nil)
 	}
@@ -69,9 +98,12 @@ func (n *NomadHandler) Handler_ListNomadNodesForSelect(ctx fiber.Ctx) error {
 
 // Handler_ShowNomadJobs 展示Jobs的Group状态，变相等于进程状态
 func (n *NomadHandler) Handler_ShowNomadJobs(ctx fiber.Ctx) error {
-
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
 	jobType := ctx.Query("type", "")
-	data, err := n.ShowNomadJobGroups(jobType)
+	data, err := h.ShowNomadJobGroups(jobType)
 	if err != nil {
 		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "show job allocations error", err.Error(), nil)
 	}
@@ -93,7 +125,37 @@ func (n *NomadHandler) Handler_ScaleTaskGroup(ctx fiber.Ctx) error {
 	if err := ctx.Bind().Body(&payload); err != nil {
 		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
 	}
-	evalID, err := n.ScaleTaskGroup(jobID, payload.Target, ops)
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	if err := pkg.CheckRequestFreeze(ctx, []string{jobID}, "scale "+ops); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	lease, err := pkg.LockServer(jobID, ctx.Get("X-Request-User"), "scale "+ops)
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	from, _ := pkg.GroupCount(h.Nomad, jobID, payload.Target)
+	evalID, err := h.ScaleTaskGroup(jobID, payload.Target, ops)
+	if ops == "start" || ops == "stop" {
+		to := 0
+		if ops == "start" {
+			to = 1
+		}
+		pkg.RecordScalingEvent(&autoscale.ScalingEvent{
+			Cluster:  ctx.Query("cluster"),
+			JobID:    jobID,
+			Group:    payload.Target,
+			From:     from,
+			To:       to,
+			Source:   autoscale.SourceManual,
+			Reason:   ops,
+			EvalID:   evalID,
+			Operator: ctx.Get("X-Request-User"),
+		}, err)
+	}
 	if err != nil {
 		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "scale job group error", err.Error(), nil)
 	}
@@ -106,7 +168,11 @@ func (n *NomadHandler) Handler_ShowGroupSelect(ctx fiber.Ctx) error {
 	if jobID == "" {
 		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "job_id is required", "", nil)
 	}
-	data, err := n.ShowGroupsForSelect(jobID)
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	data, err := h.ShowGroupsForSelect(jobID)
 	if err != nil {
 		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "show group select error", err.Error(), nil)
 	}
@@ -115,21 +181,125 @@ func (n *NomadHandler) Handler_ShowGroupSelect(ctx fiber.Ctx) error {
 	})
 }
 
-// Handler_DeployNomadOpsJob 执行nomad 运维任务(开关)
+// opsRequest 开关服请求参数，审批通过后从保存的请求参数重新解析
+type opsRequest struct {
+	keys       []string
+	ops        string
+	taskName   string
+	signal     string
+	watch      bool
+	autoRevert bool
+	timeout    time.Duration
+	params     string
+	operator   string
+	trigger    string
+}
+
+// parseOpsRequest 解析开关服请求参数
+func parseOpsRequest(rawQuery, operator, trigger string) (*opsRequest, error) {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	req := &opsRequest{
+		ops:      query.Get("ops"),
+		taskName: query.Get("task"),
+		params:   rawQuery,
+		operator: operator,
+		trigger:  trigger,
+		timeout:  defaultDeploymentTimeout,
+	}
+	serverIDs := query.Get("server_ids")
+	if serverIDs == "" {
+		return nil, errors.New("server_ids is required")
+	}
+	if req.ops == "" {
+		return nil, errors.New("ops is required")
+	}
+	req.keys = strings.Split(serverIDs, ",")
+	// signal操作向运行中的分配发送信号，task为空时发送给所有task
+	if req.ops == task.ServerOpSignal {
+		if req.signal, err = pkg.NormalizeSignal(query.Get("signal")); err != nil {
+			return nil, fmt.Errorf("invalid signal: %v", err)
+		}
+	}
+	// start操作可以跟踪注册后的deployment，失败时按auto_revert回滚到上一个稳定版本
+	req.watch = req.ops == task.ServerOpStart && query.Get("watch") == "true"
+	req.autoRevert = query.Get("auto_revert") == "true"
+	if req.watch {
+		if req.timeout, err = deploymentTimeout(query.Get("timeout")); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// Handler_DeployNomadOpsJob 执行nomad 运维任务(开关)，命中审批策略时返回待审批变更
 func (n *NomadHandler) Handler_DeployNomadOpsJob(ctx fiber.Ctx) error {
-	n.setSSEHeaders(ctx)
-	serverIDs := ctx.Query("server_ids")
-	ops := ctx.Query("ops", "")
+	req, err := parseOpsRequest(string(ctx.Request().URI().QueryString()), ctx.Get("X-Request-User"), pkg.RequestTrigger(ctx))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid request", err.Error(), nil)
+	}
+	if err := pkg.CheckRequestFreeze(ctx, req.keys, "game "+req.ops); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	envs, err := pkg.ServerEnvironments(req.keys)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "resolve cluster of servers error", err.Error(), nil)
+	}
+	change, err := pkg.RequestApproval(ctx, approval.KindGameOps, "game "+req.ops, envs, req.keys)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "request approval error", err.Error(), nil)
+	}
+	if change != nil {
+		return pkg.ApprovalPending(ctx, change)
+	}
+	lease, err := pkg.LockServers(req.keys, req.operator, req.ops)
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	id, err := n.startOpsJob(req, lease)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "create operation error", err.Error(), nil)
+	}
+	return n.attachOperation(ctx, id)
+}
+
+// ExecuteOpsChange 审批通过后执行开关服
+func (n *NomadHandler) ExecuteOpsChange(change *approval.Change) (uint, error) {
+	req, err := parseOpsRequest(change.Params, change.Requester, change.Trigger)
+	if err != nil {
+		return 0, err
+	}
+	// 审批期间开始的冻结同样生效，申请时带有覆盖原因且申请人有覆盖权限时可以覆盖
+	if err := pkg.CheckFreeze(req.keys, "game "+req.ops, change.Requester, change.Override); err != nil {
+		return 0, err
+	}
+	lease, err := pkg.LockServers(req.keys, req.operator, req.ops)
+	if err != nil {
+		return 0, err
+	}
+	return n.startOpsJob(req, lease)
+}
+
+// startOpsJob 在后台执行开关服，结束后释放锁，返回操作id
+func (n *NomadHandler) startOpsJob(req *opsRequest, lease *pkg.Lease) (uint, error) {
+	keys, ops, taskName, signal := req.keys, req.ops, req.taskName, req.signal
+	watch, autoRevert, timeout := req.watch, req.autoRevert, req.timeout
 	var successCount, failCount int
 	var successJobs, failedJobs []string
-	// 消息通知
-	if serverIDs == "" {
-		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "server_ids is required", "", nil)
-	}
-	if ops == "" {
-		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "ops is required", "", nil)
+	run, err := pkg.StartOperation(&operation.Operation{
+		Kind:     operation.KindGameOps,
+		Action:   ops,
+		Targets:  strings.Join(keys, ","),
+		Params:   req.params,
+		Operator: req.operator,
+	})
+	if err != nil {
+		lease.Release()
+		return 0, err
 	}
-	keys := strings.Split(serverIDs, ",")
+	trigger, operator := req.trigger, req.operator
 	messageChan := make(chan string, 100)
 	var mu sync.Mutex
 	go func() {
@@ -141,10 +311,20 @@ func (n *NomadHandler) Handler_DeployNomadOpsJob(ctx fiber.Ctx) error {
 			}
 		}()
 		contents := make([]map[string]string, 0)
-		kv := n.Consul.KV()
+		handlers, err := n.serverHandlers(keys)
+		if err != nil {
+			messageChan <- fmt.Sprintf("data: [X] resolve cluster of servers failed. error: %v\n\n", err)
+			return
+		}
 		for _, key := range keys {
 			content := make(map[string]string)
-			pair, _, err := kv.Get(tools.AddNamespace(key, n.Ns), &consulapi.QueryOptions{})
+			h, ok := handlers[key]
+			if !ok {
+				messageChan <- fmt.Sprintf("data: [X] cluster of server is unavailable. id: %s\n\n", key)
+				n.recordFailedJob(&mu, &failCount, &failedJobs, key)
+				continue
+			}
+			pair, _, err := h.Consul.KV().Get(tools.AddNamespace(key, n.Ns), &consulapi.QueryOptions{})
 			if err != nil {
 				messageChan <- fmt.Sprintf("data: [X] search config file failed. id: %s\n\n", key)
 				n.recordFailedJob(&mu, &failCount, &failedJobs, key)
@@ -169,7 +349,7 @@ func (n *NomadHandler) Handler_DeployNomadOpsJob(ctx fiber.Ctx) error {
 		case "stop":
 			for _, content := range contents {
 				for k, v := range content {
-					job, err := n.Nomad.Jobs().ParseHCL(v, true)
+					job, err := handlers[k].Nomad.Jobs().ParseHCL(v, true)
 					if err != nil {
 						messageChan <- fmt.Sprintf("data: [X] parse job hcl config file failed. id: %s\n\n", k)
 						n.recordFailedJob(&mu, &failCount, &failedJobs, k)
@@ -178,7 +358,9 @@ func (n *NomadHandler) Handler_DeployNomadOpsJob(ctx fiber.Ctx) error {
 					wg.Add(1)
 					go func(id string, serverID string) {
 						defer wg.Done()
-						res, _, err := n.Nomad.Jobs().Deregister(id, false, nil)
+						rec := pkg.NewGameOperation(serverID, ops, trigger, operator, run.Op.ID)
+						res, _, err := handlers[serverID].Nomad.Jobs().Deregister(id, false, nil)
+						pkg.FinishGameOperation(rec, res, err)
 						if err != nil {
 							messageChan <- fmt.Sprintf("data: [X] stop job failed. key: %s job: %s, error: %v, message: %s\n\n", serverID, id, err, res)
 							n.recordFailedJob(&mu, &failCount, &failedJobs, k)
@@ -186,7 +368,7 @@ func (n *NomadHandler) Handler_DeployNomadOpsJob(ctx fiber.Ctx) error {
 						}
 						messageChan <- fmt.Sprintf("data: [√] stop job success. key: %s job: %s\n\n", serverID, id)
 						mu.Lock()
-						n.updateGameStatus(serverID, statusOffline)
+						n.updateGameStatus(serverID, statusOffline, "stop")
 						mu.Unlock()
 						n.recordSuccessJob(&mu, &successCount, &successJobs, k)
 					}(*job.ID, k)
@@ -195,27 +377,62 @@ func (n *NomadHandler) Handler_DeployNomadOpsJob(ctx fiber.Ctx) error {
 		case "start":
 			for _, content := range contents {
 				for k, v := range content {
-					job, err := n.Nomad.Jobs().ParseHCL(v, true)
+					job, err := handlers[k].Nomad.Jobs().ParseHCL(v, true)
 					if err != nil {
 						messageChan <- fmt.Sprintf("data: [X] parse job hcl config file failed. id: %s\n\n", k)
 						n.recordFailedJob(&mu, &failCount, &failedJobs, k)
 						continue
 					}
 					wg.Add(1)
-					go func(j *nomadapi.Job, serverID string) {
+					go func(j *nomadapi.Job, serverID, source string) {
 						defer wg.Done()
-						res, _, err := n.Nomad.Jobs().Register(j, nil)
+						rec := pkg.NewGameOperation(serverID, ops, trigger, operator, run.Op.ID)
+						res, err := pkg.RegisterJobWithSource(handlers[serverID].Nomad, j, source)
 						if err != nil {
+							pkg.FinishGameOperation(rec, "", err)
 							messageChan <- fmt.Sprintf("data: [X] deploy job failed. key: %s job: %s, error: %v\n\n", serverID, *j.ID, err)
 							n.recordFailedJob(&mu, &failCount, &failedJobs, k)
 							return
 						}
 						messageChan <- fmt.Sprintf("data: [√] deploy job success. key: %s job: %s, evalID: %s\n\n", serverID, *j.ID, res.EvalID)
+						if watch && !followEvalDeployment(handlers[serverID].Nomad, serverID, res.EvalID, autoRevert, timeout, messageChan) {
+							pkg.FinishGameOperation(rec, res.EvalID, errors.New("deployment did not succeed"))
+							n.recordFailedJob(&mu, &failCount, &failedJobs, serverID)
+							return
+						}
+						pkg.FinishGameOperation(rec, res.EvalID, nil)
 						mu.Lock()
-						n.updateGameStatus(serverID, statusOnline)
+						n.updateGameStatus(serverID, statusOnline, "start")
 						mu.Unlock()
 						n.recordSuccessJob(&mu, &successCount, &successJobs, k)
-					}(job, k)
+					}(job, k, v)
+				}
+			}
+		case task.ServerOpSignal:
+			for _, content := range contents {
+				for k, v := range content {
+					job, err := handlers[k].Nomad.Jobs().ParseHCL(v, true)
+					if err != nil {
+						messageChan <- fmt.Sprintf("data: [X] parse job hcl config file failed. id: %s\n\n", k)
+						n.recordFailedJob(&mu, &failCount, &failedJobs, k)
+						continue
+					}
+					wg.Add(1)
+					go func(id string, serverID string) {
+						defer wg.Done()
+						rec := pkg.NewGameOperation(serverID, ops, trigger, operator, run.Op.ID)
+						allocs, err := pkg.SignalJobAllocations(handlers[serverID].Nomad, id, taskName, signal)
+						pkg.FinishGameOperation(rec, "", err)
+						for _, alloc := range allocs {
+							messageChan <- fmt.Sprintf("data: [√] send %s success. key: %s job: %s alloc: %s\n\n", signal, serverID, id, alloc)
+						}
+						if err != nil {
+							messageChan <- fmt.Sprintf("data: [X] send %s failed. key: %s job: %s, error: %v\n\n", signal, serverID, id, err)
+							n.recordFailedJob(&mu, &failCount, &failedJobs, serverID)
+							return
+						}
+						n.recordSuccessJob(&mu, &successCount, &successJobs, serverID)
+					}(*job.ID, k)
 				}
 			}
 		default:
@@ -225,27 +442,16 @@ func (n *NomadHandler) Handler_DeployNomadOpsJob(ctx fiber.Ctx) error {
 		ntfy.PublishNotification(notify.EventTypeGameOps, fmt.Sprintf("game %s", ops), successJobs, failedJobs, successCount, failCount)
 		messageChan <- "data: [√] All operations completed\n\n"
 	}()
-	// 实时发送消息到客户端
-	for message := range messageChan {
-		if _, err := ctx.Write([]byte(message)); err != nil {
-			slog.Error("Failed to write SSE message", "error", err)
-			break
-		}
-		// 强制刷新缓冲区
-		if flusher, ok := ctx.Response().BodyWriter().(interface{ Flush() error }); ok {
-			if err := flusher.Flush(); err != nil {
-				slog.Error("Failed to flush response", "error", err)
-				break
-			}
-		}
-	}
-
-	return nil
+	// 操作在后台执行，消息保存为操作日志，客户端断开后不影响执行，结束后释放锁
+	go func() {
+		defer lease.Release()
+		run.Consume(messageChan, func() ([]string, []string) { return successJobs, failedJobs })
+	}()
+	return run.Op.ID, nil
 }
 
 // Handler_DeployNomadJob 执行nomad一次性任务dispatch
 func (n *NomadHandler) Handler_DeployNomadJob(ctx fiber.Ctx) error {
-	n.setSSEHeaders(ctx)
 	var mu sync.Mutex
 	serverIDs := ctx.Query("server_ids")
 	keys := strings.Split(serverIDs, ",")
@@ -255,6 +461,25 @@ func (n *NomadHandler) Handler_DeployNomadJob(ctx fiber.Ctx) error {
 	if len(keys) > 200 {
 		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "server_ids is too many", "", nil)
 	}
+	if err := pkg.CheckRequestFreeze(ctx, keys, "dispatch"); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	lease, err := pkg.LockServers(keys, ctx.Get("X-Request-User"), "dispatch")
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	run, err := pkg.StartOperation(&operation.Operation{
+		Kind:     operation.KindGameDispatch,
+		Action:   "dispatch",
+		Targets:  serverIDs,
+		Params:   string(ctx.Request().URI().QueryString()),
+		Operator: ctx.Get("X-Request-User"),
+	})
+	if err != nil {
+		lease.Release()
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "create operation error", err.Error(), nil)
+	}
+	trigger, operator := pkg.RequestTrigger(ctx), ctx.Get("X-Request-User")
 	var successCount, failCount int
 	var successJobs, failedJobs []string
 	messageChan := make(chan string, 200)
@@ -263,11 +488,21 @@ func (n *NomadHandler) Handler_DeployNomadJob(ctx fiber.Ctx) error {
 		//defer writer.Close()
 		defer close(messageChan)
 		contents := make([]map[string]string, 0)
-		kv := n.Consul.KV()
-		// 获取配置内容
+		handlers, err := n.serverHandlers(keys)
+		if err != nil {
+			messageChan <- fmt.Sprintf("data: [X] resolve cluster of servers failed. error: %v\n\n", err)
+			return
+		}
+		// 获取配置内容，从游戏服所属集群读取
 		for _, key := range keys {
 			content := make(map[string]string)
-			pair, _, err := kv.Get(tools.AddNamespace(key, n.Ns), nil)
+			h, ok := handlers[key]
+			if !ok {
+				messageChan <- fmt.Sprintf("data: [X] cluster of server is unavailable. id: %s\n\n", key)
+				n.recordFailedJob(&mu, &failCount, &failedJobs, key)
+				continue
+			}
+			pair, _, err := h.Consul.KV().Get(tools.AddNamespace(key, n.Ns), nil)
 			if err != nil {
 				messageChan <- fmt.Sprintf("data: [X] search config file failed. id: %s\n\n", key)
 				n.recordFailedJob(&mu, &failCount, &failedJobs, key)
@@ -288,7 +523,7 @@ func (n *NomadHandler) Handler_DeployNomadJob(ctx fiber.Ctx) error {
 		var wg sync.WaitGroup
 		for _, content := range contents {
 			for k, v := range content {
-				job, err := n.Nomad.Jobs().ParseHCL(v, true)
+				job, err := handlers[k].Nomad.Jobs().ParseHCL(v, true)
 				if err != nil {
 					messageChan <- fmt.Sprintf("data: [X] parse job hcl config file failed. id: %s\n\n", k)
 					n.recordFailedJob(&mu, &failCount, &failedJobs, k)
@@ -297,8 +532,11 @@ func (n *NomadHandler) Handler_DeployNomadJob(ctx fiber.Ctx) error {
 				wg.Add(1)
 				go func(j *nomadapi.Job, serverID string) {
 					defer wg.Done()
-					_, _, err := n.Nomad.Jobs().Register(j, nil)
+					client := handlers[serverID].Nomad
+					rec := pkg.NewGameOperation(serverID, "dispatch", trigger, operator, run.Op.ID)
+					_, _, err := client.Jobs().Register(j, nil)
 					if err != nil {
+						pkg.FinishGameOperation(rec, "", err)
 						messageChan <- fmt.Sprintf("data: [X] register dispatch job failed. key: %s job: %s, error: %v\n\n", serverID, *j.ID, err)
 						n.recordFailedJob(&mu, &failCount, &failedJobs, k)
 
@@ -306,14 +544,16 @@ func (n *NomadHandler) Handler_DeployNomadJob(ctx fiber.Ctx) error {
 					}
 					meta := make(map[string]string)
 					meta["EXEC_TIME"] = time.Now().String()
-					res, _, err := n.Nomad.Jobs().Dispatch(*j.ID, meta, []byte(time.Now().String()), "", nil)
+					res, _, err := client.Jobs().Dispatch(*j.ID, meta, []byte(time.Now().String()), "", nil)
 					if err != nil {
+						pkg.FinishGameOperation(rec, "", err)
 						messageChan <- fmt.Sprintf("data: [X] deploy job failed. key: %s job: %s, error: %v\n\n", serverID, *j.ID, err)
 						n.recordFailedJob(&mu, &failCount, &failedJobs, k)
-						waitEvalCompletion(n.Nomad, serverID, res.EvalID, 120*time.Second, messageChan, results)
+						waitEvalCompletion(client, serverID, res.EvalID, 120*time.Second, messageChan, results)
 						return
 					}
-					waitEvalCompletion(n.Nomad, serverID, res.EvalID, 120*time.Second, messageChan, results)
+					waitEvalCompletion(client, serverID, res.EvalID, 120*time.Second, messageChan, results)
+					pkg.FinishGameOperation(rec, res.EvalID, nil)
 					n.recordSuccessJob(&mu, &successCount, &successJobs, k)
 					messageChan <- fmt.Sprintf("data: [√] deploy job success. key: %s job: %s, evalID: %s\n\n", serverID, *j.ID, res.EvalID)
 				}(job, k)
@@ -328,46 +568,123 @@ func (n *NomadHandler) Handler_DeployNomadJob(ctx fiber.Ctx) error {
 		drawTable(results, headers, messageChan)
 		ntfy.PublishNotification(notify.EventTypeGameDeploy, fmt.Sprintln("deploy game server"), successJobs, failedJobs, successCount, failCount)
 	}()
-	//return ctx.SendStream(reader)
-	// 实时发送消息到客户端
-	for message := range messageChan {
-		if _, err := ctx.Write([]byte(message)); err != nil {
-			slog.Error("Failed to write SSE message", "error", err)
-			break
-		}
-		if flusher, ok := ctx.Response().BodyWriter().(interface{ Flush() error }); ok {
-			if err := flusher.Flush(); err != nil {
-				slog.Error("Failed to flush response", "error", err)
-				break
-			}
-		}
+	go func() {
+		defer lease.Release()
+		run.Consume(messageChan, func() ([]string, []string) { return successJobs, failedJobs })
+	}()
+	return n.attachOperation(ctx, run.Op.ID)
+}
+
+// attachOperation 以SSE输出操作日志，detach=true时只返回操作id，之后通过操作接口订阅
+func (n *NomadHandler) attachOperation(ctx fiber.Ctx, id uint) error {
+	if ctx.Query("detach") == "true" {
+		return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+			"operation_id": id,
+		})
 	}
-	return nil
+	n.setSSEHeaders(ctx)
+	return pkg.StreamOperation(ctx, id, 0)
 }
 
-// Handler_PurgeNomadJob 清除nomad job
+// Handler_PurgeNomadJob 清除nomad job "?job_ids=a,b&cluster=xxx"，命中审批策略时返回待审批变更
 func (n *NomadHandler) Handler_PurgeNomadJob(ctx fiber.Ctx) error {
 	ids := ctx.Query("job_ids")
 	if ids == "" {
 		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "job_ids is required", "", nil)
 	}
+	c, err := config.GetCluster(ctx.Query("cluster"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	jobIDs := strings.Split(ids, ",")
+	if err := pkg.CheckRequestFreeze(ctx, jobIDs, "purge"); err != nil {
+		return pkg.RefuseFrozen(ctx, err)
+	}
+	change, err := pkg.RequestApproval(ctx, approval.KindPurgeJob, "purge", []string{c.Name}, jobIDs)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "request approval error", err.Error(), nil)
+	}
+	if change != nil {
+		return pkg.ApprovalPending(ctx, change)
+	}
+	lease, err := pkg.LockServers(jobIDs, ctx.Get("X-Request-User"), "purge")
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	n.forCluster(c).purgeJobs(jobIDs)
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// ExecutePurgeChange 审批通过后清除nomad job
+func (n *NomadHandler) ExecutePurgeChange(change *approval.Change) (uint, error) {
+	query, err := url.ParseQuery(change.Params)
+	if err != nil {
+		return 0, err
+	}
+	c, err := config.GetCluster(query.Get("cluster"))
+	if err != nil {
+		return 0, err
+	}
+	// 审批期间开始的冻结同样生效，申请时带有覆盖原因且申请人有覆盖权限时可以覆盖
+	if err := pkg.CheckFreeze(change.Targets, "purge", change.Requester, change.Override); err != nil {
+		return 0, err
+	}
+	lease, err := pkg.LockServers(change.Targets, change.Requester, "purge")
+	if err != nil {
+		return 0, err
+	}
+	defer lease.Release()
+	if failed := n.forCluster(c).purgeJobs(change.Targets); len(failed) > 0 {
+		return 0, fmt.Errorf("purge jobs failed: %s", strings.Join(failed, ","))
+	}
+	return 0, nil
+}
+
+// purgeJobs 并发清除job，返回失败的job
+func (n *NomadHandler) purgeJobs(ids []string) []string {
 	var wg sync.WaitGroup
-	for _, id := range strings.Split(ids, ",") {
+	var mu sync.Mutex
+	var failed []string
+	for _, id := range ids {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			res, _, err := n.Nomad.Jobs().Deregister(id, true, nil)
 			if err != nil {
 				slog.Error("purge job failed", "id", id, "err", err, "message", res)
+				mu.Lock()
+				failed = append(failed, id)
+				mu.Unlock()
 				return
 			}
 		}()
 	}
 	wg.Wait()
-	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", nil)
+	return failed
 }
 
-// waitEvalCompletion 轮询eval状态
+// serverHandlers 按游戏服所属集群生成handler，集群不可用的游戏服不在结果中
+func (n *NomadHandler) serverHandlers(serverIDs []string) (map[string]*NomadHandler, error) {
+	clusters, errs, err := pkg.ClustersOfServers(serverIDs)
+	if err != nil {
+		return nil, err
+	}
+	handlers := make(map[string]*NomadHandler, len(clusters))
+	byCluster := make(map[string]*NomadHandler)
+	for serverID, c := range clusters {
+		if _, ok := byCluster[c.Name]; !ok {
+			byCluster[c.Name] = n.forCluster(c)
+		}
+		handlers[serverID] = byCluster[c.Name]
+	}
+	for serverID, err := range errs {
+		slog.Warn("cluster of server is unavailable", "server_id", serverID, "error", err)
+	}
+	return handlers, nil
+}
+
+// waitEvalCompletion 等待eval结束，事件流已连接时由eval事件触发查询，否则轮询
 func waitEvalCompletion(client *nomadapi.Client, serverID, evalID string, timeout time.Duration, messageChan chan string, ch chan task.JobResult) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -376,13 +693,15 @@ func waitEvalCompletion(client *nomadapi.Client, serverID, evalID string, timeou
 	}()
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	wake := pkg.NomadEventWakeups(ctx, func(e *nomadjob.NomadEvent) bool {
+		return e.EvalID == evalID
+	}, 2*time.Second)
 	for {
 		select {
 		case <-ctx.Done():
 			messageChan <- fmt.Sprintf("data: timeout waiting for eval %s to complete,serverID: %s\n\n", evalID, serverID)
-		case <-ticker.C:
+			return
+		case <-wake:
 			eval, _, err := client.Evaluations().Info(evalID, nil)
 			if err != nil {
 				messageChan <- fmt.Sprintf("data: failed to get job eval info. serverID: %s, error: %v\n\n", serverID, err)
@@ -441,9 +760,9 @@ func (n *NomadHandler) setSSEHeaders(ctx fiber.Ctx) {
 	ctx.Set("Access-Control-Allow-Headers", "Cache-Control")
 }
 
-// updateGameStatus 更新游戏状态
-func (n *NomadHandler) updateGameStatus(serverID string, status int) error {
-	return config.DB.Exec("UPDATE games set status = ? where server_id = ?;", status, serverID).Error
+// updateGameStatus 更新游戏状态并记录变化原因
+func (n *NomadHandler) updateGameStatus(serverID string, status int, reason string) error {
+	return pkg.SetGameStatus(serverID, status, reason)
 }
 
 // recordFailedJob 记录失败的任务
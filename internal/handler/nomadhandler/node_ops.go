@@ -0,0 +1,204 @@
+package nomadhandler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"saurfang/internal/tools/pkg"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// nodeGameServers 查询节点上的游戏服，查询失败时只记录日志，不阻止操作
+func nodeGameServers(h *NomadHandler, nodeID string) []string {
+	servers, err := pkg.NodeGameServers(h.Nomad, nodeID)
+	if err != nil {
+		slog.Warn("failed to list game servers on node", "node_id", nodeID, "error", err)
+		return []string{}
+	}
+	return servers
+}
+
+// Handler_ShowNodeGameServers 展示节点上的游戏服，维护前确认影响范围 "/node/:node_id/servers?cluster=xxx"
+func (n *NomadHandler) Handler_ShowNodeGameServers(ctx fiber.Ctx) error {
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	servers, err := pkg.NodeGameServers(h.Nomad, ctx.Params("node_id"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list node game servers error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items":   servers,
+		"warning": pkg.NodeWarning(servers),
+	})
+}
+
+// Handler_ToggleNodeEligibility 设置节点是否可以调度新的分配 "/node/:node_id/eligibility"
+func (n *NomadHandler) Handler_ToggleNodeEligibility(ctx fiber.Ctx) error {
+	var payload nomadjob.EligibilityPayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	nodeID := ctx.Params("node_id")
+	servers := nodeGameServers(h, nodeID)
+	if _, err := h.Nomad.Nodes().ToggleEligibility(nodeID, payload.Eligible, nil); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "toggle node eligibility error", err.Error(), nil)
+	}
+	slog.Info("node eligibility changed", "node_id", nodeID, "eligible", payload.Eligible, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"game_servers": servers,
+		"warning":      pkg.NodeWarning(servers),
+	})
+}
+
+// Handler_DrainNode 开始排空节点，排空期间节点不可调度 "/node/:node_id/drain"
+func (n *NomadHandler) Handler_DrainNode(ctx fiber.Ctx) error {
+	var payload nomadjob.DrainPayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	spec, err := pkg.BuildDrainSpec(&payload)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid drain options", err.Error(), nil)
+	}
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	nodeID := ctx.Params("node_id")
+	servers := nodeGameServers(h, nodeID)
+	// 开始排空时锁定节点上的游戏服，避免和这些游戏服上进行中的操作同时执行
+	lease, err := pkg.LockServers(servers, ctx.Get("X-Request-User"), "drain node "+nodeID)
+	if err != nil {
+		return lockErrorResponse(ctx, err)
+	}
+	defer lease.Release()
+	resp, err := h.Nomad.Nodes().UpdateDrainOpts(nodeID, &nomadapi.DrainOptions{DrainSpec: spec}, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "drain node error", err.Error(), nil)
+	}
+	ntfy.PublishNotification(notify.EventTypeNodeOps, fmt.Sprintf("drain node %s by %s", nodeID, ctx.Get("X-Request-User")), servers, nil, len(servers), 0)
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"eval_ids":     resp.EvalIDs,
+		"deadline":     spec.Deadline.String(),
+		"game_servers": servers,
+		"warning":      pkg.NodeWarning(servers),
+	})
+}
+
+// Handler_CancelNodeDrain 取消排空 "/node/:node_id/drain?eligible=true"，eligible为true时同时恢复调度
+func (n *NomadHandler) Handler_CancelNodeDrain(ctx fiber.Ctx) error {
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	nodeID := ctx.Params("node_id")
+	servers := nodeGameServers(h, nodeID)
+	markEligible := ctx.Query("eligible") == "true"
+	if _, err := h.Nomad.Nodes().UpdateDrainOpts(nodeID, &nomadapi.DrainOptions{MarkEligible: markEligible}, nil); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "cancel node drain error", err.Error(), nil)
+	}
+	slog.Info("node drain cancelled", "node_id", nodeID, "eligible", markEligible, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"game_servers": servers,
+		"warning":      pkg.NodeWarning(servers),
+	})
+}
+
+// Handler_MonitorNodeDrain 通过SSE推送排空进度，排空完成后结束 "/node/:node_id/drain/monitor"
+func (n *NomadHandler) Handler_MonitorNodeDrain(ctx fiber.Ctx) error {
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	n.setSSEHeaders(ctx)
+	nodeID := strings.Clone(ctx.Params("node_id"))
+	ignoreSys := ctx.Query("ignore_system_jobs") == "true"
+	warning := pkg.NodeWarning(nodeGameServers(h, nodeID))
+	// 流式写入在handler返回后执行，写入失败说明客户端已断开，取消排空监控
+	return ctx.SendStreamWriter(func(w *bufio.Writer) {
+		monitorCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		write := func(message string) bool {
+			if _, err := w.WriteString(message); err != nil {
+				slog.Error("Failed to write SSE message", "error", err)
+				return false
+			}
+			if err := w.Flush(); err != nil {
+				slog.Error("Failed to flush response", "error", err)
+				return false
+			}
+			return true
+		}
+		if warning != "" {
+			if !write(fmt.Sprintf("data: [!] %s\n\n", warning)) {
+				return
+			}
+		}
+		for msg := range h.Nomad.Nodes().MonitorDrain(monitorCtx, nodeID, 0, ignoreSys) {
+			prefix := "[√]"
+			switch msg.Level {
+			case nomadapi.MonitorMsgLevelWarn:
+				prefix = "[!]"
+			case nomadapi.MonitorMsgLevelError:
+				prefix = "[X]"
+			}
+			if !write(fmt.Sprintf("data: %s %s\n\n", prefix, msg.Message)) {
+				return
+			}
+		}
+		write("data: [√] drain monitor finished\n\n")
+	})
+}
+
+// Handler_ShowNodeMeta 展示节点元数据，dynamic为可修改部分 "/node/:node_id/meta"
+func (n *NomadHandler) Handler_ShowNodeMeta(ctx fiber.Ctx) error {
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	meta, err := h.Nomad.Nodes().Meta().Read(ctx.Params("node_id"), nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "read node meta error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", meta)
+}
+
+// Handler_UpdateNodeMeta 修改节点动态元数据，值为null时删除 "/node/:node_id/meta"
+func (n *NomadHandler) Handler_UpdateNodeMeta(ctx fiber.Ctx) error {
+	var payload nomadjob.NodeMetaPayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	if len(payload.Meta) == 0 {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "meta is required", "", nil)
+	}
+	h, err := n.clusterHandler(ctx)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid cluster", err.Error(), nil)
+	}
+	nodeID := ctx.Params("node_id")
+	servers := nodeGameServers(h, nodeID)
+	meta, err := h.Nomad.Nodes().Meta().Apply(&nomadapi.NodeMetaApplyRequest{NodeID: nodeID, Meta: payload.Meta}, nil)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "update node meta error", err.Error(), nil)
+	}
+	slog.Info("node meta updated", "node_id", nodeID, "user", ctx.Get("X-Request-User"))
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"meta":         meta,
+		"game_servers": servers,
+		"warning":      pkg.NodeWarning(servers),
+	})
+}
@@ -0,0 +1,312 @@
+package taskhandler
+
+import (
+	"errors"
+	"html/template"
+	"path"
+	"saurfang/internal/config"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+type ApprovalHandler struct {
+	base.BaseGormRepository[approval.Change]
+}
+
+func NewApprovalHandler() *ApprovalHandler {
+	return &ApprovalHandler{
+		BaseGormRepository: base.BaseGormRepository[approval.Change]{DB: config.DB},
+	}
+}
+
+// DecisionPayload 审批意见
+type DecisionPayload struct {
+	Comment string `json:"comment"`
+}
+
+// validatePolicy 校验审批策略
+func validatePolicy(p *approval.Policy) error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	if p.Action == "" {
+		return errors.New("action is required")
+	}
+	if _, err := path.Match(p.Action, ""); err != nil {
+		return errors.New("invalid action pattern")
+	}
+	if p.MinTargets < 0 || p.ExpireMinutes < 0 {
+		return errors.New("min_targets and expire_minutes must not be negative")
+	}
+	return nil
+}
+
+// canManagePolicy 审批策略决定哪些操作需要谁审批，修改需要审批权限
+func canManagePolicy(ctx fiber.Ctx) bool {
+	return pkg.UserHasPermission(ctx.Get("X-Request-User"), approval.ApprovePermission)
+}
+
+func refusePolicyChange(ctx fiber.Ctx) error {
+	return pkg.NewAppResponse(ctx, fiber.StatusForbidden, 1, "permission denied", "changing approval policies requires permission "+approval.ApprovePermission, nil)
+}
+
+// Handler_ListPolicies 展示审批策略
+func (a *ApprovalHandler) Handler_ListPolicies(ctx fiber.Ctx) error {
+	var policies []approval.Policy
+	if err := config.DB.Order("id").Find(&policies).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list approval policies error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": policies,
+	})
+}
+
+// Handler_CreatePolicy 创建审批策略
+func (a *ApprovalHandler) Handler_CreatePolicy(ctx fiber.Ctx) error {
+	if !canManagePolicy(ctx) {
+		return refusePolicyChange(ctx)
+	}
+	var p approval.Policy
+	if err := ctx.Bind().Body(&p); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	p.ID = 0
+	if err := validatePolicy(&p); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid approval policy", err.Error(), nil)
+	}
+	if err := config.DB.Create(&p).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "create approval policy error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", p)
+}
+
+// Handler_UpdatePolicy 修改审批策略 "/approval/policy/:id"
+func (a *ApprovalHandler) Handler_UpdatePolicy(ctx fiber.Ctx) error {
+	if !canManagePolicy(ctx) {
+		return refusePolicyChange(ctx)
+	}
+	var old approval.Policy
+	if err := config.DB.First(&old, ctx.Params("id")).Error; err != nil {
+		return approvalNotFound(ctx, err)
+	}
+	var p approval.Policy
+	if err := ctx.Bind().Body(&p); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	p.ID, p.CreatedAt = old.ID, old.CreatedAt
+	if err := validatePolicy(&p); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid approval policy", err.Error(), nil)
+	}
+	if err := config.DB.Save(&p).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "update approval policy error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", p)
+}
+
+// Handler_DeletePolicy 删除审批策略 "/approval/policy/:id"
+func (a *ApprovalHandler) Handler_DeletePolicy(ctx fiber.Ctx) error {
+	if !canManagePolicy(ctx) {
+		return refusePolicyChange(ctx)
+	}
+	res := config.DB.Delete(&approval.Policy{}, ctx.Params("id"))
+	if res.Error != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "delete approval policy error", res.Error.Error(), nil)
+	}
+	if res.RowsAffected == 0 {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "approval policy not found", "", nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_ListChanges 展示变更 "?page=1&perPage=10&status=pending&requester=xxx"
+func (a *ApprovalHandler) Handler_ListChanges(ctx fiber.Ctx) error {
+	page, err := strconv.Atoi(ctx.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(ctx.Query("perPage"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	query := config.DB.Model(&approval.Change{})
+	for _, field := range []string{"status", "requester", "kind"} {
+		if v := ctx.Query(field); v != "" {
+			query = query.Where(field+" = ?", v)
+		}
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "count changes error", err.Error(), nil)
+	}
+	var changes []approval.Change
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&changes).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list changes error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": changes,
+		"total": total,
+	})
+}
+
+// Handler_ShowChange 展示变更详情 "/approval/change/:id"
+func (a *ApprovalHandler) Handler_ShowChange(ctx fiber.Ctx) error {
+	var change approval.Change
+	if err := config.DB.First(&change, ctx.Params("id")).Error; err != nil {
+		return approvalNotFound(ctx, err)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", change)
+}
+
+// Handler_ApproveChange 审批通过，变更随即执行 "/approval/change/:id/approve"
+func (a *ApprovalHandler) Handler_ApproveChange(ctx fiber.Ctx) error {
+	return a.decide(ctx, pkg.ApproveChange)
+}
+
+// Handler_RejectChange 拒绝变更 "/approval/change/:id/reject"
+func (a *ApprovalHandler) Handler_RejectChange(ctx fiber.Ctx) error {
+	return a.decide(ctx, pkg.RejectChange)
+}
+
+// Handler_CancelChange 申请人取消变更 "/approval/change/:id/cancel"
+func (a *ApprovalHandler) Handler_CancelChange(ctx fiber.Ctx) error {
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid id", err.Error(), nil)
+	}
+	change, err := pkg.CancelChange(uint(id), pkg.RequestUserID(ctx))
+	if err != nil {
+		return decisionError(ctx, change, err)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", change)
+}
+
+// decisionPage 审批链接的确认页面，链接预览或爬虫的GET请求不会执行审批
+var decisionPage = template.Must(template.New("decision").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>变更审批 #{{.Change.ID}}</title></head>
+<body>
+<h3>变更审批 #{{.Change.ID}} {{.Change.Action}}</h3>
+<p>申请人: {{.Change.Requester}}</p>
+<p>目标: {{range $i, $t := .Change.Targets}}{{if $i}}, {{end}}{{$t}}{{end}}</p>
+{{if .Change.Reason}}<p>原因: {{.Change.Reason}}</p>{{end}}
+<p>状态: {{.Change.Status}}</p>
+<form method="post" action="{{.Action}}">
+<p><textarea name="comment" rows="3" cols="50" placeholder="审批意见"></textarea></p>
+<button type="submit">以 {{.Approver}} 的身份{{if eq .Decision "approve"}}通过{{else}}拒绝{{end}}</button>
+</form>
+</body>
+</html>`))
+
+// linkDecision 校验审批链接，返回变更id、审批函数和审批人，校验失败时已写入响应
+func linkDecision(ctx fiber.Ctx) (uint, func(uint, string, uint, string) (*approval.Change, error), string, error) {
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return 0, nil, "", pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid id", err.Error(), nil)
+	}
+	decide := pkg.ApproveChange
+	switch ctx.Params("action") {
+	case "approve":
+	case "reject":
+		decide = pkg.RejectChange
+	default:
+		return 0, nil, "", pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid action", "action must be approve or reject", nil)
+	}
+	approver, err := pkg.VerifyApprovalLink(uint(id), ctx.Params("action"), ctx.Query("approver"), ctx.Query("expires"), ctx.Query("sign"))
+	if err != nil {
+		return 0, nil, "", pkg.NewAppResponse(ctx, fiber.StatusForbidden, 1, err.Error(), "", nil)
+	}
+	return uint(id), decide, approver, nil
+}
+
+// Handler_ShowDecisionLink 通知中的审批链接，展示变更并由审批人确认后提交 "/approval/:id/:action?approver=xxx&expires=xxx&sign=xxx"
+func (a *ApprovalHandler) Handler_ShowDecisionLink(ctx fiber.Ctx) error {
+	id, decide, approver, err := linkDecision(ctx)
+	if decide == nil {
+		return err
+	}
+	var change approval.Change
+	if err := config.DB.First(&change, id).Error; err != nil {
+		return approvalNotFound(ctx, err)
+	}
+	var b strings.Builder
+	if err := decisionPage.Execute(&b, map[string]any{
+		"Change":   change,
+		"Approver": approver,
+		"Decision": ctx.Params("action"),
+		"Action":   ctx.OriginalURL(),
+	}); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "render approval page error", err.Error(), nil)
+	}
+	ctx.Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Set("Cache-Control", "no-store")
+	return ctx.SendString(b.String())
+}
+
+// Handler_DecideByLink 确认页面提交的审批，由链接签名确定审批人 "/approval/:id/:action?approver=xxx&expires=xxx&sign=xxx"
+func (a *ApprovalHandler) Handler_DecideByLink(ctx fiber.Ctx) error {
+	id, decide, approver, err := linkDecision(ctx)
+	if decide == nil {
+		return err
+	}
+	comment := strings.TrimSpace(ctx.FormValue("comment"))
+	if comment == "" {
+		comment = "via notification link"
+	}
+	// 链接中的审批人为用户名，按用户ID判断是否为申请人
+	approverID, err := pkg.UserIDOfUsername(approver)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusForbidden, 1, pkg.ErrApprovalForbidden.Error(), "approver of the link does not exist", nil)
+	}
+	change, err := decide(id, approver, approverID, comment)
+	if err != nil {
+		return decisionError(ctx, change, err)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", change)
+}
+
+// decide 按请求的操作人审批
+func (a *ApprovalHandler) decide(ctx fiber.Ctx, decide func(uint, string, uint, string) (*approval.Change, error)) error {
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid id", err.Error(), nil)
+	}
+	var payload DecisionPayload
+	if len(ctx.Body()) > 0 {
+		if err := ctx.Bind().Body(&payload); err != nil {
+			return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+		}
+	}
+	change, err := decide(uint(id), ctx.Get("X-Request-User"), pkg.RequestUserID(ctx), payload.Comment)
+	if err != nil {
+		return decisionError(ctx, change, err)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", change)
+}
+
+// decisionError 审批失败的响应
+func decisionError(ctx fiber.Ctx, change *approval.Change, err error) error {
+	switch {
+	case errors.Is(err, pkg.ErrChangeNotPending):
+		return pkg.NewAppResponse(ctx, fiber.StatusConflict, 1, err.Error(), change.Status, change)
+	case errors.Is(err, pkg.ErrApprovalForbidden):
+		return pkg.NewAppResponse(ctx, fiber.StatusForbidden, 1, err.Error(), "only approvers other than the requester can decide", nil)
+	}
+	return approvalNotFound(ctx, err)
+}
+
+// approvalNotFound 记录不存在时返回404
+func approvalNotFound(ctx fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "record not found", "", nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "approval error", err.Error(), nil)
+}
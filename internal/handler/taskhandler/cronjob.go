@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"saurfang/internal/config"
 	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/gmcommand"
 	"saurfang/internal/models/task"
 	"saurfang/internal/repository/base"
 	"saurfang/internal/tools/pkg"
@@ -36,7 +37,7 @@ func (j *CronjobHandler) Handler_CreateCronjobTask(c fiber.Ctx) error {
 	}
 
 	// 根据任务类型进行验证
-	if err := j.validateTaskPayload(payload); err != nil {
+	if err := j.validateTaskPayload(payload, c.Get("X-Request-User")); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "创建计划任务失败", err.Error(), nil)
 	}
 
@@ -52,7 +53,15 @@ func (j *CronjobHandler) Handler_CreateCronjobTask(c fiber.Ctx) error {
 	case task.TaskTypeServer:
 		cronJob.ServerIDs = strings.Join(payload.ServerIDs, ",")
 		cronJob.ServerOperation = payload.ServerOperation
+		cronJob.Signal, _ = pkg.NormalizeSignal(payload.Signal) // 非signal操作时为空
+		cronJob.SignalTask = payload.SignalTask
+	case task.TaskTypeGM:
+		params, _ := json.Marshal(payload.GMParams)
+		cronJob.GMTemplateID = payload.GMTemplateID
+		cronJob.ServerIDs = strings.Join(payload.ServerIDs, ",")
+		cronJob.GMParams = string(params)
 	}
+	cronJob.Operator = c.Get("X-Request-User")
 
 	if err := j.Create(&cronJob); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "创建计划任务失败", err.Error(), nil)
@@ -77,7 +86,7 @@ func (j *CronjobHandler) Handler_UpdateCronjobTask(c fiber.Ctx) error {
 	}
 
 	// 根据任务类型进行验证
-	if err := j.validateTaskPayload(payload); err != nil {
+	if err := j.validateTaskPayload(payload, c.Get("X-Request-User")); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "更新计划任务失败", err.Error(), nil)
 	}
 
@@ -99,14 +108,32 @@ func (j *CronjobHandler) Handler_UpdateCronjobTask(c fiber.Ctx) error {
 		cronJob.CustomTaskID = payload.CustomTaskID
 		cronJob.ServerIDs = ""
 		cronJob.ServerOperation = ""
+		cronJob.Signal = ""
+		cronJob.SignalTask = ""
+		cronJob.GMTemplateID = nil
+		cronJob.GMParams = ""
 	case task.TaskTypeServer:
 		cronJob.CustomTaskID = nil
 		cronJob.ServerIDs = strings.Join(payload.ServerIDs, ",")
 		cronJob.ServerOperation = payload.ServerOperation
+		cronJob.Signal, _ = pkg.NormalizeSignal(payload.Signal) // 非signal操作时为空
+		cronJob.SignalTask = payload.SignalTask
+		cronJob.GMTemplateID = nil
+		cronJob.GMParams = ""
+	case task.TaskTypeGM:
+		params, _ := json.Marshal(payload.GMParams)
+		cronJob.CustomTaskID = nil
+		cronJob.ServerIDs = strings.Join(payload.ServerIDs, ",")
+		cronJob.ServerOperation = ""
+		cronJob.Signal = ""
+		cronJob.SignalTask = ""
+		cronJob.GMTemplateID = payload.GMTemplateID
+		cronJob.GMParams = string(params)
 	}
+	cronJob.Operator = c.Get("X-Request-User")
 
 	// 使用 Select 明确指定要更新的字段，包括零值字段 TaskStatus
-	if err := j.DB.Model(&cronJob).Where("id = ?", uint(id)).Select("task_name", "spec", "task_type", "task_status", "custom_task_id", "server_ids", "server_operation").Updates(&cronJob).Error; err != nil {
+	if err := j.DB.Model(&cronJob).Where("id = ?", uint(id)).Select("task_name", "spec", "task_type", "task_status", "custom_task_id", "server_ids", "server_operation", "signal", "signal_task", "gm_template_id", "gm_params", "operator").Updates(&cronJob).Error; err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "更新计划任务失败", err.Error(), nil)
 	}
 
@@ -184,6 +211,19 @@ func (j *CronjobHandler) Handler_ShowCronjobTask(c fiber.Ctx) error {
 
 			formattedJob["server_ids"] = serverIDs
 			formattedJob["server_operation"] = job.ServerOperation
+			if job.ServerOperation == task.ServerOpSignal {
+				formattedJob["signal"] = job.Signal
+				formattedJob["signal_task"] = job.SignalTask
+			}
+		case task.TaskTypeGM:
+			serverIDs := []string{}
+			if job.ServerIDs != "" {
+				serverIDs = strings.Split(job.ServerIDs, ",")
+			}
+			formattedJob["server_ids"] = serverIDs
+			formattedJob["gm_template_id"] = job.GMTemplateID
+			formattedJob["gm_params"] = job.GMParams
+			formattedJob["operator"] = job.Operator
 		}
 
 		// 添加最后执行时间
@@ -263,7 +303,7 @@ func (j *CronjobHandler) Handler_GetAvailableServers(c fiber.Ctx) error {
 
 // isValidTaskType 验证任务类型是否有效
 func (j *CronjobHandler) isValidTaskType(taskType string) bool {
-	validTypes := []string{task.TaskTypeCustom, task.TaskTypeServer}
+	validTypes := []string{task.TaskTypeCustom, task.TaskTypeServer, task.TaskTypeGM}
 	for _, validType := range validTypes {
 		if taskType == validType {
 			return true
@@ -273,7 +313,7 @@ func (j *CronjobHandler) isValidTaskType(taskType string) bool {
 }
 
 // validateTaskPayload 根据任务类型验证请求参数
-func (j *CronjobHandler) validateTaskPayload(payload task.CronJobPayload) error {
+func (j *CronjobHandler) validateTaskPayload(payload task.CronJobPayload, operator string) error {
 	switch payload.TaskType {
 	case task.TaskTypeCustom:
 		if payload.CustomTaskID == nil || *payload.CustomTaskID == 0 {
@@ -292,7 +332,7 @@ func (j *CronjobHandler) validateTaskPayload(payload task.CronJobPayload) error
 			return fmt.Errorf("server_operation is required for server operation task type")
 		}
 		// 验证服务器操作类型
-		validOps := []string{task.ServerOpStart, task.ServerOpStop, task.ServerOpRestart}
+		validOps := []string{task.ServerOpStart, task.ServerOpStop, task.ServerOpRestart, task.ServerOpSignal}
 		isValidOp := false
 		for _, op := range validOps {
 			if payload.ServerOperation == op {
@@ -303,6 +343,11 @@ func (j *CronjobHandler) validateTaskPayload(payload task.CronJobPayload) error
 		if !isValidOp {
 			return fmt.Errorf("invalid server operation: %s", payload.ServerOperation)
 		}
+		if payload.ServerOperation == task.ServerOpSignal {
+			if _, err := pkg.NormalizeSignal(payload.Signal); err != nil {
+				return err
+			}
+		}
 		// 验证服务器ID是否存在
 		for _, serverID := range payload.ServerIDs {
 			var game gameserver.Games
@@ -310,6 +355,23 @@ func (j *CronjobHandler) validateTaskPayload(payload task.CronJobPayload) error
 				return fmt.Errorf("server not found: %s", serverID)
 			}
 		}
+	case task.TaskTypeGM:
+		if payload.GMTemplateID == nil || *payload.GMTemplateID == 0 {
+			return fmt.Errorf("gm_template_id is required for gm command task type")
+		}
+		if len(payload.ServerIDs) == 0 {
+			return fmt.Errorf("server_ids is required for gm command task type")
+		}
+		var tpl gmcommand.GMTemplate
+		if err := config.DB.First(&tpl, *payload.GMTemplateID).Error; err != nil {
+			return fmt.Errorf("gm template not found: %v", err)
+		}
+		if tpl.Permission != "" && !pkg.UserHasPermission(operator, tpl.Permission) {
+			return fmt.Errorf("permission %s is required for gm template %s", tpl.Permission, tpl.Name)
+		}
+		if _, err := pkg.RenderGMCommand(&tpl, payload.ServerIDs[0], payload.GMParams); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -333,6 +395,7 @@ func CustonCronjobHandler(ctx context.Context, at *asynq.Task) error {
 	// 创建执行记录
 	execution := task.CustomTaskExecution{
 		TaskID:        uint(customTaskID),
+		Cluster:       customTask.Cluster,
 		Status:        "pending",
 		StartTime:     startTime,
 		CheckInterval: 10, // 10秒检查一次
@@ -55,6 +55,9 @@ func (h *CustomTaskHandler) Handler_CreateCustomTask(c fiber.Ctx) error {
 	if !h.isValidScriptType(payload.ScriptType) {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid script type", "unsupported script type", nil)
 	}
+	if !config.HasCluster(payload.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid cluster", "cluster "+payload.Cluster+" not found", nil)
+	}
 
 	customTask := task.CustomTask{
 		Name:        payload.Name,
@@ -66,6 +69,7 @@ func (h *CustomTaskHandler) Handler_CreateCustomTask(c fiber.Ctx) error {
 		Status:      "active",
 		Timeout:     payload.Timeout,
 		RetryCount:  payload.RetryCount,
+		Cluster:     payload.Cluster,
 	}
 
 	if err := h.Create(&customTask); err != nil {
@@ -92,6 +96,7 @@ func (h *CustomTaskHandler) Handler_ExecuteCustomTask(c fiber.Ctx) error {
 	// 创建执行记录
 	execution := task.CustomTaskExecution{
 		TaskID:        uint(taskID),
+		Cluster:       customTask.Cluster,
 		Status:        "pending",
 		StartTime:     time.Now(),
 		CheckInterval: 10, // 10秒检查一次
@@ -136,6 +141,14 @@ func (h *CustomTaskHandler) ExecuteCustomTaskAsync(customTask *task.CustomTask,
 		execution.ErrorMsg = "no target hosts specified"
 		return
 	}
+	// 任务在指定集群中执行
+	cluster, err := config.GetCluster(customTask.Cluster)
+	if err != nil {
+		execution.Status = "failed"
+		execution.ErrorMsg = err.Error()
+		return
+	}
+	nomadClient := cluster.Nomad()
 
 	// 为每个目标主机生成单独的Job配置
 	var dispatchResults []string
@@ -157,13 +170,13 @@ func (h *CustomTaskHandler) ExecuteCustomTaskAsync(customTask *task.CustomTask,
 
 		// 保存Job HCL到Consul（使用主机特定的key）
 		consulKey := fmt.Sprintf("custom_job/%d_%s", customTask.ID, host)
-		if err = h.saveJobHCLToConsulWithKey(&hostTask, jobSpec, consulKey); err != nil {
+		if err = h.saveJobHCLToConsulWithKey(cluster.Consul(), &hostTask, jobSpec, consulKey); err != nil {
 			slog.Warn("Failed to save job HCL to Consul", "host", host, "error", err)
 			// 不阻止任务执行，只记录警告
 		}
 
 		// 解析并注册Nomad Job
-		job, err := h.NomadClient.Jobs().ParseHCL(jobSpec, true)
+		job, err := nomadClient.Jobs().ParseHCL(jobSpec, true)
 		if err != nil {
 			slog.Error("Failed to parse job spec for host", "host", host, "error", err)
 			dispatchResults = append(dispatchResults, fmt.Sprintf("Failed to parse job spec for %s: %v", host, err))
@@ -175,7 +188,7 @@ func (h *CustomTaskHandler) ExecuteCustomTaskAsync(customTask *task.CustomTask,
 		// job.ID 由Nomad根据job名称自动生成
 
 		// 注册Job
-		resp, _, err := h.NomadClient.Jobs().Register(job, &nomadapi.WriteOptions{})
+		resp, _, err := nomadClient.Jobs().Register(job, &nomadapi.WriteOptions{})
 		if err != nil {
 			slog.Error("Failed to register job for host", "host", host, "error", err)
 			dispatchResults = append(dispatchResults, fmt.Sprintf("Failed to register job for %s: %v", host, err))
@@ -534,6 +547,10 @@ func (h *CustomTaskHandler) Handler_UpdateCustomTask(c fiber.Ctx) error {
 	//customTask.Schedule = payload.Schedule
 	customTask.Timeout = payload.Timeout
 	customTask.RetryCount = payload.RetryCount
+	if !config.HasCluster(payload.Cluster) {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid cluster", "cluster "+payload.Cluster+" not found", nil)
+	}
+	customTask.Cluster = payload.Cluster
 
 	customTask.Parameters = payload.Parameters // 直接使用字符串，因为已经是 JSON 字符串格式
 
@@ -588,7 +605,7 @@ func (h *CustomTaskHandler) formatHCL(hclContent string) (string, error) {
 }
 
 // saveJobHCLToConsulWithKey 保存Job HCL到Consul（指定key）
-func (h *CustomTaskHandler) saveJobHCLToConsulWithKey(customTask *task.CustomTask, jobSpec string, key string) error {
+func (h *CustomTaskHandler) saveJobHCLToConsulWithKey(cli *consulapi.Client, customTask *task.CustomTask, jobSpec string, key string) error {
 	// 格式化HCL配置
 	formattedJobSpec, err := h.formatHCL(jobSpec)
 	if err != nil {
@@ -602,7 +619,7 @@ func (h *CustomTaskHandler) saveJobHCLToConsulWithKey(customTask *task.CustomTas
 		Value: []byte(formattedJobSpec),
 	}
 
-	_, err = config.ConsulCli.KV().Put(kvPair, nil)
+	_, err = cli.KV().Put(kvPair, nil)
 	if err != nil {
 		return fmt.Errorf("failed to save job HCL to Consul: %v", err)
 	}
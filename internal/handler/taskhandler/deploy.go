@@ -1,12 +1,18 @@
 package taskhandler
 
 import (
+	"errors"
+	"saurfang/internal/config"
+	"saurfang/internal/models/operation"
 	"saurfang/internal/models/task"
 	"saurfang/internal/repository/base"
 	"saurfang/internal/tools/pkg"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
 )
 
 type DeployHandler struct {
@@ -21,20 +27,39 @@ type DeployHandler struct {
 // Handler_CreateDeployTask 创建发布任务
 func (d *DeployHandler) Handler_CreateDeployTask(c fiber.Ctx) error {
 	var payload task.DeployTaskPayload
-	var task task.GameDeploymentTask
 	if err := c.Bind().Body(&payload); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
 	}
 	if payload.ServerID == "" {
 		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "server_id is required", "", nil)
 	}
-	task.ServerId = payload.ServerID
-	task.Comment = payload.Comment
-	if err := d.Create(&task); err != nil {
+	if payload.Strategy == "" {
+		payload.Strategy = task.DeployStrategyRolling
+	}
+	if payload.Strategy != task.DeployStrategyRolling && payload.Strategy != task.DeployStrategyAll {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid strategy", "strategy must be rolling or all", nil)
+	}
+	if payload.BatchSize <= 0 {
+		payload.BatchSize = 1
+	}
+	if payload.HealthTimeout <= 0 {
+		payload.HealthTimeout = 300
+	}
+	t := task.GameDeploymentTask{
+		ServerId:       strings.Join(deployTargets(payload.ServerID), ","),
+		Comment:        payload.Comment,
+		UploadRecordID: payload.UploadRecordID,
+		DatasourceID:   payload.DatasourceID,
+		Strategy:       payload.Strategy,
+		BatchSize:      payload.BatchSize,
+		BatchInterval:  max(payload.BatchInterval, 0),
+		HealthTimeout:  payload.HealthTimeout,
+	}
+	if err := d.Create(&t); err != nil {
 		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "create deploy task error", err.Error(), nil)
 
 	}
-	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", nil)
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", t)
 }
 
 // Handler_DeleteDeployTask 删除发布任务
@@ -90,3 +115,117 @@ func (d *DeployHandler) Handler_ShowDeployPerPage(c fiber.Ctx) error {
 	})
 
 }
+
+// deployTargets 拆分逗号分隔的游戏服并去重
+func deployTargets(serverID string) []string {
+	var keys []string
+	for _, id := range strings.Split(serverID, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" && !slices.Contains(keys, id) {
+			keys = append(keys, id)
+		}
+	}
+	return keys
+}
+
+// Handler_ExecuteDeployTask 执行发布任务 "/deploy/execute/:id?detach=true"
+func (d *DeployHandler) Handler_ExecuteDeployTask(c fiber.Ctx) error {
+	var t task.GameDeploymentTask
+	if err := config.DB.First(&t, c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "deploy task not found", "", nil)
+		}
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "find deploy task error", err.Error(), nil)
+	}
+	keys := deployTargets(t.ServerId)
+	if len(keys) == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "deploy task has no server", "", nil)
+	}
+	if len(keys) > 200 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "too many servers", "at most 200 servers per deployment", nil)
+	}
+	if t.UploadRecordID == 0 || t.DatasourceID == 0 {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "deploy task has no package", "upload_record_id and datasource_id are required", nil)
+	}
+	if err := pkg.CheckRequestFreeze(c, keys, "deploy"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	operator := c.Get("X-Request-User")
+	lease, err := pkg.LockServers(keys, operator, "deploy")
+	if err != nil {
+		var conflict *pkg.LockConflictError
+		if errors.As(err, &conflict) {
+			return pkg.NewAppResponse(c, fiber.StatusConflict, 1, conflict.Error(), conflict.Error(), conflict.Info)
+		}
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "acquire lock error", err.Error(), nil)
+	}
+	run, err := pkg.StartOperation(&operation.Operation{
+		Kind:     operation.KindGameDeploy,
+		Action:   "deploy",
+		Targets:  strings.Join(keys, ","),
+		Params:   "task_id=" + strconv.Itoa(int(t.ID)),
+		Operator: operator,
+	})
+	if err != nil {
+		lease.Release()
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "create operation error", err.Error(), nil)
+	}
+	trigger := pkg.RequestTrigger(c)
+	go func() {
+		defer lease.Release()
+		pkg.ExecuteDeployTask(&t, keys, operator, trigger, run)
+	}()
+	if c.Query("detach") == "true" {
+		return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+			"operation_id": run.Op.ID,
+		})
+	}
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("Transfer-Encoding", "chunked")
+	return pkg.StreamOperation(c, run.Op.ID, 0)
+}
+
+// Handler_ListDeployRuns 展示发布任务的执行记录 "/deploy/runs/:id?page=1&perPage=10"
+func (d *DeployHandler) Handler_ListDeployRuns(c fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.Query("perPage"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	query := config.DB.Model(&task.DeploymentRun{}).Where("task_id = ?", c.Params("id"))
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "count deployment runs error", err.Error(), nil)
+	}
+	var runs []task.DeploymentRun
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&runs).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "list deployment runs error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": runs,
+		"total": total,
+	})
+}
+
+// Handler_ShowDeployRun 展示一次执行及各游戏服的结果 "/deploy/run/:run_id"
+func (d *DeployHandler) Handler_ShowDeployRun(c fiber.Ctx) error {
+	var detail task.DeploymentRunDetail
+	if err := config.DB.First(&detail.DeploymentRun, c.Params("run_id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "deployment run not found", "", nil)
+		}
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "find deployment run error", err.Error(), nil)
+	}
+	if err := config.DB.Where("run_id = ?", detail.ID).Order("id").Find(&detail.Results).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "list deployment run results error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", detail)
+}
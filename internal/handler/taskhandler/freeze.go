@@ -0,0 +1,185 @@
+package taskhandler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/freeze"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+// calendarMaxDays 日历最多展示的天数
+const calendarMaxDays = 366
+
+type FreezeHandler struct {
+	base.BaseGormRepository[freeze.FreezeWindow]
+}
+
+func NewFreezeHandler() *FreezeHandler {
+	return &FreezeHandler{
+		BaseGormRepository: base.BaseGormRepository[freeze.FreezeWindow]{DB: config.DB},
+	}
+}
+
+// Handler_ListFreezeWindows 展示冻结窗口 "?enabled=true"
+func (f *FreezeHandler) Handler_ListFreezeWindows(ctx fiber.Ctx) error {
+	query := config.DB.Order("start_at DESC")
+	if enabled := ctx.Query("enabled"); enabled != "" {
+		query = query.Where("enabled = ?", enabled == "true")
+	}
+	var windows []freeze.FreezeWindow
+	if err := query.Find(&windows).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list freeze windows error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": windows,
+	})
+}
+
+// Handler_CreateFreezeWindow 创建冻结窗口
+func (f *FreezeHandler) Handler_CreateFreezeWindow(ctx fiber.Ctx) error {
+	var w freeze.FreezeWindow
+	if err := ctx.Bind().Body(&w); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	w.ID = 0
+	w.Creator = ctx.Get("X-Request-User")
+	if err := pkg.ValidateFreezeWindow(&w); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid freeze window", err.Error(), nil)
+	}
+	if err := config.DB.Create(&w).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "create freeze window error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", w)
+}
+
+// canOverrideFreeze 修改或删除冻结窗口可能提前解除冻结，需要强制变更权限
+func canOverrideFreeze(ctx fiber.Ctx) bool {
+	return pkg.UserHasPermission(ctx.Get("X-Request-User"), freeze.OverridePermission)
+}
+
+func refuseFreezeChange(ctx fiber.Ctx) error {
+	return pkg.NewAppResponse(ctx, fiber.StatusForbidden, 1, "permission denied", "changing a freeze window requires permission "+freeze.OverridePermission, nil)
+}
+
+// Handler_UpdateFreezeWindow 修改冻结窗口 "/freeze/:id"
+func (f *FreezeHandler) Handler_UpdateFreezeWindow(ctx fiber.Ctx) error {
+	if !canOverrideFreeze(ctx) {
+		return refuseFreezeChange(ctx)
+	}
+	var old freeze.FreezeWindow
+	if err := config.DB.First(&old, ctx.Params("id")).Error; err != nil {
+		return freezeNotFound(ctx, err)
+	}
+	var w freeze.FreezeWindow
+	if err := ctx.Bind().Body(&w); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	w.ID, w.CreatedAt, w.Creator = old.ID, old.CreatedAt, old.Creator
+	if err := pkg.ValidateFreezeWindow(&w); err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "invalid freeze window", err.Error(), nil)
+	}
+	if err := config.DB.Save(&w).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "update freeze window error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", w)
+}
+
+// Handler_DeleteFreezeWindow 删除冻结窗口 "/freeze/:id"
+func (f *FreezeHandler) Handler_DeleteFreezeWindow(ctx fiber.Ctx) error {
+	if !canOverrideFreeze(ctx) {
+		return refuseFreezeChange(ctx)
+	}
+	res := config.DB.Delete(&freeze.FreezeWindow{}, ctx.Params("id"))
+	if res.Error != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "delete freeze window error", res.Error.Error(), nil)
+	}
+	if res.RowsAffected == 0 {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "freeze window not found", "", nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", nil)
+}
+
+// Handler_CheckFreeze 查询当前是否冻结 "?server_ids=1,2"，不传server_ids时只检查全局冻结
+func (f *FreezeHandler) Handler_CheckFreeze(ctx fiber.Ctx) error {
+	var serverIDs []string
+	if ids := ctx.Query("server_ids"); ids != "" {
+		serverIDs = strings.Split(ids, ",")
+	}
+	frozen, err := pkg.ActiveFreeze(serverIDs, time.Now())
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "check freeze window error", err.Error(), nil)
+	}
+	if frozen == nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{"frozen": false})
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"frozen": true,
+		"window": frozen.Window,
+		"until":  frozen.Until,
+	})
+}
+
+// Handler_ListFreezeOverrides 展示冻结期间的强制变更记录 "?window_id=1&operator=xxx"
+func (f *FreezeHandler) Handler_ListFreezeOverrides(ctx fiber.Ctx) error {
+	query := config.DB.Order("id DESC").Limit(500)
+	if id := ctx.Query("window_id"); id != "" {
+		query = query.Where("window_id = ?", id)
+	}
+	if operator := ctx.Query("operator"); operator != "" {
+		query = query.Where("operator = ?", operator)
+	}
+	var overrides []freeze.FreezeOverride
+	if err := query.Find(&overrides).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list freeze overrides error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": overrides,
+	})
+}
+
+// Handler_ShowCalendar 变更日历iCal订阅，包括冻结窗口、计划任务和计划开服 "?days=90"
+func (f *FreezeHandler) Handler_ShowCalendar(ctx fiber.Ctx) error {
+	days, err := strconv.Atoi(ctx.Query("days"))
+	if err != nil || days < 1 {
+		days = 90
+	}
+	if days > calendarMaxDays {
+		days = calendarMaxDays
+	}
+	ics, err := pkg.OpsCalendar(days)
+	if err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "build calendar error", err.Error(), nil)
+	}
+	ctx.Set("Content-Type", "text/calendar; charset=utf-8")
+	ctx.Set("Content-Disposition", `inline; filename="saurfang.ics"`)
+	return ctx.SendString(ics)
+}
+
+// Handler_ShowCalendarFeed 日历客户端无法登录，通过CALENDAR_FEED_TOKEN订阅 "?token=xxx"，未配置时不开放
+func (f *FreezeHandler) Handler_ShowCalendarFeed(ctx fiber.Ctx) error {
+	token := os.Getenv("CALENDAR_FEED_TOKEN")
+	if token == "" {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "calendar feed is disabled", "", nil)
+	}
+	if subtle.ConstantTimeCompare([]byte(ctx.Query("token")), []byte(token)) != 1 {
+		return pkg.NewAppResponse(ctx, fiber.StatusUnauthorized, 1, "invalid token", "", nil)
+	}
+	return f.Handler_ShowCalendar(ctx)
+}
+
+// freezeNotFound 冻结窗口不存在时返回404
+func freezeNotFound(ctx fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "freeze window not found", "", nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get freeze window error", err.Error(), nil)
+}
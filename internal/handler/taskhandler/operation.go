@@ -0,0 +1,125 @@
+package taskhandler
+
+import (
+	"errors"
+	"saurfang/internal/config"
+	"saurfang/internal/models/operation"
+	"saurfang/internal/repository/base"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+type OperationHandler struct {
+	base.BaseGormRepository[operation.Operation]
+}
+
+func NewOperationHandler() *OperationHandler {
+	return &OperationHandler{
+		BaseGormRepository: base.BaseGormRepository[operation.Operation]{DB: config.DB},
+	}
+}
+
+// findOperation 按路由参数id查询操作
+func (o *OperationHandler) findOperation(ctx fiber.Ctx) (*operation.Operation, error) {
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return nil, err
+	}
+	var op operation.Operation
+	if err := config.DB.First(&op, id).Error; err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// operationError 操作不存在时返回404
+func (o *OperationHandler) operationError(ctx fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg.NewAppResponse(ctx, fiber.StatusNotFound, 1, "operation not found", "", nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusBadRequest, 1, "get operation error", err.Error(), nil)
+}
+
+// Handler_ListOperations 展示历史操作 "?page=1&perPage=10&kind=game_ops&status=failed&operator=xxx&target=xxx"
+func (o *OperationHandler) Handler_ListOperations(ctx fiber.Ctx) error {
+	page, err := strconv.Atoi(ctx.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(ctx.Query("perPage"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	query := config.DB.Model(&operation.Operation{})
+	for _, field := range []string{"kind", "status", "operator"} {
+		if v := ctx.Query(field); v != "" {
+			query = query.Where(field+" = ?", v)
+		}
+	}
+	if target := ctx.Query("target"); target != "" {
+		query = query.Where("targets LIKE ?", "%"+target+"%")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "count operations error", err.Error(), nil)
+	}
+	var ops []operation.Operation
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&ops).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list operations error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": ops,
+		"total": total,
+	})
+}
+
+// Handler_ShowOperation 展示操作及每个目标的结果
+func (o *OperationHandler) Handler_ShowOperation(ctx fiber.Ctx) error {
+	op, err := o.findOperation(ctx)
+	if err != nil {
+		return o.operationError(ctx, err)
+	}
+	detail := operation.OperationDetail{Operation: *op, Results: []operation.OperationResult{}}
+	if err := config.DB.Where("operation_id = ?", op.ID).Order("id").Find(&detail.Results).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list operation results error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", detail)
+}
+
+// Handler_ShowOperationLogs 展示操作的完整日志 "?after=0"
+func (o *OperationHandler) Handler_ShowOperationLogs(ctx fiber.Ctx) error {
+	op, err := o.findOperation(ctx)
+	if err != nil {
+		return o.operationError(ctx, err)
+	}
+	after, _ := strconv.Atoi(ctx.Query("after", "0"))
+	logs := []operation.OperationLog{}
+	if err := config.DB.Where("operation_id = ? AND seq > ?", op.ID, after).Order("seq").Find(&logs).Error; err != nil {
+		return pkg.NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "list operation logs error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(ctx, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items":  logs,
+		"status": op.Status,
+	})
+}
+
+// Handler_StreamOperation 订阅操作日志(SSE)，重连时按Last-Event-ID从断开的位置继续，运行中的操作持续输出直到结束
+func (o *OperationHandler) Handler_StreamOperation(ctx fiber.Ctx) error {
+	op, err := o.findOperation(ctx)
+	if err != nil {
+		return o.operationError(ctx, err)
+	}
+	ctx.Set("Content-Type", "text/event-stream")
+	ctx.Set("Cache-control", "no-cache")
+	ctx.Set("Connection", "keep-alive")
+	ctx.Set("Transfer-Encoding", "chunked")
+	ctx.Set("Access-Control-Allow-Origin", "*")
+	ctx.Set("Access-Control-Allow-Headers", "Cache-Control, Last-Event-ID")
+	return pkg.StreamOperation(ctx, op.ID, pkg.LastEventID(ctx))
+}
@@ -0,0 +1,94 @@
+package taskhandler
+
+import (
+	"errors"
+	"saurfang/internal/config"
+	"saurfang/internal/models/upload"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+type PackageHandler struct{}
+
+// PackageStatusPayload 变更安装包状态
+type PackageStatusPayload struct {
+	Status string `json:"status"`
+}
+
+// Handler_ListPackages 展示安装包版本 "?page=1&perPage=10&status=released&version=1.2"
+func (p *PackageHandler) Handler_ListPackages(c fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.Query("perPage"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	query := config.DB.Model(&upload.Package{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if version := c.Query("version"); version != "" {
+		query = query.Where("version LIKE ?", "%"+version+"%")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "count packages error", err.Error(), nil)
+	}
+	var packages []upload.Package
+	if err := query.Preload("Targets").Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&packages).Error; err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "list packages error", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+		"items": packages,
+		"total": total,
+	})
+}
+
+// Handler_ShowPackage 展示安装包详情 "/package/:id"
+func (p *PackageHandler) Handler_ShowPackage(c fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid id", err.Error(), nil)
+	}
+	detail, err := pkg.GetPackageDetail(uint(id))
+	if err != nil {
+		return packageError(c, err)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", detail)
+}
+
+// Handler_SetPackageStatus 变更安装包状态 "/package/:id/status"
+func (p *PackageHandler) Handler_SetPackageStatus(c fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid id", err.Error(), nil)
+	}
+	var payload PackageStatusPayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "bind payload error", err.Error(), nil)
+	}
+	pack, err := pkg.SetPackageStatus(uint(id), payload.Status)
+	if err != nil {
+		return packageError(c, err)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", pack)
+}
+
+// packageError 安装包操作失败的响应
+func packageError(c fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return pkg.NewAppResponse(c, fiber.StatusNotFound, 1, "package not found", "", nil)
+	case errors.Is(err, pkg.ErrInvalidPackageStatus):
+		return pkg.NewAppResponse(c, fiber.StatusBadRequest, 1, "invalid package status", err.Error(), nil)
+	}
+	return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "package error", err.Error(), nil)
+}
@@ -1,13 +1,14 @@
 package taskhandler
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"saurfang/internal/config"
 	"saurfang/internal/models/notify"
+	"saurfang/internal/models/operation"
 	"saurfang/internal/models/upload"
 	"saurfang/internal/repository/base"
 	"saurfang/internal/tools"
@@ -50,76 +51,125 @@ func (u *UploadHandler) Handler_ShowServerPackage(c fiber.Ctx) error {
 
 // Handler_UploadServerPackage 上传服务器端文件
 func (u *UploadHandler) Handler_UploadServerPackage(c fiber.Ctx) error {
-	u.setSSEHeaders(c)
 	file := c.Query("file")
 	targetID, _ := strconv.Atoi(c.Query("target"))
 	startTime := time.Now()
 	var successCount, failCount int
 	var successJobs, failedJobs []string
 	var mu sync.Mutex
-	reader, writer := io.Pipe()
+	if err := pkg.CheckRequestFreeze(c, nil, "upload"); err != nil {
+		return pkg.RefuseFrozen(c, err)
+	}
+	operator := c.Get("X-Request-User")
+	// 上传会清空并复用同一个解压目录，同时只允许一个上传
+	lease, err := pkg.LockGlobal("upload", operator, "upload "+file)
+	if err != nil {
+		var conflict *pkg.LockConflictError
+		if errors.As(err, &conflict) {
+			return pkg.NewAppResponse(c, fiber.StatusConflict, 1, conflict.Error(), conflict.Error(), conflict.Info)
+		}
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "acquire lock error", err.Error(), nil)
+	}
+	run, err := pkg.StartOperation(&operation.Operation{
+		Kind:     operation.KindUpload,
+		Action:   "upload",
+		Targets:  file,
+		Params:   string(c.Request().URI().QueryString()),
+		Operator: operator,
+	})
+	if err != nil {
+		lease.Release()
+		return pkg.NewAppResponse(c, fiber.StatusInternalServerError, 1, "create operation error", err.Error(), nil)
+	}
 	go func() {
-		defer writer.Close()
+		defer lease.Release()
+		defer func() { run.Finish(successJobs, failedJobs) }()
 		if _, err := os.Stat(path.Join(os.Getenv("SERVER_PACKAGE_SRC_PATH"), file)); err != nil {
-			writer.Write([]byte(fmt.Sprintf("[%v] ERROR 缺少服务器端文件\n", time.Now().Format("2006-01-02 13:04:05"))))
+			run.Log(fmt.Sprintf("[%v] ERROR 缺少服务器端文件\n", time.Now().Format("2006-01-02 13:04:05")))
+			u.recordFailedJob(&mu, &failCount, &failedJobs, file)
+			return
+		}
+		pack, err := pkg.InspectPackage(path.Join(os.Getenv("SERVER_PACKAGE_SRC_PATH"), file), operator)
+		if err != nil {
+			run.Log(fmt.Sprintf("[%v] ERROR 读取服务器端版本信息失败 %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error()))
 			u.recordFailedJob(&mu, &failCount, &failedJobs, file)
 			return
 		}
-		writer.Write([]byte(fmt.Sprintf("[%v] INFO 清空目标目录 %s\n", time.Now().Format("2006-01-02 13:04:05"), os.Getenv("SERVER_PACKAGE_SRC_PATH"))))
+		run.Log(fmt.Sprintf("[%v] INFO 服务器端版本 %s sha256: %s\n", time.Now().Format("2006-01-02 13:04:05"), pack.Version, pack.SHA256))
+		run.Log(fmt.Sprintf("[%v] INFO 清空目标目录 %s\n", time.Now().Format("2006-01-02 13:04:05"), os.Getenv("SERVER_PACKAGE_SRC_PATH")))
 		files, err := filepath.Glob(path.Join(os.Getenv("SERVER_PACKAGE_DEST_PATH"), "*"))
 		if err != nil {
-			writer.Write([]byte(fmt.Sprintf("[%v] ERROR 清空目录失败\n", time.Now().Format("2006-01-02 13:04:05"))))
+			run.Log(fmt.Sprintf("[%v] ERROR 清空目录失败\n", time.Now().Format("2006-01-02 13:04:05")))
 		}
 		for _, f := range files {
 			err = os.RemoveAll(f)
 			if err != nil {
-				writer.Write([]byte(fmt.Sprintf("[%v] ERROR 删除 %s 失败: %v\n", time.Now().Format("2006-01-02 13:04:05"), f, err.Error())))
+				run.Log(fmt.Sprintf("[%v] ERROR 删除 %s 失败: %v\n", time.Now().Format("2006-01-02 13:04:05"), f, err.Error()))
 				u.recordFailedJob(&mu, &failCount, &failedJobs, file)
 				return
 			}
 		}
-		writer.Write([]byte(fmt.Sprintf("[%v] Success 清空目录成功\n", time.Now().Format("2006-01-02 13:04:05"))))
-		writer.Write([]byte(fmt.Sprintf("[%v] INFO 正在解压服务器端 %s 到 %s\n", time.Now().Format("2006-01-02 13:04:05"), file, os.Getenv("SERVER_PACKAGE_DEST_PATH"))))
+		run.Log(fmt.Sprintf("[%v] Success 清空目录成功\n", time.Now().Format("2006-01-02 13:04:05")))
+		run.Log(fmt.Sprintf("[%v] INFO 正在解压服务器端 %s 到 %s\n", time.Now().Format("2006-01-02 13:04:05"), file, os.Getenv("SERVER_PACKAGE_DEST_PATH")))
 		if err := tools.SafeUnzip(path.Join(os.Getenv("SERVER_PACKAGE_SRC_PATH"), file), os.Getenv("SERVER_PACKAGE_DEST_PATH")); err != nil {
-			writer.Write([]byte(fmt.Sprintf("[%v] ERROR 解压服务器端失败 %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error())))
+			run.Log(fmt.Sprintf("[%v] ERROR 解压服务器端失败 %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error()))
 			u.recordFailedJob(&mu, &failCount, &failedJobs, file)
 			return
 		}
 		entries, err := os.ReadDir(os.Getenv("SERVER_PACKAGE_DEST_PATH"))
 		if err != nil {
-			writer.Write([]byte(fmt.Sprintf("[%v] ERROR 获取资源列表失败 %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error())))
+			run.Log(fmt.Sprintf("[%v] ERROR 获取资源列表失败 %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error()))
 			u.recordFailedJob(&mu, &failCount, &failedJobs, file)
 			return
 		}
 		for _, entry := range entries {
 			info, _ := entry.Info()
 			if entry.IsDir() {
-				writer.Write([]byte(fmt.Sprintf("%s %s %d %s\n", info.Mode().String(), info.ModTime().String(), info.Size(), entry.Name())))
+				run.Log(fmt.Sprintf("%s %s %d %s\n", info.Mode().String(), info.ModTime().String(), info.Size(), entry.Name()))
 
 			} else {
-				writer.Write([]byte(fmt.Sprintf("%s %s %d %s\n", info.Mode().String(), info.ModTime().String(), info.Size(), entry.Name())))
+				run.Log(fmt.Sprintf("%s %s %d %s\n", info.Mode().String(), info.ModTime().String(), info.Size(), entry.Name()))
 			}
 		}
-		writer.Write([]byte(fmt.Sprintf("[%v] INFO 上传服务器端到存储 \n", time.Now().Format("2006-01-02 13:04:05"))))
+		run.Log(fmt.Sprintf("[%v] INFO 上传服务器端到存储 \n", time.Now().Format("2006-01-02 13:04:05")))
 		p, s, err := tools.UploadToOss(targetID)
 		if err != nil {
-			writer.Write([]byte(fmt.Sprintf("[%v] ERROR 上传到存储失败: %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error())))
+			run.Log(fmt.Sprintf("[%v] ERROR 上传到存储失败: %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error()))
 			u.recordFailedJob(&mu, &failCount, &failedJobs, file)
 			return
-		} else {
-			record := upload.UploadRecord{
-				GameServer: file,
-				DestTarget: s,
-				DestPath:   p,
-				UploadTime: startTime,
-			}
-			config.DB.Create(&record)
 		}
-		writer.Write([]byte(fmt.Sprintf("[%v] Success 上传服务器端到存储成功  Path: %s \n", time.Now().Format("2006-01-02 13:04:05"), p)))
+		sum, err := pkg.VerifyPackageUpload(uint(targetID), os.Getenv("SERVER_PACKAGE_DEST_PATH"))
+		if err != nil {
+			run.Log(fmt.Sprintf("[%v] ERROR 校验存储中的服务器端失败: %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error()))
+			u.recordFailedJob(&mu, &failCount, &failedJobs, file)
+			return
+		}
+		run.Log(fmt.Sprintf("[%v] INFO 存储中的服务器端校验和 %s\n", time.Now().Format("2006-01-02 13:04:05"), sum))
+		if err := pkg.RecordPackageSync(pack, uint(targetID), s, p, sum); err != nil {
+			run.Log(fmt.Sprintf("[%v] ERROR 登记服务器端版本失败: %s\n", time.Now().Format("2006-01-02 13:04:05"), err.Error()))
+			u.recordFailedJob(&mu, &failCount, &failedJobs, file)
+			return
+		}
+		record := upload.UploadRecord{
+			GameServer: file,
+			DestTarget: s,
+			DestPath:   p,
+			UploadTime: startTime,
+			PackageID:  pack.ID,
+			Checksum:   sum,
+		}
+		config.DB.Create(&record)
+		run.Log(fmt.Sprintf("[%v] Success 上传服务器端到存储成功  Path: %s \n", time.Now().Format("2006-01-02 13:04:05"), p))
 		u.recordSuccessJob(&mu, &successCount, &successJobs, file)
 		ntfy.PublishNotification(notify.EventTypeUpload, fmt.Sprintf("upload %s", file), successJobs, failedJobs, successCount, failCount)
 	}()
-	return c.SendStream(reader)
+	if c.Query("detach") == "true" {
+		return pkg.NewAppResponse(c, fiber.StatusOK, 0, "success", "", fiber.Map{
+			"operation_id": run.Op.ID,
+		})
+	}
+	u.setSSEHeaders(c)
+	return pkg.StreamOperation(c, run.Op.ID, 0)
 }
 
 // Handler_ShowUploadRecords 显示上传记录
@@ -17,6 +17,9 @@ import (
 func UserAuth() fiber.Handler {
 	return func(ctx fiber.Ctx) error {
 		requestPath := string(ctx.Request().URI().Path())
+		// 操作人只能由认证结果确定，忽略客户端传入的值
+		ctx.Request().Header.Del("X-Request-User")
+		ctx.Request().Header.Del("X-Request-User-ID")
 		// 确保用户登录等通用类不受限制
 		if strings.HasPrefix(requestPath, "/api/v1/common") {
 			return ctx.Next()
@@ -44,7 +47,13 @@ func UserAuth() fiber.Handler {
 				}
 				//perm := formatRequestPath(requestPath)
 				if hasPermission(roleid, perm) {
-					ctx.Request().Header.Set("X-Request-User", strconv.Itoa(int(userid)))
+					// ak/sk认证时同样以用户名作为操作人，用户ID单独放在X-Request-User-ID
+					username, err := pkg.UsernameOfUser(userid)
+					if err != nil {
+						return pkg.NewAppResponse(ctx, fiber.StatusUnauthorized, 1, err.Error(), "", nil)
+					}
+					ctx.Request().Header.Set("X-Request-User", username)
+					ctx.Request().Header.Set("X-Request-User-ID", strconv.Itoa(int(userid)))
 					//ctx.Set("X-Request-User", (claims["username"].(interface{}).(string)))
 					return ctx.Next()
 				} else {
@@ -70,6 +79,9 @@ func UserAuth() fiber.Handler {
 		perm := formatRequestPath(requestPath)
 		if hasPermission(uint(role.(float64)), perm) {
 			ctx.Request().Header.Set("X-Request-User", (claims["username"].(interface{})).(string))
+			if id, ok := claims["id"].(float64); ok {
+				ctx.Request().Header.Set("X-Request-User-ID", strconv.Itoa(int(id)))
+			}
 			//ctx.Set("X-Request-User", (claims["username"].(interface{}).(string)))
 			return ctx.Next()
 		} else {
@@ -0,0 +1,67 @@
+// Package approval 高风险操作审批，命中审批策略的请求生成待审批变更，审批通过后自动执行
+package approval
+
+import "time"
+
+// ApprovePermission 审批策略未指定审批人时，审批需要的权限
+const ApprovePermission = "approval:approve"
+
+// 变更类型，决定审批通过后的执行方式
+const (
+	KindGameOps  = "game_ops"  // 游戏服开关服
+	KindPurgeJob = "purge_job" // 清除nomad job
+)
+
+// 变更状态
+const (
+	StatusPending   = "pending"   // 等待审批
+	StatusApproved  = "approved"  // 审批通过，执行中
+	StatusExecuted  = "executed"  // 已执行
+	StatusFailed    = "failed"    // 执行失败
+	StatusRejected  = "rejected"  // 已拒绝
+	StatusExpired   = "expired"   // 超时无人审批
+	StatusCancelled = "cancelled" // 申请人取消
+)
+
+// DefaultExpireMinutes 策略未设置过期时间时待审批变更的有效期
+const DefaultExpireMinutes = 60
+
+// Policy 审批策略，操作类型、目标数量和环境都满足时需要审批
+type Policy struct {
+	ID            uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Name          string    `gorm:"type:varchar(100);comment:名称" json:"name"`
+	Action        string    `gorm:"type:varchar(100);comment:操作类型,支持通配符,如game stop,game *,purge" json:"action"`
+	MinTargets    int       `gorm:"default:0;comment:目标数量达到时需要审批,0为任意数量" json:"min_targets"`
+	Environment   string    `gorm:"type:varchar(50);comment:集群名称,为空时匹配所有集群" json:"environment"`
+	Approvers     []string  `gorm:"serializer:json;type:json;comment:指定审批人" json:"approvers"`
+	ExpireMinutes int       `gorm:"default:60;comment:待审批变更的有效期(分钟)" json:"expire_minutes"`
+	Enabled       bool      `gorm:"default:true;comment:是否启用" json:"enabled"`
+}
+
+// Change 待审批变更，Params保存原请求参数，审批通过后按Kind执行
+type Change struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	PolicyID    uint       `gorm:"index;comment:命中的策略" json:"policy_id"`
+	Kind        string     `gorm:"type:varchar(20);comment:变更类型" json:"kind"`
+	Action      string     `gorm:"type:varchar(100);comment:操作类型" json:"action"`
+	Environment string     `gorm:"type:varchar(255);comment:涉及的集群" json:"environment"`
+	Targets     []string   `gorm:"serializer:json;type:json;comment:操作目标" json:"targets"`
+	Params      string     `gorm:"type:text;comment:请求参数" json:"params"`
+	Requester   string     `gorm:"type:varchar(100);index;comment:申请人" json:"requester"`
+	RequesterID uint       `gorm:"index;comment:申请人用户ID" json:"requester_id"`
+	Trigger     string     `gorm:"type:varchar(20);comment:触发来源" json:"trigger"`
+	Reason      string     `gorm:"type:varchar(500);comment:申请原因" json:"reason"`
+	Override    string     `gorm:"type:varchar(500);comment:申请时的冻结窗口覆盖原因,执行时按申请人的权限覆盖" json:"override,omitempty"`
+	Status      string     `gorm:"type:varchar(20);index;comment:状态" json:"status"`
+	Approver    string     `gorm:"type:varchar(100);comment:审批人" json:"approver"`
+	ApproverID  uint       `gorm:"comment:审批人用户ID" json:"approver_id"`
+	Comment     string     `gorm:"type:varchar(500);comment:审批意见" json:"comment"`
+	ExpiresAt   time.Time  `gorm:"index;comment:过期时间" json:"expires_at"`
+	DecidedAt   *time.Time `gorm:"comment:审批时间" json:"decided_at,omitempty"`
+	OperationID uint       `gorm:"comment:执行生成的后台操作" json:"operation_id,omitempty"`
+	Error       string     `gorm:"type:text;comment:执行错误" json:"error,omitempty"`
+}
@@ -0,0 +1,42 @@
+// Package autodeploy consul配置变更自动发布记录
+package autodeploy
+
+import "time"
+
+// 自动发布动作
+const (
+	ActionRedeploy = "redeploy" // 重新注册job
+	ActionSkip     = "skip"     // 检测到变更但未执行
+)
+
+// 自动发布结果
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// 暂停自动发布在redis中的key
+const (
+	SuspendKeyPrefix = "autodeploy:suspend"
+	SuspendGlobal    = "global"
+)
+
+// AutoDeployRecord 配置变更触发的自动发布记录
+type AutoDeployRecord struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Key         string    `gorm:"type:varchar(255);index;comment:consul key" json:"key"`
+	ServerID    string    `gorm:"type:varchar(100);index;comment:服务器ID" json:"server_id"`
+	ModifyIndex uint64    `gorm:"comment:consul ModifyIndex" json:"modify_index"`
+	Action      string    `gorm:"type:varchar(20);comment:动作:redeploy,skip" json:"action"`
+	Status      string    `gorm:"type:varchar(20);comment:结果:success,failed,skipped" json:"status"`
+	EvalID      string    `gorm:"type:varchar(100);comment:Nomad Evaluation ID" json:"eval_id"`
+	Message     string    `gorm:"type:text;comment:说明" json:"message"`
+}
+
+// Suspension 暂停自动发布的状态
+type Suspension struct {
+	Target    string `json:"target"`     // global或者服务器ID
+	ExpiresIn int64  `json:"expires_in"` // 剩余秒数，-1表示不会自动恢复
+}
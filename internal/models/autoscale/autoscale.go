@@ -0,0 +1,101 @@
+// Package autoscale nomad任务组的自动伸缩策略
+//
+// 每次评估按以下顺序决定目标数量:
+//
+//  1. 手动覆盖未过期时使用覆盖的数量，不受冷却期限制
+//  2. 指标规则命中时在当前数量上增减step，当前定时计划的数量作为下限
+//  3. 没有规则命中时使用当前生效的定时计划数量，没有计划时保持不变
+//  4. 结果限制在[min,max]之间，冷却期内不执行指标和计划触发的伸缩
+package autoscale
+
+import "time"
+
+// 指标，取任务组所有运行中分配的平均值
+const (
+	MetricCPU    = "cpu_percent"    // cpu使用量占分配cpu的百分比
+	MetricMemory = "memory_percent" // 内存使用量占分配内存的百分比
+)
+
+// 伸缩来源
+const (
+	SourceOverride = "override"
+	SourceMetric   = "metric"
+	SourceSchedule = "schedule"
+	SourceManual   = "manual"
+)
+
+// 伸缩结果
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Schedule 定时计划，cron触发后目标数量为count，直到下一个计划触发
+type Schedule struct {
+	Cron  string `json:"cron"`
+	Count int    `json:"count"`
+}
+
+// MetricRule 阈值规则，如cpu_percent > 80时step为1
+type MetricRule struct {
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"` // >或<
+	Threshold float64 `json:"threshold"`
+	Step      int     `json:"step"` // 扩容为正数，缩容为负数
+}
+
+// ScalingPolicy 任务组的伸缩策略，每个任务组只能有一个
+type ScalingPolicy struct {
+	ID              uint         `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+	Name            string       `gorm:"type:varchar(100);comment:名称" json:"name"`
+	Cluster         string       `gorm:"type:varchar(50);uniqueIndex:idx_scaling_group;comment:集群,为空时为默认集群" json:"cluster"`
+	JobID           string       `gorm:"type:varchar(100);uniqueIndex:idx_scaling_group;comment:nomad job" json:"job_id"`
+	Group           string       `gorm:"type:varchar(100);uniqueIndex:idx_scaling_group;comment:任务组" json:"group"`
+	Min             int          `gorm:"comment:最小数量" json:"min"`
+	Max             int          `gorm:"comment:最大数量" json:"max"`
+	Schedules       []Schedule   `gorm:"serializer:json;type:json;comment:定时计划" json:"schedules"`
+	Rules           []MetricRule `gorm:"serializer:json;type:json;comment:指标规则" json:"rules"`
+	Cooldown        int          `gorm:"default:300;comment:两次伸缩的最小间隔(秒)" json:"cooldown"`
+	Enabled         bool         `gorm:"default:true;comment:是否启用" json:"enabled"`
+	OverrideCount   *int         `gorm:"comment:手动覆盖的数量" json:"override_count"`
+	OverrideUntil   *time.Time   `gorm:"comment:手动覆盖的过期时间" json:"override_until"`
+	LastScaleAt     *time.Time   `gorm:"comment:最近一次伸缩时间" json:"last_scale_at"`
+	LastEvaluatedAt *time.Time   `gorm:"comment:最近一次评估时间" json:"last_evaluated_at"`
+	LastDecision    string       `gorm:"type:varchar(500);comment:最近一次评估结果" json:"last_decision"`
+}
+
+// ScalingEvent 一次伸缩记录，手动伸缩的PolicyID为0
+type ScalingEvent struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	PolicyID  uint      `gorm:"index;comment:策略" json:"policy_id"`
+	Cluster   string    `gorm:"type:varchar(50);comment:集群" json:"cluster"`
+	JobID     string    `gorm:"type:varchar(100);index;comment:nomad job" json:"job_id"`
+	Group     string    `gorm:"type:varchar(100);comment:任务组" json:"group"`
+	From      int       `gorm:"comment:伸缩前数量" json:"from"`
+	To        int       `gorm:"comment:伸缩后数量" json:"to"`
+	Source    string    `gorm:"type:varchar(20);comment:来源:override,metric,schedule,manual" json:"source"`
+	Reason    string    `gorm:"type:varchar(500);comment:原因" json:"reason"`
+	Status    string    `gorm:"type:varchar(20);comment:结果" json:"status"`
+	EvalID    string    `gorm:"type:varchar(100);comment:Nomad Evaluation ID" json:"eval_id"`
+	Operator  string    `gorm:"type:varchar(100);comment:操作人,自动伸缩为空" json:"operator"`
+	Error     string    `gorm:"type:text;comment:错误" json:"error,omitempty"`
+}
+
+// Decision 一次评估的结果
+type Decision struct {
+	Current int                `json:"current"`
+	Target  int                `json:"target"`
+	Scale   bool               `json:"scale"` // 是否需要执行伸缩
+	Source  string             `json:"source"`
+	Reason  string             `json:"reason"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// OverridePayload 手动覆盖目标数量，duration如2h，覆盖期间不执行计划和指标规则
+type OverridePayload struct {
+	Count    int    `json:"count"`
+	Duration string `json:"duration"`
+}
@@ -0,0 +1,102 @@
+// Package crossserver 跨服分组
+//
+// 分组发布到consul的key由CROSS_SERVER_KV_KEY指定(默认cross_server/groups)，值为json:
+//
+//	{
+//	  "season_id": 3,
+//	  "name": "S3",
+//	  "effective_at": "2026-01-01T00:00:00+08:00",
+//	  "expire_at": "2026-03-01T00:00:00+08:00",
+//	  "published_at": "2025-12-31T23:59:00+08:00",
+//	  "groups": [{"group": 1, "servers": ["10001", "10002"]}],
+//	  "servers": {"10001": 1, "10002": 1}
+//	}
+//
+// groups按分组序号升序，servers为服务器ID到分组序号的映射，游戏进程按自身服务器ID查询即可。
+// expire_at为空表示长期有效，未分组的服务器不会出现在servers中。
+package crossserver
+
+import "time"
+
+// 赛季状态
+const (
+	StatusDraft     = "draft"     // 编辑中
+	StatusScheduled = "scheduled" // 等待生效时间自动发布
+	StatusPublished = "published" // 已发布到consul
+	StatusExpired   = "expired"   // 被新的赛季替换
+)
+
+// 审计动作
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionGroups    = "groups"
+	ActionRebalance = "rebalance"
+	ActionSchedule  = "schedule"
+	ActionPublish   = "publish"
+	ActionDelete    = "delete"
+)
+
+// CrossSeason 一个赛季的跨服分组方案
+type CrossSeason struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Name        string     `gorm:"type:varchar(100);comment:名称" json:"name"`
+	EffectiveAt time.Time  `gorm:"comment:生效时间" json:"effective_at"`
+	ExpireAt    *time.Time `gorm:"comment:失效时间" json:"expire_at,omitempty"`
+	Status      string     `gorm:"type:varchar(20);default:draft;index;comment:状态" json:"status"`
+	PublishedAt *time.Time `gorm:"comment:发布时间" json:"published_at,omitempty"`
+	Comment     string     `gorm:"type:text;comment:备注" json:"comment"`
+}
+
+// CrossGroup 服务器所属的分组
+type CrossGroup struct {
+	ID       uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	SeasonID uint   `gorm:"index;comment:赛季ID" json:"season_id"`
+	GroupNo  int    `gorm:"comment:分组序号" json:"group_no"`
+	ServerID string `gorm:"type:varchar(100);comment:服务器ID" json:"server_id"`
+}
+
+// CrossGroupAudit 跨服分组操作记录
+type CrossGroupAudit struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	SeasonID  uint      `gorm:"index;comment:赛季ID" json:"season_id"`
+	Action    string    `gorm:"type:varchar(20);comment:动作" json:"action"`
+	Operator  string    `gorm:"type:varchar(100);comment:操作人" json:"operator"`
+	Detail    string    `gorm:"type:text;comment:详情" json:"detail"`
+}
+
+// GroupServers 一个分组的服务器
+type GroupServers struct {
+	Group   int      `json:"group"`
+	Servers []string `json:"servers"`
+}
+
+// RebalancePayload 重新分组的参数，group_count和group_size二选一
+type RebalancePayload struct {
+	ChannelID  uint     `json:"channel_id"`
+	ServerIDs  []string `json:"server_ids"`
+	GroupCount int      `json:"group_count"`
+	GroupSize  int      `json:"group_size"` // 每组服务器数量
+	DryRun     bool     `json:"dry_run"`
+}
+
+// ServerInfo 分组时使用的服务器信息
+type ServerInfo struct {
+	ServerID   string     `json:"server_id"`
+	OpenAt     *time.Time `json:"open_at"`
+	Population int        `json:"population"`
+}
+
+// Mapping 发布到consul的分组数据
+type Mapping struct {
+	SeasonID    uint           `json:"season_id"`
+	Name        string         `json:"name"`
+	EffectiveAt time.Time      `json:"effective_at"`
+	ExpireAt    *time.Time     `json:"expire_at"`
+	PublishedAt time.Time      `json:"published_at"`
+	Groups      []GroupServers `json:"groups"`
+	Servers     map[string]int `json:"servers"`
+}
@@ -0,0 +1,71 @@
+// Package environment 发布环境和环境之间的晋级
+//
+// 环境按Stage从小到大排列(如dev→staging→prod)，配置和安装包只能晋级到下一个环境。
+// 目标环境开启RequireApproval时，晋级需要有审批权限的非申请人审批后才会执行。
+package environment
+
+import "time"
+
+// 晋级内容类型
+const (
+	KindConfig  = "config"  // 游戏服配置，Artifact为服务器ID(consul key去掉前缀)
+	KindPackage = "package" // 安装包，Artifact为数据源路径下的相对路径
+)
+
+// 晋级状态
+const (
+	StatusPending  = "pending"  // 等待审批
+	StatusApproved = "approved" // 审批通过，执行中
+	StatusApplied  = "applied"  // 已执行
+	StatusRejected = "rejected" // 已拒绝
+	StatusFailed   = "failed"   // 执行失败
+)
+
+// Environment 发布环境
+type Environment struct {
+	ID              uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Name            string    `gorm:"type:varchar(50);uniqueIndex;comment:名称" json:"name"`
+	Stage           int       `gorm:"uniqueIndex;comment:顺序,越大越靠后" json:"stage"`
+	ConsulPrefix    string    `gorm:"type:varchar(255);comment:consul中存放游戏服配置的前缀" json:"consul_prefix"`
+	Cluster         string    `gorm:"type:varchar(50);comment:配置所在的集群,为空时为游戏服所属集群" json:"cluster"`
+	NomadNamespace  string    `gorm:"type:varchar(100);comment:nomad namespace" json:"nomad_namespace"`
+	NomadRegion     string    `gorm:"type:varchar(100);comment:nomad region" json:"nomad_region"`
+	DatasourceID    uint      `gorm:"comment:安装包存放的数据源" json:"datasource_id"`
+	RequireApproval bool      `gorm:"default:false;comment:晋级到该环境需要审批" json:"require_approval"`
+}
+
+// Promotion 一次晋级，ParentID指向把该内容带入源环境的晋级，形成血缘链
+type Promotion struct {
+	ID                uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	Kind              string     `gorm:"type:varchar(20);index:idx_promotion_artifact;comment:类型" json:"kind"`
+	Artifact          string     `gorm:"type:varchar(255);index:idx_promotion_artifact;comment:服务器ID或安装包路径" json:"artifact"`
+	SourceEnvID       uint       `gorm:"comment:源环境" json:"source_env_id"`
+	SourceEnv         string     `gorm:"type:varchar(50)" json:"source_env"`
+	TargetEnvID       uint       `gorm:"index;comment:目标环境" json:"target_env_id"`
+	TargetEnv         string     `gorm:"type:varchar(50)" json:"target_env"`
+	ParentID          *uint      `gorm:"comment:上游晋级" json:"parent_id,omitempty"`
+	Checksum          string     `gorm:"type:varchar(64);comment:晋级内容sha256" json:"checksum"`
+	Content           string     `gorm:"type:mediumtext;comment:晋级后的配置内容" json:"content,omitempty"`
+	TargetModifyIndex uint64     `gorm:"comment:申请时目标配置的ModifyIndex" json:"-"`
+	Diff              string     `gorm:"type:mediumtext;comment:与目标环境的差异" json:"diff"`
+	Status            string     `gorm:"type:varchar(20);index;comment:状态" json:"status"`
+	Requester         string     `gorm:"type:varchar(100);comment:申请人" json:"requester"`
+	RequesterID       uint       `gorm:"comment:申请人ID" json:"requester_id"`
+	Approver          string     `gorm:"type:varchar(100);comment:审批人" json:"approver"`
+	ApproverID        uint       `gorm:"comment:审批人ID" json:"approver_id"`
+	Comment           string     `gorm:"type:varchar(255);comment:备注" json:"comment"`
+	Error             string     `gorm:"type:text;comment:错误" json:"error,omitempty"`
+	AppliedAt         *time.Time `json:"applied_at,omitempty"`
+}
+
+// PromotePayload 晋级请求参数
+type PromotePayload struct {
+	Kind        string `json:"kind"`
+	Artifact    string `json:"artifact"`
+	SourceEnvID uint   `json:"source_env_id"`
+	Comment     string `json:"comment"`
+}
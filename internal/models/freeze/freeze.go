@@ -0,0 +1,50 @@
+// Package freeze 变更冻结窗口，高峰期、节假日和活动期间拒绝非紧急变更
+package freeze
+
+import "time"
+
+// OverridePermission 冻结期间强制变更需要的权限，同时需要在X-Freeze-Override请求头中填写原因
+const OverridePermission = "freeze:override"
+
+// 冻结范围
+const (
+	ScopeGlobal  = "global"  // 所有变更，包括上传服务器端
+	ScopeChannel = "channel" // 指定渠道下的游戏服
+	ScopeServer  = "server"  // 指定游戏服
+)
+
+// FreezeWindow 冻结窗口
+// 不设置Cron时为一次性窗口[StartAt, EndAt)；设置Cron时每次触发后冻结Duration分钟，StartAt和EndAt为生效范围，EndAt为空时一直生效
+type FreezeWindow struct {
+	ID         uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Name       string     `gorm:"type:varchar(100);comment:名称" json:"name"`
+	Reason     string     `gorm:"type:varchar(500);comment:冻结原因" json:"reason"`
+	Scope      string     `gorm:"type:varchar(20);comment:范围:global,channel,server" json:"scope"`
+	ChannelIDs []uint     `gorm:"serializer:json;type:json;comment:渠道" json:"channel_ids"`
+	ServerIDs  []string   `gorm:"serializer:json;type:json;comment:游戏服" json:"server_ids"`
+	StartAt    time.Time  `gorm:"comment:开始时间" json:"start_at"`
+	EndAt      *time.Time `gorm:"comment:结束时间" json:"end_at"`
+	Cron       string     `gorm:"type:varchar(100);comment:重复规则" json:"cron"`
+	Duration   int        `gorm:"comment:每次冻结的时长(分钟)" json:"duration"`
+	Enabled    bool       `gorm:"default:true;comment:是否启用" json:"enabled"`
+	Creator    string     `gorm:"type:varchar(100);comment:创建人" json:"creator"`
+}
+
+// FreezeOverride 冻结期间强制变更的记录
+type FreezeOverride struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	WindowID  uint      `gorm:"index;comment:冻结窗口" json:"window_id"`
+	Operator  string    `gorm:"type:varchar(100);comment:操作人" json:"operator"`
+	Action    string    `gorm:"type:varchar(100);comment:操作" json:"action"`
+	Targets   string    `gorm:"type:text;comment:操作目标" json:"targets"`
+	Reason    string    `gorm:"type:varchar(500);comment:原因" json:"reason"`
+}
+
+// Period 一次冻结的时间段
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
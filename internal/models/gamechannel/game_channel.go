@@ -12,4 +12,5 @@ type Channels struct {
 	DeletedAt   *time.Time `gorm:"index" json:"deleted_at,omitempty"`
 	Name        string     `gorm:"type:text;comment:名称" json:"name"`
 	Description string     `gorm:"type:text;comment:描述" json:"description"`
+	Cluster     string     `gorm:"type:varchar(50);comment:所属集群" json:"cluster"` // 渠道下游戏服默认部署的集群
 }
@@ -0,0 +1,22 @@
+package gamehost
+
+import "time"
+
+// 端口占用来源
+const (
+	PortSourceManual = "manual" // 手动预留
+	PortSourceConfig = "config" // 保存游戏服配置时自动登记
+)
+
+// PortReservation 主机端口登记，同一主机上不同游戏服的端口范围不能重叠
+type PortReservation struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	HostID      uint      `gorm:"index;comment:主机ID" json:"host_id"`
+	Host        *Hosts    `gorm:"foreignKey:HostID" json:"host,omitempty"` // 外键关系
+	ServerID    string    `gorm:"type:varchar(100);index;comment:服务器ID" json:"server_id"`
+	StartPort   int       `gorm:"comment:起始端口" json:"start_port"`
+	EndPort     int       `gorm:"comment:结束端口" json:"end_port"`
+	Source      string    `gorm:"type:varchar(20);comment:来源:manual,config" json:"source"`
+	Description string    `gorm:"type:text;comment:描述" json:"description"`
+}
@@ -8,16 +8,22 @@ import (
 
 // Games 游戏逻辑服
 type Games struct {
-	ID        uint                  `gorm:"primaryKey;autoIncrement" json:"id"`
-	CreatedAt time.Time             `json:"created_at"`
-	UpdatedAt time.Time             `json:"updated_at"`
-	DeletedAt *time.Time            `gorm:"index" json:"deleted_at,omitempty"`
-	Name      string                `gorm:"type:text;comment:名称" json:"name"`
-	ServerID  string                `gorm:"type:text;comment:服务器ID" json:"server_id"`
-	Status    string                `gorm:"type:text;comment:服务器状态" json:"status"`
-	ChannelID *uint                 `gorm:"comment:渠道ID" json:"channel_id,omitempty"`
-	Channel   *gamechannel.Channels `gorm:"foreignKey:ChannelID" json:"channel,omitempty"` // 外键关系
-	ServerDir string                `gorm:"type:text;comment:服务器端家目录" json:"server_dir"`
+	ID             uint                  `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+	DeletedAt      *time.Time            `gorm:"index" json:"deleted_at,omitempty"`
+	Name           string                `gorm:"type:text;comment:名称" json:"name"`
+	ServerID       string                `gorm:"type:varchar(100);uniqueIndex;comment:服务器ID" json:"server_id"`
+	Status         string                `gorm:"type:text;comment:服务器状态" json:"status"`
+	ChannelID      *uint                 `gorm:"comment:渠道ID" json:"channel_id,omitempty"`
+	Channel        *gamechannel.Channels `gorm:"foreignKey:ChannelID" json:"channel,omitempty"` // 外键关系
+	ServerDir      string                `gorm:"type:text;comment:服务器端家目录" json:"server_dir"`
+	AutoDeploy     bool                  `gorm:"default:false;comment:配置变更自动发布" json:"auto_deploy"` // consul中配置变更后自动重新注册job
+	OpenAt         *time.Time            `gorm:"comment:开服时间" json:"open_at,omitempty"`
+	Population     int                   `gorm:"default:0;comment:服务器规模" json:"population"`    // 活跃人数等规模指标，跨服分组时使用
+	Cluster        string                `gorm:"type:varchar(50);comment:所属集群" json:"cluster"` // 为空时使用渠道的集群
+	PackageVersion string                `gorm:"type:varchar(100);comment:运行的安装包版本" json:"package_version"`
+	Health         string                `gorm:"-" json:"health,omitempty"` // consul健康检查汇总状态，不入库
 }
 
 // GameHosts 逻辑服与主机关系
@@ -0,0 +1,37 @@
+package gameserver
+
+// consul健康检查状态，汇总时取最差的状态
+const (
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+	HealthUnknown  = "unknown" // 没有注册服务
+)
+
+// HealthCheck consul健康检查
+type HealthCheck struct {
+	CheckID string `json:"check_id"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Output  string `json:"output"`
+}
+
+// ServiceHealth 游戏服在consul中注册的服务
+type ServiceHealth struct {
+	ServiceID string        `json:"service_id"`
+	Service   string        `json:"service"`
+	Node      string        `json:"node"`
+	Address   string        `json:"address"`
+	Port      int           `json:"port"`
+	Tags      []string      `json:"tags"`
+	Status    string        `json:"status"`
+	Checks    []HealthCheck `json:"checks"`
+}
+
+// GameHealth 游戏服及其服务的健康状态
+type GameHealth struct {
+	ServerID string          `json:"server_id"`
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	Services []ServiceHealth `json:"services"`
+}
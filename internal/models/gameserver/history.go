@@ -0,0 +1,59 @@
+package gameserver
+
+import "time"
+
+// 操作触发来源
+const (
+	TriggerUser   = "user"   // 页面操作
+	TriggerAPI    = "api"    // ak/sk调用
+	TriggerCron   = "cron"   // 计划任务
+	TriggerSystem = "system" // 自动回滚等系统行为
+)
+
+// 操作结果
+const (
+	OperationSuccess = "success"
+	OperationFailed  = "failed"
+)
+
+// 时间线条目类型
+const (
+	TimelineOperation = "operation" // 开关服、一次性任务等操作
+	TimelineConfig    = "config"    // nomad job版本，即每次注册的配置
+	TimelineStatus    = "status"    // 游戏服状态变化
+)
+
+// GameOperation 游戏服的一次操作记录
+type GameOperation struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	ServerID    string    `gorm:"type:varchar(100);index:idx_game_operation_server;comment:服务器ID" json:"server_id"`
+	Operation   string    `gorm:"type:varchar(30);comment:操作:start,stop,signal,dispatch,revert" json:"operation"`
+	Trigger     string    `gorm:"type:varchar(20);comment:触发来源:user,api,cron,system" json:"trigger"`
+	Operator    string    `gorm:"type:varchar(100);comment:操作人或计划任务" json:"operator"`
+	OperationID uint      `gorm:"comment:所属的运维操作,没有时为0" json:"operation_id,omitempty"`
+	EvalID      string    `gorm:"type:varchar(100);comment:Nomad Evaluation ID" json:"eval_id"`
+	Status      string    `gorm:"type:varchar(20);comment:结果" json:"status"`
+	Error       string    `gorm:"type:text;comment:错误" json:"error,omitempty"`
+	StartedAt   time.Time `gorm:"index:idx_game_operation_server;comment:开始时间" json:"started_at"`
+	FinishedAt  time.Time `gorm:"comment:结束时间" json:"finished_at"`
+	Duration    int64     `gorm:"comment:耗时(毫秒)" json:"duration"`
+}
+
+// GameStatusChange 游戏服状态变化记录
+type GameStatusChange struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `gorm:"index:idx_game_status_server" json:"created_at"`
+	ServerID  string    `gorm:"type:varchar(100);index:idx_game_status_server;comment:服务器ID" json:"server_id"`
+	From      string    `gorm:"type:varchar(20);comment:变化前状态" json:"from"`
+	To        string    `gorm:"type:varchar(20);comment:变化后状态" json:"to"`
+	Reason    string    `gorm:"type:varchar(200);comment:原因" json:"reason"`
+}
+
+// TimelineEntry 游戏服时间线条目，按时间倒序
+type TimelineEntry struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Summary string    `json:"summary"`
+	Detail  any       `json:"detail"`
+}
@@ -0,0 +1,44 @@
+package gameserver
+
+// ServerRecord 逻辑服导入导出的一行数据
+// 表格中hosts使用";"分隔，vars使用"k=v;k=v"格式
+type ServerRecord struct {
+	Name       string            `json:"name"`
+	ServerID   string            `json:"server_id"`
+	Channel    string            `json:"channel"`            // 渠道名称或ID
+	ServerDir  string            `json:"server_dir"`         // 服务器端家目录
+	Hosts      []string          `json:"hosts"`              // 主机名或内网IP
+	Template   string            `json:"template,omitempty"` // consul中配置模板名称
+	Vars       map[string]string `json:"vars,omitempty"`     // 配置模板变量
+	Setting    string            `json:"setting,omitempty"`  // nomad job配置，设置后忽略template
+	AutoDeploy bool              `json:"auto_deploy"`
+	Line       int               `json:"-"` // 在导入文件中的行号，json为数组中的序号
+}
+
+// 导入动作
+const (
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+	ImportActionSkip   = "skip"
+)
+
+// ImportRowResult 单行导入校验结果
+type ImportRowResult struct {
+	Line     int      `json:"line"`
+	ServerID string   `json:"server_id"`
+	Action   string   `json:"action"`
+	Config   bool     `json:"config"` // 是否写入consul配置
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// ImportReport 导入校验报告
+type ImportReport struct {
+	DryRun    bool              `json:"dry_run"`
+	Committed bool              `json:"committed"`
+	Total     int               `json:"total"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Skipped   int               `json:"skipped"`
+	Invalid   int               `json:"invalid"`
+	Rows      []ImportRowResult `json:"rows"`
+}
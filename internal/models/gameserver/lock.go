@@ -0,0 +1,24 @@
+package gameserver
+
+import "time"
+
+// 操作锁类型
+const (
+	LockServer  = "server"  // 单个游戏服
+	LockChannel = "channel" // 渠道，批量操作时加锁
+	LockGlobal  = "global"  // 与游戏服无关的共享资源，如上传使用的本地目录
+)
+
+// ForceReleaseLockPermission 强制释放其他人持有的操作锁需要的权限
+const ForceReleaseLockPermission = "lock:force_release"
+
+// LockInfo 操作锁信息，保存在redis中
+type LockInfo struct {
+	Kind      string        `json:"kind"`
+	ID        string        `json:"id"`
+	Owner     string        `json:"owner"`
+	Operation string        `json:"operation"`
+	Since     time.Time     `json:"since"`
+	Token     string        `json:"token,omitempty"`
+	TTL       time.Duration `json:"ttl,omitempty"`
+}
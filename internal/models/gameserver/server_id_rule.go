@@ -0,0 +1,17 @@
+package gameserver
+
+import "time"
+
+// ServerIDRule 渠道的服务器ID分配规则
+// 生成的服务器ID为 Prefix + 左补零到Width位的序号，序号从Start开始按Step递增
+type ServerIDRule struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ChannelID uint      `gorm:"uniqueIndex;comment:渠道ID" json:"channel_id"`
+	Prefix    string    `gorm:"type:varchar(50);comment:前缀" json:"prefix"`
+	Start     int64     `gorm:"comment:起始序号" json:"start"`
+	Step      int64     `gorm:"default:1;comment:步长" json:"step"`
+	Width     int       `gorm:"comment:序号位数，不足左补零" json:"width"`
+	Next      int64     `gorm:"comment:下一个序号" json:"next"`
+}
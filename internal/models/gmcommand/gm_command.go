@@ -0,0 +1,97 @@
+// Package gmcommand 游戏服GM命令
+//
+// 每个游戏服登记一个GM管理端点，按协议选择适配器发送命令:
+//
+//	http  POST http://<address>:<port><path>，body为模板渲染结果，2xx为成功
+//	tcp   连接<address>:<port>，有token时先发送"AUTH <token>"，
+//	      之后每行发送一条命令并读取一行响应，响应以"ERR"开头为失败
+package gmcommand
+
+import "time"
+
+// 协议
+const (
+	ProtocolHTTP = "http"
+	ProtocolTCP  = "tcp"
+)
+
+// 端点来源
+const (
+	SourceManual = "manual"
+	SourceConfig = "config"
+	SourceConsul = "consul"
+)
+
+// 执行触发来源
+const (
+	TriggerManual = "manual"
+	TriggerCron   = "cron"
+)
+
+// GMEndpoint 游戏服的GM管理端点
+type GMEndpoint struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ServerID  string    `gorm:"type:varchar(100);uniqueIndex;comment:服务器ID" json:"server_id"`
+	Protocol  string    `gorm:"type:varchar(20);default:http;comment:协议:http,tcp" json:"protocol"`
+	Address   string    `gorm:"type:varchar(255);comment:地址" json:"address"`
+	Port      int       `gorm:"comment:端口" json:"port"`
+	Path      string    `gorm:"type:varchar(255);comment:http协议的请求路径" json:"path"`
+	Token     string    `gorm:"type:varchar(255);comment:认证token" json:"-"` // 不返回给前端
+	Source    string    `gorm:"type:varchar(20);comment:来源:manual,config,consul" json:"source"`
+	HasToken  bool      `gorm:"-" json:"has_token"` // 是否设置了token，不入库
+}
+
+// GMEndpointPayload 创建、更新GM端点时传参，更新时token为空表示不修改
+type GMEndpointPayload struct {
+	GMEndpoint
+	Token string `json:"token"`
+}
+
+// GMTemplate GM命令模板，Content使用text/template渲染
+// 可用变量: .ServerID .Params(执行时传入的参数)
+type GMTemplate struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `gorm:"type:varchar(100);uniqueIndex;comment:名称" json:"name"`
+	Description string    `gorm:"type:varchar(255);comment:描述" json:"description"`
+	Content     string    `gorm:"type:text;comment:命令模板" json:"content"`
+	Params      string    `gorm:"type:varchar(255);comment:必填参数,逗号分隔" json:"params"`
+	Permission  string    `gorm:"type:varchar(100);comment:执行需要的权限,为空时不校验" json:"permission"`
+	Timeout     int       `gorm:"default:10;comment:单个服务器超时时间(秒)" json:"timeout"`
+}
+
+// GMExecution 一次GM命令执行
+type GMExecution struct {
+	ID           uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	TemplateID   uint       `gorm:"index;comment:模板ID" json:"template_id"`
+	TemplateName string     `gorm:"type:varchar(100);comment:模板名称" json:"template_name"`
+	Operator     string     `gorm:"type:varchar(100);comment:操作人" json:"operator"`
+	Trigger      string     `gorm:"type:varchar(20);comment:触发来源" json:"trigger"`
+	Params       string     `gorm:"type:text;comment:参数" json:"params"`
+	Total        int        `json:"total"`
+	Success      int        `json:"success"`
+	Failed       int        `json:"failed"`
+	FinishedAt   *time.Time `json:"finished_at"`
+}
+
+// GMExecutionResult 单个服务器的执行结果
+type GMExecutionResult struct {
+	ID          uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	ExecutionID uint   `gorm:"index;comment:执行ID" json:"execution_id"`
+	ServerID    string `gorm:"type:varchar(100);comment:服务器ID" json:"server_id"`
+	Success     bool   `json:"success"`
+	Output      string `gorm:"type:text;comment:响应" json:"output"`
+	Error       string `gorm:"type:text;comment:错误" json:"error"`
+	Duration    int64  `gorm:"comment:耗时(毫秒)" json:"duration"`
+}
+
+// ExecutePayload 执行GM命令的请求参数
+type ExecutePayload struct {
+	TemplateID uint              `json:"template_id"`
+	ServerIDs  []string          `json:"server_ids"`
+	Params     map[string]string `json:"params"`
+}
@@ -4,3 +4,81 @@ package nomadjob
 type ScalePayload struct {
 	Target string `json:"target"`
 }
+
+// EligibilityPayload 设置节点是否可以调度
+type EligibilityPayload struct {
+	Eligible bool `json:"eligible"`
+}
+
+// DrainPayload 节点排空参数
+type DrainPayload struct {
+	Deadline         string `json:"deadline"`           // 超时时间，如30m、1h，为空时默认1h
+	NoDeadline       bool   `json:"no_deadline"`        // 不设置超时，等待所有分配迁移完成
+	Force            bool   `json:"force"`              // 立即停止节点上的所有分配
+	IgnoreSystemJobs bool   `json:"ignore_system_jobs"` // 不迁移system类型的job
+}
+
+// NodeMetaPayload 修改节点动态元数据，值为null时删除该key
+type NodeMetaPayload struct {
+	Meta map[string]*string `json:"meta"`
+}
+
+// AllocationInfo 游戏服job的分配信息
+type AllocationInfo struct {
+	ID            string                    `json:"id"`
+	Name          string                    `json:"name"`
+	NodeID        string                    `json:"node_id"`
+	NodeName      string                    `json:"node_name"`
+	TaskGroup     string                    `json:"task_group"`
+	JobVersion    uint64                    `json:"job_version"`
+	DesiredStatus string                    `json:"desired_status"`
+	ClientStatus  string                    `json:"client_status"`
+	CreateTime    int64                     `json:"create_time"`
+	ModifyTime    int64                     `json:"modify_time"`
+	Tasks         map[string]*TaskStateInfo `json:"tasks"`
+}
+
+// TaskStateInfo 分配中单个task的状态
+type TaskStateInfo struct {
+	State       string           `json:"state"`
+	Failed      bool             `json:"failed"`
+	Restarts    uint64           `json:"restarts"`
+	LastRestart int64            `json:"last_restart"`
+	StartedAt   int64            `json:"started_at"`
+	FinishedAt  int64            `json:"finished_at"`
+	Events      []*TaskEventInfo `json:"events"`
+}
+
+// TaskEventInfo task事件，time为毫秒时间戳
+type TaskEventInfo struct {
+	Type    string            `json:"type"`
+	Time    int64             `json:"time"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details"` // 包含exit_code、signal等
+}
+
+// RevertPayload 回滚job版本参数
+type RevertPayload struct {
+	SyncConfig bool `json:"sync_config"` // 同时将该版本的hcl写回consul
+}
+
+// PromotePayload 提升金丝雀，groups为空时提升所有任务组
+type PromotePayload struct {
+	Groups []string `json:"groups"`
+}
+
+// NomadEvent 从nomad事件流转换的内部事件，不同topic只填充相关字段
+type NomadEvent struct {
+	Cluster      string `json:"cluster"`
+	Topic        string `json:"topic"` // Job、Allocation、Deployment、Evaluation、Node
+	Type         string `json:"type"`  // nomad事件类型，如AllocationUpdated、DeploymentStatusUpdate
+	Key          string `json:"key"`
+	Index        uint64 `json:"index"`
+	JobID        string `json:"job_id,omitempty"`
+	EvalID       string `json:"eval_id,omitempty"`
+	AllocID      string `json:"alloc_id,omitempty"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	NodeID       string `json:"node_id,omitempty"`
+	Status       string `json:"status,omitempty"` // job、deployment、evaluation、node的状态，分配为client状态
+	Description  string `json:"description,omitempty"`
+}
@@ -14,14 +14,36 @@ gameops
 gamedeploy
 customjob
 cronjob
+configchange
+autodeploy
+crossserver
+serverlist
+gmcommand
+servicehealth
+promotion
+nodeops
+deployment
+autoscale
+approval
 */
 const (
-	EventChannel        string = "event:notification"
-	EventTypeUpload     string = "upload"
-	EventTypeGameOps    string = "gameops"
-	EventTypeGameDeploy string = "gamedeploy"
-	EventTypeCustomJob  string = "customjob"
-	EventTypeCronJob    string = "cronjob"
+	EventChannel           string = "event:notification"
+	EventTypeUpload        string = "upload"
+	EventTypeGameOps       string = "gameops"
+	EventTypeGameDeploy    string = "gamedeploy"
+	EventTypeCustomJob     string = "customjob"
+	EventTypeCronJob       string = "cronjob"
+	EventTypeConfigChange  string = "configchange"
+	EventTypeAutoDeploy    string = "autodeploy"
+	EventTypeCrossServer   string = "crossserver"
+	EventTypeServerList    string = "serverlist"
+	EventTypeGMCommand     string = "gmcommand"
+	EventTypeServiceHealth string = "servicehealth"
+	EventTypePromotion     string = "promotion"
+	EventTypeNodeOps       string = "nodeops"
+	EventTypeDeployment    string = "deployment"
+	EventTypeAutoscale     string = "autoscale"
+	EventTypeApproval      string = "approval"
 )
 
 // status 通知订阅状态
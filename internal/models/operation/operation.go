@@ -0,0 +1,63 @@
+// Package operation 后台执行的运维操作，保存每行日志和每个目标的结果，客户端可以断开后按Last-Event-ID重新订阅
+package operation
+
+import "time"
+
+// 操作类型
+const (
+	KindGameOps      = "game_ops"      // 游戏服开关、信号
+	KindGameDispatch = "game_dispatch" // 游戏服一次性任务
+	KindUpload       = "upload"        // 上传服务器端
+	KindGameDeploy   = "game_deploy"   // 执行发布任务
+)
+
+// 操作状态
+const (
+	StatusRunning     = "running"
+	StatusSuccess     = "success"
+	StatusFailed      = "failed"      // 全部或部分目标失败
+	StatusInterrupted = "interrupted" // 执行的服务实例退出时仍在运行
+)
+
+// Operation 一次运维操作
+type Operation struct {
+	ID           uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Kind         string     `gorm:"type:varchar(30);index;comment:操作类型" json:"kind"`
+	Action       string     `gorm:"type:varchar(50);comment:具体操作,如start,stop" json:"action"`
+	Targets      string     `gorm:"type:text;comment:操作目标,逗号分隔" json:"targets"`
+	Params       string     `gorm:"type:text;comment:请求参数" json:"params"`
+	Operator     string     `gorm:"type:varchar(100);comment:操作人" json:"operator"`
+	Status       string     `gorm:"type:varchar(20);index;comment:状态" json:"status"`
+	SuccessCount int        `gorm:"comment:成功数量" json:"success_count"`
+	FailCount    int        `gorm:"comment:失败数量" json:"fail_count"`
+	LastSeq      int        `gorm:"comment:最后一行日志序号" json:"last_seq"`
+	FinishedAt   *time.Time `gorm:"comment:结束时间" json:"finished_at"`
+	Instance     string     `gorm:"type:varchar(100);index;comment:执行的服务实例" json:"instance"`
+	HeartbeatAt  *time.Time `gorm:"comment:实例最后心跳,超时未更新视为中断" json:"heartbeat_at"`
+}
+
+// OperationLog 操作日志，Seq从1开始递增，作为SSE的事件id
+type OperationLog struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	OperationID uint      `gorm:"uniqueIndex:idx_operation_seq;comment:操作" json:"operation_id"`
+	Seq         int       `gorm:"uniqueIndex:idx_operation_seq;comment:序号" json:"seq"`
+	Message     string    `gorm:"type:text;comment:日志" json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OperationResult 单个目标的执行结果
+type OperationResult struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	OperationID uint      `gorm:"index;comment:操作" json:"operation_id"`
+	Target      string    `gorm:"type:varchar(200);comment:目标" json:"target"`
+	Status      string    `gorm:"type:varchar(20);comment:结果:success,failed" json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OperationDetail 操作详情
+type OperationDetail struct {
+	Operation
+	Results []OperationResult `json:"results"`
+}
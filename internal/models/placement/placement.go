@@ -0,0 +1,58 @@
+// Package placement 新建游戏服的主机选择
+package placement
+
+// PlacementRequest 选择主机的条件
+type PlacementRequest struct {
+	ChannelID  uint     `json:"channel_id"`           // 同渠道的游戏服尽量分散到不同主机
+	GroupID    *uint    `json:"group_id,omitempty"`   // 限定主机组
+	Labels     []string `json:"labels,omitempty"`     // 主机必须包含的标签
+	Datacenter string   `json:"datacenter,omitempty"` // 限定nomad数据中心
+	Cluster    string   `json:"cluster,omitempty"`    // 为空时使用渠道的集群
+	Count      int      `json:"count"`                // 返回的候选数量，默认3
+	Setting    string   `json:"setting,omitempty"`    // 需要注入constraint的nomad job配置
+	Weights    *Weights `json:"weights,omitempty"`
+}
+
+// Weights 各项指标的权重，越大越看重
+type Weights struct {
+	CPU         float64 `json:"cpu"`
+	Memory      float64 `json:"memory"`
+	Allocations float64 `json:"allocations"`
+	GameServers float64 `json:"game_servers"`
+	SameChannel float64 `json:"same_channel"`
+}
+
+// DefaultWeights 默认权重
+var DefaultWeights = Weights{CPU: 0.2, Memory: 0.2, Allocations: 0.15, GameServers: 0.2, SameChannel: 0.25}
+
+// Candidate 候选主机及其负载
+type Candidate struct {
+	HostID      uint     `json:"host_id"`
+	Hostname    string   `json:"hostname"`
+	PrivateIP   string   `json:"private_ip"`
+	NodeID      string   `json:"node_id,omitempty"`
+	NodeName    string   `json:"node_name,omitempty"`
+	CPUUsage    float64  `json:"cpu_usage"`    // 百分比
+	MemoryUsage float64  `json:"memory_usage"` // 百分比
+	Allocations int      `json:"allocations"`  // 运行中的allocation数量
+	GameServers int      `json:"game_servers"` // 已分配的逻辑服数量
+	SameChannel int      `json:"same_channel"` // 同渠道的逻辑服数量
+	Score       float64  `json:"score"`        // 0-100，越高越推荐
+	Reasons     []string `json:"reasons,omitempty"`
+}
+
+// Excluded 被排除的主机
+type Excluded struct {
+	HostID   uint   `json:"host_id"`
+	Hostname string `json:"hostname"`
+	Reason   string `json:"reason"`
+}
+
+// PlacementResult 选择结果
+type PlacementResult struct {
+	Recommendation *Candidate  `json:"recommendation"`
+	Candidates     []Candidate `json:"candidates"`
+	Excluded       []Excluded  `json:"excluded,omitempty"`
+	Constraint     string      `json:"constraint,omitempty"` // 推荐节点的constraint块
+	Setting        string      `json:"setting,omitempty"`    // 注入constraint后的nomad job配置
+}
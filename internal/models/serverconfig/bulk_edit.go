@@ -0,0 +1,53 @@
+package serverconfig
+
+// BulkEditSelector 批量修改时选择游戏服的条件，多个条件之间取交集
+type BulkEditSelector struct {
+	ChannelID uint     `json:"channel_id,omitempty"` // 按渠道选择
+	ServerIDs []string `json:"server_ids,omitempty"` // 按ServerID列表选择
+	KeyRegex  string   `json:"key_regex,omitempty"`  // 按consul key(去掉前缀后)正则选择
+}
+
+// JobPatch 对解析后的nomad job进行修改
+// Group/Task为空时表示作用于所有group/task
+type JobPatch struct {
+	Group          string            `json:"group,omitempty"`
+	Task           string            `json:"task,omitempty"`
+	Meta           map[string]string `json:"meta,omitempty"`            // job级别meta
+	Env            map[string]string `json:"env,omitempty"`             // task级别env
+	ArtifactSource string            `json:"artifact_source,omitempty"` // task中artifact的source
+	Image          string            `json:"image,omitempty"`           // task config中的image
+	Count          *int              `json:"count,omitempty"`           // group的count
+}
+
+// TextReplace 文本替换,Regex为true时Find按正则处理
+type TextReplace struct {
+	Find    string `json:"find"`
+	Replace string `json:"replace"`
+	Regex   bool   `json:"regex"`
+}
+
+// RedeployOption 批量修改提交后的滚动发布选项
+type RedeployOption struct {
+	Enabled   bool `json:"enabled"`
+	BatchSize int  `json:"batch_size"` // 每批重新注册的job数量
+	Interval  int  `json:"interval"`   // 批次之间的间隔(秒)
+}
+
+// BulkEditPayload 批量修改游戏服配置的请求参数
+type BulkEditPayload struct {
+	Selector BulkEditSelector `json:"selector"`
+	Patch    *JobPatch        `json:"patch,omitempty"`
+	Replace  *TextReplace     `json:"replace,omitempty"`
+	Redeploy RedeployOption   `json:"redeploy"`
+}
+
+// BulkEditResult 单个游戏服配置的修改结果
+type BulkEditResult struct {
+	ServerID    string `json:"server_id"`
+	Key         string `json:"key"`
+	Changed     bool   `json:"changed"`
+	Diff        string `json:"diff,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ModifyIndex uint64 `json:"-"`
+	Setting     string `json:"-"`
+}
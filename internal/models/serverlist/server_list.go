@@ -0,0 +1,89 @@
+// Package serverlist 发布给游戏客户端的服务器列表
+//
+// 每个渠道上传两份文件到对象存储:
+//
+//	<path>/<channel_id>.json                    最新版本，客户端读取
+//	<path>/versions/<channel_id>/<version>.json 历史版本
+//
+// 文件内容为ServerList的json。
+package serverlist
+
+import "time"
+
+// 服务器在列表中的状态
+const (
+	StatusOnline      = "online"
+	StatusOffline     = "offline"
+	StatusMaintenance = "maintenance"
+)
+
+// 服务器标签
+const (
+	TagRecommended = "recommended"
+	TagNew         = "new"
+)
+
+// 发布触发来源
+const (
+	TriggerManual = "manual"
+	TriggerChange = "change"
+)
+
+// ServerListSetting 渠道服务器列表的发布配置
+type ServerListSetting struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ChannelID    uint      `gorm:"uniqueIndex;comment:渠道ID" json:"channel_id"`
+	DatasourceID uint      `gorm:"comment:对象存储ID" json:"datasource_id"`
+	Path         string    `gorm:"type:varchar(255);comment:存储路径" json:"path"`
+	Enabled      bool      `gorm:"default:true;comment:变更时自动发布" json:"enabled"`
+	KeepVersions int       `gorm:"default:20;comment:保留的历史版本数量" json:"keep_versions"`
+}
+
+// ServerListFlag 服务器在列表中的展示设置
+type ServerListFlag struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	ServerID    string    `gorm:"type:varchar(100);uniqueIndex;comment:服务器ID" json:"server_id"`
+	Maintenance bool      `gorm:"default:false;comment:维护中" json:"maintenance"`
+	Recommended bool      `gorm:"default:false;comment:推荐" json:"recommended"`
+	New         bool      `gorm:"default:false;comment:新服" json:"new"` // 开服时间在SERVER_LIST_NEW_DAYS内的服务器自动标记为新服
+	Hidden      bool      `gorm:"default:false;comment:不在列表中显示" json:"hidden"`
+	Address     string    `gorm:"type:varchar(255);comment:覆盖连接地址" json:"address"`
+	Port        int       `gorm:"comment:覆盖连接端口" json:"port"`
+	SortOrder   int       `gorm:"default:0;comment:排序，越大越靠前" json:"sort_order"`
+}
+
+// ServerListVersion 已发布的版本
+type ServerListVersion struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ChannelID uint      `gorm:"index;comment:渠道ID" json:"channel_id"`
+	Version   string    `gorm:"type:varchar(50);comment:版本" json:"version"`
+	Checksum  string    `gorm:"type:varchar(64);comment:内容sha256" json:"checksum"`
+	Path      string    `gorm:"type:varchar(255);comment:历史版本路径" json:"path"`
+	Trigger   string    `gorm:"type:varchar(20);comment:触发来源" json:"trigger"`
+	Content   string    `gorm:"type:mediumtext;comment:内容" json:"content,omitempty"`
+	Pending   bool      `gorm:"default:false;index;comment:上传中,上传完成前不作为已发布版本" json:"-"`
+}
+
+// ServerList 客户端读取的服务器列表
+type ServerList struct {
+	ChannelID   uint          `json:"channel_id"`
+	Channel     string        `json:"channel"`
+	Version     string        `json:"version"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Servers     []ServerEntry `json:"servers"`
+}
+
+// ServerEntry 列表中的一个服务器
+type ServerEntry struct {
+	ServerID    string   `json:"server_id"`
+	Name        string   `json:"name"`
+	Address     string   `json:"address"`
+	Port        int      `json:"port"`
+	Status      string   `json:"status"`
+	Maintenance bool     `json:"maintenance"`
+	Tags        []string `json:"tags"`
+}
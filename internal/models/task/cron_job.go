@@ -21,18 +21,29 @@ type CronJobs struct {
 	CustomTaskID    *uint       `gorm:"comment:关联的CustomTask ID" json:"custom_task_id,omitempty"`
 	CustomTask      *CustomTask `gorm:"foreignKey:CustomTaskID" json:"custom_task,omitempty"`
 	ServerIDs       string      `gorm:"type:text;comment:游戏服务器ID列表(逗号分隔)" json:"server_ids,omitempty"`
-	ServerOperation string      `gorm:"type:varchar(20);comment:服务器操作类型:start,stop,restart" json:"server_operation,omitempty"`
+	ServerOperation string      `gorm:"type:varchar(20);comment:服务器操作类型:start,stop,restart,signal" json:"server_operation,omitempty"`
+	Signal          string      `gorm:"type:varchar(20);comment:signal操作发送的信号" json:"signal,omitempty"`
+	SignalTask      string      `gorm:"type:varchar(100);comment:signal操作的task,为空时发送给所有task" json:"signal_task,omitempty"`
+
+	// GM命令
+	GMTemplateID *uint  `gorm:"comment:GM命令模板ID" json:"gm_template_id,omitempty"`
+	GMParams     string `gorm:"type:text;comment:GM命令参数(json)" json:"gm_params,omitempty"`
+	Operator     string `gorm:"type:varchar(100);comment:创建人" json:"operator,omitempty"`
 }
 
 // CronJobPayload 创建计划任务的请求参数
 type CronJobPayload struct {
-	TaskName        string   `json:"task_name"`
-	CustomTaskID    *uint    `json:"custom_task_id,omitempty"`   // CustomTask ID
-	ServerIDs       []string `json:"server_ids,omitempty"`       // 游戏服务器ID列表
-	ServerOperation string   `json:"server_operation,omitempty"` // 服务器操作类型
-	Spec            string   `json:"spec"`                       // cron表达式
-	TaskType        string   `json:"task_type"`                  // 任务类型
-	TaskStatus      int      `json:"task_status"`                // 任务状态
+	TaskName        string            `json:"task_name"`
+	CustomTaskID    *uint             `json:"custom_task_id,omitempty"`   // CustomTask ID
+	ServerIDs       []string          `json:"server_ids,omitempty"`       // 游戏服务器ID列表
+	ServerOperation string            `json:"server_operation,omitempty"` // 服务器操作类型
+	Signal          string            `json:"signal,omitempty"`           // signal操作发送的信号
+	SignalTask      string            `json:"signal_task,omitempty"`      // signal操作的task
+	GMTemplateID    *uint             `json:"gm_template_id,omitempty"`   // GM命令模板ID
+	GMParams        map[string]string `json:"gm_params,omitempty"`        // GM命令参数
+	Spec            string            `json:"spec"`                       // cron表达式
+	TaskType        string            `json:"task_type"`                  // 任务类型
+	TaskStatus      int               `json:"task_status"`                // 任务状态
 }
 
 // UnmarshalJSON 自定义JSON反序列化，支持server_ids的字符串和数组格式
@@ -78,6 +89,7 @@ func (c *CronJobPayload) UnmarshalJSON(data []byte) error {
 const (
 	TaskTypeCustom = "custom_task" // 自定义任务
 	TaskTypeServer = "server_op"   // 游戏服务器操作
+	TaskTypeGM     = "gm_command"  // GM命令
 )
 
 // ServerOperation 常量定义
@@ -85,4 +97,5 @@ const (
 	ServerOpStart   = "start"   // 启动服务器
 	ServerOpStop    = "stop"    // 停止服务器
 	ServerOpRestart = "restart" // 重启服务器
+	ServerOpSignal  = "signal"  // 向游戏进程发送信号(热加载)
 )
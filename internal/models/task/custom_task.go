@@ -20,6 +20,8 @@ type CustomTask struct {
 	Status     string     `gorm:"type:varchar(20);default:'active';comment:任务状态" json:"status"`
 	Timeout    int        `gorm:"default:300;comment:超时时间(秒)" json:"timeout"`
 	RetryCount int        `gorm:"default:0;comment:重试次数" json:"retry_count"`
+	Cluster    string     `gorm:"type:varchar(50);comment:执行集群" json:"cluster"`
+	AutoDeploy bool       `gorm:"default:false;comment:consul中job配置变更后自动重新注册" json:"auto_deploy"`
 	LastRun    *time.Time `gorm:"comment:最后执行时间" json:"last_run,omitempty"`
 	NextRun    *time.Time `gorm:"comment:下次执行时间" json:"next_run,omitempty"`
 }
@@ -35,6 +37,8 @@ type CustomTaskExecution struct {
 	Result     string     `gorm:"type:text;comment:执行结果" json:"result"`
 	ErrorMsg   string     `gorm:"type:text;comment:错误信息" json:"error_msg"`
 	NomadJobID string     `gorm:"type:varchar(255);comment:关联的Nomad Job ID" json:"nomad_job_id"`
+	Cluster    string     `gorm:"type:varchar(50);comment:执行集群" json:"cluster"`
+	AutoDeploy bool       `gorm:"default:false;comment:consul中job配置变更后自动重新注册" json:"auto_deploy"`
 	ExitCode   int        `gorm:"comment:退出码" json:"exit_code"`
 	// 新增字段用于跟踪 Nomad Job 状态
 	NomadEvalID    string     `gorm:"type:varchar(255);comment:Nomad Evaluation ID" json:"nomad_eval_id"`
@@ -56,8 +60,9 @@ type CustomTaskPayload struct {
 	TargetHosts string `json:"target_hosts"`
 	Parameters  string `json:"parameters"` // 改为 string 类型，支持 JSON 字符串
 	//Schedule    string                 `json:"schedule"`
-	Timeout    int `json:"timeout"`
-	RetryCount int `json:"retry_count"`
+	Timeout    int    `json:"timeout"`
+	RetryCount int    `json:"retry_count"`
+	Cluster    string `json:"cluster"` // 为空时使用默认集群
 }
 
 // UnmarshalJSON 自定义 JSON 解析，支持 parameters 为对象或字符串
@@ -2,17 +2,88 @@ package task
 
 import "time"
 
-// GameDeploymentTask 服务器端发布任务
+// 发布策略
+const (
+	DeployStrategyRolling = "rolling" // 按批次重启，批次健康检查失败时停止后续批次
+	DeployStrategyAll     = "all"     // 所有游戏服同时重启
+)
+
+// 发布执行状态
+const (
+	DeployRunRunning = "running"
+	DeployRunSuccess = "success"
+	DeployRunFailed  = "failed" // 校验失败或部分游戏服失败
+)
+
+// 发布阶段
+const (
+	DeployStageVerify   = "verify"   // 校验存储中的安装包
+	DeployStageDispatch = "dispatch" // 派发发布任务并等待完成
+	DeployStageRestart  = "restart"  // 重启游戏服
+	DeployStageHealth   = "health"   // 健康检查
+	DeployStageDone     = "done"
+)
+
+// GameDeploymentTask 服务器端发布任务，ServerId为逗号分隔的目标游戏服
 type GameDeploymentTask struct {
-	ID            uint       `gorm:"primaryKey;autoIncrement" json:"id"`
-	ServerId      string     `gorm:"type:longtext;comment:服务器ServerID" json:"server_id"`
-	Comment       string     `gorm:"type:text;comment:备注" json:"comment"`
-	LastExecution *time.Time `gorm:"comment:最后执行时间" json:"last_execution,omitempty"`
-	LastUser      string     `gorm:"type:text;comment:最后执行用户" json:"last_user"`
+	ID             uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	ServerId       string     `gorm:"type:longtext;comment:服务器ServerID" json:"server_id"`
+	Comment        string     `gorm:"type:text;comment:备注" json:"comment"`
+	UploadRecordID uint       `gorm:"comment:安装包版本(上传记录)" json:"upload_record_id"`
+	DatasourceID   uint       `gorm:"comment:安装包所在数据源" json:"datasource_id"`
+	Strategy       string     `gorm:"type:varchar(20);default:rolling;comment:发布策略:rolling,all" json:"strategy"`
+	BatchSize      int        `gorm:"default:1;comment:每批重启数量" json:"batch_size"`
+	BatchInterval  int        `gorm:"default:0;comment:批次间隔(秒)" json:"batch_interval"`
+	HealthTimeout  int        `gorm:"default:300;comment:健康检查超时(秒)" json:"health_timeout"`
+	LastExecution  *time.Time `gorm:"comment:最后执行时间" json:"last_execution,omitempty"`
+	LastUser       string     `gorm:"type:text;comment:最后执行用户" json:"last_user"`
 }
 
 // DeployTaskPayload 创建任务时传参
 type DeployTaskPayload struct {
-	ServerID string `json:"server_id"`
-	Comment  string `json:"comment"`
+	ServerID       string `json:"server_id"`
+	Comment        string `json:"comment"`
+	UploadRecordID uint   `json:"upload_record_id"`
+	DatasourceID   uint   `json:"datasource_id"`
+	Strategy       string `json:"strategy"`
+	BatchSize      int    `json:"batch_size"`
+	BatchInterval  int    `json:"batch_interval"`
+	HealthTimeout  int    `json:"health_timeout"`
+}
+
+// DeploymentRun 发布任务的一次执行
+type DeploymentRun struct {
+	ID             uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	TaskID         uint       `gorm:"index;comment:发布任务" json:"task_id"`
+	UploadRecordID uint       `gorm:"comment:安装包版本" json:"upload_record_id"`
+	Package        string     `gorm:"type:varchar(255);comment:安装包" json:"package"`
+	Checksum       string     `gorm:"type:varchar(64);comment:存储中安装包的校验和" json:"checksum"`
+	Strategy       string     `gorm:"type:varchar(20);comment:发布策略" json:"strategy"`
+	Operator       string     `gorm:"type:varchar(100);comment:执行人" json:"operator"`
+	OperationID    uint       `gorm:"comment:后台操作" json:"operation_id"`
+	Status         string     `gorm:"type:varchar(20);index;comment:状态" json:"status"`
+	Stage          string     `gorm:"type:varchar(20);comment:当前阶段" json:"stage"`
+	Error          string     `gorm:"type:text;comment:错误" json:"error,omitempty"`
+	FinishedAt     *time.Time `gorm:"comment:结束时间" json:"finished_at,omitempty"`
+}
+
+// DeploymentRunResult 一次执行中单个游戏服的结果，Stage为结束时所在的阶段
+type DeploymentRunResult struct {
+	ID            uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	RunID         uint      `gorm:"index;comment:发布执行" json:"run_id"`
+	ServerID      string    `gorm:"type:varchar(100);comment:游戏服" json:"server_id"`
+	Stage         string    `gorm:"type:varchar(20);comment:阶段" json:"stage"`
+	Success       bool      `gorm:"comment:是否成功" json:"success"`
+	EvalID        string    `gorm:"type:varchar(100);comment:发布任务的eval" json:"eval_id"`
+	DispatchedJob string    `gorm:"type:varchar(255);comment:派发的job" json:"dispatched_job"`
+	Health        string    `gorm:"type:varchar(20);comment:健康状态" json:"health"`
+	Error         string    `gorm:"type:text;comment:错误" json:"error,omitempty"`
+}
+
+// DeploymentRunDetail 执行详情
+type DeploymentRunDetail struct {
+	DeploymentRun
+	Results []DeploymentRunResult `json:"results"`
 }
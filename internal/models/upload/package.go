@@ -0,0 +1,55 @@
+package upload
+
+import "time"
+
+// ManifestFile 安装包内的构建信息文件
+const ManifestFile = "manifest.json"
+
+// 安装包生命周期状态
+const (
+	PackageUploaded   = "uploaded"   // 已上传到存储
+	PackageReleased   = "released"   // 已发布到游戏服
+	PackageDeprecated = "deprecated" // 已废弃，不能再发布
+)
+
+// Manifest 安装包内manifest.json的构建信息
+type Manifest struct {
+	Version     string `json:"version"`
+	GitCommit   string `json:"git_commit"`
+	BuildNumber string `json:"build_number"`
+	Changelog   string `json:"changelog"`
+}
+
+// Package 安装包版本，按SHA256区分同一版本的不同构建
+type Package struct {
+	ID          uint            `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Version     string          `gorm:"type:varchar(100);uniqueIndex;comment:版本号" json:"version"`
+	FileName    string          `gorm:"type:varchar(255);comment:压缩包文件名" json:"file_name"`
+	SHA256      string          `gorm:"type:char(64);index;comment:压缩包SHA256" json:"sha256"`
+	ContentSum  string          `gorm:"type:varchar(64);comment:首次上传后存储中文件校验和的sha256" json:"content_sum"`
+	Size        int64           `gorm:"comment:压缩包大小" json:"size"`
+	Uploader    string          `gorm:"type:varchar(100);comment:上传人" json:"uploader"`
+	GitCommit   string          `gorm:"type:varchar(64);comment:构建的git commit" json:"git_commit"`
+	BuildNumber string          `gorm:"type:varchar(64);comment:构建号" json:"build_number"`
+	Changelog   string          `gorm:"type:text;comment:更新日志" json:"changelog"`
+	Status      string          `gorm:"type:varchar(20);index;default:uploaded;comment:状态:uploaded,released,deprecated" json:"status"`
+	Targets     []PackageTarget `gorm:"foreignKey:PackageID" json:"targets,omitempty"`
+}
+
+// PackageTarget 安装包同步到的存储
+type PackageTarget struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	PackageID    uint      `gorm:"uniqueIndex:idx_package_datasource;comment:安装包" json:"package_id"`
+	DatasourceID uint      `gorm:"uniqueIndex:idx_package_datasource;comment:数据源" json:"datasource_id"`
+	Label        string    `gorm:"type:varchar(100);comment:数据源名称" json:"label"`
+	Path         string    `gorm:"type:varchar(255);comment:存储路径" json:"path"`
+	SyncedAt     time.Time `gorm:"comment:最后同步时间" json:"synced_at"`
+}
+
+// PackageDetail 安装包详情，包含运行该版本的游戏服
+type PackageDetail struct {
+	Package
+	Servers []string `json:"servers"`
+}
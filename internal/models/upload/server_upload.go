@@ -23,4 +23,6 @@ type UploadRecord struct {
 	DestTarget string    `gorm:"text" json:"dest_target"`
 	DestPath   string    `gorm:"text" json:"dest_path"`
 	UploadTime time.Time `json:"upload_time"`
+	PackageID  uint      `gorm:"index;comment:安装包版本" json:"package_id"`
+	Checksum   string    `gorm:"type:varchar(64);comment:上传后存储中文件校验和的sha256" json:"checksum"`
 }
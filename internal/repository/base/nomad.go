@@ -2,6 +2,7 @@ package base
 
 import (
 	"errors"
+	"fmt"
 	"saurfang/internal/models/amis"
 	"saurfang/internal/models/serverconfig"
 	"saurfang/internal/tools"
@@ -34,6 +35,24 @@ type Allocation struct {
 	Status string `json:"status"`
 }
 
+// ConsulTxnMaxOps consul单个事务的最大操作数，不超过时批量写入是原子的
+const ConsulTxnMaxOps = 64
+
+// CASBatchError 分批写入时某个批次失败，之前的批次已经写入且不会回滚
+type CASBatchError struct {
+	Committed []string // 已写入的key
+	Failed    []string // 未写入的key
+	Err       error
+}
+
+func (e *CASBatchError) Error() string {
+	return fmt.Sprintf("%d keys committed, %d keys not written: %v", len(e.Committed), len(e.Failed), e.Err)
+}
+
+func (e *CASBatchError) Unwrap() error {
+	return e.Err
+}
+
 // JobStatus 作业状态常量
 const (
 	JobStatusComplete = "complete"
@@ -168,6 +187,55 @@ func (n *NomadJobRepository) ShowNomadJobByKey(key string) (*serverconfig.GameCo
 	return result, nil
 }
 
+// ListNomadJobPairs 列出前缀下全部的kv，保留ModifyIndex用于CAS更新
+func (n *NomadJobRepository) ListNomadJobPairs() (consulapi.KVPairs, error) {
+	pairs, _, err := n.Consul.KV().List(n.Ns, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// CASUpdateNomadJobs 使用consul事务按ModifyIndex批量更新配置
+// consul单个事务最多支持64个操作，超过时按批次提交，每个批次内保证原子性，批次之间不是原子的
+// 第一个批次之后失败时返回*CASBatchError，列出已写入和未写入的key
+func (n *NomadJobRepository) CASUpdateNomadJobs(pairs []*consulapi.KVPair) error {
+	keys := func(ps []*consulapi.KVPair) []string {
+		out := make([]string, 0, len(ps))
+		for _, p := range ps {
+			out = append(out, p.Key)
+		}
+		return out
+	}
+	for start := 0; start < len(pairs); start += ConsulTxnMaxOps {
+		end := min(start+ConsulTxnMaxOps, len(pairs))
+		ops := make(consulapi.KVTxnOps, 0, end-start)
+		for _, pair := range pairs[start:end] {
+			ops = append(ops, &consulapi.KVTxnOp{
+				Verb:  consulapi.KVCAS,
+				Key:   pair.Key,
+				Value: pair.Value,
+				Index: pair.ModifyIndex,
+			})
+		}
+		ok, resp, _, err := n.Consul.KV().Txn(ops, nil)
+		if err == nil && !ok {
+			var errs []string
+			for _, e := range resp.Errors {
+				errs = append(errs, fmt.Sprintf("%s: %s", ops[e.OpIndex].Key, e.What))
+			}
+			err = fmt.Errorf("transaction rolled back, keys %d-%d were not written: %s", start, end-1, strings.Join(errs, "; "))
+		}
+		if err != nil {
+			if start == 0 {
+				return err
+			}
+			return &CASBatchError{Committed: keys(pairs[:start]), Failed: keys(pairs[start:]), Err: err}
+		}
+	}
+	return nil
+}
+
 // convertJobToNomadJobs 将Nomad作业转换为内部结构
 func (n *NomadJobRepository) convertJobToNomadJobs(job *nomadapi.JobListStub) NomadJobs {
 	var allocations []Allocation
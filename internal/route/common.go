@@ -2,6 +2,7 @@ package route
 
 import (
 	"saurfang/internal/config"
+	"saurfang/internal/handler/taskhandler"
 	"saurfang/internal/handler/userhandler"
 	"saurfang/internal/models/user"
 	"saurfang/internal/repository/base"
@@ -29,6 +30,12 @@ func (u *CommonRouteModule) RegisterRoutesModule(r *fiber.App) {
 	commonRoute.Post("/auth/login", userHandler.Handler_UserLogin)
 	commonRoute.Post("/auth/logout", userHandler.Handler_UserLogout)
 	commonRoute.Get("/auth/status", userHandler.Handler_LoginStatus)
+	// 变更日历订阅，使用CALENDAR_FEED_TOKEN校验
+	commonRoute.Get("/calendar.ics", taskhandler.NewFreezeHandler().Handler_ShowCalendarFeed)
+	// 通知中的审批链接，使用APPROVAL_LINK_SECRET签名校验，GET展示确认页面，确认后POST提交
+	approvalHandler := taskhandler.NewApprovalHandler()
+	commonRoute.Get("/approval/:id/:action", approvalHandler.Handler_ShowDecisionLink)
+	commonRoute.Post("/approval/:id/:action", approvalHandler.Handler_DecideByLink)
 }
 func init() {
 	RegisterRoutesModule(&CommonRouteModule{Namespace: "/api/v1/common", Comment: "通用路由"})
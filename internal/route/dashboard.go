@@ -31,6 +31,11 @@ func (d *DashboardRouteModule) RegisterRoutesModule(r *fiber.App) {
 	*/
 	dashboardRoute.Get("/stats", boardhandler.Handler_DashboardStats)
 
+	/*
+		集群状态
+	*/
+	dashboardRoute.Get("/clusters", boardhandler.Handler_ClusterHealth)
+
 	/*
 		自定义任务图表统计
 	*/
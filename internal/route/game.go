@@ -35,14 +35,13 @@ func (g *GameRouteModule) RegisterRoutesModule(r *fiber.App) {
 	/*
 		逻辑服
 	*/
-	// nomadcli, _ := config.NewNomadClient()
 	logicHandler := gamehandler.LogicServerHandler{
 		BaseGormRepository: base.BaseGormRepository[gameserver.Games]{DB: config.DB},
-		// NomadJobRepository: base.NomadJobRepository{
-		// 	Consul: config.ConsulCli,
-		// 	// Ns:     os.Getenv("GAME_CONFIG_NAMESPACE"),
-		// 	Nomad: nomadcli,
-		// },
+		NomadJobRepository: base.NomadJobRepository{
+			Consul: config.ConsulCli,
+			Ns:     os.Getenv("GAME_NOMAD_JOB_NAMESPACE"),
+			Nomad:  config.NomadCli,
+		},
 	}
 	// 创建游戏服
 	gameRouter.Post("/logic/create", logicHandler.Handler_CreateLogicServer)
@@ -52,11 +51,18 @@ func (g *GameRouteModule) RegisterRoutesModule(r *fiber.App) {
 	gameRouter.Get("/logic/list", logicHandler.Handler_ShowLogicServer)
 	//
 	gameRouter.Get("/logic/select", logicHandler.Handler_ShowChannelServerList)
+	gameRouter.Get("/logic/health", logicHandler.Handler_ShowServerHealth)
+	// 游戏服操作记录和时间线
+	gameRouter.Get("/logic/:server_id/operations", logicHandler.Handler_ShowServerOperations)
+	gameRouter.Get("/logic/:server_id/timeline", logicHandler.Handler_ShowServerTimeline)
 	// gameRouter.Get("/logic/detail", logicHandler.Handler_ShowServerDetail)
 	//gameRouter.Get("/logic/detail/select", logicHandler.Handler_ShowGameserverByTree)
 	gameRouter.Get("/logic/detail/picker", logicHandler.Handler_ShowServerDetailForPicker)
 	// gameRouter.Put("/logic/hosts/assign", logicHandler.Handler_AddHostsToLogicServer)
 	gameRouter.Get("/logic/config/select", logicHandler.Handler_TreeSelectForSyncServerConfig)
+	// 批量导入导出逻辑服
+	gameRouter.Post("/logic/import", logicHandler.Handler_ImportLogicServer)
+	gameRouter.Get("/logic/export", logicHandler.Handler_ExportLogicServer)
 	/*
 		逻辑服配置
 	*/
@@ -67,6 +73,9 @@ func (g *GameRouteModule) RegisterRoutesModule(r *fiber.App) {
 	gameRouter.Get("/config/list", serverconfigHandler.Handler_ListServerConfig)
 	//gameRouter.Get("/config/listByKey/:key", serverconfigHandler.Handler_ListNomadJobByKey)
 	gameRouter.Get("/config/:server_id/show", serverconfigHandler.Handler_ListNomadJobByKey)
+	// 批量修改配置
+	gameRouter.Post("/config/bulk/preview", serverconfigHandler.Handler_BulkEditPreview)
+	gameRouter.Post("/config/bulk/commit", serverconfigHandler.Handler_BulkEditCommit)
 
 	/*
 		nomad发布配置
@@ -77,6 +86,105 @@ func (g *GameRouteModule) RegisterRoutesModule(r *fiber.App) {
 	gameRouter.Put("/deploy/config/update", deployConfigHandler.Handler_UpdateServerConfig)
 	gameRouter.Get("/deploy/config/list", deployConfigHandler.Handler_ListServerConfig)
 	gameRouter.Get("/deploy/config/:server_id/show", deployConfigHandler.Handler_ListNomadJobByKey)
+
+	/*
+		游戏服配置模板，导入逻辑服时使用
+	*/
+	templateConfigHandler := gamehandler.NewServerConfigHandler(config.ConsulCli, os.Getenv("GAME_CONFIG_TEMPLATE_NAMESPACE"))
+	gameRouter.Post("/template/config/create", templateConfigHandler.Handler_CreateServerConfig)
+	gameRouter.Delete("/template/config/delete", templateConfigHandler.Handler_DeleteServerConfig)
+	gameRouter.Put("/template/config/update", templateConfigHandler.Handler_UpdateServerConfig)
+	gameRouter.Get("/template/config/list", templateConfigHandler.Handler_ListServerConfig)
+	gameRouter.Get("/template/config/:server_id/show", templateConfigHandler.Handler_ListNomadJobByKey)
+
+	/*
+		服务器ID分配和主机端口登记
+	*/
+	allocationHandler := gamehandler.NewAllocationHandler()
+	gameRouter.Post("/idrule/create", allocationHandler.Handler_CreateServerIDRule)
+	gameRouter.Put("/idrule/update/:id", allocationHandler.Handler_UpdateServerIDRule)
+	gameRouter.Delete("/idrule/delete/:id", allocationHandler.Handler_DeleteServerIDRule)
+	gameRouter.Get("/idrule/list", allocationHandler.Handler_ListServerIDRule)
+	gameRouter.Get("/idrule/:channel_id/next", allocationHandler.Handler_NextServerID)
+	gameRouter.Get("/ports/list", allocationHandler.Handler_ListPortReservation)
+	gameRouter.Post("/ports/reserve", allocationHandler.Handler_ReservePorts)
+	gameRouter.Delete("/ports/release/:id", allocationHandler.Handler_ReleasePorts)
+	gameRouter.Get("/ports/check", allocationHandler.Handler_CheckPorts)
+	gameRouter.Post("/placement/plan", allocationHandler.Handler_PlanPlacement)
+
+	/*
+		跨服分组
+	*/
+	crossHandler := gamehandler.NewCrossServerHandler()
+	gameRouter.Post("/cross/season/create", crossHandler.Handler_CreateCrossSeason)
+	gameRouter.Put("/cross/season/update/:id", crossHandler.Handler_UpdateCrossSeason)
+	gameRouter.Delete("/cross/season/delete/:id", crossHandler.Handler_DeleteCrossSeason)
+	gameRouter.Get("/cross/season/list", crossHandler.Handler_ListCrossSeason)
+	gameRouter.Get("/cross/season/:id/groups", crossHandler.Handler_ShowCrossGroups)
+	gameRouter.Put("/cross/season/:id/groups", crossHandler.Handler_SetCrossGroups)
+	gameRouter.Post("/cross/season/:id/rebalance", crossHandler.Handler_RebalanceCrossGroups)
+	gameRouter.Put("/cross/season/:id/schedule", crossHandler.Handler_ScheduleCrossSeason)
+	gameRouter.Put("/cross/season/:id/publish", crossHandler.Handler_PublishCrossSeason)
+	gameRouter.Get("/cross/audit", crossHandler.Handler_ListCrossAudit)
+
+	/*
+		客户端服务器列表
+	*/
+	serverListHandler := gamehandler.NewServerListHandler()
+	gameRouter.Post("/serverlist/setting/create", serverListHandler.Handler_CreateServerListSetting)
+	gameRouter.Put("/serverlist/setting/update/:id", serverListHandler.Handler_UpdateServerListSetting)
+	gameRouter.Delete("/serverlist/setting/delete/:id", serverListHandler.Handler_DeleteServerListSetting)
+	gameRouter.Get("/serverlist/setting/list", serverListHandler.Handler_ListServerListSetting)
+	gameRouter.Get("/serverlist/flag/list", serverListHandler.Handler_ListServerListFlag)
+	gameRouter.Put("/serverlist/flag/:server_id", serverListHandler.Handler_SetServerListFlag)
+	gameRouter.Get("/serverlist/version/:id", serverListHandler.Handler_ShowServerListVersion)
+	gameRouter.Get("/serverlist/:channel_id/preview", serverListHandler.Handler_PreviewServerList)
+	gameRouter.Post("/serverlist/:channel_id/publish", serverListHandler.Handler_PublishServerList)
+	gameRouter.Get("/serverlist/:channel_id/versions", serverListHandler.Handler_ListServerListVersion)
+
+	/*
+		GM命令
+	*/
+	gmHandler := gamehandler.NewGMCommandHandler()
+	gameRouter.Get("/gm/endpoint/list", gmHandler.Handler_ListGMEndpoint)
+	gameRouter.Post("/gm/endpoint/create", gmHandler.Handler_CreateGMEndpoint)
+	gameRouter.Put("/gm/endpoint/update/:id", gmHandler.Handler_UpdateGMEndpoint)
+	gameRouter.Delete("/gm/endpoint/delete/:id", gmHandler.Handler_DeleteGMEndpoint)
+	gameRouter.Post("/gm/endpoint/discover", gmHandler.Handler_DiscoverGMEndpoint)
+	gameRouter.Post("/gm/template/create", gmHandler.Handler_CreateGMTemplate)
+	gameRouter.Put("/gm/template/update/:id", gmHandler.Handler_UpdateGMTemplate)
+	gameRouter.Delete("/gm/template/delete/:id", gmHandler.Handler_DeleteGMTemplate)
+	gameRouter.Get("/gm/template/list", gmHandler.Handler_ListGMTemplate)
+	gameRouter.Post("/gm/execute", gmHandler.Handler_ExecuteGMCommand)
+	gameRouter.Get("/gm/execution/list", gmHandler.Handler_ListGMExecution)
+	gameRouter.Get("/gm/execution/:id", gmHandler.Handler_ShowGMExecution)
+
+	/*
+		发布环境和晋级
+	*/
+	envHandler := gamehandler.NewEnvironmentHandler()
+	gameRouter.Post("/env/create", envHandler.Handler_CreateEnvironment)
+	gameRouter.Put("/env/update/:id", envHandler.Handler_UpdateEnvironment)
+	gameRouter.Delete("/env/delete/:id", envHandler.Handler_DeleteEnvironment)
+	gameRouter.Get("/env/list", envHandler.Handler_ListEnvironment)
+	gameRouter.Post("/env/promote/preview", envHandler.Handler_PreviewPromotion)
+	gameRouter.Post("/env/promote", envHandler.Handler_Promote)
+	gameRouter.Get("/env/promotion/list", envHandler.Handler_ListPromotion)
+	gameRouter.Get("/env/promotion/:id", envHandler.Handler_ShowPromotion)
+	gameRouter.Post("/env/promotion/:id/approve", envHandler.Handler_ApprovePromotion)
+	gameRouter.Post("/env/promotion/:id/reject", envHandler.Handler_RejectPromotion)
+	gameRouter.Get("/env/promotion/:id/lineage", envHandler.Handler_ShowPromotionLineage)
+
+	/*
+		配置变更自动发布
+	*/
+	autoDeployHandler := gamehandler.NewAutoDeployHandler()
+	gameRouter.Get("/autodeploy/records", autoDeployHandler.Handler_ListAutoDeployRecords)
+	gameRouter.Put("/autodeploy/:server_id/set", autoDeployHandler.Handler_SetAutoDeploy)
+	gameRouter.Put("/autodeploy/custom/:task_id/set", autoDeployHandler.Handler_SetCustomTaskAutoDeploy)
+	gameRouter.Get("/autodeploy/suspend/list", autoDeployHandler.Handler_ListSuspensions)
+	gameRouter.Put("/autodeploy/suspend", autoDeployHandler.Handler_SuspendAutoDeploy)
+	gameRouter.Put("/autodeploy/resume", autoDeployHandler.Handler_ResumeAutoDeploy)
 }
 func init() {
 	RegisterRoutesModule(&GameRouteModule{Namespace: "/api/v1/game", Comment: "游戏服管理"})
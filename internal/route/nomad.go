@@ -4,6 +4,8 @@ import (
 	"os"
 	"saurfang/internal/config"
 	"saurfang/internal/handler/nomadhandler"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/tools/pkg"
 
 	"github.com/gofiber/fiber/v3"
 )
@@ -19,13 +21,53 @@ func (n *NomadRouteModule) Info() (namespace string, comment string) {
 func (n *NomadRouteModule) RegisterRoutesModule(r *fiber.App) {
 	opshandler := nomadhandler.NewNomadHandler(config.ConsulCli, os.Getenv("GAME_NOMAD_JOB_NAMESPACE"))
 	nomadRouter := r.Group(n.Namespace)
+	// 审批通过后由opshandler执行变更
+	pkg.RegisterApprovalExecutor(approval.KindGameOps, opshandler.ExecuteOpsChange)
+	pkg.RegisterApprovalExecutor(approval.KindPurgeJob, opshandler.ExecutePurgeChange)
 	nomadRouter.Get("/nodes", opshandler.Handler_ListNomadNodes)
 	nomadRouter.Get("/nodes/select", opshandler.Handler_ListNomadNodesForSelect)
+	// 节点维护
+	nomadRouter.Get("/node/:node_id/servers", opshandler.Handler_ShowNodeGameServers)
+	nomadRouter.Put("/node/:node_id/eligibility", opshandler.Handler_ToggleNodeEligibility)
+	nomadRouter.Put("/node/:node_id/drain", opshandler.Handler_DrainNode)
+	nomadRouter.Delete("/node/:node_id/drain", opshandler.Handler_CancelNodeDrain)
+	nomadRouter.Get("/node/:node_id/drain/monitor", opshandler.Handler_MonitorNodeDrain)
+	nomadRouter.Get("/node/:node_id/meta", opshandler.Handler_ShowNodeMeta)
+	nomadRouter.Put("/node/:node_id/meta", opshandler.Handler_UpdateNodeMeta)
+	// 游戏服分配
+	nomadRouter.Get("/server/:server_id/allocations", opshandler.Handler_ListServerAllocations)
+	nomadRouter.Put("/server/:server_id/alloc/:alloc_id/restart", opshandler.Handler_RestartAllocation)
+	nomadRouter.Put("/server/:server_id/alloc/:alloc_id/stop", opshandler.Handler_StopAllocation)
+	nomadRouter.Get("/server/:server_id/alloc/:alloc_id/logs", opshandler.Handler_StreamAllocationLogs)
+	nomadRouter.Get("/server/:server_id/alloc/:alloc_id/fs", opshandler.Handler_BrowseAllocationFS)
+	nomadRouter.Get("/server/:server_id/alloc/:alloc_id/fs/download", opshandler.Handler_DownloadAllocationFile)
+	// 游戏服job版本
+	nomadRouter.Get("/server/:server_id/versions", opshandler.Handler_ListJobVersions)
+	nomadRouter.Get("/server/:server_id/versions/:version", opshandler.Handler_ShowJobVersion)
+	nomadRouter.Put("/server/:server_id/versions/:version/revert", opshandler.Handler_RevertJobVersion)
+	// 游戏服deployment
+	nomadRouter.Get("/server/:server_id/deployment", opshandler.Handler_ShowServerDeployment)
+	nomadRouter.Get("/server/:server_id/deployment/watch", opshandler.Handler_WatchServerDeployment)
+	nomadRouter.Put("/server/:server_id/deployment/:deployment_id/promote", opshandler.Handler_PromoteDeployment)
+	nomadRouter.Put("/server/:server_id/deployment/:deployment_id/fail", opshandler.Handler_FailDeployment)
+	// 操作锁，同一游戏服或渠道同时只能执行一个变更操作
+	nomadRouter.Get("/locks", opshandler.Handler_ListLocks)
+	nomadRouter.Delete("/lock/:kind/:id", opshandler.Handler_ForceReleaseLock)
+	// 自动伸缩
+	nomadRouter.Get("/autoscale/policy/list", opshandler.Handler_ListScalingPolicy)
+	nomadRouter.Post("/autoscale/policy/create", opshandler.Handler_CreateScalingPolicy)
+	nomadRouter.Put("/autoscale/policy/update/:id", opshandler.Handler_UpdateScalingPolicy)
+	nomadRouter.Delete("/autoscale/policy/delete/:id", opshandler.Handler_DeleteScalingPolicy)
+	nomadRouter.Get("/autoscale/policy/:id/decision", opshandler.Handler_PreviewScalingDecision)
+	nomadRouter.Put("/autoscale/policy/:id/override", opshandler.Handler_OverrideScalingPolicy)
+	nomadRouter.Delete("/autoscale/policy/:id/override", opshandler.Handler_ClearScalingOverride)
+	nomadRouter.Get("/autoscale/events", opshandler.Handler_ListScalingEvents)
 	nomadRouter.Get("/jobs", opshandler.Handler_ShowNomadJobs)
 	nomadRouter.Post("/job/:job_id/scale", opshandler.Handler_ScaleTaskGroup)
 	nomadRouter.Get("/job/:job_id/group", opshandler.Handler_ShowGroupSelect)
 	nomadRouter.Delete("/job/stop", opshandler.Handler_DeployNomadOpsJob)
 	nomadRouter.Put("/job/start", opshandler.Handler_DeployNomadOpsJob)
+	nomadRouter.Put("/job/signal", opshandler.Handler_DeployNomadOpsJob)
 	nomadRouter.Delete("/job/purge", opshandler.Handler_PurgeNomadJob)
 	handler := nomadhandler.NewNomadHandler(config.ConsulCli, os.Getenv("GAME_NOMAD_DEPLOY_NAMESPACE"))
 	nomadRouter.Put("/job/deploy", handler.Handler_DeployNomadJob)
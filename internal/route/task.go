@@ -31,6 +31,9 @@ func (t *TaskRouteModule) RegisterRoutesModule(r *fiber.App) {
 	taskRouter.Get("/deploy/list", deployhandler.Handler_ShowDeployTask)
 	taskRouter.Get("/deploy/listById/:id", deployhandler.Handler_ShowDeployTaskByID)
 	taskRouter.Get("/deploy/listPerPage", deployhandler.Handler_ShowDeployPerPage)
+	taskRouter.Post("/deploy/execute/:id", deployhandler.Handler_ExecuteDeployTask)
+	taskRouter.Get("/deploy/runs/:id", deployhandler.Handler_ListDeployRuns)
+	taskRouter.Get("/deploy/run/:run_id", deployhandler.Handler_ShowDeployRun)
 
 	/*
 		上传服务器端
@@ -40,6 +43,49 @@ func (t *TaskRouteModule) RegisterRoutesModule(r *fiber.App) {
 	taskRouter.Get("/upload/records", uploadhandler.Handler_ShowUploadRecords)
 	taskRouter.Get("/upload/server", uploadhandler.Handler_UploadServerPackage)
 
+	/*
+		安装包版本
+	*/
+	packageHandler := taskhandler.PackageHandler{}
+	taskRouter.Get("/package/list", packageHandler.Handler_ListPackages)
+	taskRouter.Get("/package/:id", packageHandler.Handler_ShowPackage)
+	taskRouter.Put("/package/:id/status", packageHandler.Handler_SetPackageStatus)
+
+	/*
+		运维操作记录，开关服、一次性任务和上传服务器端在后台执行，可以断开后重新订阅日志
+	*/
+	operationHandler := taskhandler.NewOperationHandler()
+	taskRouter.Get("/operation/list", operationHandler.Handler_ListOperations)
+	taskRouter.Get("/operation/:id", operationHandler.Handler_ShowOperation)
+	taskRouter.Get("/operation/:id/logs", operationHandler.Handler_ShowOperationLogs)
+	taskRouter.Get("/operation/:id/stream", operationHandler.Handler_StreamOperation)
+
+	/*
+		变更冻结窗口和变更日历
+	*/
+	freezeHandler := taskhandler.NewFreezeHandler()
+	taskRouter.Get("/freeze/list", freezeHandler.Handler_ListFreezeWindows)
+	taskRouter.Post("/freeze/create", freezeHandler.Handler_CreateFreezeWindow)
+	taskRouter.Get("/freeze/check", freezeHandler.Handler_CheckFreeze)
+	taskRouter.Get("/freeze/overrides", freezeHandler.Handler_ListFreezeOverrides)
+	taskRouter.Get("/freeze/calendar.ics", freezeHandler.Handler_ShowCalendar)
+	taskRouter.Put("/freeze/:id", freezeHandler.Handler_UpdateFreezeWindow)
+	taskRouter.Delete("/freeze/:id", freezeHandler.Handler_DeleteFreezeWindow)
+
+	/*
+		高风险操作审批，命中策略的操作审批通过后自动执行
+	*/
+	approvalHandler := taskhandler.NewApprovalHandler()
+	taskRouter.Get("/approval/policy/list", approvalHandler.Handler_ListPolicies)
+	taskRouter.Post("/approval/policy/create", approvalHandler.Handler_CreatePolicy)
+	taskRouter.Put("/approval/policy/:id", approvalHandler.Handler_UpdatePolicy)
+	taskRouter.Delete("/approval/policy/:id", approvalHandler.Handler_DeletePolicy)
+	taskRouter.Get("/approval/change/list", approvalHandler.Handler_ListChanges)
+	taskRouter.Get("/approval/change/:id", approvalHandler.Handler_ShowChange)
+	taskRouter.Post("/approval/change/:id/approve", approvalHandler.Handler_ApproveChange)
+	taskRouter.Post("/approval/change/:id/reject", approvalHandler.Handler_RejectChange)
+	taskRouter.Post("/approval/change/:id/cancel", approvalHandler.Handler_CancelChange)
+
 	/*
 		创建计划任务
 	*/
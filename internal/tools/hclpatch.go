@@ -0,0 +1,205 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"saurfang/internal/models/serverconfig"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// PatchJobHCL 按照patch修改nomad job的HCL文本
+// 使用hclwrite直接修改语法树，尽量保留原有的格式和注释
+func PatchJobHCL(src string, patch *serverconfig.JobPatch) (string, error) {
+	if patch == nil {
+		return src, nil
+	}
+	f, diags := hclwrite.ParseConfig([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("failed to parse HCL: %v", diags)
+	}
+	var job *hclwrite.Block
+	for _, block := range f.Body().Blocks() {
+		if block.Type() == "job" {
+			job = block
+			break
+		}
+	}
+	if job == nil {
+		return "", errors.New("job block not found")
+	}
+	if len(patch.Meta) > 0 {
+		if err := setBlockAttributes(job.Body(), "meta", patch.Meta); err != nil {
+			return "", err
+		}
+	}
+	matchedGroup := false
+	for _, group := range job.Body().Blocks() {
+		if group.Type() != "group" || !matchLabel(group, patch.Group) {
+			continue
+		}
+		matchedGroup = true
+		if patch.Count != nil {
+			group.Body().SetAttributeValue("count", cty.NumberIntVal(int64(*patch.Count)))
+		}
+		for _, t := range group.Body().Blocks() {
+			if t.Type() != "task" || !matchLabel(t, patch.Task) {
+				continue
+			}
+			if len(patch.Env) > 0 {
+				if err := setBlockAttributes(t.Body(), "env", patch.Env); err != nil {
+					return "", err
+				}
+			}
+			for _, sub := range t.Body().Blocks() {
+				switch sub.Type() {
+				case "artifact":
+					if patch.ArtifactSource != "" {
+						sub.Body().SetAttributeValue("source", cty.StringVal(patch.ArtifactSource))
+					}
+				case "config":
+					if patch.Image != "" {
+						sub.Body().SetAttributeValue("image", cty.StringVal(patch.Image))
+					}
+				}
+			}
+		}
+	}
+	if patch.Group != "" && !matchedGroup {
+		return "", fmt.Errorf("group %s not found", patch.Group)
+	}
+	return string(hclwrite.Format(f.Bytes())), nil
+}
+
+// ReplaceText 对配置文本做字面量或正则替换
+func ReplaceText(src string, replace *serverconfig.TextReplace) (string, error) {
+	if replace == nil || replace.Find == "" {
+		return src, nil
+	}
+	if !replace.Regex {
+		return strings.ReplaceAll(src, replace.Find, replace.Replace), nil
+	}
+	re, err := regexp.Compile(replace.Find)
+	if err != nil {
+		return "", fmt.Errorf("invalid regex: %v", err)
+	}
+	return re.ReplaceAllString(src, replace.Replace), nil
+}
+
+// UnifiedDiff 生成两段文本的unified diff，用于预览
+func UnifiedDiff(name, before, after string) string {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: name,
+		ToFile:   name,
+		Context:  3,
+	})
+	if err != nil {
+		return ""
+	}
+	return diff
+}
+
+// matchLabel label为空时匹配所有block
+func matchLabel(block *hclwrite.Block, label string) bool {
+	if label == "" {
+		return true
+	}
+	labels := block.Labels()
+	return len(labels) > 0 && labels[0] == label
+}
+
+// setBlockAttributes 在body下的指定block中设置属性，block不存在时新建
+func setBlockAttributes(body *hclwrite.Body, blockType string, attrs map[string]string) error {
+	if body.GetAttribute(blockType) != nil {
+		return fmt.Errorf("%s defined as attribute is not supported, use %s block instead", blockType, blockType)
+	}
+	block := body.FirstMatchingBlock(blockType, nil)
+	if block == nil {
+		block = body.AppendNewBlock(blockType, nil)
+	}
+	// 按key排序保证输出稳定
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		block.Body().SetAttributeValue(k, cty.StringVal(attrs[k]))
+	}
+	return nil
+}
+
+// nodeNameAttribute 按nomad节点名称约束的属性
+const nodeNameAttribute = "${node.unique.name}"
+
+// NodeConstraintHCL 生成把job固定到指定nomad节点的constraint块
+func NodeConstraintHCL(nodeName string) string {
+	return string(hclwrite.Format([]byte(fmt.Sprintf("constraint {\nattribute = %q\nvalue = %q\n}\n", nodeNameAttribute, nodeName))))
+}
+
+// InjectNodeConstraint 在job级别注入节点constraint，已有的节点名称constraint会被替换
+func InjectNodeConstraint(src, nodeName string) (string, error) {
+	f, diags := hclwrite.ParseConfig([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("failed to parse HCL: %v", diags)
+	}
+	var job *hclwrite.Block
+	for _, block := range f.Body().Blocks() {
+		if block.Type() == "job" {
+			job = block
+			break
+		}
+	}
+	if job == nil {
+		return "", errors.New("job block not found")
+	}
+	for _, block := range job.Body().Blocks() {
+		if block.Type() != "constraint" {
+			continue
+		}
+		if attr := block.Body().GetAttribute("attribute"); attr != nil &&
+			strings.Contains(string(attr.Expr().BuildTokens(nil).Bytes()), "node.unique.name") {
+			job.Body().RemoveBlock(block)
+		}
+	}
+	snippet, diags := hclwrite.ParseConfig([]byte(NodeConstraintHCL(nodeName)), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("failed to build constraint: %v", diags)
+	}
+	job.Body().AppendNewline()
+	job.Body().AppendBlock(snippet.Body().Blocks()[0])
+	return string(hclwrite.Format(f.Bytes())), nil
+}
+
+// SetJobPlacement 设置job级别的namespace和region，为空的值保持不变
+func SetJobPlacement(src, namespace, region string) (string, error) {
+	f, diags := hclwrite.ParseConfig([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("failed to parse HCL: %v", diags)
+	}
+	var job *hclwrite.Block
+	for _, block := range f.Body().Blocks() {
+		if block.Type() == "job" {
+			job = block
+			break
+		}
+	}
+	if job == nil {
+		return "", errors.New("job block not found")
+	}
+	if namespace != "" {
+		job.Body().SetAttributeValue("namespace", cty.StringVal(namespace))
+	}
+	if region != "" {
+		job.Body().SetAttributeValue("region", cty.StringVal(region))
+	}
+	return string(hclwrite.Format(f.Bytes())), nil
+}
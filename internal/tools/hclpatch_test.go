@@ -0,0 +1,137 @@
+package tools
+
+import (
+	"saurfang/internal/models/serverconfig"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testJobHCL = `job "game-10001" {
+  datacenters = ["dc1"]
+
+  group "game" {
+    count = 1
+
+    task "server" {
+      driver = "raw_exec"
+
+      artifact {
+        source = "https://cdn.example.com/game/1.0.0/server.zip"
+      }
+
+      env {
+        GAME_VERSION = "1.0.0"
+      }
+    }
+  }
+}
+`
+
+// TestPatchJobHCL 测试按patch修改job
+func TestPatchJobHCL(t *testing.T) {
+	t.Run("PatchFields", func(t *testing.T) {
+		count := 2
+		res, err := PatchJobHCL(testJobHCL, &serverconfig.JobPatch{
+			Meta:           map[string]string{"owner": "ops"},
+			Env:            map[string]string{"GAME_VERSION": "1.0.1"},
+			ArtifactSource: "https://cdn.example.com/game/1.0.1/server.zip",
+			Count:          &count,
+		})
+		assert.NoError(t, err)
+		assert.Contains(t, res, `GAME_VERSION = "1.0.1"`)
+		assert.Contains(t, res, `source = "https://cdn.example.com/game/1.0.1/server.zip"`)
+		assert.Contains(t, res, `owner = "ops"`)
+		assert.Contains(t, res, "count = 2")
+	})
+	t.Run("GroupNotFound", func(t *testing.T) {
+		_, err := PatchJobHCL(testJobHCL, &serverconfig.JobPatch{Group: "missing"})
+		assert.Error(t, err)
+	})
+	t.Run("NilPatch", func(t *testing.T) {
+		res, err := PatchJobHCL(testJobHCL, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, testJobHCL, res)
+	})
+}
+
+// TestReplaceText 测试字面量和正则替换
+func TestReplaceText(t *testing.T) {
+	res, err := ReplaceText(testJobHCL, &serverconfig.TextReplace{Find: "1.0.0", Replace: "1.0.2"})
+	assert.NoError(t, err)
+	assert.NotContains(t, res, "1.0.0")
+
+	res, err = ReplaceText(testJobHCL, &serverconfig.TextReplace{Find: `game/[0-9.]+/`, Replace: "game/2.0.0/", Regex: true})
+	assert.NoError(t, err)
+	assert.Contains(t, res, "game/2.0.0/server.zip")
+
+	_, err = ReplaceText(testJobHCL, &serverconfig.TextReplace{Find: "(", Regex: true})
+	assert.Error(t, err)
+}
+
+// TestUnifiedDiff 测试diff生成
+func TestUnifiedDiff(t *testing.T) {
+	assert.Equal(t, "", UnifiedDiff("game_job/10001", "a\n", "a\n"))
+	assert.Contains(t, UnifiedDiff("game_job/10001", "a\n", "b\n"), "+b")
+}
+
+func TestExtractJobPorts(t *testing.T) {
+	src := `job "game-10001" {
+  group "game" {
+    network {
+      port "game" {
+        static = 7001
+      }
+      port "gm" {
+        static = 7101
+      }
+      port "metrics" {}
+    }
+  }
+}
+`
+	ports, err := ExtractJobPorts(src)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{7001, 7101}, ports)
+
+	ports, err = ExtractJobPorts(`{"configs":[{"svc_name":"game","port":8001},{"svc_name":"gate","port":8002},{"svc_name":"log"}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{8001, 8002}, ports)
+
+	_, err = ExtractJobPorts(`job "x" { group "g" { network { port "a" { static = var.port } } } }`)
+	assert.Error(t, err)
+}
+
+func TestInjectNodeConstraint(t *testing.T) {
+	assert.Equal(t, "constraint {\n  attribute = \"${node.unique.name}\"\n  value     = \"node-1\"\n}\n", NodeConstraintHCL("node-1"))
+
+	out, err := InjectNodeConstraint(testJobHCL, "node-1")
+	assert.NoError(t, err)
+	assert.Contains(t, out, `attribute = "${node.unique.name}"`)
+	assert.Contains(t, out, `value     = "node-1"`)
+
+	// 再次注入会替换原有的节点约束
+	out, err = InjectNodeConstraint(out, "node-2")
+	assert.NoError(t, err)
+	assert.NotContains(t, out, "node-1")
+	assert.Equal(t, 1, strings.Count(out, "constraint {"))
+}
+
+// TestSetJobPlacement 测试设置job的namespace和region
+func TestSetJobPlacement(t *testing.T) {
+	res, err := SetJobPlacement(testJobHCL, "prod", "cn-east")
+	assert.NoError(t, err)
+	assert.Contains(t, res, `namespace = "prod"`)
+	assert.Contains(t, res, `region    = "cn-east"`)
+
+	// 已存在时替换，空值不修改
+	res, err = SetJobPlacement(res, "staging", "")
+	assert.NoError(t, err)
+	assert.Contains(t, res, `"staging"`)
+	assert.NotContains(t, res, `"prod"`)
+	assert.Contains(t, res, `"cn-east"`)
+
+	_, err = SetJobPlacement(`variable "a" {}`, "prod", "")
+	assert.Error(t, err)
+}
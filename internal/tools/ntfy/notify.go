@@ -40,9 +40,6 @@ func init() {
 
 // PublishNotification 发布消息
 func PublishNotification(eventType, taskType string, successJobs []string, failedJobs []string, successCount int, failedCount int) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	var notification *Notification
 	var notifyMsg strings.Builder
 	notifyMsg.WriteString("📢 游戏操作通知: ")
 	notifyMsg.WriteString(fmt.Sprintf("ℹ️任务类型: %s", taskType))
@@ -62,9 +59,16 @@ func PublishNotification(eventType, taskType string, successJobs []string, faile
 		}
 	}
 	notifyMsg.WriteString(fmt.Sprintf("🕒 操作时间：%s", time.Now().Format("2006-01-02 15:04:05")))
-	notification = &Notification{
+	PublishMessage(eventType, notifyMsg.String())
+}
+
+// PublishMessage 发布自定义内容的消息，如审批通知中的审批链接
+func PublishMessage(eventType, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	notification := &Notification{
 		Type:    eventType,
-		Message: notifyMsg.String(),
+		Message: message,
 	}
 	// 序列化通知消息
 	notificationJSON, err := json.Marshal(notification)
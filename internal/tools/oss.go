@@ -2,11 +2,18 @@ package tools
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"saurfang/internal/config"
 	"saurfang/internal/models/datasource"
+	"sort"
+	"strings"
 )
 
 // UploadToOss 上传文件到对象存储
@@ -34,3 +41,148 @@ func UploadToOss(target int) (path, source string, err error) {
 	}
 	return ossInfo.Path, ossInfo.Label, nil
 }
+
+// UploadContentToOss 上传内容到对象存储的指定路径，remotePath为bucket下的路径
+func UploadContentToOss(target uint, remotePath string, content []byte) error {
+	var ossInfo datasource.Datasources
+	if err := config.DB.Raw("select * from datasources where id = ?;", target).Scan(&ossInfo).Error; err != nil {
+		return err
+	}
+	if ossInfo.ID == 0 {
+		return fmt.Errorf("datasource %d not found", target)
+	}
+	if os.Getenv("TESTING") == "true" {
+		return nil
+	}
+	f, err := os.CreateTemp("", "saurfang-upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	var stdErr bytes.Buffer
+	cmd := exec.Command("rclone", "copyto", f.Name(), fmt.Sprintf("%s:%s/%s", ossInfo.Profile, ossInfo.Bucket, strings.TrimPrefix(remotePath, "/")))
+	cmd.Stderr = &stdErr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s", stdErr.String())
+	}
+	return nil
+}
+
+// DeleteFromOss 删除对象存储中的文件
+func DeleteFromOss(target uint, remotePath string) error {
+	var ossInfo datasource.Datasources
+	if err := config.DB.Raw("select * from datasources where id = ?;", target).Scan(&ossInfo).Error; err != nil {
+		return err
+	}
+	if os.Getenv("TESTING") == "true" {
+		return nil
+	}
+	var stdErr bytes.Buffer
+	cmd := exec.Command("rclone", "deletefile", fmt.Sprintf("%s:%s/%s", ossInfo.Profile, ossInfo.Bucket, strings.TrimPrefix(remotePath, "/")))
+	cmd.Stderr = &stdErr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s", stdErr.String())
+	}
+	return nil
+}
+
+// ossRemote 数据源中路径对应的rclone地址
+func ossRemote(ossInfo *datasource.Datasources, remotePath string) string {
+	return fmt.Sprintf("%s:%s%s/%s", ossInfo.Profile, ossInfo.Bucket, strings.TrimSuffix(ossInfo.Path, "/"), strings.TrimPrefix(remotePath, "/"))
+}
+
+// CopyBetweenOss 把数据源src中的路径复制到数据源dst的相同路径
+func CopyBetweenOss(src, dst uint, remotePath string) error {
+	var srcInfo, dstInfo datasource.Datasources
+	if err := config.DB.Raw("select * from datasources where id = ?;", src).Scan(&srcInfo).Error; err != nil {
+		return err
+	}
+	if err := config.DB.Raw("select * from datasources where id = ?;", dst).Scan(&dstInfo).Error; err != nil {
+		return err
+	}
+	if srcInfo.ID == 0 || dstInfo.ID == 0 {
+		return fmt.Errorf("datasource %d or %d not found", src, dst)
+	}
+	if os.Getenv("TESTING") == "true" {
+		return nil
+	}
+	var stdErr bytes.Buffer
+	cmd := exec.Command("rclone", "copy", "--no-update-modtime", ossRemote(&srcInfo, remotePath), ossRemote(&dstInfo, remotePath))
+	cmd.Stderr = &stdErr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s", stdErr.String())
+	}
+	return nil
+}
+
+// OssChecksums 列出数据源中路径下所有文件的md5，按文件名排序，路径不存在时返回空
+func OssChecksums(target uint, remotePath string) (string, error) {
+	var ossInfo datasource.Datasources
+	if err := config.DB.Raw("select * from datasources where id = ?;", target).Scan(&ossInfo).Error; err != nil {
+		return "", err
+	}
+	if ossInfo.ID == 0 {
+		return "", fmt.Errorf("datasource %d not found", target)
+	}
+	if os.Getenv("TESTING") == "true" {
+		return "", nil
+	}
+	var stdOut, stdErr bytes.Buffer
+	cmd := exec.Command("rclone", "md5sum", ossRemote(&ossInfo, remotePath))
+	cmd.Stdout = &stdOut
+	cmd.Stderr = &stdErr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdErr.String(), "directory not found") {
+			return "", nil
+		}
+		return "", fmt.Errorf("%s", stdErr.String())
+	}
+	if strings.TrimSpace(stdOut.String()) == "" {
+		return "", nil
+	}
+	lines := strings.Split(strings.TrimSpace(stdOut.String()), "\n")
+	// md5sum输出为"<md5>  <文件名>"，按文件名排序
+	sort.Slice(lines, func(i, j int) bool {
+		return strings.TrimSpace(lines[i][min(32, len(lines[i])):]) < strings.TrimSpace(lines[j][min(32, len(lines[j])):])
+	})
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// LocalChecksums 按OssChecksums的格式计算本地目录中文件的md5，用于和同步到存储的文件比对
+func LocalChecksums(dir string) (string, error) {
+	var lines []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// rclone同步时跳过符号链接
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		lines = append(lines, hex.EncodeToString(h.Sum(nil))+"  "+filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil || len(lines) == 0 {
+		return "", err
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][34:] < lines[j][34:] })
+	return strings.Join(lines, "\n") + "\n", nil
+}
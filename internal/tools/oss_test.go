@@ -2,6 +2,7 @@ package tools
 
 import (
 	"os"
+	"path/filepath"
 	"saurfang/internal/config"
 	"saurfang/internal/testutils"
 	"testing"
@@ -30,3 +31,19 @@ func TestUploadToOss(t *testing.T) {
 	assert.Equal(t, "FISH-CN", source)
 	assert.NoError(t, mockDB.Mock.ExpectationsWereMet())
 }
+
+// TestLocalChecksums 测试本地目录的校验和与rclone md5sum的格式一致，按文件名排序
+func TestLocalChecksums(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "game.conf"), []byte("a"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bin", "server"), []byte("b"), 0o644))
+
+	sums, err := LocalChecksums(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "92eb5ffee6ae2fec3ad71c777531578f  bin/server\n0cc175b9c0f1b6a831c399e269772661  game.conf\n", sums)
+
+	empty, err := LocalChecksums(t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, "", empty)
+}
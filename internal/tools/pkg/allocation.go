@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"saurfang/internal/models/nomadjob"
+	"sort"
+	"strings"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// SummarizeAllocation 转换分配信息，只保留task状态和事件
+func SummarizeAllocation(stub *nomadapi.AllocationListStub) *nomadjob.AllocationInfo {
+	info := &nomadjob.AllocationInfo{
+		ID:            stub.ID,
+		Name:          stub.Name,
+		NodeID:        stub.NodeID,
+		NodeName:      stub.NodeName,
+		TaskGroup:     stub.TaskGroup,
+		JobVersion:    stub.JobVersion,
+		DesiredStatus: stub.DesiredStatus,
+		ClientStatus:  stub.ClientStatus,
+		CreateTime:    stub.CreateTime,
+		ModifyTime:    stub.ModifyTime,
+		Tasks:         make(map[string]*nomadjob.TaskStateInfo, len(stub.TaskStates)),
+	}
+	for name, state := range stub.TaskStates {
+		if state == nil {
+			continue
+		}
+		ts := &nomadjob.TaskStateInfo{
+			State:    state.State,
+			Failed:   state.Failed,
+			Restarts: state.Restarts,
+			Events:   make([]*nomadjob.TaskEventInfo, 0, len(state.Events)),
+		}
+		if !state.LastRestart.IsZero() {
+			ts.LastRestart = state.LastRestart.Unix()
+		}
+		if !state.StartedAt.IsZero() {
+			ts.StartedAt = state.StartedAt.Unix()
+		}
+		if !state.FinishedAt.IsZero() {
+			ts.FinishedAt = state.FinishedAt.Unix()
+		}
+		for _, e := range state.Events {
+			msg := e.DisplayMessage
+			if msg == "" {
+				msg = e.Message
+			}
+			ts.Events = append(ts.Events, &nomadjob.TaskEventInfo{
+				Type:    e.Type,
+				Time:    e.Time / 1e6,
+				Message: msg,
+				Details: e.Details,
+			})
+		}
+		info.Tasks[name] = ts
+	}
+	return info
+}
+
+// GameAllocations 游戏服job的所有分配，按创建时间倒序
+func GameAllocations(client *nomadapi.Client, jobID string) ([]*nomadjob.AllocationInfo, error) {
+	stubs, _, err := client.Jobs().Allocations(jobID, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*nomadjob.AllocationInfo, 0, len(stubs))
+	for _, stub := range stubs {
+		items = append(items, SummarizeAllocation(stub))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreateTime > items[j].CreateTime
+	})
+	return items, nil
+}
+
+// GameAllocation 查询分配并校验属于该游戏服job，防止通过游戏服接口操作其他job的分配
+func GameAllocation(client *nomadapi.Client, jobID, allocID string) (*nomadapi.Allocation, error) {
+	alloc, _, err := client.Allocations().Info(allocID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if alloc.JobID != jobID {
+		return nil, fmt.Errorf("allocation %s does not belong to %s", allocID, jobID)
+	}
+	return alloc, nil
+}
+
+// AllocTask 校验task属于该分配，分配只有一个task时可以不指定
+func AllocTask(alloc *nomadapi.Allocation, task string) (string, error) {
+	if task != "" {
+		if _, ok := alloc.TaskStates[task]; !ok {
+			return "", fmt.Errorf("task %s not found in allocation", task)
+		}
+		return task, nil
+	}
+	if len(alloc.TaskStates) == 1 {
+		for name := range alloc.TaskStates {
+			return name, nil
+		}
+	}
+	return "", errors.New("task is required")
+}
+
+// AllocPath 规范化分配文件系统中的路径，为空时为根目录
+func AllocPath(p string) (string, error) {
+	if p == "" {
+		return "/", nil
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", errors.New("path must not contain ..")
+		}
+	}
+	return path.Clean("/" + p), nil
+}
+
+// LogType 日志类型只能为stdout或stderr，默认为stdout
+func LogType(t string) (string, error) {
+	switch t {
+	case "":
+		return "stdout", nil
+	case "stdout", "stderr":
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid log type %s", t)
+}
@@ -0,0 +1,58 @@
+package pkg_test
+
+import (
+	"saurfang/internal/tools/pkg"
+	"testing"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSummarizeAllocation 测试分配信息转换和参数校验
+func TestSummarizeAllocation(t *testing.T) {
+	started := time.Unix(1700000000, 0)
+	info := pkg.SummarizeAllocation(&nomadapi.AllocationListStub{
+		ID:           "a1",
+		ClientStatus: "running",
+		TaskStates: map[string]*nomadapi.TaskState{
+			"game": {
+				State:     "running",
+				Restarts:  2,
+				StartedAt: started,
+				Events: []*nomadapi.TaskEvent{
+					{Type: "Terminated", Time: 1700000000123000000, Message: "raw", Details: map[string]string{"exit_code": "1"}},
+					{Type: "Started", Time: 1700000001000000000, DisplayMessage: "Task started by client"},
+				},
+			},
+		},
+	})
+	assert.Equal(t, "a1", info.ID)
+	task := info.Tasks["game"]
+	assert.Equal(t, uint64(2), task.Restarts)
+	assert.Equal(t, started.Unix(), task.StartedAt)
+	assert.Zero(t, task.FinishedAt)
+	assert.Equal(t, int64(1700000000123), task.Events[0].Time)
+	assert.Equal(t, "raw", task.Events[0].Message)
+	assert.Equal(t, "1", task.Events[0].Details["exit_code"])
+	assert.Equal(t, "Task started by client", task.Events[1].Message)
+
+	alloc := &nomadapi.Allocation{TaskStates: map[string]*nomadapi.TaskState{"game": {}}}
+	name, err := pkg.AllocTask(alloc, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "game", name)
+	_, err = pkg.AllocTask(alloc, "other")
+	assert.Error(t, err)
+
+	p, err := pkg.AllocPath("alloc/logs/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/alloc/logs", p)
+	_, err = pkg.AllocPath("/alloc/../../etc")
+	assert.Error(t, err)
+
+	logType, err := pkg.LogType("")
+	assert.NoError(t, err)
+	assert.Equal(t, "stdout", logType)
+	_, err = pkg.LogType("stdin")
+	assert.Error(t, err)
+}
@@ -0,0 +1,226 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gamehost"
+	"saurfang/internal/models/gameserver"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrServerIDExists = errors.New("server id already exists")
+	ErrPortConflict   = errors.New("port conflict")
+	ErrNoServerIDRule = errors.New("cannot allocate server id")
+)
+
+// maxAllocateAttempts 分配服务器ID时最多尝试的序号数量
+const maxAllocateAttempts = 10000
+
+// AllocateServerID 按渠道规则预览下一个未使用的服务器ID，不会推进规则中的序号
+func AllocateServerID(channelID uint) (string, error) {
+	return allocateServerID(config.DB, channelID, false)
+}
+
+// AllocateServerIDTx 在创建游戏服的事务中分配服务器ID并推进序号
+// 规则行锁到事务结束，并发创建不会分配到同一个ID，创建失败时序号随事务回滚
+func AllocateServerIDTx(tx *gorm.DB, channelID uint) (string, error) {
+	return allocateServerID(tx, channelID, true)
+}
+
+func allocateServerID(tx *gorm.DB, channelID uint, commit bool) (string, error) {
+	var rule gameserver.ServerIDRule
+	query := tx.Where("channel_id = ?", channelID)
+	if commit {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	if err := query.First(&rule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("%w: no server id rule for channel %d", ErrNoServerIDRule, channelID)
+		}
+		return "", err
+	}
+	step := max(rule.Step, 1)
+	seq := max(rule.Next, rule.Start)
+	for range maxAllocateAttempts {
+		candidate := formatServerID(&rule, seq)
+		var count int64
+		if err := tx.Model(&gameserver.Games{}).Where("server_id = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			if commit {
+				if err := tx.Model(&rule).Update("next", seq+step).Error; err != nil {
+					return "", err
+				}
+			}
+			return candidate, nil
+		}
+		seq += step
+	}
+	return "", fmt.Errorf("%w: no free server id found for channel %d", ErrNoServerIDRule, channelID)
+}
+
+func formatServerID(rule *gameserver.ServerIDRule, seq int64) string {
+	return fmt.Sprintf("%s%0*d", rule.Prefix, rule.Width, seq)
+}
+
+// CheckServerIDUnique 检查服务器ID是否已被使用
+func CheckServerIDUnique(serverID string) error {
+	return checkServerIDUnique(config.DB, serverID)
+}
+
+// CheckServerIDUniqueTx 在创建游戏服的事务中检查服务器ID，锁定查询范围到事务结束，并发创建同一个ID时后者等待后冲突
+func CheckServerIDUniqueTx(tx *gorm.DB, serverID string) error {
+	return checkServerIDUnique(tx.Clauses(clause.Locking{Strength: "UPDATE"}), serverID)
+}
+
+func checkServerIDUnique(db *gorm.DB, serverID string) error {
+	var ids []uint
+	if err := db.Model(&gameserver.Games{}).Where("server_id = ?", serverID).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		return fmt.Errorf("%w: %s", ErrServerIDExists, serverID)
+	}
+	return nil
+}
+
+// ServerHostIDs 查询游戏服分配的主机
+func ServerHostIDs(serverID string) ([]uint, error) {
+	return serverHostIDs(config.DB, serverID)
+}
+
+func serverHostIDs(db *gorm.DB, serverID string) ([]uint, error) {
+	var ids []uint
+	err := db.Raw("SELECT gh.host_id FROM game_hosts gh JOIN games g ON gh.game_id = g.id WHERE g.server_id = ?", serverID).
+		Scan(&ids).Error
+	return ids, err
+}
+
+// FindPortConflicts 查询主机上与端口范围重叠的其他游戏服登记
+func FindPortConflicts(hostIDs []uint, serverID string, start, end int) ([]gamehost.PortReservation, error) {
+	return findPortConflicts(config.DB, hostIDs, serverID, start, end)
+}
+
+func findPortConflicts(db *gorm.DB, hostIDs []uint, serverID string, start, end int) ([]gamehost.PortReservation, error) {
+	var conflicts []gamehost.PortReservation
+	if len(hostIDs) == 0 {
+		return conflicts, nil
+	}
+	err := db.Where("host_id IN ? AND server_id <> ? AND start_port <= ? AND end_port >= ?", hostIDs, serverID, end, start).
+		Find(&conflicts).Error
+	return conflicts, err
+}
+
+// CheckServerPorts 检查游戏服配置的端口在其主机上是否与其他游戏服冲突
+// hostIDs为nil时从逻辑服分配的主机中查询
+func CheckServerPorts(serverID string, hostIDs []uint, ports []int) error {
+	return checkServerPorts(config.DB, serverID, hostIDs, ports)
+}
+
+// CheckServerPortsTx 在事务中锁定主机后检查端口，之后在同一个事务中用SyncConfigPortsTx登记
+// 同一主机上的并发检查和登记串行执行，不会同时通过检查
+func CheckServerPortsTx(tx *gorm.DB, serverID string, hostIDs []uint, ports []int) error {
+	if len(ports) > 0 && len(hostIDs) > 0 {
+		var locked []uint
+		if err := tx.Model(&gamehost.Hosts{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", hostIDs).Order("id").Pluck("id", &locked).Error; err != nil {
+			return err
+		}
+	}
+	return checkServerPorts(tx, serverID, hostIDs, ports)
+}
+
+func checkServerPorts(db *gorm.DB, serverID string, hostIDs []uint, ports []int) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	if hostIDs == nil {
+		var err error
+		if hostIDs, err = serverHostIDs(db, serverID); err != nil {
+			return err
+		}
+	}
+	var msgs []string
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+		conflicts, err := findPortConflicts(db, hostIDs, serverID, port, port)
+		if err != nil {
+			return err
+		}
+		for _, r := range conflicts {
+			msgs = append(msgs, fmt.Sprintf("port %d on host %d is reserved by %s (%d-%d)", port, r.HostID, r.ServerID, r.StartPort, r.EndPort))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%w: %s", ErrPortConflict, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// SyncConfigPorts 用配置中的端口替换游戏服自动登记的端口
+func SyncConfigPorts(serverID string, hostIDs []uint, ports []int) error {
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		return SyncConfigPortsTx(tx, serverID, hostIDs, ports)
+	})
+}
+
+// SyncConfigPortsTx 在调用方的事务中登记端口
+func SyncConfigPortsTx(tx *gorm.DB, serverID string, hostIDs []uint, ports []int) error {
+	if hostIDs == nil {
+		var err error
+		if hostIDs, err = serverHostIDs(tx, serverID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("server_id = ? AND source = ?", serverID, gamehost.PortSourceConfig).
+		Delete(&gamehost.PortReservation{}).Error; err != nil {
+		return err
+	}
+	var reservations []gamehost.PortReservation
+	for _, hostID := range hostIDs {
+		for _, port := range ports {
+			reservations = append(reservations, gamehost.PortReservation{
+				HostID:    hostID,
+				ServerID:  serverID,
+				StartPort: port,
+				EndPort:   port,
+				Source:    gamehost.PortSourceConfig,
+			})
+		}
+	}
+	if len(reservations) == 0 {
+		return nil
+	}
+	return tx.Create(&reservations).Error
+}
+
+// ReservePorts 手动预留端口范围，与其他游戏服重叠时拒绝
+func ReservePorts(r *gamehost.PortReservation) error {
+	if r.HostID == 0 || r.ServerID == "" {
+		return errors.New("host_id and server_id are required")
+	}
+	if r.EndPort == 0 {
+		r.EndPort = r.StartPort
+	}
+	if r.StartPort < 1 || r.EndPort > 65535 || r.StartPort > r.EndPort {
+		return fmt.Errorf("invalid port range %d-%d", r.StartPort, r.EndPort)
+	}
+	conflicts, err := FindPortConflicts([]uint{r.HostID}, r.ServerID, r.StartPort, r.EndPort)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		c := conflicts[0]
+		return fmt.Errorf("%w: %d-%d overlaps %s (%d-%d)", ErrPortConflict, r.StartPort, r.EndPort, c.ServerID, c.StartPort, c.EndPort)
+	}
+	r.Source = gamehost.PortSourceManual
+	return config.DB.Create(r).Error
+}
@@ -0,0 +1,66 @@
+package pkg_test
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/testutils"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// TestCheckServerPorts 测试端口与同主机其他游戏服冲突时拒绝
+func TestCheckServerPorts(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `port_reservations`").
+		WithArgs(1, "10001", 7001, 7001).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "host_id", "server_id", "start_port", "end_port"}))
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `port_reservations`").
+		WithArgs(1, "10001", 7101, 7101).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "host_id", "server_id", "start_port", "end_port"}).
+			AddRow(3, 1, "10002", 7100, 7199))
+
+	err := pkg.CheckServerPorts("10001", []uint{1}, []int{7001, 7101})
+	assert.ErrorIs(t, err, pkg.ErrPortConflict)
+	assert.Contains(t, err.Error(), "port 7101 on host 1 is reserved by 10002 (7100-7199)")
+	mockDB.ExpectationsWereMet(t)
+}
+
+// TestCheckServerPortsInvalid 测试非法端口
+func TestCheckServerPortsInvalid(t *testing.T) {
+	err := pkg.CheckServerPorts("10001", []uint{}, []int{70000})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, pkg.ErrPortConflict)
+}
+
+// TestAllocateServerIDTx 测试序号在调用方的事务中推进，创建失败时随事务回滚
+func TestAllocateServerIDTx(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	mockDB.Mock.ExpectBegin()
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `server_id_rules` WHERE channel_id = \\? .* FOR UPDATE").
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "channel_id", "prefix", "start", "step", "width", "next"}).AddRow(1, 2, "s", 1, 1, 3, 5))
+	mockDB.Mock.ExpectQuery("SELECT count\\(\\*\\) FROM `games`").
+		WithArgs("s005").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mockDB.Mock.ExpectExec("UPDATE `server_id_rules` SET `next`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.Mock.ExpectRollback()
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		serverID, err := pkg.AllocateServerIDTx(tx, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "s005", serverID)
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+	mockDB.ExpectationsWereMet(t)
+}
@@ -0,0 +1,363 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"saurfang/internal/config"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// 审批错误
+var (
+	ErrChangeNotPending  = errors.New("change is not pending")
+	ErrApprovalForbidden = errors.New("not allowed to decide this change")
+	ErrInvalidLink       = errors.New("invalid or expired approval link")
+)
+
+// ApprovalExecutor 审批通过后执行变更，返回生成的后台操作id(没有时为0)
+type ApprovalExecutor func(change *approval.Change) (uint, error)
+
+var (
+	approvalExecutors   = map[string]ApprovalExecutor{}
+	approvalExecutorsMu sync.RWMutex
+)
+
+// RegisterApprovalExecutor 注册变更类型的执行方式，由发起变更的handler注册
+func RegisterApprovalExecutor(kind string, exec ApprovalExecutor) {
+	approvalExecutorsMu.Lock()
+	defer approvalExecutorsMu.Unlock()
+	approvalExecutors[kind] = exec
+}
+
+// PolicyMatches 策略是否适用于操作，envs为操作涉及的集群
+func PolicyMatches(p *approval.Policy, action string, envs []string, targets int) bool {
+	if !p.Enabled {
+		return false
+	}
+	if ok, err := path.Match(p.Action, action); err != nil || !ok {
+		return false
+	}
+	if targets < p.MinTargets {
+		return false
+	}
+	return p.Environment == "" || slices.Contains(envs, p.Environment)
+}
+
+// MatchApprovalPolicy 第一个适用于操作的策略，没有时返回nil
+func MatchApprovalPolicy(action string, envs []string, targets int) (*approval.Policy, error) {
+	var policies []approval.Policy
+	if err := config.DB.Where("enabled = ?", true).Order("id").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		if PolicyMatches(&policies[i], action, envs, targets) {
+			return &policies[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ServerEnvironments 游戏服涉及的集群，按名称排序
+func ServerEnvironments(serverIDs []string) ([]string, error) {
+	names, err := ClusterNamesOfServers(serverIDs)
+	if err != nil {
+		return nil, err
+	}
+	var envs []string
+	for _, name := range names {
+		if !slices.Contains(envs, name) {
+			envs = append(envs, name)
+		}
+	}
+	sort.Strings(envs)
+	return envs, nil
+}
+
+// RequestApproval 操作命中审批策略时生成待审批变更并通知审批人，返回nil表示不需要审批
+// 申请原因取自X-Approval-Reason请求头(可url编码)，X-Freeze-Override随变更保存，审批通过后执行时按申请人的权限覆盖冻结
+func RequestApproval(ctx fiber.Ctx, kind, action string, envs, targets []string) (*approval.Change, error) {
+	policy, err := MatchApprovalPolicy(action, envs, len(targets))
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	reason := ctx.Get("X-Approval-Reason")
+	if decoded, err := url.QueryUnescape(reason); err == nil {
+		reason = decoded
+	}
+	expire := policy.ExpireMinutes
+	if expire <= 0 {
+		expire = approval.DefaultExpireMinutes
+	}
+	change := &approval.Change{
+		PolicyID:    policy.ID,
+		Kind:        kind,
+		Action:      action,
+		Environment: strings.Join(envs, ","),
+		Targets:     targets,
+		Params:      string(ctx.Request().URI().QueryString()),
+		Requester:   ctx.Get("X-Request-User"),
+		RequesterID: RequestUserID(ctx),
+		Trigger:     RequestTrigger(ctx),
+		Reason:      truncate(strings.TrimSpace(reason), 500),
+		Override:    truncate(RequestFreezeOverride(ctx), 500),
+		Status:      approval.StatusPending,
+		ExpiresAt:   time.Now().Add(time.Duration(expire) * time.Minute),
+	}
+	if err := config.DB.Create(change).Error; err != nil {
+		return nil, err
+	}
+	slog.Info("change requires approval", "change_id", change.ID, "policy", policy.Name, "action", action, "requester", change.Requester)
+	ntfy.PublishMessage(notify.EventTypeApproval, approvalRequestMessage(change, policy))
+	return change, nil
+}
+
+// ApprovalPending 需要审批时的响应，返回202和待审批变更
+func ApprovalPending(ctx fiber.Ctx, change *approval.Change) error {
+	return NewAppResponse(ctx, fiber.StatusAccepted, 0, fmt.Sprintf("approval required, change %d is pending", change.ID), "", change)
+}
+
+// canDecide 策略指定了审批人(用户名)时只有审批人可以审批，否则需要审批权限，申请人不能审批自己的变更
+// 按用户ID判断是否为申请人，operatorID为0时无法确认身份，不允许审批
+func canDecide(change *approval.Change, operator string, operatorID uint) bool {
+	if operator == "" || operatorID == 0 || operatorID == change.RequesterID || operator == change.Requester {
+		return false
+	}
+	var policy approval.Policy
+	if err := config.DB.First(&policy, change.PolicyID).Error; err == nil && len(policy.Approvers) > 0 {
+		return slices.Contains(policy.Approvers, operator)
+	}
+	return UserHasPermission(operator, approval.ApprovePermission)
+}
+
+// decideChange 将待审批且未过期的变更更新为审批结果，避免重复审批
+func decideChange(id uint, operator string, operatorID uint, comment, status string) (*approval.Change, error) {
+	var change approval.Change
+	if err := config.DB.First(&change, id).Error; err != nil {
+		return nil, err
+	}
+	if change.Status != approval.StatusPending || !change.ExpiresAt.After(time.Now()) {
+		return &change, ErrChangeNotPending
+	}
+	if !canDecide(&change, operator, operatorID) {
+		return &change, ErrApprovalForbidden
+	}
+	now := time.Now()
+	res := config.DB.Model(&approval.Change{}).Where("id = ? AND status = ?", id, approval.StatusPending).
+		Updates(map[string]any{"status": status, "approver": operator, "approver_id": operatorID, "comment": truncate(comment, 500), "decided_at": now})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &change, ErrChangeNotPending
+	}
+	change.Status, change.Approver, change.ApproverID, change.Comment, change.DecidedAt = status, operator, operatorID, truncate(comment, 500), &now
+	return &change, nil
+}
+
+// ApproveChange 审批通过并执行变更
+func ApproveChange(id uint, approver string, approverID uint, comment string) (*approval.Change, error) {
+	change, err := decideChange(id, approver, approverID, comment, approval.StatusApproved)
+	if err != nil {
+		return change, err
+	}
+	executeChange(change)
+	return change, nil
+}
+
+// RejectChange 拒绝变更
+func RejectChange(id uint, approver string, approverID uint, comment string) (*approval.Change, error) {
+	change, err := decideChange(id, approver, approverID, comment, approval.StatusRejected)
+	if err != nil {
+		return change, err
+	}
+	ntfy.PublishMessage(notify.EventTypeApproval, approvalResultMessage(change))
+	return change, nil
+}
+
+// CancelChange 申请人取消待审批的变更
+func CancelChange(id uint, operatorID uint) (*approval.Change, error) {
+	var change approval.Change
+	if err := config.DB.First(&change, id).Error; err != nil {
+		return nil, err
+	}
+	if operatorID == 0 || operatorID != change.RequesterID {
+		return &change, ErrApprovalForbidden
+	}
+	res := config.DB.Model(&approval.Change{}).Where("id = ? AND status = ?", id, approval.StatusPending).
+		Update("status", approval.StatusCancelled)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &change, ErrChangeNotPending
+	}
+	change.Status = approval.StatusCancelled
+	return &change, nil
+}
+
+// executeChange 按变更类型执行并保存结果
+func executeChange(change *approval.Change) {
+	approvalExecutorsMu.RLock()
+	exec, ok := approvalExecutors[change.Kind]
+	approvalExecutorsMu.RUnlock()
+	var opID uint
+	err := fmt.Errorf("no executor for kind %s", change.Kind)
+	if ok {
+		opID, err = exec(change)
+	}
+	change.OperationID = opID
+	change.Status = approval.StatusExecuted
+	if err != nil {
+		change.Status = approval.StatusFailed
+		change.Error = err.Error()
+		slog.Error("execute approved change failed", "change_id", change.ID, "action", change.Action, "error", err)
+	}
+	if err := config.DB.Model(change).Updates(map[string]any{"status": change.Status, "operation_id": opID, "error": change.Error}).Error; err != nil {
+		slog.Error("save approved change result failed", "change_id", change.ID, "error", err)
+	}
+	ntfy.PublishMessage(notify.EventTypeApproval, approvalResultMessage(change))
+}
+
+// ExpireChanges 过期无人审批的变更
+func ExpireChanges(now time.Time) (int, error) {
+	var changes []approval.Change
+	if err := config.DB.Where("status = ? AND expires_at <= ?", approval.StatusPending, now).Find(&changes).Error; err != nil {
+		return 0, err
+	}
+	expired := 0
+	for i := range changes {
+		res := config.DB.Model(&approval.Change{}).Where("id = ? AND status = ?", changes[i].ID, approval.StatusPending).
+			Update("status", approval.StatusExpired)
+		if res.Error != nil {
+			return expired, res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		expired++
+		changes[i].Status = approval.StatusExpired
+		ntfy.PublishMessage(notify.EventTypeApproval, approvalResultMessage(&changes[i]))
+	}
+	return expired, nil
+}
+
+// StartApprovalExpirer 每分钟过期无人审批的变更
+func StartApprovalExpirer() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n, err := ExpireChanges(time.Now()); err != nil {
+				slog.Error("failed to expire approval changes", "error", err)
+			} else if n > 0 {
+				slog.Info("approval changes expired", "count", n)
+			}
+		}
+	}()
+}
+
+// approvalLinkSign 审批链接签名，绑定变更、审批动作、审批人和链接过期时间
+func approvalLinkSign(secret string, id uint, action, approver string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d:%s:%s:%d", id, action, approver, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ApprovalLink 通知中的审批链接，未配置APPROVAL_LINK_BASE_URL或APPROVAL_LINK_SECRET时返回空
+func ApprovalLink(change *approval.Change, action, approver string) string {
+	base, secret := strings.TrimRight(os.Getenv("APPROVAL_LINK_BASE_URL"), "/"), os.Getenv("APPROVAL_LINK_SECRET")
+	if base == "" || secret == "" {
+		return ""
+	}
+	expires := change.ExpiresAt.Unix()
+	q := url.Values{}
+	q.Set("approver", approver)
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sign", approvalLinkSign(secret, change.ID, action, approver, expires))
+	return fmt.Sprintf("%s/api/v1/common/approval/%d/%s?%s", base, change.ID, action, q.Encode())
+}
+
+// VerifyApprovalLink 校验审批链接，返回链接对应的审批人
+func VerifyApprovalLink(id uint, action, approver, expires, sign string) (string, error) {
+	secret := os.Getenv("APPROVAL_LINK_SECRET")
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if secret == "" || err != nil || approver == "" || time.Now().Unix() > exp {
+		return "", ErrInvalidLink
+	}
+	expected := approvalLinkSign(secret, id, action, approver, exp)
+	if !hmac.Equal([]byte(expected), []byte(sign)) {
+		return "", ErrInvalidLink
+	}
+	return approver, nil
+}
+
+// approvalTargets 通知中展示的目标，避免消息过长
+func approvalTargets(targets []string) string {
+	const maxDisplay = 10
+	if len(targets) <= maxDisplay {
+		return strings.Join(targets, ",")
+	}
+	return fmt.Sprintf("%s ...等共%d个", strings.Join(targets[:maxDisplay], ","), len(targets))
+}
+
+// approvalRequestMessage 待审批通知，策略指定了审批人时附带每个审批人的审批链接
+func approvalRequestMessage(change *approval.Change, policy *approval.Policy) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📝 变更审批: #%d %s\n", change.ID, change.Action)
+	fmt.Fprintf(&b, "申请人: %s\n", change.Requester)
+	if change.Environment != "" {
+		fmt.Fprintf(&b, "集群: %s\n", change.Environment)
+	}
+	fmt.Fprintf(&b, "目标(%d): %s\n", len(change.Targets), approvalTargets(change.Targets))
+	if change.Reason != "" {
+		fmt.Fprintf(&b, "原因: %s\n", change.Reason)
+	}
+	fmt.Fprintf(&b, "策略: %s\n", policy.Name)
+	fmt.Fprintf(&b, "🕒 过期时间: %s\n", change.ExpiresAt.Format(time.DateTime))
+	for _, approver := range policy.Approvers {
+		approve, reject := ApprovalLink(change, "approve", approver), ApprovalLink(change, "reject", approver)
+		if approve == "" {
+			break
+		}
+		fmt.Fprintf(&b, "%s: 通过 %s 拒绝 %s\n", approver, approve, reject)
+	}
+	return b.String()
+}
+
+// approvalResultMessage 审批结果通知
+func approvalResultMessage(change *approval.Change) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📝 变更审批结果: #%d %s %s\n", change.ID, change.Action, change.Status)
+	fmt.Fprintf(&b, "申请人: %s\n", change.Requester)
+	if change.Approver != "" {
+		fmt.Fprintf(&b, "审批人: %s\n", change.Approver)
+	}
+	if change.Comment != "" {
+		fmt.Fprintf(&b, "审批意见: %s\n", change.Comment)
+	}
+	if change.OperationID > 0 {
+		fmt.Fprintf(&b, "操作: %d\n", change.OperationID)
+	}
+	if change.Error != "" {
+		fmt.Fprintf(&b, "错误: %s\n", change.Error)
+	}
+	fmt.Fprintf(&b, "🕒 时间: %s", time.Now().Format(time.DateTime))
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package pkg_test
+
+import (
+	"net/url"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/tools/pkg"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPolicyMatches 测试审批策略按操作类型、目标数量和集群匹配
+func TestPolicyMatches(t *testing.T) {
+	stopAll := &approval.Policy{Action: "game stop", MinTargets: 10, Environment: "default", Enabled: true}
+	assert.True(t, pkg.PolicyMatches(stopAll, "game stop", []string{"default", "sea"}, 10))
+	assert.False(t, pkg.PolicyMatches(stopAll, "game stop", []string{"default"}, 9))
+	assert.False(t, pkg.PolicyMatches(stopAll, "game stop", []string{"sea"}, 20))
+	assert.False(t, pkg.PolicyMatches(stopAll, "game start", []string{"default"}, 20))
+
+	anyOps := &approval.Policy{Action: "game *", Enabled: true}
+	assert.True(t, pkg.PolicyMatches(anyOps, "game restart", []string{"eu"}, 1))
+	assert.False(t, pkg.PolicyMatches(anyOps, "purge", []string{"eu"}, 1))
+	anyOps.Enabled = false
+	assert.False(t, pkg.PolicyMatches(anyOps, "game restart", []string{"eu"}, 1))
+}
+
+// TestApprovalLink 测试审批链接签名校验
+func TestApprovalLink(t *testing.T) {
+	t.Setenv("APPROVAL_LINK_BASE_URL", "https://ops.example.com/")
+	t.Setenv("APPROVAL_LINK_SECRET", "secret")
+	change := &approval.Change{ID: 7, ExpiresAt: time.Now().Add(time.Hour)}
+	link := pkg.ApprovalLink(change, "approve", "alice")
+	assert.True(t, strings.HasPrefix(link, "https://ops.example.com/api/v1/common/approval/7/approve?"))
+	u, err := url.Parse(link)
+	if !assert.NoError(t, err) {
+		return
+	}
+	q := u.Query()
+	approver, err := pkg.VerifyApprovalLink(7, "approve", q.Get("approver"), q.Get("expires"), q.Get("sign"))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", approver)
+
+	// 链接不能用于其他变更、其他动作或其他审批人
+	_, err = pkg.VerifyApprovalLink(8, "approve", "alice", q.Get("expires"), q.Get("sign"))
+	assert.ErrorIs(t, err, pkg.ErrInvalidLink)
+	_, err = pkg.VerifyApprovalLink(7, "reject", "alice", q.Get("expires"), q.Get("sign"))
+	assert.ErrorIs(t, err, pkg.ErrInvalidLink)
+	_, err = pkg.VerifyApprovalLink(7, "approve", "bob", q.Get("expires"), q.Get("sign"))
+	assert.ErrorIs(t, err, pkg.ErrInvalidLink)
+}
@@ -0,0 +1,339 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/autoscale"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"strconv"
+	"strings"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleLookback 查找定时计划最近一次触发时间的范围
+const scheduleLookback = 7 * 24 * time.Hour
+
+// ValidateScalingPolicy 校验策略参数
+func ValidateScalingPolicy(p *autoscale.ScalingPolicy) error {
+	if p.JobID == "" || p.Group == "" {
+		return errors.New("job_id and group are required")
+	}
+	if !config.HasCluster(p.Cluster) {
+		return fmt.Errorf("cluster %s not found", p.Cluster)
+	}
+	if p.Min < 0 || p.Max < p.Min {
+		return errors.New("require 0 <= min <= max")
+	}
+	if p.Cooldown < 0 {
+		return errors.New("cooldown must not be negative")
+	}
+	for _, s := range p.Schedules {
+		if _, err := cron.ParseStandard(s.Cron); err != nil {
+			return fmt.Errorf("invalid cron %s: %v", s.Cron, err)
+		}
+		if s.Count < p.Min || s.Count > p.Max {
+			return fmt.Errorf("count of schedule %s must be between min and max", s.Cron)
+		}
+	}
+	for _, r := range p.Rules {
+		if r.Metric != autoscale.MetricCPU && r.Metric != autoscale.MetricMemory {
+			return fmt.Errorf("invalid metric %s", r.Metric)
+		}
+		if r.Operator != ">" && r.Operator != "<" {
+			return fmt.Errorf("invalid operator %s", r.Operator)
+		}
+		if r.Step == 0 {
+			return fmt.Errorf("step of rule %s %s %v must not be 0", r.Metric, r.Operator, r.Threshold)
+		}
+	}
+	return nil
+}
+
+// ScheduledCount 当前生效的定时计划，即最近一次触发的计划，7天内没有触发时返回false
+func ScheduledCount(schedules []autoscale.Schedule, now time.Time) (int, string, bool) {
+	var latest time.Time
+	var count int
+	var spec string
+	for _, s := range schedules {
+		sched, err := cron.ParseStandard(s.Cron)
+		if err != nil {
+			continue
+		}
+		var last time.Time
+		for t := sched.Next(now.Add(-scheduleLookback)); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+			last = t
+		}
+		if !last.IsZero() && last.After(latest) {
+			latest, count, spec = last, s.Count, s.Cron
+		}
+	}
+	return count, spec, !latest.IsZero()
+}
+
+// DecideScale 根据覆盖、指标规则和定时计划计算目标数量，不访问nomad
+func DecideScale(p *autoscale.ScalingPolicy, current int, metrics map[string]float64, now time.Time) *autoscale.Decision {
+	d := &autoscale.Decision{Current: current, Target: current, Metrics: metrics}
+	clamp := func(n int) int {
+		return max(p.Min, min(p.Max, n))
+	}
+	if p.OverrideCount != nil && p.OverrideUntil != nil && now.Before(*p.OverrideUntil) {
+		d.Source = autoscale.SourceOverride
+		d.Target = clamp(*p.OverrideCount)
+		d.Reason = fmt.Sprintf("override to %d until %s", *p.OverrideCount, p.OverrideUntil.Format(time.DateTime))
+		d.Scale = d.Target != current
+		return d
+	}
+	scheduled, spec, hasSchedule := ScheduledCount(p.Schedules, now)
+	for _, r := range p.Rules {
+		value, ok := metrics[r.Metric]
+		if !ok {
+			continue
+		}
+		if (r.Operator == ">" && value > r.Threshold) || (r.Operator == "<" && value < r.Threshold) {
+			d.Source = autoscale.SourceMetric
+			d.Target = current + r.Step
+			d.Reason = fmt.Sprintf("%s %.1f %s %v, step %+d", r.Metric, value, r.Operator, r.Threshold, r.Step)
+			if hasSchedule && d.Target < scheduled {
+				d.Target = scheduled
+				d.Reason += fmt.Sprintf(", not below schedule %s count %d", spec, scheduled)
+			}
+			break
+		}
+	}
+	if d.Source == "" && hasSchedule {
+		d.Source = autoscale.SourceSchedule
+		d.Target = scheduled
+		d.Reason = fmt.Sprintf("schedule %s count %d", spec, scheduled)
+	}
+	if target := clamp(d.Target); target != d.Target {
+		d.Reason += fmt.Sprintf(", limited to [%d,%d]", p.Min, p.Max)
+		d.Target = target
+	}
+	if d.Source == "" {
+		d.Reason = "no schedule or rule matched"
+	}
+	if d.Target != current && p.LastScaleAt != nil && now.Sub(*p.LastScaleAt) < time.Duration(p.Cooldown)*time.Second {
+		d.Reason += ", in cooldown"
+		return d
+	}
+	d.Scale = d.Target != current
+	return d
+}
+
+// GroupCount 任务组当前的数量
+func GroupCount(client *nomadapi.Client, jobID, group string) (int, error) {
+	job, _, err := client.Jobs().Info(jobID, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, tg := range job.TaskGroups {
+		if tg.Name != nil && *tg.Name == group && tg.Count != nil {
+			return *tg.Count, nil
+		}
+	}
+	return 0, fmt.Errorf("group %s not found in job %s", group, jobID)
+}
+
+// GroupMetrics 任务组运行中分配的平均cpu和内存使用百分比，没有运行中的分配时返回空
+func GroupMetrics(client *nomadapi.Client, jobID, group string) (map[string]float64, error) {
+	stubs, _, err := client.Jobs().Allocations(jobID, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	var cpuSum, memSum float64
+	var n int
+	for _, stub := range stubs {
+		if stub.TaskGroup != group || stub.ClientStatus != nomadapi.AllocClientStatusRunning {
+			continue
+		}
+		alloc, _, err := client.Allocations().Info(stub.ID, nil)
+		if err != nil || alloc.AllocatedResources == nil {
+			continue
+		}
+		usage, err := client.Allocations().Stats(alloc, nil)
+		if err != nil || usage.ResourceUsage == nil {
+			slog.Warn("failed to get allocation stats", "alloc_id", stub.ID, "error", err)
+			continue
+		}
+		var cpuMHz, memBytes float64
+		for _, t := range alloc.AllocatedResources.Tasks {
+			cpuMHz += float64(t.Cpu.CpuShares)
+			memBytes += float64(t.Memory.MemoryMB) * 1024 * 1024
+		}
+		if cpuMHz == 0 || memBytes == 0 {
+			continue
+		}
+		if cs := usage.ResourceUsage.CpuStats; cs != nil {
+			cpuSum += cs.TotalTicks / cpuMHz * 100
+		}
+		if ms := usage.ResourceUsage.MemoryStats; ms != nil {
+			used := ms.RSS
+			if used == 0 {
+				used = ms.Usage
+			}
+			memSum += float64(used) / memBytes * 100
+		}
+		n++
+	}
+	if n == 0 {
+		return map[string]float64{}, nil
+	}
+	return map[string]float64{
+		autoscale.MetricCPU:    cpuSum / float64(n),
+		autoscale.MetricMemory: memSum / float64(n),
+	}, nil
+}
+
+// ScaleGroup 伸缩任务组并记录、发送通知，job被其他操作锁定时不伸缩也不记录
+func ScaleGroup(client *nomadapi.Client, event *autoscale.ScalingEvent) error {
+	lease, err := LockServer(event.JobID, "autoscaler", "scale "+event.Group)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+	target := event.To
+	resp, _, err := client.Jobs().Scale(event.JobID, event.Group, &target, event.Reason, false, map[string]interface{}{
+		"source": event.Source,
+	}, nil)
+	if err == nil {
+		event.EvalID = resp.EvalID
+	}
+	RecordScalingEvent(event, err)
+	return err
+}
+
+// RecordScalingEvent 保存伸缩记录并发送通知，err不为空时记录为失败
+func RecordScalingEvent(event *autoscale.ScalingEvent, err error) {
+	event.Status = autoscale.StatusSuccess
+	if err != nil {
+		event.Status = autoscale.StatusFailed
+		event.Error = err.Error()
+	}
+	if dbErr := config.DB.Create(event).Error; dbErr != nil {
+		slog.Error("failed to save scaling event", "job_id", event.JobID, "group", event.Group, "error", dbErr)
+	}
+	name := fmt.Sprintf("scale %s/%s %d -> %d (%s): %s", event.JobID, event.Group, event.From, event.To, event.Source, event.Reason)
+	key := event.JobID + "/" + event.Group
+	if err != nil {
+		ntfy.PublishNotification(notify.EventTypeAutoscale, name, nil, []string{key}, 0, 1)
+		return
+	}
+	ntfy.PublishNotification(notify.EventTypeAutoscale, name, []string{key}, nil, 1, 0)
+}
+
+// EvaluateScalingPolicy 评估策略，apply为true且需要伸缩时执行，评估结果保存到策略
+// 伸缩前检查冻结窗口，operator和overrideReason用于手动操作时覆盖冻结，定时评估时为空
+func EvaluateScalingPolicy(p *autoscale.ScalingPolicy, apply bool, operator, overrideReason string) (*autoscale.Decision, error) {
+	cluster, err := config.GetCluster(p.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	client := cluster.Nomad()
+	current, err := GroupCount(client, p.JobID, p.Group)
+	if err != nil {
+		return nil, err
+	}
+	var metrics map[string]float64
+	if len(p.Rules) > 0 {
+		if metrics, err = GroupMetrics(client, p.JobID, p.Group); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	d := DecideScale(p, current, metrics, now)
+	if !apply {
+		return d, nil
+	}
+	updates := map[string]any{"last_evaluated_at": now, "last_decision": truncate(d.Reason, 500)}
+	if d.Scale {
+		if err = CheckFreeze([]string{p.JobID}, "autoscale "+p.Group, operator, overrideReason); err != nil {
+			updates["last_decision"] = truncate("skipped: "+err.Error(), 500)
+			config.DB.Model(&autoscale.ScalingPolicy{}).Where("id = ?", p.ID).Updates(updates)
+			return d, err
+		}
+		err = ScaleGroup(client, &autoscale.ScalingEvent{
+			PolicyID: p.ID,
+			Cluster:  cluster.Name,
+			JobID:    p.JobID,
+			Group:    p.Group,
+			From:     d.Current,
+			To:       d.Target,
+			Source:   d.Source,
+			Reason:   truncate(d.Reason, 500),
+		})
+		if err == nil {
+			updates["last_scale_at"] = now
+		}
+	}
+	config.DB.Model(&autoscale.ScalingPolicy{}).Where("id = ?", p.ID).Updates(updates)
+	return d, err
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// autoscalerLock 定时评估的全局锁，多实例部署时同一时间只有一个实例评估
+const autoscalerLock = "autoscaler"
+
+// StartAutoscaler 定时评估所有启用的伸缩策略，AUTOSCALE_ENABLED=true时生效，AUTOSCALE_INTERVAL为评估间隔(秒)
+func StartAutoscaler() {
+	if os.Getenv("AUTOSCALE_ENABLED") != "true" {
+		return
+	}
+	interval, _ := strconv.Atoi(os.Getenv("AUTOSCALE_INTERVAL"))
+	if interval <= 0 {
+		interval = 60
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			evaluateScalingPolicies()
+		}
+	}()
+	slog.Info("autoscaler started", "interval", interval)
+}
+
+// evaluateScalingPolicies 持有全局锁时评估所有启用的策略，锁被其他实例持有时跳过本轮
+func evaluateScalingPolicies() {
+	lease, err := LockGlobal(autoscalerLock, "autoscaler@"+operationInstance, "evaluate scaling policies")
+	if err != nil {
+		var conflict *LockConflictError
+		if !errors.As(err, &conflict) {
+			slog.Error("failed to acquire autoscaler lock", "error", err)
+		}
+		return
+	}
+	defer lease.Release()
+	var policies []autoscale.ScalingPolicy
+	if err := config.DB.Where("enabled = ?", true).Find(&policies).Error; err != nil {
+		slog.Error("failed to load scaling policies", "error", err)
+		return
+	}
+	for i := range policies {
+		p := &policies[i]
+		if _, err := EvaluateScalingPolicy(p, true, "", ""); err != nil {
+			var frozen *FreezeError
+			if errors.As(err, &frozen) {
+				slog.Info("scaling skipped in freeze window", "policy_id", p.ID, "job_id", p.JobID, "group", p.Group, "error", err)
+				continue
+			}
+			slog.Error("failed to evaluate scaling policy", "policy_id", p.ID, "job_id", p.JobID, "group", p.Group, "error", err)
+			config.DB.Model(p).Updates(map[string]any{
+				"last_evaluated_at": time.Now(),
+				"last_decision":     truncate(strings.TrimSpace("error: "+err.Error()), 500),
+			})
+		}
+	}
+}
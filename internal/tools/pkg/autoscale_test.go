@@ -0,0 +1,71 @@
+package pkg_test
+
+import (
+	"saurfang/internal/models/autoscale"
+	"saurfang/internal/tools/pkg"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDecideScale 测试覆盖、指标规则、定时计划和冷却期的优先级
+func TestDecideScale(t *testing.T) {
+	now := time.Date(2025, 8, 1, 20, 30, 0, 0, time.Local)
+	p := &autoscale.ScalingPolicy{
+		Min:      1,
+		Max:      5,
+		Cooldown: 300,
+		Schedules: []autoscale.Schedule{
+			{Cron: "0 8 * * *", Count: 2},
+			{Cron: "0 20 * * *", Count: 4},
+		},
+		Rules: []autoscale.MetricRule{
+			{Metric: autoscale.MetricCPU, Operator: ">", Threshold: 80, Step: 2},
+			{Metric: autoscale.MetricCPU, Operator: "<", Threshold: 20, Step: -1},
+		},
+	}
+
+	count, spec, ok := pkg.ScheduledCount(p.Schedules, now)
+	assert.True(t, ok)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, "0 20 * * *", spec)
+
+	// 没有指标时使用定时计划
+	d := pkg.DecideScale(p, 2, nil, now)
+	assert.Equal(t, autoscale.SourceSchedule, d.Source)
+	assert.Equal(t, 4, d.Target)
+	assert.True(t, d.Scale)
+
+	// 扩容受max限制
+	d = pkg.DecideScale(p, 4, map[string]float64{autoscale.MetricCPU: 90}, now)
+	assert.Equal(t, autoscale.SourceMetric, d.Source)
+	assert.Equal(t, 5, d.Target)
+
+	// 缩容不低于当前定时计划
+	d = pkg.DecideScale(p, 4, map[string]float64{autoscale.MetricCPU: 10}, now)
+	assert.Equal(t, 4, d.Target)
+	assert.False(t, d.Scale)
+
+	// 冷却期内不伸缩
+	last := now.Add(-time.Minute)
+	p.LastScaleAt = &last
+	d = pkg.DecideScale(p, 2, nil, now)
+	assert.Equal(t, 4, d.Target)
+	assert.False(t, d.Scale)
+
+	// 覆盖不受冷却期限制
+	override, until := 1, now.Add(time.Hour)
+	p.OverrideCount, p.OverrideUntil = &override, &until
+	d = pkg.DecideScale(p, 4, map[string]float64{autoscale.MetricCPU: 90}, now)
+	assert.Equal(t, autoscale.SourceOverride, d.Source)
+	assert.Equal(t, 1, d.Target)
+	assert.True(t, d.Scale)
+
+	// 覆盖过期后恢复
+	d = pkg.DecideScale(p, 4, nil, now.Add(2*time.Hour))
+	assert.Equal(t, autoscale.SourceSchedule, d.Source)
+
+	_, _, ok = pkg.ScheduledCount([]autoscale.Schedule{{Cron: "0 0 1 1 *", Count: 1}}, now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"saurfang/internal/config"
+	"saurfang/internal/models/freeze"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/task"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// calendarMaxCronEvents 每个计划任务最多展开的次数，避免@every等高频任务撑爆日历
+const calendarMaxCronEvents = 200
+
+// CalendarEvent 日历中的一个事件
+type CalendarEvent struct {
+	UID         string
+	Start       time.Time
+	End         time.Time // 为空时为时间点
+	Summary     string
+	Description string
+	Category    string
+}
+
+// icsEscape 转义iCal文本
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsFold 按iCal规范将超过75字节的行折行，不拆分多字节字符
+func icsFold(line string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// BuildCalendar 生成iCal日历，事件按开始时间排序
+func BuildCalendar(name string, events []CalendarEvent, now time.Time) string {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	const layout = "20060102T150405Z"
+	var b strings.Builder
+	for _, line := range []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//saurfang//ops calendar//CN", "CALSCALE:GREGORIAN", "X-WR-CALNAME:" + icsEscape(name)} {
+		b.WriteString(icsFold(line))
+	}
+	for _, e := range events {
+		lines := []string{
+			"BEGIN:VEVENT",
+			"UID:" + e.UID,
+			"DTSTAMP:" + now.UTC().Format(layout),
+			"DTSTART:" + e.Start.UTC().Format(layout),
+		}
+		if !e.End.IsZero() {
+			lines = append(lines, "DTEND:"+e.End.UTC().Format(layout))
+		}
+		lines = append(lines, "SUMMARY:"+icsEscape(e.Summary))
+		if e.Description != "" {
+			lines = append(lines, "DESCRIPTION:"+icsEscape(e.Description))
+		}
+		if e.Category != "" {
+			lines = append(lines, "CATEGORIES:"+icsEscape(e.Category))
+		}
+		lines = append(lines, "END:VEVENT")
+		for _, line := range lines {
+			b.WriteString(icsFold(line))
+		}
+	}
+	b.WriteString(icsFold("END:VCALENDAR"))
+	return b.String()
+}
+
+// FreezeEvents 冻结窗口在[from, to)内的事件
+func FreezeEvents(windows []freeze.FreezeWindow, from, to time.Time) []CalendarEvent {
+	var events []CalendarEvent
+	for i := range windows {
+		w := &windows[i]
+		scope := w.Scope
+		switch w.Scope {
+		case freeze.ScopeChannel:
+			scope = fmt.Sprintf("channel %v", w.ChannelIDs)
+		case freeze.ScopeServer:
+			scope = fmt.Sprintf("server %s", strings.Join(w.ServerIDs, ","))
+		}
+		for _, p := range FreezePeriods(w, from, to) {
+			events = append(events, CalendarEvent{
+				UID:         fmt.Sprintf("freeze-%d-%d@saurfang", w.ID, p.Start.Unix()),
+				Start:       p.Start,
+				End:         p.End,
+				Summary:     "[freeze] " + w.Name,
+				Description: fmt.Sprintf("scope: %s\n%s", scope, w.Reason),
+				Category:    "freeze",
+			})
+		}
+	}
+	return events
+}
+
+// CronJobEvents 计划任务在[from, to)内的执行时间，loc为计划任务调度使用的时区
+func CronJobEvents(jobs []task.CronJobs, from, to time.Time, loc *time.Location) []CalendarEvent {
+	var events []CalendarEvent
+	for _, job := range jobs {
+		sched, err := cron.ParseStandard(job.Spec)
+		if err != nil {
+			continue
+		}
+		desc := fmt.Sprintf("type: %s\nspec: %s", job.TaskType, job.Spec)
+		if job.ServerOperation != "" {
+			desc += fmt.Sprintf("\noperation: %s\nservers: %s", job.ServerOperation, job.ServerIDs)
+		}
+		n := 0
+		for t := sched.Next(from.In(loc).Add(-time.Second)); !t.IsZero() && t.Before(to) && n < calendarMaxCronEvents; t = sched.Next(t) {
+			events = append(events, CalendarEvent{
+				UID:         fmt.Sprintf("cron-%d-%d@saurfang", job.ID, t.Unix()),
+				Start:       t,
+				Summary:     "[cron] " + job.TaskName,
+				Description: desc,
+				Category:    "cron",
+			})
+			n++
+		}
+	}
+	return events
+}
+
+// OpeningEvents 计划开服
+func OpeningEvents(games []gameserver.Games) []CalendarEvent {
+	events := make([]CalendarEvent, 0, len(games))
+	for _, g := range games {
+		if g.OpenAt == nil {
+			continue
+		}
+		events = append(events, CalendarEvent{
+			UID:         fmt.Sprintf("opening-%s@saurfang", g.ServerID),
+			Start:       *g.OpenAt,
+			Summary:     fmt.Sprintf("[opening] %s %s", g.ServerID, g.Name),
+			Description: "server " + g.ServerID + " opens",
+			Category:    "opening",
+		})
+	}
+	return events
+}
+
+// OpsCalendar 未来days天的冻结窗口、计划任务和计划开服
+func OpsCalendar(days int) (string, error) {
+	now := time.Now()
+	from, to := now.Add(-24*time.Hour), now.AddDate(0, 0, days)
+	var windows []freeze.FreezeWindow
+	if err := config.DB.Where("enabled = ? AND start_at < ? AND (end_at IS NULL OR end_at > ?)", true, to, from).Find(&windows).Error; err != nil {
+		return "", err
+	}
+	var jobs []task.CronJobs
+	if err := config.DB.Where("task_status = ?", 0).Find(&jobs).Error; err != nil {
+		return "", err
+	}
+	var games []gameserver.Games
+	if err := config.DB.Where("open_at BETWEEN ? AND ?", from, to).Find(&games).Error; err != nil {
+		return "", err
+	}
+	loc := time.Local
+	if config.SynqConfig.Location != "" {
+		if l, err := time.LoadLocation(config.SynqConfig.Location); err == nil {
+			loc = l
+		} else {
+			slog.Warn("invalid cron location, use local", "location", config.SynqConfig.Location, "error", err)
+		}
+	}
+	events := FreezeEvents(windows, from, to)
+	events = append(events, CronJobEvents(jobs, from, to, loc)...)
+	events = append(events, OpeningEvents(games)...)
+	return BuildCalendar("saurfang ops calendar", events, now), nil
+}
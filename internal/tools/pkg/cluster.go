@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"saurfang/internal/config"
+	"sort"
+)
+
+// ResolveCluster 游戏服设置了集群时使用游戏服的集群，否则使用渠道的集群，都为空时为默认集群
+func ResolveCluster(gameCluster, channelCluster string) string {
+	if gameCluster != "" {
+		return gameCluster
+	}
+	if channelCluster != "" {
+		return channelCluster
+	}
+	return config.DefaultCluster
+}
+
+// ClusterNamesOfServers 查询游戏服所属的集群名，不存在的游戏服属于默认集群
+func ClusterNamesOfServers(serverIDs []string) (map[string]string, error) {
+	var rows []struct {
+		ServerID       string
+		Cluster        string
+		ChannelCluster string
+	}
+	if len(serverIDs) > 0 {
+		if err := config.DB.Table("games as g").
+			Select("g.server_id, g.cluster, c.cluster as channel_cluster").
+			Joins("LEFT JOIN channels c ON c.id = g.channel_id").
+			Where("g.server_id IN ? AND g.deleted_at IS NULL", serverIDs).
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+	names := make(map[string]string, len(serverIDs))
+	for _, id := range serverIDs {
+		names[id] = config.DefaultCluster
+	}
+	for _, row := range rows {
+		names[row.ServerID] = ResolveCluster(row.Cluster, row.ChannelCluster)
+	}
+	return names, nil
+}
+
+// ClusterOfServer 游戏服所属的集群
+func ClusterOfServer(serverID string) (*config.Cluster, error) {
+	names, err := ClusterNamesOfServers([]string{serverID})
+	if err != nil {
+		return nil, err
+	}
+	return config.GetCluster(names[serverID])
+}
+
+// ClustersOfServers 查询多个游戏服所属的集群，集群不可用的游戏服返回对应错误
+func ClustersOfServers(serverIDs []string) (map[string]*config.Cluster, map[string]error, error) {
+	names, err := ClusterNamesOfServers(serverIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := make(map[string]*config.Cluster, len(serverIDs))
+	errs := make(map[string]error)
+	for id, name := range names {
+		c, err := config.GetCluster(name)
+		if err != nil {
+			errs[id] = err
+			continue
+		}
+		result[id] = c
+	}
+	return result, errs, nil
+}
+
+// ServersByCluster 按集群名对游戏服分组，names为游戏服到集群名的映射
+func ServersByCluster(names map[string]string) map[string][]string {
+	groups := make(map[string][]string)
+	for id, name := range names {
+		groups[name] = append(groups[name], id)
+	}
+	for name := range groups {
+		sort.Strings(groups[name])
+	}
+	return groups
+}
@@ -0,0 +1,27 @@
+package pkg_test
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResolveCluster 测试游戏服、渠道和默认集群的优先级
+func TestResolveCluster(t *testing.T) {
+	assert.Equal(t, "sea", pkg.ResolveCluster("sea", "eu"))
+	assert.Equal(t, "eu", pkg.ResolveCluster("", "eu"))
+	assert.Equal(t, config.DefaultCluster, pkg.ResolveCluster("", ""))
+}
+
+// TestGetCluster 测试未配置的集群
+func TestGetCluster(t *testing.T) {
+	c, err := config.GetCluster("")
+	assert.NoError(t, err)
+	assert.Equal(t, config.DefaultCluster, c.Name)
+	_, err = config.GetCluster("not-exist")
+	assert.Error(t, err)
+	assert.True(t, config.HasCluster(""))
+	assert.False(t, config.HasCluster("not-exist"))
+}
@@ -0,0 +1,334 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/autodeploy"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/models/task"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/ntfy"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+// customJobPrefix 自定义任务在consul中的前缀
+const customJobPrefix = "custom_job"
+
+// ConfigWatcher 使用consul blocking query监听配置变更并自动发布
+type ConfigWatcher struct {
+	prefixes []string
+	debounce time.Duration
+	limiter  *rate.Limiter
+	mu       sync.Mutex
+	timers   map[string]*time.Timer
+	indexes  map[string]uint64
+}
+
+// NewConfigWatcher 创建配置监听器
+// debounce: 同一个key在该时间内多次变更只处理最后一次
+// perMinute: 每分钟最多自动发布次数
+func NewConfigWatcher(prefixes []string, debounce time.Duration, perMinute int) *ConfigWatcher {
+	if perMinute <= 0 {
+		perMinute = 30
+	}
+	return &ConfigWatcher{
+		prefixes: prefixes,
+		debounce: debounce,
+		limiter:  rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), perMinute),
+		timers:   make(map[string]*time.Timer),
+		indexes:  make(map[string]uint64),
+	}
+}
+
+// StartConfigWatcher 按环境变量启动配置监听，CONFIG_WATCH_ENABLED=true时生效
+func StartConfigWatcher() {
+	if os.Getenv("CONFIG_WATCH_ENABLED") != "true" {
+		return
+	}
+	debounce, _ := strconv.Atoi(os.Getenv("CONFIG_WATCH_DEBOUNCE"))
+	if debounce <= 0 {
+		debounce = 10 // 默认10秒
+	}
+	perMinute, _ := strconv.Atoi(os.Getenv("CONFIG_WATCH_RATE"))
+	w := NewConfigWatcher([]string{os.Getenv("GAME_NOMAD_JOB_NAMESPACE"), customJobPrefix}, time.Duration(debounce)*time.Second, perMinute)
+	// 每个集群的consul分别监听
+	for _, cluster := range config.Clusters() {
+		if cluster.Err != nil {
+			continue
+		}
+		for _, prefix := range w.prefixes {
+			go w.watch(cluster, prefix)
+		}
+	}
+	slog.Info("config watcher started", "prefixes", w.prefixes, "debounce", debounce)
+}
+
+// watch 对集群中的单个前缀循环执行blocking query
+func (w *ConfigWatcher) watch(cluster *config.Cluster, prefix string) {
+	var waitIndex uint64
+	initialized := false
+	for {
+		pairs, meta, err := cluster.Consul().KV().List(prefix+"/", &consulapi.QueryOptions{
+			WaitIndex: waitIndex,
+			WaitTime:  5 * time.Minute,
+		})
+		if err != nil {
+			slog.Error("config watcher query failed", "cluster", cluster.Name, "prefix", prefix, "error", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		// index回退时(如consul重建)重新开始
+		if meta.LastIndex < waitIndex {
+			waitIndex = 0
+			continue
+		}
+		waitIndex = meta.LastIndex
+		changed := w.diff(cluster.Name, pairs)
+		// 第一次查询只建立快照
+		if !initialized {
+			initialized = true
+			continue
+		}
+		for _, pair := range changed {
+			w.schedule(cluster, prefix, pair.Key, pair.ModifyIndex)
+		}
+	}
+}
+
+// diff 对比快照，返回ModifyIndex发生变化的key，不同集群的快照分开记录
+func (w *ConfigWatcher) diff(cluster string, pairs consulapi.KVPairs) []*consulapi.KVPair {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var changed []*consulapi.KVPair
+	for _, pair := range pairs {
+		key := cluster + ":" + pair.Key
+		if idx, ok := w.indexes[key]; !ok || idx != pair.ModifyIndex {
+			changed = append(changed, pair)
+		}
+		w.indexes[key] = pair.ModifyIndex
+	}
+	return changed
+}
+
+// schedule 防抖，debounce时间内的多次变更只处理最后一次
+func (w *ConfigWatcher) schedule(cluster *config.Cluster, prefix, key string, modifyIndex uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	timerKey := cluster.Name + ":" + key
+	if t, ok := w.timers[timerKey]; ok {
+		t.Stop()
+	}
+	w.timers[timerKey] = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, timerKey)
+		w.mu.Unlock()
+		w.handle(cluster, prefix, key, modifyIndex)
+	})
+}
+
+// handle 处理单个变更的key
+func (w *ConfigWatcher) handle(cluster *config.Cluster, prefix, key string, modifyIndex uint64) {
+	record := autodeploy.AutoDeployRecord{
+		Key:         key,
+		ModifyIndex: modifyIndex,
+		Action:      autodeploy.ActionSkip,
+		Status:      autodeploy.StatusSkipped,
+	}
+	record.ServerID = tools.RemoveNamespace(key, prefix)
+	if prefix == customJobPrefix {
+		// 没有开启自动发布的自定义任务不做处理
+		if !customJobAutoDeploy(record.ServerID) {
+			return
+		}
+		w.handleCustomJob(cluster, &record)
+	} else {
+		var game gameserver.Games
+		if err := config.DB.Where("server_id = ?", record.ServerID).First(&game).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return
+			}
+			slog.Error("config watcher query game failed", "server_id", record.ServerID, "error", err)
+			return
+		}
+		// 没有开启自动发布的游戏服不做处理
+		if !game.AutoDeploy {
+			return
+		}
+		// 只处理游戏服所属集群中的配置
+		if names, err := ClusterNamesOfServers([]string{record.ServerID}); err != nil || names[record.ServerID] != cluster.Name {
+			return
+		}
+		w.handleGameJob(&record)
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		slog.Error("failed to save auto deploy record", "key", key, "error", err)
+	}
+	switch record.Status {
+	case autodeploy.StatusSuccess:
+		ntfy.PublishNotification(notify.EventTypeAutoDeploy, fmt.Sprintf("auto deploy %s", key), []string{record.ServerID}, nil, 1, 0)
+	case autodeploy.StatusFailed:
+		ntfy.PublishNotification(notify.EventTypeAutoDeploy, fmt.Sprintf("auto deploy %s", key), nil, []string{record.ServerID}, 0, 1)
+	}
+}
+
+// handleGameJob 重新注册游戏服job
+func (w *ConfigWatcher) handleGameJob(record *autodeploy.AutoDeployRecord) {
+	if ok, reason := w.allow(record.ServerID); !ok {
+		record.Message = reason
+		return
+	}
+	// 冻结期间不自动发布
+	if err := CheckFreeze([]string{record.ServerID}, "auto redeploy", "", ""); err != nil {
+		record.Message = err.Error()
+		return
+	}
+	// 游戏服正在被其他操作处理时跳过，避免并发修改同一个job
+	lease, err := LockServer(record.ServerID, "config-watcher", "auto redeploy")
+	if err != nil {
+		record.Message = err.Error()
+		return
+	}
+	defer lease.Release()
+	record.Action = autodeploy.ActionRedeploy
+	evalID, err := RegisterGameJob(record.ServerID)
+	if err != nil {
+		record.Status = autodeploy.StatusFailed
+		record.Message = err.Error()
+		return
+	}
+	record.Status = autodeploy.StatusSuccess
+	record.EvalID = evalID
+}
+
+// handleCustomJob 自定义任务只在对应job仍在运行时重新注册
+func (w *ConfigWatcher) handleCustomJob(cluster *config.Cluster, record *autodeploy.AutoDeployRecord) {
+	pair, _, err := cluster.Consul().KV().Get(record.Key, nil)
+	if err != nil || pair == nil {
+		record.Message = "key deleted or not readable"
+		return
+	}
+	job, err := cluster.Nomad().Jobs().ParseHCL(strings.ReplaceAll(string(pair.Value), "\r", ""), true)
+	if err != nil {
+		record.Status = autodeploy.StatusFailed
+		record.Message = fmt.Sprintf("failed to parse job hcl config file: %v", err)
+		return
+	}
+	current, _, err := cluster.Nomad().Jobs().Info(*job.ID, nil)
+	if err != nil || current.Status == nil || *current.Status == "dead" {
+		record.Message = "job is not running, skip"
+		return
+	}
+	if ok, reason := w.allow(record.ServerID); !ok {
+		record.Message = reason
+		return
+	}
+	if err := CheckFreeze([]string{*job.ID}, "auto redeploy", "", ""); err != nil {
+		record.Message = err.Error()
+		return
+	}
+	lease, err := LockServer(*job.ID, "config-watcher", "auto redeploy")
+	if err != nil {
+		record.Message = err.Error()
+		return
+	}
+	defer lease.Release()
+	record.Action = autodeploy.ActionRedeploy
+	res, _, err := cluster.Nomad().Jobs().Register(job, nil)
+	if err != nil {
+		record.Status = autodeploy.StatusFailed
+		record.Message = fmt.Sprintf("failed to register job: %v", err)
+		return
+	}
+	record.Status = autodeploy.StatusSuccess
+	record.EvalID = res.EvalID
+}
+
+// customJobAutoDeploy 自定义任务是否开启了自动发布，key为custom_job/{任务ID}_{主机}去掉前缀
+func customJobAutoDeploy(name string) bool {
+	id, _, ok := strings.Cut(name, "_")
+	if !ok {
+		return false
+	}
+	var enabled []bool
+	if err := config.DB.Model(&task.CustomTask{}).Where("id = ?", id).Pluck("auto_deploy", &enabled).Error; err != nil {
+		slog.Error("config watcher query custom task failed", "key", name, "error", err)
+		return false
+	}
+	return len(enabled) > 0 && enabled[0]
+}
+
+// allow 检查暂停状态和速率限制
+func (w *ConfigWatcher) allow(serverID string) (bool, string) {
+	if suspended, _ := IsAutoDeploySuspended(autodeploy.SuspendGlobal); suspended {
+		return false, "auto deploy suspended globally"
+	}
+	if serverID != "" {
+		if suspended, _ := IsAutoDeploySuspended(serverID); suspended {
+			return false, "auto deploy suspended for this server"
+		}
+	}
+	if !w.limiter.Allow() {
+		return false, "rate limited"
+	}
+	return true, ""
+}
+
+// SuspendAutoDeploy 暂停自动发布，target为global时全局暂停，d为0时不会自动恢复
+func SuspendAutoDeploy(target string, d time.Duration) error {
+	return config.CahceClient.Set(context.Background(), suspendKey(target), time.Now().Format(time.RFC3339), d).Err()
+}
+
+// ResumeAutoDeploy 恢复自动发布
+func ResumeAutoDeploy(target string) error {
+	return config.CahceClient.Del(context.Background(), suspendKey(target)).Err()
+}
+
+// IsAutoDeploySuspended 检查是否暂停了自动发布
+func IsAutoDeploySuspended(target string) (bool, error) {
+	n, err := config.CahceClient.Exists(context.Background(), suspendKey(target)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ListAutoDeploySuspensions 列出全部暂停状态
+func ListAutoDeploySuspensions() ([]autodeploy.Suspension, error) {
+	ctx := context.Background()
+	keys, err := config.CahceClient.Keys(ctx, fmt.Sprintf("%s:*", autodeploy.SuspendKeyPrefix)).Result()
+	if err != nil {
+		return nil, err
+	}
+	suspensions := make([]autodeploy.Suspension, 0, len(keys))
+	for _, key := range keys {
+		ttl, err := config.CahceClient.TTL(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		expiresIn := int64(-1)
+		if ttl > 0 {
+			expiresIn = int64(ttl.Seconds())
+		}
+		suspensions = append(suspensions, autodeploy.Suspension{
+			Target:    strings.TrimPrefix(key, autodeploy.SuspendKeyPrefix+":"),
+			ExpiresIn: expiresIn,
+		})
+	}
+	return suspensions, nil
+}
+
+func suspendKey(target string) string {
+	return fmt.Sprintf("%s:%s", autodeploy.SuspendKeyPrefix, target)
+}
@@ -122,3 +122,21 @@ func GetRoleOfUser(user_id uint) (uint, error) {
 	}
 	return ur.RoleID, nil
 }
+
+// UsernameOfUser 用户ID对应的用户名
+func UsernameOfUser(userID uint) (string, error) {
+	var u user.User
+	if err := config.DB.Select("username").Where("id = ?", userID).First(&u).Error; err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// UserIDOfUsername 用户名对应的用户ID
+func UserIDOfUsername(username string) (uint, error) {
+	var u user.User
+	if err := config.DB.Select("id").Where("username = ?", username).First(&u).Error; err != nil {
+		return 0, err
+	}
+	return u.ID, nil
+}
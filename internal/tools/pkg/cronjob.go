@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/gmcommand"
 	"saurfang/internal/models/notify"
 	"saurfang/internal/models/serverconfig"
 	"saurfang/internal/models/task"
@@ -43,7 +45,7 @@ func (s ScheduledJobProvider) GetConfigs() ([]*asynq.PeriodicTaskConfig, error)
 // getExtendedCronJobConfigs 获取扩展的 CronJobs 配置
 func (s ScheduledJobProvider) getExtendedCronJobConfigs() ([]*asynq.PeriodicTaskConfig, error) {
 	var jobs []task.CronJobs
-	if err := s.DB.Where("task_status = ? AND task_type IN (?, ?)", 0, task.TaskTypeCustom, task.TaskTypeServer).Find(&jobs).Error; err != nil {
+	if err := s.DB.Where("task_status = ? AND task_type IN (?, ?, ?)", 0, task.TaskTypeCustom, task.TaskTypeServer, task.TaskTypeGM).Find(&jobs).Error; err != nil {
 		return nil, err
 	}
 
@@ -65,6 +67,13 @@ func (s ScheduledJobProvider) getExtendedCronJobConfigs() ([]*asynq.PeriodicTask
 			if job.ServerIDs != "" {
 				payload["server_ids"] = strings.Split(job.ServerIDs, ",")
 				payload["server_operation"] = job.ServerOperation
+				payload["signal"] = job.Signal
+				payload["signal_task"] = job.SignalTask
+			}
+		case task.TaskTypeGM:
+			if job.GMTemplateID != nil {
+				payload["gm_template_id"] = *job.GMTemplateID
+				payload["server_ids"] = strings.Split(job.ServerIDs, ",")
 			}
 		}
 
@@ -134,6 +143,9 @@ func ServerOperationHandler(ctx context.Context, at *asynq.Task) error {
 		return fmt.Errorf("invalid server_operation in payload")
 	}
 
+	signal, _ := payload["signal"].(string)
+	signalTask, _ := payload["signal_task"].(string)
+
 	// 转换 serverIDs
 	var serverIDs []string
 	for _, id := range serverIDsInterface {
@@ -165,103 +177,54 @@ func ServerOperationHandler(ctx context.Context, at *asynq.Task) error {
 	// successCount := 0
 	// failedCount := 0
 	var errors []string
+	// 按游戏服所属集群执行
+	clusters, clusterErrs, err := ClustersOfServers(serverIDs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve cluster of servers: %v", err)
+	}
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	for _, serverID := range serverIDs {
 		wg.Add(1)
-		go func(serverID string, failedCount *int, successCount *int, errors *[]string, wg *sync.WaitGroup, mu *sync.Mutex, successJobs, failedJobs *[]string) {
-			//go func(serverID string, failedCount *int, successCount *int, errors *[]string, wg *sync.WaitGroup, mu *sync.Mutex) {
+		go func(serverID string) {
 			defer wg.Done()
-			// 从 Consul 获取服务器配置
-			configKey := tools.AddNamespace(serverID, os.Getenv("GAME_NOMAD_JOB_NAMESPACE"))
-			gameConfig, _, err := config.ConsulCli.KV().Get(configKey, nil)
-			if err != nil {
-				mu.Lock()
-				*errors = append(*errors, fmt.Sprintf("Failed to get config for server %s: %v", serverID, err))
-				*failedCount++
-				*failedJobs = append(*failedJobs, serverID)
-				mu.Unlock()
-				return
-			}
-			if gameConfig == nil || gameConfig.Value == nil {
-				mu.Lock()
-				*errors = append(*errors, fmt.Sprintf("No config found for server %s", serverID))
-				*failedCount++
-				*failedJobs = append(*failedJobs, serverID)
-				mu.Unlock()
-				return
-			}
-			// 将获取到的kv转换成结构体
-			gameConfigData := &serverconfig.GameConfig{
-				Key:     gameConfig.Key,
-				Setting: strings.ReplaceAll(string(gameConfig.Value), "\r", ""),
-			}
-			// 根据操作类型执行相应命令
-			switch serverOperation {
-			case task.ServerOpStart:
-				defer func() {
-					if r := recover(); r != nil {
-						slog.Error("panic in deploy nomad ops job", "panic", r)
-					}
-				}()
-				job, err := config.NomadCli.Jobs().ParseHCL(gameConfigData.Setting, true)
-				if err != nil {
-					mu.Lock()
-					*errors = append(*errors, fmt.Sprintf("Failed to parse job hcl config file: %v", err))
-					*failedCount++
-					*failedJobs = append(*failedJobs, serverID)
-					mu.Unlock()
-					return
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("panic in deploy nomad ops job", "panic", r)
 				}
-				_, _, err = config.NomadCli.Jobs().Register(job, nil)
+			}()
+			rec := NewGameOperation(serverID, serverOperation, gameserver.TriggerCron, cronJob.TaskName, 0)
+			var evalID string
+			// 计划任务不能强制变更，冻结期间记为失败
+			err := CheckFreeze([]string{serverID}, "cron "+serverOperation, "", "")
+			var lease *Lease
+			if err == nil {
+				lease, err = LockServer(serverID, "cron:"+cronJob.TaskName, serverOperation)
+			}
+			defer lease.Release()
+			if err == nil {
+				err = clusterErrs[serverID]
 				if err != nil {
-					mu.Lock()
-					*errors = append(*errors, fmt.Sprintf("Failed to register job: %v", err))
-					*failedCount++
-					*failedJobs = append(*failedJobs, serverID)
-					mu.Unlock()
-					return
+					err = fmt.Errorf("Failed to get cluster for server %s: %v", serverID, err)
+				} else {
+					evalID, err = runCronServerOperation(clusters[serverID], serverID, serverOperation, signalTask, signal)
 				}
-				mu.Lock()
-				*successCount++
-				*successJobs = append(*successJobs, serverID)
-				mu.Unlock()
-				slog.Info("start nomad ops job success", "server_id", serverID)
-				config.DB.Exec("UPDATE games set status = 1 where server_id = ?;", serverID)
+			}
+			if err == errUnsupportedServerOp {
 				return
-			case task.ServerOpStop:
-				defer func() {
-					if r := recover(); r != nil {
-						slog.Error("panic in deploy nomad ops job", "panic", r)
-					}
-				}()
-				job, err := config.NomadCli.Jobs().ParseHCL(gameConfigData.Setting, true)
-				if err != nil {
-					mu.Lock()
-					*errors = append(*errors, fmt.Sprintf("Failed to parse job hcl config file: %v", err))
-					*failedCount++
-					*failedJobs = append(*failedJobs, serverID)
-					mu.Unlock()
-					return
-				}
-				_, _, err = config.NomadCli.Jobs().Deregister(*job.ID, false, nil)
-				if err != nil {
-					mu.Lock()
-					*errors = append(*errors, fmt.Sprintf("Failed to register job: %v", err))
-					*failedCount++
-					*failedJobs = append(*failedJobs, serverID)
-					mu.Unlock()
-					return
-				}
-				mu.Lock()
-				*successCount++
-				*successJobs = append(*successJobs, serverID)
-				mu.Unlock()
-				slog.Info("stop nomad ops job success", "server_id", serverID)
-				config.DB.Exec("UPDATE games set status = 0 where server_id = ?;", serverID)
+			}
+			FinishGameOperation(rec, evalID, err)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errors = append(errors, err.Error())
+				failCount++
+				failedJobs = append(failedJobs, serverID)
 				return
 			}
-		}(serverID, &failCount, &successCount, &errors, &wg, &mu, &successJobs, &failedJobs)
+			successCount++
+			successJobs = append(successJobs, serverID)
+		}(serverID)
 	}
 	wg.Wait()
 	// 如果有错误，返回所有错误信息
@@ -272,3 +235,101 @@ func ServerOperationHandler(ctx context.Context, at *asynq.Task) error {
 
 	return nil
 }
+
+// errUnsupportedServerOp 计划任务中没有实现的操作，不执行也不记录
+var errUnsupportedServerOp = fmt.Errorf("unsupported server operation")
+
+// runCronServerOperation 从consul读取游戏服配置并执行计划任务中的操作，返回evalID
+func runCronServerOperation(cluster *config.Cluster, serverID, serverOperation, signalTask, signal string) (string, error) {
+	// 从 Consul 获取服务器配置
+	configKey := tools.AddNamespace(serverID, os.Getenv("GAME_NOMAD_JOB_NAMESPACE"))
+	gameConfig, _, err := cluster.Consul().KV().Get(configKey, nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get config for server %s: %v", serverID, err)
+	}
+	if gameConfig == nil || gameConfig.Value == nil {
+		return "", fmt.Errorf("No config found for server %s", serverID)
+	}
+	// 将获取到的kv转换成结构体
+	gameConfigData := &serverconfig.GameConfig{
+		Key:     gameConfig.Key,
+		Setting: strings.ReplaceAll(string(gameConfig.Value), "\r", ""),
+	}
+	switch serverOperation {
+	case task.ServerOpStart, task.ServerOpStop, task.ServerOpSignal:
+	default:
+		return "", errUnsupportedServerOp
+	}
+	job, err := cluster.Nomad().Jobs().ParseHCL(gameConfigData.Setting, true)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse job hcl config file: %v", err)
+	}
+	// 根据操作类型执行相应命令
+	switch serverOperation {
+	case task.ServerOpStart:
+		res, err := RegisterJobWithSource(cluster.Nomad(), job, gameConfigData.Setting)
+		if err != nil {
+			return "", fmt.Errorf("Failed to register job: %v", err)
+		}
+		slog.Info("start nomad ops job success", "server_id", serverID)
+		SetGameStatus(serverID, 1, "cron start")
+		return res.EvalID, nil
+	case task.ServerOpStop:
+		evalID, _, err := cluster.Nomad().Jobs().Deregister(*job.ID, false, nil)
+		if err != nil {
+			return "", fmt.Errorf("Failed to deregister job: %v", err)
+		}
+		slog.Info("stop nomad ops job success", "server_id", serverID)
+		SetGameStatus(serverID, 0, "cron stop")
+		return evalID, nil
+	default:
+		if _, err := SignalJobAllocations(cluster.Nomad(), *job.ID, signalTask, signal); err != nil {
+			return "", fmt.Errorf("Failed to send %s to server %s: %v", signal, serverID, err)
+		}
+		slog.Info("signal nomad job success", "server_id", serverID, "signal", signal)
+		return "", nil
+	}
+}
+
+// GMCommandCronHandler 定时执行GM命令
+func GMCommandCronHandler(ctx context.Context, at *asynq.Task) error {
+	var payload struct {
+		CronJobID    uint     `json:"cron_job_id"`
+		GMTemplateID uint     `json:"gm_template_id"`
+		ServerIDs    []string `json:"server_ids"`
+	}
+	if err := json.Unmarshal(at.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v", err)
+	}
+	defer func() {
+		if err := config.DB.Model(&task.CronJobs{}).Where("id = ?", payload.CronJobID).Update("last_execution", time.Now()).Error; err != nil {
+			log.Printf("Failed to update last_execution: %v", err)
+		}
+	}()
+	var cronJob task.CronJobs
+	if err := config.DB.First(&cronJob, payload.CronJobID).Error; err != nil {
+		return fmt.Errorf("cron job not found: %v", err)
+	}
+	var tpl gmcommand.GMTemplate
+	if err := config.DB.First(&tpl, payload.GMTemplateID).Error; err != nil {
+		return fmt.Errorf("gm template not found: %v", err)
+	}
+	// 创建人的权限被收回后不再执行
+	if tpl.Permission != "" && !UserHasPermission(cronJob.Operator, tpl.Permission) {
+		return fmt.Errorf("operator %s has no permission %s", cronJob.Operator, tpl.Permission)
+	}
+	params := make(map[string]string)
+	if cronJob.GMParams != "" {
+		if err := json.Unmarshal([]byte(cronJob.GMParams), &params); err != nil {
+			return fmt.Errorf("invalid gm params: %v", err)
+		}
+	}
+	execution, _, err := ExecuteGMCommand(&tpl, payload.ServerIDs, params, cronJob.Operator, gmcommand.TriggerCron)
+	if err != nil {
+		return err
+	}
+	if execution.Failed > 0 {
+		return fmt.Errorf("gm command %s failed on %d servers", tpl.Name, execution.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/crossserver"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"sort"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gorm.io/gorm"
+)
+
+// ErrSeasonNotActive 赛季已过期或已结束，不能再发布
+var ErrSeasonNotActive = errors.New("season is not active")
+
+// crossServerKVKey 跨服分组在consul中的key
+func crossServerKVKey() string {
+	if key := os.Getenv("CROSS_SERVER_KV_KEY"); key != "" {
+		return key
+	}
+	return "cross_server/groups"
+}
+
+// RebalanceCrossGroups 按开服时间排序后切分为连续的分组，使每组的服务器规模尽量接近
+// 开服时间相近的服务器进度相近，分在同一组；规模为0的服务器按1计算
+func RebalanceCrossGroups(servers []crossserver.ServerInfo, groupCount int) []crossserver.GroupServers {
+	n := len(servers)
+	if n == 0 || groupCount <= 0 {
+		return nil
+	}
+	groupCount = min(groupCount, n)
+	sorted := make([]crossserver.ServerInfo, n)
+	copy(sorted, servers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].OpenAt, sorted[j].OpenAt
+		switch {
+		case a == nil && b == nil:
+			return sorted[i].ServerID < sorted[j].ServerID
+		case a == nil:
+			return false
+		case b == nil:
+			return true
+		case !a.Equal(*b):
+			return a.Before(*b)
+		}
+		return sorted[i].ServerID < sorted[j].ServerID
+	})
+	weight := func(s crossserver.ServerInfo) float64 {
+		return float64(max(s.Population, 1))
+	}
+	var remaining float64
+	for _, s := range sorted {
+		remaining += weight(s)
+	}
+	groups := make([]crossserver.GroupServers, 0, groupCount)
+	idx := 0
+	for g := range groupCount {
+		left := groupCount - g
+		target := remaining / float64(left)
+		group := crossserver.GroupServers{Group: g + 1}
+		var sum float64
+		for idx < n {
+			if len(group.Servers) > 0 && g < groupCount-1 {
+				// 后面的每个分组至少保留一个服务器
+				if n-idx < left {
+					break
+				}
+				// 加入后离目标更远时停止
+				if sum+weight(sorted[idx])/2 > target {
+					break
+				}
+			}
+			group.Servers = append(group.Servers, sorted[idx].ServerID)
+			sum += weight(sorted[idx])
+			idx++
+		}
+		remaining -= sum
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// BuildCrossMapping 生成发布到consul的分组数据
+func BuildCrossMapping(season *crossserver.CrossSeason, rows []crossserver.CrossGroup) crossserver.Mapping {
+	mapping := crossserver.Mapping{
+		SeasonID:    season.ID,
+		Name:        season.Name,
+		EffectiveAt: season.EffectiveAt,
+		ExpireAt:    season.ExpireAt,
+		Groups:      []crossserver.GroupServers{},
+		Servers:     make(map[string]int, len(rows)),
+	}
+	if season.PublishedAt != nil {
+		mapping.PublishedAt = *season.PublishedAt
+	}
+	byGroup := make(map[int][]string)
+	for _, row := range rows {
+		byGroup[row.GroupNo] = append(byGroup[row.GroupNo], row.ServerID)
+		mapping.Servers[row.ServerID] = row.GroupNo
+	}
+	for no, servers := range byGroup {
+		sort.Strings(servers)
+		mapping.Groups = append(mapping.Groups, crossserver.GroupServers{Group: no, Servers: servers})
+	}
+	sort.Slice(mapping.Groups, func(i, j int) bool { return mapping.Groups[i].Group < mapping.Groups[j].Group })
+	return mapping
+}
+
+// PublishCrossSeason 发布赛季分组到所有集群的consul，之前发布的赛季标记为过期
+func PublishCrossSeason(seasonID uint, operator string) error {
+	var season crossserver.CrossSeason
+	if err := config.DB.First(&season, seasonID).Error; err != nil {
+		return err
+	}
+	if season.Status == crossserver.StatusExpired {
+		return fmt.Errorf("%w: season %d is expired", ErrSeasonNotActive, seasonID)
+	}
+	if season.ExpireAt != nil && !season.ExpireAt.After(time.Now()) {
+		return fmt.Errorf("%w: season %d ended at %s", ErrSeasonNotActive, seasonID, season.ExpireAt.Format(time.RFC3339))
+	}
+	var rows []crossserver.CrossGroup
+	if err := config.DB.Where("season_id = ?", seasonID).Find(&rows).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("season %d has no groups", seasonID)
+	}
+	now := time.Now()
+	season.PublishedAt = &now
+	mapping := BuildCrossMapping(&season, rows)
+	data, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	// 分组中的游戏服可能分布在不同集群，写入所有集群的consul，不包含游戏服的集群也覆盖掉之前赛季的分组
+	serverIDs := make([]string, 0, len(mapping.Servers))
+	for id := range mapping.Servers {
+		serverIDs = append(serverIDs, id)
+	}
+	clusters, clusterErrs, err := ClustersOfServers(serverIDs)
+	if err != nil {
+		return err
+	}
+	for id, err := range clusterErrs {
+		return fmt.Errorf("failed to publish cross server groups: server %s: %v", id, err)
+	}
+	required := make(map[string]bool)
+	for _, cluster := range clusters {
+		required[cluster.Name] = true
+	}
+	for _, cluster := range config.Clusters() {
+		consul := cluster.Consul()
+		if consul == nil {
+			if required[cluster.Name] {
+				return fmt.Errorf("failed to publish cross server groups: cluster %s has no consul client", cluster.Name)
+			}
+			continue
+		}
+		if _, err := consul.KV().Put(&consulapi.KVPair{Key: crossServerKVKey(), Value: data}, nil); err != nil {
+			return fmt.Errorf("failed to publish cross server groups to cluster %s: %v", cluster.Name, err)
+		}
+	}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&crossserver.CrossSeason{}).Where("status = ? AND id <> ?", crossserver.StatusPublished, seasonID).
+			Update("status", crossserver.StatusExpired).Error; err != nil {
+			return err
+		}
+		return tx.Model(&season).Updates(map[string]any{"status": crossserver.StatusPublished, "published_at": now}).Error
+	})
+	if err != nil {
+		return err
+	}
+	AuditCrossSeason(seasonID, crossserver.ActionPublish, operator, fmt.Sprintf("%d groups, %d servers", len(mapping.Groups), len(mapping.Servers)))
+	ntfy.PublishNotification(notify.EventTypeCrossServer, fmt.Sprintf("publish cross server season %s", season.Name), []string{season.Name}, nil, 1, 0)
+	return nil
+}
+
+// AuditCrossSeason 记录跨服分组操作
+func AuditCrossSeason(seasonID uint, action, operator, detail string) {
+	audit := crossserver.CrossGroupAudit{SeasonID: seasonID, Action: action, Operator: operator, Detail: detail}
+	if err := config.DB.Create(&audit).Error; err != nil {
+		slog.Error("failed to save cross server audit", "season_id", seasonID, "error", err)
+	}
+}
+
+// StartCrossGroupScheduler 定时发布到达生效时间的赛季
+func StartCrossGroupScheduler() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		var seasons []crossserver.CrossSeason
+		if err := config.DB.Where("status = ? AND effective_at <= ?", crossserver.StatusScheduled, time.Now()).
+			Order("effective_at").Find(&seasons).Error; err != nil {
+			slog.Error("failed to query scheduled cross server seasons", "error", err)
+			continue
+		}
+		for _, season := range seasons {
+			if err := PublishCrossSeason(season.ID, "scheduler"); err != nil {
+				slog.Error("failed to publish cross server season", "season_id", season.ID, "error", err)
+				// 发布失败退回草稿，避免每分钟重复发布和通知
+				config.DB.Model(&season).Update("status", crossserver.StatusDraft)
+				AuditCrossSeason(season.ID, crossserver.ActionPublish, "scheduler", fmt.Sprintf("failed: %v", err))
+				ntfy.PublishNotification(notify.EventTypeCrossServer, fmt.Sprintf("publish cross server season %s", season.Name), nil, []string{season.Name}, 0, 1)
+			}
+		}
+	}
+}
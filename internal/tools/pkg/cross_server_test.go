@@ -0,0 +1,57 @@
+package pkg_test
+
+import (
+	"saurfang/internal/models/crossserver"
+	"saurfang/internal/tools/pkg"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRebalanceCrossGroups 测试按开服时间连续分组并平衡规模
+func TestRebalanceCrossGroups(t *testing.T) {
+	day := func(d int) *time.Time {
+		v := time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC)
+		return &v
+	}
+	servers := []crossserver.ServerInfo{
+		{ServerID: "10004", OpenAt: day(4), Population: 100},
+		{ServerID: "10001", OpenAt: day(1), Population: 400},
+		{ServerID: "10003", OpenAt: day(3), Population: 100},
+		{ServerID: "10002", OpenAt: day(2), Population: 200},
+		{ServerID: "10005", Population: 0},
+	}
+	groups := pkg.RebalanceCrossGroups(servers, 2)
+	assert.Equal(t, []crossserver.GroupServers{
+		{Group: 1, Servers: []string{"10001"}},
+		{Group: 2, Servers: []string{"10002", "10003", "10004", "10005"}},
+	}, groups)
+
+	// 规模相同时平均分配
+	for i := range servers {
+		servers[i].Population = 0
+	}
+	groups = pkg.RebalanceCrossGroups(servers, 3)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, []string{"10001", "10002"}, groups[0].Servers)
+	assert.Equal(t, []string{"10005"}, groups[2].Servers)
+
+	assert.Len(t, pkg.RebalanceCrossGroups(servers[:2], 5), 2)
+	assert.Nil(t, pkg.RebalanceCrossGroups(nil, 2))
+}
+
+// TestBuildCrossMapping 测试发布到consul的分组格式
+func TestBuildCrossMapping(t *testing.T) {
+	season := &crossserver.CrossSeason{ID: 3, Name: "S3"}
+	mapping := pkg.BuildCrossMapping(season, []crossserver.CrossGroup{
+		{GroupNo: 2, ServerID: "10003"},
+		{GroupNo: 1, ServerID: "10002"},
+		{GroupNo: 1, ServerID: "10001"},
+	})
+	assert.Equal(t, []crossserver.GroupServers{
+		{Group: 1, Servers: []string{"10001", "10002"}},
+		{Group: 2, Servers: []string{"10003"}},
+	}, mapping.Groups)
+	assert.Equal(t, map[string]int{"10001": 1, "10002": 1, "10003": 2}, mapping.Servers)
+}
@@ -0,0 +1,498 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/datasource"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/models/task"
+	"saurfang/internal/models/upload"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/ntfy"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+const (
+	deployDispatchTimeout = 10 * time.Minute // 等待派发的发布任务完成
+	deployHealthGrace     = 15 * time.Second // 重启后等待健康检查更新
+	deployHealthInterval  = 10 * time.Second
+	// healthNotRestarted 重启的分配还没有重新启动，健康检查结果仍是重启前的
+	healthNotRestarted = "not restarted"
+)
+
+// PlanDeployBatches 按发布策略把游戏服分批，all策略为一批
+func PlanDeployBatches(serverIDs []string, strategy string, batchSize int) [][]string {
+	if len(serverIDs) == 0 {
+		return nil
+	}
+	if strategy == task.DeployStrategyAll {
+		return [][]string{serverIDs}
+	}
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	var batches [][]string
+	for start := 0; start < len(serverIDs); start += batchSize {
+		batches = append(batches, serverIDs[start:min(start+batchSize, len(serverIDs))])
+	}
+	return batches
+}
+
+// VerifyDeployPackage 校验安装包版本仍在数据源中：版本未废弃、上传记录属于该数据源、之后没有新的上传覆盖，
+// 上传内容属于登记的安装包构建，且存储中文件的校验和与上传时记录的一致，返回上传记录和存储中文件校验和的sha256
+func VerifyDeployPackage(recordID, datasourceID uint) (*upload.UploadRecord, string, error) {
+	var record upload.UploadRecord
+	if err := config.DB.First(&record, recordID).Error; err != nil {
+		return nil, "", fmt.Errorf("upload record %d not found: %v", recordID, err)
+	}
+	var ds datasource.Datasources
+	if err := config.DB.First(&ds, datasourceID).Error; err != nil {
+		return nil, "", fmt.Errorf("datasource %d not found: %v", datasourceID, err)
+	}
+	if record.PackageID != 0 {
+		var p upload.Package
+		if err := config.DB.First(&p, record.PackageID).Error; err != nil {
+			return nil, "", fmt.Errorf("package %d not found: %v", record.PackageID, err)
+		}
+		if p.Status == upload.PackageDeprecated {
+			return nil, "", fmt.Errorf("package version %s is deprecated", p.Version)
+		}
+		// 上传记录的内容必须是登记的构建
+		if p.ContentSum != "" && p.ContentSum != record.Checksum {
+			return nil, "", fmt.Errorf("%w: upload %s does not contain version %s (sha256 %s)", ErrPackageContentMismatch, record.GameServer, p.Version, p.SHA256)
+		}
+	}
+	if record.DestTarget != ds.Label {
+		return nil, "", fmt.Errorf("package %s was uploaded to %s, not %s", record.GameServer, record.DestTarget, ds.Label)
+	}
+	var newer int64
+	if err := config.DB.Model(&upload.UploadRecord{}).Where("dest_target = ? AND id > ?", record.DestTarget, record.ID).Count(&newer).Error; err != nil {
+		return nil, "", err
+	}
+	if newer > 0 {
+		return nil, "", fmt.Errorf("package %s in %s has been overwritten by %d newer upload(s)", record.GameServer, ds.Label, newer)
+	}
+	if record.Checksum == "" {
+		return nil, "", fmt.Errorf("package %s has no upload checksum, upload it again", record.GameServer)
+	}
+	sums, err := tools.OssChecksums(datasourceID, "")
+	if err != nil {
+		return nil, "", fmt.Errorf("list package in storage failed: %v", err)
+	}
+	if sums == "" {
+		return nil, "", fmt.Errorf("package %s not found in %s", record.GameServer, ds.Label)
+	}
+	sum := checksum(sums)
+	if sum != record.Checksum {
+		return nil, "", fmt.Errorf("package %s in %s was modified after upload: checksum %s, uploaded %s", record.GameServer, ds.Label, sum, record.Checksum)
+	}
+	return &record, sum, nil
+}
+
+// dispatchDeployJob 从游戏服所属集群读取发布任务配置，注册并派发，返回nomad客户端、evalID和派发的job
+func dispatchDeployJob(serverID string) (*nomadapi.Client, string, string, error) {
+	cluster, err := ClusterOfServer(serverID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	pair, _, err := cluster.Consul().KV().Get(tools.AddNamespace(serverID, os.Getenv("GAME_NOMAD_DEPLOY_NAMESPACE")), nil)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("search deploy config failed: %v", err)
+	}
+	if pair == nil {
+		return nil, "", "", errors.New("deploy config not found")
+	}
+	client := cluster.Nomad()
+	job, err := client.Jobs().ParseHCL(strings.ReplaceAll(string(pair.Value), "\r", ""), true)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("parse deploy job failed: %v", err)
+	}
+	if _, _, err := client.Jobs().Register(job, nil); err != nil {
+		return nil, "", "", fmt.Errorf("register deploy job failed: %v", err)
+	}
+	meta := map[string]string{"EXEC_TIME": time.Now().String()}
+	res, _, err := client.Jobs().Dispatch(*job.ID, meta, []byte(time.Now().String()), "", nil)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("dispatch deploy job failed: %v", err)
+	}
+	return client, res.EvalID, res.DispatchedJobID, nil
+}
+
+// waitDispatchedJob 等待eval完成且派发的job所有分配成功结束
+func waitDispatchedJob(ctx context.Context, client *nomadapi.Client, evalID, jobID string) error {
+	wake := NomadEventWakeups(ctx, func(e *nomadjob.NomadEvent) bool {
+		return e.EvalID == evalID || e.JobID == jobID
+	}, 2*time.Second)
+	for {
+		eval, _, err := client.Evaluations().Info(evalID, nil)
+		if err != nil {
+			return err
+		}
+		switch eval.Status {
+		case "failed", "cancelled":
+			return fmt.Errorf("eval %s %s: %s", evalID, eval.Status, eval.StatusDescription)
+		case "complete":
+			summary, _, err := client.Jobs().Summary(jobID, nil)
+			if err != nil {
+				return err
+			}
+			var pending, complete, failed int
+			for _, s := range summary.Summary {
+				pending += s.Queued + s.Starting + s.Running
+				complete += s.Complete
+				failed += s.Failed + s.Lost
+			}
+			if failed > 0 {
+				return fmt.Errorf("dispatched job %s failed", jobID)
+			}
+			if pending == 0 && complete > 0 {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for dispatched job %s", jobID)
+		case <-wake:
+		}
+	}
+}
+
+// restartGameServer 重启游戏服运行中的分配，返回重启的分配和发起重启的时间
+func restartGameServer(serverID string) (map[string]time.Time, error) {
+	cluster, err := ClusterOfServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+	client := cluster.Nomad()
+	stubs, _, err := client.Jobs().Allocations(serverID, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	restarted := make(map[string]time.Time)
+	for _, stub := range stubs {
+		if stub.ClientStatus != nomadapi.AllocClientStatusRunning {
+			continue
+		}
+		alloc, _, err := client.Allocations().Info(stub.ID, nil)
+		if err != nil {
+			return restarted, err
+		}
+		at := time.Now()
+		if err := client.Allocations().RestartAllTasks(alloc, nil); err != nil {
+			return restarted, err
+		}
+		restarted[stub.ID] = at
+	}
+	return restarted, nil
+}
+
+// allocationsRestarted 重启的分配中运行的任务都在发起重启之后重新启动
+func allocationsRestarted(serverID string, allocs map[string]time.Time) (bool, error) {
+	cluster, err := ClusterOfServer(serverID)
+	if err != nil {
+		return false, err
+	}
+	for id, at := range allocs {
+		alloc, _, err := cluster.Nomad().Allocations().Info(id, nil)
+		if err != nil {
+			return false, err
+		}
+		running := false
+		for _, ts := range alloc.TaskStates {
+			if ts.State != "running" {
+				continue
+			}
+			if ts.StartedAt.Before(at) {
+				return false, nil
+			}
+			running = true
+		}
+		if !running {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// serversHealth 游戏服当前的汇总健康状态
+func serversHealth(serverIDs []string) (map[string]string, error) {
+	data, err := CollectServiceHealth()
+	if data == nil {
+		return nil, err
+	}
+	if err != nil {
+		// 失败集群中的游戏服状态为unknown，继续等待
+		slog.Warn("failed to collect service health of some clusters", "error", err)
+	}
+	health := make(map[string]string, len(serverIDs))
+	for _, id := range serverIDs {
+		health[id] = GameServerHealth(data[id])
+	}
+	return health, nil
+}
+
+// waitServersHealthy 等待重启的游戏服重新启动且健康检查通过，超时返回最后一次的状态
+// 重新启动之前和之后一个缓存周期内的健康检查结果可能是重启前的，不作为通过的依据
+func waitServersHealthy(restarts map[string]map[string]time.Time, timeout time.Duration) map[string]string {
+	deadline := time.Now().Add(timeout)
+	time.Sleep(deployHealthGrace)
+	serverIDs := make([]string, 0, len(restarts))
+	for id := range restarts {
+		serverIDs = append(serverIDs, id)
+	}
+	startedAt := make(map[string]time.Time, len(restarts))
+	health := make(map[string]string, len(serverIDs))
+	for {
+		for _, id := range serverIDs {
+			if _, ok := startedAt[id]; ok {
+				continue
+			}
+			started, err := allocationsRestarted(id, restarts[id])
+			if err != nil {
+				slog.Warn("check restarted allocations failed", "server_id", id, "error", err)
+			}
+			if started {
+				startedAt[id] = time.Now()
+			}
+		}
+		current, err := serversHealth(serverIDs)
+		passing := err == nil
+		if err != nil {
+			slog.Warn("collect service health failed", "error", err)
+		}
+		for _, id := range serverIDs {
+			at, ok := startedAt[id]
+			switch {
+			case !ok:
+				health[id] = healthNotRestarted
+			case time.Since(at) < serviceHealthTTL:
+				health[id] = gameserver.HealthUnknown
+			default:
+				health[id] = current[id]
+			}
+			if health[id] != gameserver.HealthPassing {
+				passing = false
+			}
+		}
+		if passing || time.Now().After(deadline) {
+			return health
+		}
+		time.Sleep(deployHealthInterval)
+	}
+}
+
+// deployRunState 一次发布执行中各游戏服的结果
+type deployRunState struct {
+	mu      sync.Mutex
+	results map[string]*task.DeploymentRunResult
+	records map[string]*gameserver.GameOperation
+	run     *OperationRun
+}
+
+// fail 游戏服在某个阶段失败，不再进入后续阶段
+func (s *deployRunState) fail(serverID, stage string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.results[serverID]
+	res.Stage, res.Success, res.Error = stage, false, err.Error()
+	config.DB.Save(res)
+	FinishGameOperation(s.records[serverID], res.EvalID, err)
+	s.run.Log(fmt.Sprintf("[X] %s %s failed: %v", serverID, stage, err))
+}
+
+// succeed 游戏服完成所有阶段
+func (s *deployRunState) succeed(serverID, health string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.results[serverID]
+	res.Stage, res.Success, res.Health = task.DeployStageDone, true, health
+	config.DB.Save(res)
+	FinishGameOperation(s.records[serverID], res.EvalID, nil)
+	s.run.Log(fmt.Sprintf("[√] %s deployed, health: %s", serverID, health))
+}
+
+// ExecuteDeployTask 执行发布任务：校验安装包、派发发布任务并等待完成、分批重启并检查健康，日志写入run，结束时run.Finish
+func ExecuteDeployTask(t *task.GameDeploymentTask, serverIDs []string, operator, trigger string, run *OperationRun) *task.DeploymentRun {
+	now := time.Now()
+	config.DB.Model(t).Updates(map[string]any{"last_execution": now, "last_user": operator})
+	dr := &task.DeploymentRun{
+		TaskID:         t.ID,
+		UploadRecordID: t.UploadRecordID,
+		Strategy:       t.Strategy,
+		Operator:       operator,
+		OperationID:    run.Op.ID,
+		Status:         task.DeployRunRunning,
+		Stage:          task.DeployStageVerify,
+	}
+	if err := config.DB.Create(dr).Error; err != nil {
+		slog.Error("failed to save deployment run", "task_id", t.ID, "error", err)
+	}
+	state := &deployRunState{
+		results: make(map[string]*task.DeploymentRunResult, len(serverIDs)),
+		records: make(map[string]*gameserver.GameOperation, len(serverIDs)),
+		run:     run,
+	}
+	results := make([]task.DeploymentRunResult, len(serverIDs))
+	for i, id := range serverIDs {
+		results[i] = task.DeploymentRunResult{RunID: dr.ID, ServerID: id, Stage: task.DeployStageVerify}
+		state.results[id] = &results[i]
+		state.records[id] = NewGameOperation(id, "deploy", trigger, operator, run.Op.ID)
+	}
+	if err := config.DB.Create(&results).Error; err != nil {
+		slog.Error("failed to save deployment run results", "run_id", dr.ID, "error", err)
+	}
+	var packageID uint
+	stage := func(s string) {
+		dr.Stage = s
+		config.DB.Model(dr).Update("stage", s)
+		run.Log(fmt.Sprintf("==> %s", s))
+	}
+	defer func() {
+		var success, failed []string
+		for _, id := range serverIDs {
+			if state.results[id].Success {
+				success = append(success, id)
+			} else {
+				failed = append(failed, id)
+			}
+		}
+		finished := time.Now()
+		dr.FinishedAt = &finished
+		dr.Status = task.DeployRunSuccess
+		if len(failed) > 0 || dr.Error != "" {
+			dr.Status = task.DeployRunFailed
+		}
+		config.DB.Save(dr)
+		markPackageDeployed(packageID, success)
+		run.Finish(success, failed)
+		ntfy.PublishNotification(notify.EventTypeGameDeploy, fmt.Sprintf("deploy task %d %s", t.ID, dr.Package), success, failed, len(success), len(failed))
+	}()
+
+	stage(task.DeployStageVerify)
+	record, checksum, err := VerifyDeployPackage(t.UploadRecordID, t.DatasourceID)
+	if err != nil {
+		dr.Error = err.Error()
+		for _, id := range serverIDs {
+			state.fail(id, task.DeployStageVerify, err)
+		}
+		return dr
+	}
+	dr.Package, dr.Checksum, packageID = record.GameServer, checksum, record.PackageID
+	config.DB.Model(dr).Updates(map[string]any{"package": dr.Package, "checksum": checksum})
+	run.Log(fmt.Sprintf("[√] package %s verified, checksum %s", record.GameServer, checksum))
+
+	// 派发所有游戏服的发布任务，成功完成的进入重启
+	stage(task.DeployStageDispatch)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var dispatched []string
+	for _, id := range serverIDs {
+		wg.Add(1)
+		go func(serverID string) {
+			defer wg.Done()
+			client, evalID, jobID, err := dispatchDeployJob(serverID)
+			if err != nil {
+				state.fail(serverID, task.DeployStageDispatch, err)
+				return
+			}
+			state.mu.Lock()
+			state.results[serverID].EvalID, state.results[serverID].DispatchedJob = evalID, jobID
+			state.mu.Unlock()
+			run.Log(fmt.Sprintf("[√] %s dispatched %s, evalID: %s", serverID, jobID, evalID))
+			ctx, cancel := context.WithTimeout(context.Background(), deployDispatchTimeout)
+			defer cancel()
+			if err := waitDispatchedJob(ctx, client, evalID, jobID); err != nil {
+				state.fail(serverID, task.DeployStageDispatch, err)
+				return
+			}
+			run.Log(fmt.Sprintf("[√] %s deploy job completed", serverID))
+			mu.Lock()
+			dispatched = append(dispatched, serverID)
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	// 保持目标顺序分批
+	var ready []string
+	for _, id := range serverIDs {
+		if slices.Contains(dispatched, id) {
+			ready = append(ready, id)
+		}
+	}
+
+	stage(task.DeployStageRestart)
+	timeout := time.Duration(t.HealthTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	batches := PlanDeployBatches(ready, t.Strategy, t.BatchSize)
+	for i, batch := range batches {
+		run.Log(fmt.Sprintf("==> batch %d/%d: %s", i+1, len(batches), strings.Join(batch, ",")))
+		// 重启前没有健康检查的游戏服不等待健康检查
+		before, err := serversHealth(batch)
+		if err != nil {
+			run.Log(fmt.Sprintf("[!] collect service health failed: %v", err))
+		}
+		checked := make(map[string]map[string]time.Time)
+		batchFailed := false
+		for _, serverID := range batch {
+			restarted, err := restartGameServer(serverID)
+			if err != nil {
+				state.fail(serverID, task.DeployStageRestart, err)
+				batchFailed = true
+				continue
+			}
+			n := len(restarted)
+			if n == 0 {
+				run.Log(fmt.Sprintf("[!] %s is not running, skip restart", serverID))
+				state.succeed(serverID, gameserver.HealthUnknown)
+				continue
+			}
+			run.Log(fmt.Sprintf("[√] %s restarted %d allocation(s)", serverID, n))
+			if h, ok := before[serverID]; !ok || h == gameserver.HealthUnknown {
+				state.succeed(serverID, gameserver.HealthUnknown)
+				continue
+			}
+			checked[serverID] = restarted
+		}
+		if len(checked) > 0 {
+			health := waitServersHealthy(checked, timeout)
+			for _, serverID := range batch {
+				if _, ok := checked[serverID]; !ok {
+					continue
+				}
+				if health[serverID] != gameserver.HealthPassing {
+					state.fail(serverID, task.DeployStageHealth, fmt.Errorf("health is %s after %s", health[serverID], timeout))
+					batchFailed = true
+					continue
+				}
+				state.succeed(serverID, health[serverID])
+			}
+		}
+		if batchFailed && t.Strategy == task.DeployStrategyRolling && i < len(batches)-1 {
+			dr.Error = fmt.Sprintf("batch %d failed, remaining batches skipped", i+1)
+			for _, rest := range batches[i+1:] {
+				for _, serverID := range rest {
+					state.fail(serverID, task.DeployStageRestart, errors.New("skipped after failed batch"))
+				}
+			}
+			return dr
+		}
+		if i < len(batches)-1 && t.BatchInterval > 0 {
+			time.Sleep(time.Duration(t.BatchInterval) * time.Second)
+		}
+	}
+	stage(task.DeployStageDone)
+	return dr
+}
@@ -0,0 +1,59 @@
+package pkg_test
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/models/task"
+	"saurfang/internal/testutils"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPlanDeployBatches 测试按发布策略分批
+func TestPlanDeployBatches(t *testing.T) {
+	servers := []string{"1", "2", "3", "4", "5"}
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}, pkg.PlanDeployBatches(servers, task.DeployStrategyRolling, 2))
+	assert.Equal(t, [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"5"}}, pkg.PlanDeployBatches(servers, task.DeployStrategyRolling, 0))
+	assert.Equal(t, [][]string{servers}, pkg.PlanDeployBatches(servers, task.DeployStrategyAll, 2))
+	assert.Nil(t, pkg.PlanDeployBatches(nil, task.DeployStrategyRolling, 2))
+}
+
+// TestVerifyDeployPackageOverwritten 测试安装包被之后的上传覆盖时拒绝发布
+func TestVerifyDeployPackageOverwritten(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `upload_records`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_server", "dest_target"}).AddRow(3, "server-1.0.zip", "oss-prod"))
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `datasources`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "label"}).AddRow(1, "oss-prod"))
+	mockDB.Mock.ExpectQuery("SELECT count\\(\\*\\) FROM `upload_records`").
+		WithArgs("oss-prod", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	_, _, err := pkg.VerifyDeployPackage(3, 1)
+	assert.ErrorContains(t, err, "overwritten by 1 newer upload(s)")
+	mockDB.ExpectationsWereMet(t)
+}
+
+// TestVerifyDeployPackageWithoutChecksum 测试没有上传校验和的记录拒绝发布
+func TestVerifyDeployPackageWithoutChecksum(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `upload_records`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "game_server", "dest_target"}).AddRow(3, "server-1.0.zip", "oss-prod"))
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `datasources`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "label"}).AddRow(1, "oss-prod"))
+	mockDB.Mock.ExpectQuery("SELECT count\\(\\*\\) FROM `upload_records`").
+		WithArgs("oss-prod", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	_, _, err := pkg.VerifyDeployPackage(3, 1)
+	assert.ErrorContains(t, err, "has no upload checksum")
+	mockDB.ExpectationsWereMet(t)
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"sort"
+	"strings"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// deploymentPollInterval 查询deployment状态的间隔
+const deploymentPollInterval = 3 * time.Second
+
+// DeploymentTerminal deployment是否已结束
+func DeploymentTerminal(status string) bool {
+	switch status {
+	case nomadapi.DeploymentStatusSuccessful, nomadapi.DeploymentStatusFailed, nomadapi.DeploymentStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// DeploymentNeedsPromotion 金丝雀已全部健康但未提升，需要手动promote
+func DeploymentNeedsPromotion(d *nomadapi.Deployment) bool {
+	if d.Status != nomadapi.DeploymentStatusRunning {
+		return false
+	}
+	need := false
+	for _, state := range d.TaskGroups {
+		if state.DesiredCanaries == 0 || state.Promoted {
+			continue
+		}
+		if len(state.PlacedCanaries) < state.DesiredCanaries || state.HealthyAllocs < state.DesiredCanaries {
+			return false
+		}
+		need = true
+	}
+	return need
+}
+
+// DeploymentSummary deployment各任务组的进度，按任务组名排序
+func DeploymentSummary(d *nomadapi.Deployment) string {
+	groups := make([]string, 0, len(d.TaskGroups))
+	for name := range d.TaskGroups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	parts := make([]string, 0, len(groups))
+	for _, name := range groups {
+		s := d.TaskGroups[name]
+		part := fmt.Sprintf("%s placed %d/%d healthy %d unhealthy %d", name, s.PlacedAllocs, s.DesiredTotal, s.HealthyAllocs, s.UnhealthyAllocs)
+		if s.DesiredCanaries > 0 {
+			part += fmt.Sprintf(" canaries %d/%d promoted %t", len(s.PlacedCanaries), s.DesiredCanaries, s.Promoted)
+		}
+		parts = append(parts, part)
+	}
+	id := d.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return fmt.Sprintf("deployment %s %s (v%d): %s", id, d.Status, d.JobVersion, strings.Join(parts, "; "))
+}
+
+// EvalDeployment 等待eval调度完成并返回对应的deployment，job没有update配置时没有deployment，返回空字符串
+func EvalDeployment(ctx context.Context, client *nomadapi.Client, evalID string) (string, error) {
+	wake := NomadEventWakeups(ctx, func(e *nomadjob.NomadEvent) bool {
+		return e.EvalID == evalID
+	}, time.Second)
+	for {
+		eval, _, err := client.Evaluations().Info(evalID, nil)
+		if err != nil {
+			return "", err
+		}
+		if eval.DeploymentID != "" {
+			return eval.DeploymentID, nil
+		}
+		switch eval.Status {
+		case "complete", "failed", "cancelled", "blocked":
+			return "", nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// WatchDeployment 跟踪deployment直到结束，状态或进度变化时调用onUpdate，超时返回最后一次查询的结果和错误
+// 跟踪期间事件总线不再发送该deployment的失败通知，由调用方处理
+func WatchDeployment(ctx context.Context, client *nomadapi.Client, deploymentID string, onUpdate func(*nomadapi.Deployment)) (*nomadapi.Deployment, error) {
+	watchedDeployments.Store(deploymentID, struct{}{})
+	defer time.AfterFunc(time.Minute, func() { watchedDeployments.Delete(deploymentID) })
+	wake := NomadEventWakeups(ctx, func(e *nomadjob.NomadEvent) bool {
+		return e.DeploymentID == deploymentID
+	}, deploymentPollInterval)
+	var last *nomadapi.Deployment
+	var lastSummary string
+	for {
+		d, _, err := client.Deployments().Info(deploymentID, nil)
+		if err != nil {
+			return last, err
+		}
+		last = d
+		if summary := DeploymentSummary(d); summary != lastSummary {
+			lastSummary = summary
+			onUpdate(d)
+		}
+		if DeploymentTerminal(d.Status) {
+			return d, nil
+		}
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// ServerDeployment 查询deployment并校验属于该游戏服job
+func ServerDeployment(client *nomadapi.Client, serverID, deploymentID string) (*nomadapi.Deployment, error) {
+	d, _, err := client.Deployments().Info(deploymentID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.JobID != serverID {
+		return nil, fmt.Errorf("deployment %s does not belong to %s", deploymentID, serverID)
+	}
+	return d, nil
+}
+
+// LastStableVersion 早于指定版本的最近一个稳定版本
+func LastStableVersion(client *nomadapi.Client, jobID string, before uint64) (uint64, error) {
+	versions, _, _, err := client.Jobs().Versions(jobID, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range versions {
+		if v.Version == nil || *v.Version >= before {
+			continue
+		}
+		if v.Stable != nil && *v.Stable {
+			return *v.Version, nil
+		}
+	}
+	return 0, errors.New("no stable version found")
+}
+
+// HandleFailedDeployment deployment失败时发送通知，autoRevert为true时回滚到上一个稳定版本
+// 回滚不写回consul配置，返回说明信息
+func HandleFailedDeployment(cluster *config.Cluster, serverID string, d *nomadapi.Deployment, autoRevert bool) string {
+	name := fmt.Sprintf("deployment of %s v%d %s: %s", serverID, d.JobVersion, d.Status, d.StatusDescription)
+	if !autoRevert || d.Status != nomadapi.DeploymentStatusFailed {
+		ntfy.PublishNotification(notify.EventTypeDeployment, name, nil, []string{serverID}, 0, 1)
+		return name
+	}
+	rec := NewGameOperation(serverID, "revert", gameserver.TriggerSystem, "auto revert", 0)
+	var evalID string
+	version, err := LastStableVersion(cluster.Nomad(), serverID, d.JobVersion)
+	if err == nil {
+		evalID, err = RevertGameJob(cluster, serverID, version, false)
+	}
+	FinishGameOperation(rec, evalID, err)
+	if err != nil {
+		slog.Error("failed to auto revert deployment", "server_id", serverID, "deployment_id", d.ID, "error", err)
+		name = fmt.Sprintf("%s, auto revert failed: %v", name, err)
+	} else {
+		name = fmt.Sprintf("%s, reverted to v%d", name, version)
+	}
+	ntfy.PublishNotification(notify.EventTypeDeployment, name, nil, []string{serverID}, 0, 1)
+	return name
+}
@@ -0,0 +1,31 @@
+package pkg_test
+
+import (
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeploymentNeedsPromotion 测试金丝雀提升判断和进度汇总
+func TestDeploymentNeedsPromotion(t *testing.T) {
+	d := &nomadapi.Deployment{
+		ID:         "0123456789abcdef",
+		Status:     nomadapi.DeploymentStatusRunning,
+		JobVersion: 3,
+		TaskGroups: map[string]*nomadapi.DeploymentState{
+			"game": {DesiredCanaries: 1, DesiredTotal: 2, PlacedCanaries: []string{"a1"}, PlacedAllocs: 1, HealthyAllocs: 0},
+		},
+	}
+	assert.False(t, pkg.DeploymentNeedsPromotion(d))
+	d.TaskGroups["game"].HealthyAllocs = 1
+	assert.True(t, pkg.DeploymentNeedsPromotion(d))
+	assert.Equal(t, "deployment 01234567 running (v3): game placed 1/2 healthy 1 unhealthy 0 canaries 1/1 promoted false", pkg.DeploymentSummary(d))
+
+	d.TaskGroups["game"].Promoted = true
+	assert.False(t, pkg.DeploymentNeedsPromotion(d))
+	d.Status = nomadapi.DeploymentStatusFailed
+	assert.True(t, pkg.DeploymentTerminal(d.Status))
+	assert.False(t, pkg.DeploymentTerminal(nomadapi.DeploymentStatusPaused))
+}
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"saurfang/internal/config"
+	"saurfang/internal/models/freeze"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/robfig/cron/v3"
+)
+
+// freezeMaxPeriods 展开重复规则时的最大次数
+const freezeMaxPeriods = 1000
+
+// FreezeError 变更被冻结窗口拒绝
+type FreezeError struct {
+	Window freeze.FreezeWindow
+	Until  time.Time
+}
+
+func (e *FreezeError) Error() string {
+	msg := fmt.Sprintf("changes are frozen by %s until %s", e.Window.Name, e.Until.Format(time.DateTime))
+	if e.Window.Reason != "" {
+		msg += ": " + e.Window.Reason
+	}
+	return msg
+}
+
+// ValidateFreezeWindow 校验冻结窗口参数
+func ValidateFreezeWindow(w *freeze.FreezeWindow) error {
+	if w.Name == "" {
+		return errors.New("name is required")
+	}
+	switch w.Scope {
+	case freeze.ScopeGlobal:
+	case freeze.ScopeChannel:
+		if len(w.ChannelIDs) == 0 {
+			return errors.New("channel_ids is required for channel scope")
+		}
+	case freeze.ScopeServer:
+		if len(w.ServerIDs) == 0 {
+			return errors.New("server_ids is required for server scope")
+		}
+	default:
+		return fmt.Errorf("invalid scope %s", w.Scope)
+	}
+	if w.StartAt.IsZero() {
+		return errors.New("start_at is required")
+	}
+	if w.EndAt != nil && !w.EndAt.After(w.StartAt) {
+		return errors.New("end_at must be after start_at")
+	}
+	if w.Cron == "" {
+		if w.EndAt == nil {
+			return errors.New("end_at is required for one-off window")
+		}
+		return nil
+	}
+	if _, err := cron.ParseStandard(w.Cron); err != nil {
+		return fmt.Errorf("invalid cron %s: %v", w.Cron, err)
+	}
+	if w.Duration <= 0 {
+		return errors.New("duration must be positive for recurring window")
+	}
+	return nil
+}
+
+// FreezePeriods 冻结窗口在[from, to)内的冻结时间段
+func FreezePeriods(w *freeze.FreezeWindow, from, to time.Time) []freeze.Period {
+	if w.Cron == "" {
+		if w.EndAt == nil || !w.StartAt.Before(to) || !w.EndAt.After(from) {
+			return nil
+		}
+		return []freeze.Period{{Start: w.StartAt, End: *w.EndAt}}
+	}
+	sched, err := cron.ParseStandard(w.Cron)
+	if err != nil {
+		return nil
+	}
+	duration := time.Duration(w.Duration) * time.Minute
+	// 从from之前一个时长开始，包含from时仍未结束的冻结
+	cursor := from.Add(-duration)
+	if cursor.Before(w.StartAt) {
+		cursor = w.StartAt.Add(-time.Second)
+	}
+	var periods []freeze.Period
+	for t := sched.Next(cursor); !t.IsZero() && t.Before(to) && len(periods) < freezeMaxPeriods; t = sched.Next(t) {
+		if w.EndAt != nil && !t.Before(*w.EndAt) {
+			break
+		}
+		if end := t.Add(duration); end.After(from) {
+			periods = append(periods, freeze.Period{Start: t, End: end})
+		}
+	}
+	return periods
+}
+
+// freezeCovers 冻结范围是否包含操作的游戏服，没有游戏服的操作(如上传服务器端)只受全局冻结限制
+func freezeCovers(w *freeze.FreezeWindow, serverIDs []string, channels map[string]uint) bool {
+	switch w.Scope {
+	case freeze.ScopeGlobal:
+		return true
+	case freeze.ScopeServer:
+		for _, id := range serverIDs {
+			if slices.Contains(w.ServerIDs, id) {
+				return true
+			}
+		}
+	case freeze.ScopeChannel:
+		for _, id := range serverIDs {
+			if c, ok := channels[id]; ok && slices.Contains(w.ChannelIDs, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ActiveFreeze 当前限制这些游戏服的冻结，没有时返回nil
+func ActiveFreeze(serverIDs []string, now time.Time) (*FreezeError, error) {
+	var windows []freeze.FreezeWindow
+	if err := config.DB.Where("enabled = ? AND start_at <= ? AND (end_at IS NULL OR end_at > ?)", true, now, now).Find(&windows).Error; err != nil {
+		return nil, err
+	}
+	var channels map[string]uint
+	for i := range windows {
+		w := &windows[i]
+		if w.Scope == freeze.ScopeChannel && channels == nil && len(serverIDs) > 0 {
+			type row struct {
+				ServerID  string
+				ChannelID uint
+			}
+			var rows []row
+			if err := config.DB.Table("games").Select("server_id, channel_id").
+				Where("server_id IN ? AND channel_id IS NOT NULL AND deleted_at IS NULL", serverIDs).Scan(&rows).Error; err != nil {
+				return nil, err
+			}
+			channels = make(map[string]uint, len(rows))
+			for _, r := range rows {
+				channels[r.ServerID] = r.ChannelID
+			}
+		}
+		if !freezeCovers(w, serverIDs, channels) {
+			continue
+		}
+		if periods := FreezePeriods(w, now, now.Add(time.Second)); len(periods) > 0 {
+			return &FreezeError{Window: *w, Until: periods[0].End}, nil
+		}
+	}
+	return nil, nil
+}
+
+// CheckFreeze 冻结期间拒绝变更，overrideReason不为空且操作人有强制变更权限时放行并记录
+func CheckFreeze(serverIDs []string, action, operator, overrideReason string) error {
+	frozen, err := ActiveFreeze(serverIDs, time.Now())
+	if err != nil {
+		return fmt.Errorf("check freeze window failed: %v", err)
+	}
+	if frozen == nil {
+		return nil
+	}
+	if overrideReason == "" || operator == "" || !UserHasPermission(operator, freeze.OverridePermission) {
+		return frozen
+	}
+	record := freeze.FreezeOverride{
+		WindowID: frozen.Window.ID,
+		Operator: operator,
+		Action:   action,
+		Targets:  truncate(strings.Join(serverIDs, ","), 10000),
+		Reason:   truncate(overrideReason, 500),
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		return fmt.Errorf("save freeze override failed: %v", err)
+	}
+	slog.Warn("freeze window overridden", "window", frozen.Window.Name, "operator", operator, "action", action, "reason", overrideReason)
+	return nil
+}
+
+// RequestFreezeOverride X-Freeze-Override请求头中的覆盖原因(可url编码)
+func RequestFreezeOverride(ctx fiber.Ctx) string {
+	reason := ctx.Get("X-Freeze-Override")
+	if decoded, err := url.QueryUnescape(reason); err == nil {
+		reason = decoded
+	}
+	return strings.TrimSpace(reason)
+}
+
+// CheckRequestFreeze 按请求的操作人和X-Freeze-Override请求头检查冻结
+func CheckRequestFreeze(ctx fiber.Ctx, serverIDs []string, action string) error {
+	return CheckFreeze(serverIDs, action, ctx.Get("X-Request-User"), RequestFreezeOverride(ctx))
+}
+
+// RefuseFrozen 冻结检查失败的响应，被冻结时返回403和冻结窗口
+func RefuseFrozen(ctx fiber.Ctx, err error) error {
+	var frozen *FreezeError
+	if errors.As(err, &frozen) {
+		return NewAppResponse(ctx, fiber.StatusForbidden, 1, frozen.Error(), fmt.Sprintf("set X-Freeze-Override with a reason to override, requires permission %s", freeze.OverridePermission), fiber.Map{
+			"window": frozen.Window,
+			"until":  frozen.Until,
+		})
+	}
+	return NewAppResponse(ctx, fiber.StatusInternalServerError, 1, "check freeze window error", err.Error(), nil)
+}
@@ -0,0 +1,74 @@
+package pkg_test
+
+import (
+	"saurfang/internal/models/freeze"
+	"saurfang/internal/tools/pkg"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFreezePeriods 测试一次性和重复冻结窗口的展开
+func TestFreezePeriods(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	start := time.Date(2025, 8, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 3)
+	once := &freeze.FreezeWindow{Name: "holiday", Scope: freeze.ScopeGlobal, StartAt: start, EndAt: &end}
+	periods := pkg.FreezePeriods(once, start.Add(time.Hour), start.Add(2*time.Hour))
+	assert.Equal(t, []freeze.Period{{Start: start, End: end}}, periods)
+	assert.Empty(t, pkg.FreezePeriods(once, end, end.Add(time.Hour)))
+
+	// 每天20:00开始冻结3小时
+	daily := &freeze.FreezeWindow{Name: "peak", Scope: freeze.ScopeGlobal, StartAt: start, Cron: "CRON_TZ=Asia/Shanghai 0 20 * * *", Duration: 180}
+	periods = pkg.FreezePeriods(daily, time.Date(2025, 8, 2, 21, 0, 0, 0, loc), time.Date(2025, 8, 3, 21, 0, 0, 0, loc))
+	if assert.Len(t, periods, 2) {
+		assert.True(t, periods[0].Start.Equal(time.Date(2025, 8, 2, 20, 0, 0, 0, loc)))
+		assert.True(t, periods[0].End.Equal(time.Date(2025, 8, 2, 23, 0, 0, 0, loc)))
+		assert.True(t, periods[1].Start.Equal(time.Date(2025, 8, 3, 20, 0, 0, 0, loc)))
+	}
+	assert.Empty(t, pkg.FreezePeriods(daily, time.Date(2025, 8, 2, 23, 0, 0, 0, loc), time.Date(2025, 8, 3, 20, 0, 0, 0, loc)))
+}
+
+// TestValidateFreezeWindow 测试冻结窗口参数校验
+func TestValidateFreezeWindow(t *testing.T) {
+	start := time.Now()
+	end := start.Add(time.Hour)
+	cases := []struct {
+		name string
+		w    freeze.FreezeWindow
+		err  string
+	}{
+		{"ok", freeze.FreezeWindow{Name: "a", Scope: freeze.ScopeGlobal, StartAt: start, EndAt: &end}, ""},
+		{"no scope ids", freeze.FreezeWindow{Name: "a", Scope: freeze.ScopeChannel, StartAt: start, EndAt: &end}, "channel_ids is required"},
+		{"no end", freeze.FreezeWindow{Name: "a", Scope: freeze.ScopeGlobal, StartAt: start}, "end_at is required"},
+		{"bad cron", freeze.FreezeWindow{Name: "a", Scope: freeze.ScopeGlobal, StartAt: start, Cron: "bad", Duration: 10}, "invalid cron"},
+		{"no duration", freeze.FreezeWindow{Name: "a", Scope: freeze.ScopeServer, ServerIDs: []string{"1"}, StartAt: start, Cron: "0 20 * * 5"}, "duration must be positive"},
+	}
+	for _, c := range cases {
+		err := pkg.ValidateFreezeWindow(&c.w)
+		if c.err == "" {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.ErrorContains(t, err, c.err, c.name)
+		}
+	}
+}
+
+// TestBuildCalendar 测试iCal输出的转义和折行
+func TestBuildCalendar(t *testing.T) {
+	start := time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+	ics := pkg.BuildCalendar("ops", []pkg.CalendarEvent{
+		{UID: "b@saurfang", Start: start.Add(time.Hour), Summary: "[cron] restart"},
+		{UID: "a@saurfang", Start: start, End: start.Add(time.Hour), Summary: "[freeze] a,b;c", Description: strings.Repeat("冻结", 30)},
+	}, start)
+	assert.True(t, strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\n"))
+	assert.True(t, strings.HasSuffix(ics, "END:VCALENDAR\r\n"))
+	assert.Contains(t, ics, "DTSTART:20250801T120000Z\r\nDTEND:20250801T130000Z\r\n")
+	assert.Contains(t, ics, `SUMMARY:[freeze] a\,b\;c`)
+	assert.Less(t, strings.Index(ics, "UID:a@saurfang"), strings.Index(ics, "UID:b@saurfang"))
+	for _, line := range strings.Split(ics, "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+}
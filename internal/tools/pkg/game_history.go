@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// RequestTrigger 请求的触发来源，使用ak/sk调用时为api
+func RequestTrigger(ctx fiber.Ctx) string {
+	if ctx.Get("X-Access-Key") != "" && ctx.Cookies("token") == "" {
+		return gameserver.TriggerAPI
+	}
+	return gameserver.TriggerUser
+}
+
+// RequestUserID 认证中间件设置的操作人用户ID，未认证时为0
+func RequestUserID(ctx fiber.Ctx) uint {
+	id, _ := strconv.Atoi(ctx.Get("X-Request-User-ID"))
+	return uint(max(id, 0))
+}
+
+// NewGameOperation 开始一次游戏服操作，结束时调用FinishGameOperation保存
+func NewGameOperation(serverID, op, trigger, operator string, operationID uint) *gameserver.GameOperation {
+	return &gameserver.GameOperation{
+		ServerID:    serverID,
+		Operation:   op,
+		Trigger:     trigger,
+		Operator:    operator,
+		OperationID: operationID,
+		StartedAt:   time.Now(),
+	}
+}
+
+// FinishGameOperation 保存操作结果，err不为空时记录为失败
+func FinishGameOperation(rec *gameserver.GameOperation, evalID string, err error) {
+	rec.EvalID = evalID
+	rec.FinishedAt = time.Now()
+	rec.Duration = rec.FinishedAt.Sub(rec.StartedAt).Milliseconds()
+	rec.Status = gameserver.OperationSuccess
+	if err != nil {
+		rec.Status = gameserver.OperationFailed
+		rec.Error = err.Error()
+	}
+	if dbErr := config.DB.Create(rec).Error; dbErr != nil {
+		slog.Error("failed to save game operation", "server_id", rec.ServerID, "operation", rec.Operation, "error", dbErr)
+	}
+}
+
+// SetGameStatus 更新游戏服状态，状态变化时记录原因
+func SetGameStatus(serverID string, status int, reason string) error {
+	var before []string
+	config.DB.Table("games").Where("server_id = ? AND deleted_at IS NULL", serverID).Pluck("status", &before)
+	to := strconv.Itoa(status)
+	if err := config.DB.Exec("UPDATE games set status = ? where server_id = ? and deleted_at IS NULL;", status, serverID).Error; err != nil {
+		return err
+	}
+	if len(before) == 0 || before[0] == to {
+		return nil
+	}
+	change := gameserver.GameStatusChange{ServerID: serverID, From: before[0], To: to, Reason: truncate(reason, 200)}
+	if err := config.DB.Create(&change).Error; err != nil {
+		slog.Error("failed to save game status change", "server_id", serverID, "error", err)
+	}
+	return nil
+}
+
+// BuildTimeline 合并操作、配置版本和状态变化，按时间倒序，最多返回limit条
+func BuildTimeline(ops []gameserver.GameOperation, versions []*JobVersion, changes []gameserver.GameStatusChange, since, until time.Time, limit int) []gameserver.TimelineEntry {
+	entries := make([]gameserver.TimelineEntry, 0, len(ops)+len(versions)+len(changes))
+	for _, op := range ops {
+		summary := fmt.Sprintf("%s %s by %s (%s)", op.Operation, op.Status, op.Operator, op.Trigger)
+		if op.EvalID != "" {
+			summary += ", eval " + op.EvalID
+		}
+		if op.Error != "" {
+			summary += ": " + op.Error
+		}
+		entries = append(entries, gameserver.TimelineEntry{Time: op.StartedAt, Type: gameserver.TimelineOperation, Summary: summary, Detail: op})
+	}
+	for _, v := range versions {
+		// JobVersion.SubmitTime为秒级时间戳
+		t := time.Unix(v.SubmitTime, 0)
+		if t.Before(since) || t.After(until) {
+			continue
+		}
+		summary := fmt.Sprintf("job version %d registered", v.Version)
+		if v.Stable {
+			summary += " (stable)"
+		}
+		entries = append(entries, gameserver.TimelineEntry{Time: t, Type: gameserver.TimelineConfig, Summary: summary, Detail: v})
+	}
+	for _, c := range changes {
+		summary := fmt.Sprintf("status %s -> %s", c.From, c.To)
+		if c.Reason != "" {
+			summary += ": " + c.Reason
+		}
+		entries = append(entries, gameserver.TimelineEntry{Time: c.CreatedAt, Type: gameserver.TimelineStatus, Summary: summary, Detail: c})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// GameTimeline 游戏服在时间范围内的操作、配置版本和状态变化，nomad不可用时不包含配置版本
+func GameTimeline(serverID string, since, until time.Time, limit int) ([]gameserver.TimelineEntry, error) {
+	var ops []gameserver.GameOperation
+	if err := config.DB.Where("server_id = ? AND started_at BETWEEN ? AND ?", serverID, since, until).
+		Order("started_at DESC").Limit(limit).Find(&ops).Error; err != nil {
+		return nil, err
+	}
+	var changes []gameserver.GameStatusChange
+	if err := config.DB.Where("server_id = ? AND created_at BETWEEN ? AND ?", serverID, since, until).
+		Order("created_at DESC").Limit(limit).Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	var versions []*JobVersion
+	if cluster, err := ClusterOfServer(serverID); err != nil {
+		slog.Warn("failed to get cluster of server for timeline", "server_id", serverID, "error", err)
+	} else if versions, err = JobVersions(cluster.Nomad(), serverID); err != nil {
+		slog.Warn("failed to get job versions for timeline", "server_id", serverID, "error", err)
+	}
+	return BuildTimeline(ops, versions, changes, since, until, limit), nil
+}
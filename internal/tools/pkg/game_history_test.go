@@ -0,0 +1,39 @@
+package pkg_test
+
+import (
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/tools/pkg"
+	"testing"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBuildTimeline 测试时间线按时间倒序合并并过滤范围外的配置版本
+func TestBuildTimeline(t *testing.T) {
+	base := time.Date(2025, 8, 1, 22, 0, 0, 0, time.Local)
+	ops := []gameserver.GameOperation{
+		{ServerID: "1024", Operation: "stop", Trigger: gameserver.TriggerCron, Operator: "nightly", Status: gameserver.OperationSuccess, EvalID: "e1", StartedAt: base},
+		{ServerID: "1024", Operation: "start", Trigger: gameserver.TriggerUser, Operator: "alice", Status: gameserver.OperationFailed, Error: "no config", StartedAt: base.Add(2 * time.Hour)},
+	}
+	// 使用nomad返回的纳秒时间戳构造版本，和GameTimeline的数据来源一致
+	v4, v3, stable := uint64(4), uint64(3), true
+	t4, t3 := base.Add(time.Hour).UnixNano(), base.Add(-48*time.Hour).UnixNano()
+	versions := pkg.BuildJobVersions([]*nomadapi.Job{
+		{Version: &v4, Stable: &stable, SubmitTime: &t4},
+		{Version: &v3, SubmitTime: &t3},
+	}, nil)
+	changes := []gameserver.GameStatusChange{
+		{ServerID: "1024", From: "1", To: "0", Reason: "cron stop", CreatedAt: base.Add(time.Second)},
+	}
+	entries := pkg.BuildTimeline(ops, versions, changes, base.Add(-time.Hour), base.Add(3*time.Hour), 0)
+	assert.Len(t, entries, 4)
+	assert.Equal(t, gameserver.TimelineOperation, entries[0].Type)
+	assert.Equal(t, "start failed by alice (user): no config", entries[0].Summary)
+	assert.Equal(t, "job version 4 registered (stable)", entries[1].Summary)
+	assert.Equal(t, "status 1 -> 0: cron stop", entries[2].Summary)
+	assert.Equal(t, "stop success by nightly (cron), eval e1", entries[3].Summary)
+
+	assert.Len(t, pkg.BuildTimeline(ops, versions, changes, base.Add(-time.Hour), base.Add(3*time.Hour), 2), 2)
+}
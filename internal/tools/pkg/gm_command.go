@@ -0,0 +1,377 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gmcommand"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/models/serverconfig"
+	"saurfang/internal/models/user"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/ntfy"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// GMAdapter GM命令协议适配器，payload为模板渲染后的命令
+type GMAdapter interface {
+	Send(ctx context.Context, endpoint *gmcommand.GMEndpoint, payload string) (string, error)
+}
+
+var gmAdapters = map[string]GMAdapter{
+	gmcommand.ProtocolHTTP: HTTPGMAdapter{},
+	gmcommand.ProtocolTCP:  TCPGMAdapter{},
+}
+
+// RegisterGMAdapter 注册协议适配器，已存在时覆盖
+func RegisterGMAdapter(protocol string, adapter GMAdapter) {
+	gmAdapters[protocol] = adapter
+}
+
+// HTTPGMAdapter 以POST方式发送命令
+type HTTPGMAdapter struct{}
+
+func (HTTPGMAdapter) Send(ctx context.Context, endpoint *gmcommand.GMEndpoint, payload string) (string, error) {
+	path := endpoint.Path
+	if path == "" {
+		path = "/"
+	} else if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(endpoint.Address, strconv.Itoa(endpoint.Port)), path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	if json.Valid([]byte(payload)) {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	if endpoint.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+endpoint.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return string(body), fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return string(body), nil
+}
+
+// TCPGMAdapter 基于行的tcp协议，每行一条命令，每条命令读取一行响应
+type TCPGMAdapter struct{}
+
+func (TCPGMAdapter) Send(ctx context.Context, endpoint *gmcommand.GMEndpoint, payload string) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(endpoint.Address, strconv.Itoa(endpoint.Port)))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+	roundTrip := func(line string) (string, error) {
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			return "", err
+		}
+		reply, err := reader.ReadString('\n')
+		reply = strings.TrimRight(reply, "\r\n")
+		if err != nil {
+			return reply, err
+		}
+		if strings.HasPrefix(reply, "ERR") {
+			return reply, fmt.Errorf("%s", reply)
+		}
+		return reply, nil
+	}
+	if endpoint.Token != "" {
+		if _, err := roundTrip("AUTH " + endpoint.Token); err != nil {
+			return "", fmt.Errorf("auth failed: %v", err)
+		}
+	}
+	var replies []string
+	for _, line := range strings.Split(payload, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		reply, err := roundTrip(line)
+		replies = append(replies, reply)
+		if err != nil {
+			return strings.Join(replies, "\n"), err
+		}
+	}
+	return strings.Join(replies, "\n"), nil
+}
+
+// RenderGMCommand 渲染GM命令模板，缺少参数时返回错误
+func RenderGMCommand(tpl *gmcommand.GMTemplate, serverID string, params map[string]string) (string, error) {
+	for _, name := range strings.Split(tpl.Params, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && params[name] == "" {
+			return "", fmt.Errorf("param %s is required", name)
+		}
+	}
+	t, err := template.New(tpl.Name).Option("missingkey=error").Parse(tpl.Content)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, map[string]any{"ServerID": serverID, "Params": params}); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// UserHasPermission 检查用户所属角色是否有指定权限，username为认证中间件设置的X-Request-User
+func UserHasPermission(username, permission string) bool {
+	if username == "" {
+		return false
+	}
+	var u user.User
+	if err := config.DB.Select("id").Where("username = ?", username).First(&u).Error; err != nil {
+		return false
+	}
+	roleID, err := GetRoleOfUser(u.ID)
+	if err != nil {
+		return false
+	}
+	var count int64
+	config.DB.Table("role_permissions rp").Joins("JOIN permissions p ON rp.permission_id = p.id").
+		Where("rp.role_id = ? AND p.name = ?", roleID, permission).Count(&count)
+	return count > 0
+}
+
+// gmConcurrency 同时执行的服务器数量
+func gmConcurrency() int {
+	if n, _ := strconv.Atoi(os.Getenv("GM_CONCURRENCY")); n > 0 {
+		return n
+	}
+	return 20
+}
+
+// ExecuteGMCommand 并行向服务器发送GM命令，每个服务器单独超时，保存执行记录并通知
+func ExecuteGMCommand(tpl *gmcommand.GMTemplate, serverIDs []string, params map[string]string, operator, trigger string) (*gmcommand.GMExecution, []gmcommand.GMExecutionResult, error) {
+	if len(serverIDs) == 0 {
+		return nil, nil, fmt.Errorf("server_ids is required")
+	}
+	// 提前校验参数，避免所有服务器都因同样的原因失败
+	if _, err := RenderGMCommand(tpl, serverIDs[0], params); err != nil {
+		return nil, nil, err
+	}
+	var endpoints []gmcommand.GMEndpoint
+	if err := config.DB.Where("server_id IN ?", serverIDs).Find(&endpoints).Error; err != nil {
+		return nil, nil, err
+	}
+	byServer := make(map[string]*gmcommand.GMEndpoint, len(endpoints))
+	for i := range endpoints {
+		byServer[endpoints[i].ServerID] = &endpoints[i]
+	}
+	timeout := time.Duration(tpl.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	results := make([]gmcommand.GMExecutionResult, len(serverIDs))
+	sem := make(chan struct{}, gmConcurrency())
+	var wg sync.WaitGroup
+	for i, serverID := range serverIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, serverID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = sendGMCommand(tpl, byServer[serverID], serverID, params, timeout)
+		}(i, serverID)
+	}
+	wg.Wait()
+
+	paramsJSON, _ := json.Marshal(params)
+	now := time.Now()
+	execution := gmcommand.GMExecution{
+		TemplateID:   tpl.ID,
+		TemplateName: tpl.Name,
+		Operator:     operator,
+		Trigger:      trigger,
+		Params:       string(paramsJSON),
+		Total:        len(serverIDs),
+		FinishedAt:   &now,
+	}
+	var successJobs, failedJobs []string
+	for _, r := range results {
+		if r.Success {
+			execution.Success++
+			successJobs = append(successJobs, r.ServerID)
+		} else {
+			execution.Failed++
+			failedJobs = append(failedJobs, r.ServerID)
+		}
+	}
+	if err := config.DB.Create(&execution).Error; err != nil {
+		slog.Error("failed to save gm execution", "template", tpl.Name, "error", err)
+	} else {
+		for i := range results {
+			results[i].ExecutionID = execution.ID
+		}
+		if err := config.DB.CreateInBatches(results, 200).Error; err != nil {
+			slog.Error("failed to save gm execution results", "execution_id", execution.ID, "error", err)
+		}
+	}
+	ntfy.PublishNotification(notify.EventTypeGMCommand, fmt.Sprintf("gm command %s", tpl.Name), successJobs, failedJobs, execution.Success, execution.Failed)
+	return &execution, results, nil
+}
+
+// sendGMCommand 向单个服务器发送命令
+func sendGMCommand(tpl *gmcommand.GMTemplate, endpoint *gmcommand.GMEndpoint, serverID string, params map[string]string, timeout time.Duration) gmcommand.GMExecutionResult {
+	result := gmcommand.GMExecutionResult{ServerID: serverID}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start).Milliseconds() }()
+	if endpoint == nil {
+		result.Error = "gm endpoint not registered"
+		return result
+	}
+	adapter, ok := gmAdapters[endpoint.Protocol]
+	if !ok {
+		result.Error = fmt.Sprintf("unsupported protocol %s", endpoint.Protocol)
+		return result
+	}
+	payload, err := RenderGMCommand(tpl, serverID, params)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := adapter.Send(ctx, endpoint, payload)
+	result.Output = output
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+// DiscoverGMEndpoints 从各集群的游戏服配置(vars中的gm_port/gm_protocol/gm_path)和consul服务目录发现GM端点
+// 手动登记的端点不会被覆盖，返回新增或更新的数量
+func DiscoverGMEndpoints() (int, error) {
+	found := make(map[string]gmcommand.GMEndpoint)
+	var discovered bool
+	var lastErr error
+	for _, cluster := range config.Clusters() {
+		if cluster.Err != nil || cluster.Consul() == nil {
+			continue
+		}
+		if err := discoverClusterGMEndpoints(cluster, found); err != nil {
+			slog.Warn("discover gm endpoints failed", "cluster", cluster.Name, "error", err)
+			lastErr = err
+			continue
+		}
+		discovered = true
+	}
+	if !discovered && lastErr != nil {
+		return 0, lastErr
+	}
+
+	var count int
+	for serverID, ep := range found {
+		var existing gmcommand.GMEndpoint
+		err := config.DB.Where("server_id = ?", serverID).Limit(1).Find(&existing).Error
+		if err != nil {
+			return count, err
+		}
+		if existing.ID == 0 {
+			if err := config.DB.Create(&ep).Error; err != nil {
+				return count, err
+			}
+			count++
+			continue
+		}
+		if existing.Source == gmcommand.SourceManual {
+			continue
+		}
+		if err := config.DB.Model(&existing).Updates(map[string]any{
+			"protocol": ep.Protocol, "address": ep.Address, "port": ep.Port, "path": ep.Path, "source": ep.Source,
+		}).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// discoverClusterGMEndpoints 发现单个集群中的GM端点
+func discoverClusterGMEndpoints(cluster *config.Cluster, found map[string]gmcommand.GMEndpoint) error {
+	consul := cluster.Consul()
+	ns := os.Getenv("GAME_NOMAD_JOB_NAMESPACE")
+	pairs, _, err := consul.KV().List(ns, nil)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		var gc serverconfig.GameConfigs
+		if err := json.Unmarshal(pair.Value, &gc); err != nil {
+			continue
+		}
+		for _, c := range gc.Configs {
+			port, _ := strconv.Atoi(fmt.Sprint(c.Vars["gm_port"]))
+			if port <= 0 {
+				continue
+			}
+			serverID := c.ServerId
+			if serverID == "" {
+				serverID = tools.RemoveNamespace(pair.Key, ns)
+			}
+			ep := gmcommand.GMEndpoint{ServerID: serverID, Protocol: gmcommand.ProtocolHTTP, Address: c.IP, Port: port, Source: gmcommand.SourceConfig}
+			if p, ok := c.Vars["gm_protocol"].(string); ok && p != "" {
+				ep.Protocol = p
+			}
+			if p, ok := c.Vars["gm_path"].(string); ok {
+				ep.Path = p
+			}
+			found[serverID] = ep
+		}
+	}
+	service := os.Getenv("GM_CONSUL_SERVICE")
+	if service == "" {
+		service = "gm"
+	}
+	services, _, err := consul.Catalog().Service(service, "", nil)
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		serverID := s.ServiceMeta["server_id"]
+		if serverID == "" {
+			serverID = s.ServiceID
+		}
+		address := s.ServiceAddress
+		if address == "" {
+			address = s.Address
+		}
+		ep := gmcommand.GMEndpoint{ServerID: serverID, Protocol: gmcommand.ProtocolHTTP, Address: address, Port: s.ServicePort, Path: s.ServiceMeta["gm_path"], Source: gmcommand.SourceConsul}
+		if p := s.ServiceMeta["gm_protocol"]; p != "" {
+			ep.Protocol = p
+		}
+		found[serverID] = ep
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package pkg_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"saurfang/internal/models/gmcommand"
+	"saurfang/internal/tools/pkg"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRenderGMCommand 测试模板渲染和必填参数
+func TestRenderGMCommand(t *testing.T) {
+	tpl := &gmcommand.GMTemplate{Name: "kick", Content: `{"cmd":"kick","server":"{{.ServerID}}","player":"{{.Params.player}}"}`, Params: "player"}
+	out, err := pkg.RenderGMCommand(tpl, "s1", map[string]string{"player": "p1"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"cmd":"kick","server":"s1","player":"p1"}`, out)
+
+	_, err = pkg.RenderGMCommand(tpl, "s1", nil)
+	assert.ErrorContains(t, err, "player")
+}
+
+// TestHTTPGMAdapter 测试http适配器的请求内容和状态码处理
+func TestHTTPGMAdapter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/gm" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok:" + string(body)))
+	}))
+	defer srv.Close()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+	ep := &gmcommand.GMEndpoint{Address: host, Port: p, Path: "gm", Token: "secret"}
+
+	out, err := pkg.HTTPGMAdapter{}.Send(context.Background(), ep, `{"cmd":"save"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `ok:{"cmd":"save"}`, out)
+
+	ep.Token = ""
+	_, err = pkg.HTTPGMAdapter{}.Send(context.Background(), ep, "save")
+	assert.Error(t, err)
+}
+
+// TestTCPGMAdapter 测试tcp适配器的认证和逐行响应
+func TestTCPGMAdapter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					switch line := scanner.Text(); {
+					case line == "AUTH secret":
+						conn.Write([]byte("OK\n"))
+					case strings.HasPrefix(line, "fail"):
+						conn.Write([]byte("ERR unknown command\n"))
+					default:
+						conn.Write([]byte("OK " + line + "\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	ep := &gmcommand.GMEndpoint{Address: "127.0.0.1", Port: addr.Port, Token: "secret"}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	out, err := pkg.TCPGMAdapter{}.Send(ctx, ep, "broadcast hello\nsave\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "OK broadcast hello\nOK save", out)
+
+	out, err = pkg.TCPGMAdapter{}.Send(ctx, ep, "fail now")
+	assert.Error(t, err)
+	assert.Equal(t, "ERR unknown command", out)
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/tools"
+
+	consulapi "github.com/hashicorp/consul/api"
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// JobVersion job的一个历史版本，diff为与上一个版本的差异，最早的版本没有diff
+type JobVersion struct {
+	Version    uint64            `json:"version"`
+	SubmitTime int64             `json:"submit_time"`
+	Stable     bool              `json:"stable"`
+	Status     string            `json:"status"`
+	Diff       *nomadapi.JobDiff `json:"diff,omitempty"`
+}
+
+// RegisterJobWithSource 注册job时同时提交hcl原文，回滚时可以取回对应版本的配置
+func RegisterJobWithSource(client *nomadapi.Client, job *nomadapi.Job, source string) (*nomadapi.JobRegisterResponse, error) {
+	resp, _, err := client.Jobs().RegisterOpts(job, &nomadapi.RegisterOptions{
+		Submission: &nomadapi.JobSubmission{Source: source, Format: "hcl2"},
+	}, nil)
+	return resp, err
+}
+
+// BuildJobVersions 按版本倒序组装版本列表，nomad返回的diffs[i]为versions[i]与versions[i+1]的差异
+func BuildJobVersions(versions []*nomadapi.Job, diffs []*nomadapi.JobDiff) []*JobVersion {
+	items := make([]*JobVersion, 0, len(versions))
+	for i, v := range versions {
+		item := &JobVersion{}
+		if v.Version != nil {
+			item.Version = *v.Version
+		}
+		if v.SubmitTime != nil {
+			item.SubmitTime = *v.SubmitTime / 1e9
+		}
+		if v.Stable != nil {
+			item.Stable = *v.Stable
+		}
+		if v.Status != nil {
+			item.Status = *v.Status
+		}
+		if i < len(diffs) {
+			item.Diff = diffs[i]
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// JobVersions 查询job的历史版本
+func JobVersions(client *nomadapi.Client, jobID string) ([]*JobVersion, error) {
+	versions, diffs, _, err := client.Jobs().Versions(jobID, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	return BuildJobVersions(versions, diffs), nil
+}
+
+// JobVersionSource 查询版本注册时提交的hcl原文，未通过saurfang注册的版本可能没有原文
+func JobVersionSource(client *nomadapi.Client, jobID string, version uint64) (string, error) {
+	sub, _, err := client.Jobs().Submission(jobID, int(version), nil)
+	if err != nil {
+		return "", err
+	}
+	if sub == nil || sub.Source == "" {
+		return "", fmt.Errorf("source of job %s version %d is not stored", jobID, version)
+	}
+	if sub.Format != "" && sub.Format != "hcl2" {
+		return "", fmt.Errorf("source of job %s version %d is %s, not hcl2", jobID, version, sub.Format)
+	}
+	return sub.Source, nil
+}
+
+// ErrRevertConfigConflict 回滚期间consul中的配置被其他操作修改，没有写回
+var ErrRevertConfigConflict = errors.New("job reverted but config in consul was modified during the revert, not overwritten")
+
+// RevertGameJob 回滚游戏服job到指定版本，syncConfig为true时将该版本的hcl按回滚前的ModifyIndex写回consul
+// 回滚成功但写回失败或冲突时返回evalID和错误，由调用方提示手动同步配置
+func RevertGameJob(cluster *config.Cluster, serverID string, version uint64, syncConfig bool) (string, error) {
+	client := cluster.Nomad()
+	current, _, err := client.Jobs().Info(serverID, nil)
+	if err != nil {
+		return "", err
+	}
+	if current.Version != nil && *current.Version == version {
+		return "", errors.New("job is already at this version")
+	}
+	var source string
+	var modifyIndex uint64
+	key := tools.AddNamespace(serverID, os.Getenv("GAME_NOMAD_JOB_NAMESPACE"))
+	if syncConfig {
+		// 先取原文，取不到时不回滚，避免nomad和consul配置不一致
+		if source, err = JobVersionSource(client, serverID, version); err != nil {
+			return "", err
+		}
+		// 记录回滚前配置的ModifyIndex，写回时配置已被其他操作修改则不覆盖
+		pair, _, err := cluster.Consul().KV().Get(key, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to get config from consul: %v", err)
+		}
+		if pair != nil {
+			modifyIndex = pair.ModifyIndex
+		}
+	}
+	resp, _, err := client.Jobs().Revert(serverID, version, current.Version, nil, "", "")
+	if err != nil {
+		return "", err
+	}
+	if syncConfig {
+		ok, _, err := cluster.Consul().KV().CAS(&consulapi.KVPair{Key: key, Value: []byte(source), ModifyIndex: modifyIndex}, nil)
+		if err != nil {
+			return resp.EvalID, fmt.Errorf("job reverted but failed to write config to consul: %v", err)
+		}
+		if !ok {
+			return resp.EvalID, ErrRevertConfigConflict
+		}
+	}
+	return resp.EvalID, nil
+}
@@ -0,0 +1,25 @@
+package pkg_test
+
+import (
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBuildJobVersions 测试版本列表和diff对应关系
+func TestBuildJobVersions(t *testing.T) {
+	v := func(n uint64, stable bool) *nomadapi.Job {
+		submit := int64(1700000000000000000) + int64(n)*1e9
+		return &nomadapi.Job{Version: &n, Stable: &stable, SubmitTime: &submit}
+	}
+	diff := &nomadapi.JobDiff{Type: "Edited", ID: "s1"}
+	items := pkg.BuildJobVersions([]*nomadapi.Job{v(2, false), v(1, true), v(0, true)}, []*nomadapi.JobDiff{diff, {Type: "None"}})
+	assert.Len(t, items, 3)
+	assert.Equal(t, uint64(2), items[0].Version)
+	assert.Equal(t, int64(1700000002), items[0].SubmitTime)
+	assert.Same(t, diff, items[0].Diff)
+	assert.True(t, items[1].Stable)
+	assert.Nil(t, items[2].Diff)
+}
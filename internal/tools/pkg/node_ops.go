@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/nomadjob"
+	"sort"
+	"strings"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// defaultDrainDeadline 与nomad命令行默认值一致
+const defaultDrainDeadline = time.Hour
+
+// BuildDrainSpec 根据请求参数生成排空策略，force时deadline为-1，no_deadline时为0
+func BuildDrainSpec(payload *nomadjob.DrainPayload) (*nomadapi.DrainSpec, error) {
+	if payload.Force && payload.NoDeadline {
+		return nil, errors.New("force and no_deadline cannot be used together")
+	}
+	spec := &nomadapi.DrainSpec{IgnoreSystemJobs: payload.IgnoreSystemJobs}
+	switch {
+	case payload.Force:
+		spec.Deadline = -1
+	case payload.NoDeadline:
+		spec.Deadline = 0
+	case payload.Deadline == "":
+		spec.Deadline = defaultDrainDeadline
+	default:
+		d, err := time.ParseDuration(payload.Deadline)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deadline: %v", err)
+		}
+		if d <= 0 {
+			return nil, errors.New("deadline must be positive")
+		}
+		spec.Deadline = d
+	}
+	return spec, nil
+}
+
+// NodeGameServers 节点上运行中的游戏服，job ID与服务器ID相同的分配视为游戏服
+func NodeGameServers(client *nomadapi.Client, nodeID string) ([]string, error) {
+	allocs, _, err := client.Nodes().Allocations(nodeID, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jobIDs []string
+	for _, alloc := range allocs {
+		if alloc.ClientTerminalStatus() {
+			continue
+		}
+		jobIDs = append(jobIDs, alloc.JobID)
+	}
+	if len(jobIDs) == 0 {
+		return []string{}, nil
+	}
+	var servers []string
+	if err := config.DB.Model(&gameserver.Games{}).Where("server_id IN ?", jobIDs).Distinct().Pluck("server_id", &servers).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(servers)
+	return servers, nil
+}
+
+// NodeWarning 节点操作前的提示，列出会受影响的游戏服
+func NodeWarning(servers []string) string {
+	if len(servers) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d game server(s) running on this node will be affected: %s", len(servers), strings.Join(servers, ","))
+}
@@ -0,0 +1,37 @@
+package pkg_test
+
+import (
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/tools/pkg"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBuildDrainSpec 测试排空参数转换
+func TestBuildDrainSpec(t *testing.T) {
+	spec, err := pkg.BuildDrainSpec(&nomadjob.DrainPayload{})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, spec.Deadline)
+
+	spec, err = pkg.BuildDrainSpec(&nomadjob.DrainPayload{Deadline: "30m", IgnoreSystemJobs: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, spec.Deadline)
+	assert.True(t, spec.IgnoreSystemJobs)
+
+	spec, err = pkg.BuildDrainSpec(&nomadjob.DrainPayload{Force: true})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(-1), spec.Deadline)
+
+	spec, err = pkg.BuildDrainSpec(&nomadjob.DrainPayload{NoDeadline: true})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), spec.Deadline)
+
+	for _, p := range []nomadjob.DrainPayload{{Force: true, NoDeadline: true}, {Deadline: "abc"}, {Deadline: "-1m"}} {
+		_, err := pkg.BuildDrainSpec(&p)
+		assert.Error(t, err)
+	}
+	assert.Empty(t, pkg.NodeWarning(nil))
+	assert.Contains(t, pkg.NodeWarning([]string{"s1", "s2"}), "s1,s2")
+}
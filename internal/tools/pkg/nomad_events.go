@@ -0,0 +1,456 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"sync"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+const (
+	// nomadEventIndexKey redis中保存每个集群已消费的事件index，重启后从该位置继续
+	nomadEventIndexKey = "nomad:event_index:%s"
+	// nomadEventFallback 事件流已连接时的兜底轮询间隔，防止丢失事件
+	nomadEventFallback = time.Minute
+	// nomadEventRetry 事件流断开后的重连间隔
+	nomadEventRetry = 5 * time.Second
+	// nomadNotifyKey 事件通知去重的redis key，多个实例消费同一个事件流时只有一个实例发送
+	nomadNotifyKey = "nomad:notified:%s:%s"
+	// nomadNotifyTTL 去重key的保留时间
+	nomadNotifyTTL = 24 * time.Hour
+	// gameStatusBatch 一次同步游戏服状态最多处理的job数量
+	gameStatusBatch = 256
+)
+
+// nomadEventTopics 订阅的事件topic
+var nomadEventTopics = map[nomadapi.Topic][]string{
+	nomadapi.TopicJob:        {"*"},
+	nomadapi.TopicAllocation: {"*"},
+	nomadapi.TopicDeployment: {"*"},
+	nomadapi.TopicEvaluation: {"*"},
+	nomadapi.TopicNode:       {"*"},
+}
+
+// NomadEventBus 进程内的nomad事件总线，事件流消费者发布，监控和通知等订阅
+type NomadEventBus struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]*nomadEventSubscriber
+	connected   map[string]bool // cluster -> 事件流是否已连接
+}
+
+type nomadEventSubscriber struct {
+	filter  func(*nomadjob.NomadEvent) bool
+	ch      chan *nomadjob.NomadEvent
+	dropped chan struct{} // 丢弃事件时发出信号，为nil时只记录日志
+}
+
+// NomadEvents 全局事件总线
+var NomadEvents = NewNomadEventBus()
+
+func NewNomadEventBus() *NomadEventBus {
+	return &NomadEventBus{
+		subscribers: make(map[int]*nomadEventSubscriber),
+		connected:   make(map[string]bool),
+	}
+}
+
+// Subscribe 订阅满足filter的事件，返回取消订阅的函数，订阅者处理不及时时事件会被丢弃
+func (b *NomadEventBus) Subscribe(filter func(*nomadjob.NomadEvent) bool, buffer int) (<-chan *nomadjob.NomadEvent, func()) {
+	sub := &nomadEventSubscriber{filter: filter, ch: make(chan *nomadjob.NomadEvent, buffer)}
+	return sub.ch, b.subscribe(sub)
+}
+
+// SubscribeResync 同Subscribe，事件被丢弃时在第二个channel发出信号，订阅者需要自行全量同步
+func (b *NomadEventBus) SubscribeResync(filter func(*nomadjob.NomadEvent) bool, buffer int) (<-chan *nomadjob.NomadEvent, <-chan struct{}, func()) {
+	sub := &nomadEventSubscriber{filter: filter, ch: make(chan *nomadjob.NomadEvent, buffer), dropped: make(chan struct{}, 1)}
+	return sub.ch, sub.dropped, b.subscribe(sub)
+}
+
+func (b *NomadEventBus) subscribe(sub *nomadEventSubscriber) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = sub
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+		})
+	}
+}
+
+// Publish 发布事件，不阻塞
+func (b *NomadEventBus) Publish(e *nomadjob.NomadEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subscribers {
+		if sub.filter != nil && !sub.filter(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			slog.Warn("nomad event dropped, subscriber is full", "topic", e.Topic, "type", e.Type, "key", e.Key)
+			if sub.dropped != nil {
+				select {
+				case sub.dropped <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (b *NomadEventBus) setConnected(cluster string, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected[cluster] = ok
+}
+
+// Connected 所有集群的事件流都已连接，未启动事件流时返回false
+func (b *NomadEventBus) Connected() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.connected) == 0 {
+		return false
+	}
+	for _, ok := range b.connected {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeNomadEvent 将nomad事件转换为内部事件，不关心的topic返回nil
+func NormalizeNomadEvent(cluster string, e *nomadapi.Event) (*nomadjob.NomadEvent, error) {
+	out := &nomadjob.NomadEvent{
+		Cluster: cluster,
+		Topic:   string(e.Topic),
+		Type:    e.Type,
+		Key:     e.Key,
+		Index:   e.Index,
+	}
+	switch e.Topic {
+	case nomadapi.TopicJob:
+		job, err := e.Job()
+		if err != nil || job == nil {
+			return nil, fmt.Errorf("decode job event: %v", err)
+		}
+		if job.ID != nil {
+			out.JobID = *job.ID
+		}
+		if job.Status != nil {
+			out.Status = *job.Status
+		}
+	case nomadapi.TopicAllocation:
+		alloc, err := e.Allocation()
+		if err != nil || alloc == nil {
+			return nil, fmt.Errorf("decode allocation event: %v", err)
+		}
+		out.JobID = alloc.JobID
+		out.AllocID = alloc.ID
+		out.EvalID = alloc.EvalID
+		out.DeploymentID = alloc.DeploymentID
+		out.NodeID = alloc.NodeID
+		out.Status = alloc.ClientStatus
+		out.Description = alloc.ClientDescription
+	case nomadapi.TopicDeployment:
+		d, err := e.Deployment()
+		if err != nil || d == nil {
+			return nil, fmt.Errorf("decode deployment event: %v", err)
+		}
+		out.JobID = d.JobID
+		out.DeploymentID = d.ID
+		out.Status = d.Status
+		out.Description = d.StatusDescription
+	case nomadapi.TopicEvaluation:
+		eval, err := e.Evaluation()
+		if err != nil || eval == nil {
+			return nil, fmt.Errorf("decode evaluation event: %v", err)
+		}
+		out.JobID = eval.JobID
+		out.EvalID = eval.ID
+		out.DeploymentID = eval.DeploymentID
+		out.NodeID = eval.NodeID
+		out.Status = eval.Status
+		out.Description = eval.StatusDescription
+	case nomadapi.TopicNode:
+		node, err := e.Node()
+		if err != nil || node == nil {
+			return nil, fmt.Errorf("decode node event: %v", err)
+		}
+		out.NodeID = node.ID
+		out.Status = node.Status
+		out.Description = node.Name
+	default:
+		return nil, nil
+	}
+	return out, nil
+}
+
+// NomadEventWakeups 满足filter的事件到达时发出信号；事件流未连接时每interval发出一次，已连接时按nomadEventFallback兜底
+// 用于替代定时轮询，ctx结束后关闭返回的channel
+func NomadEventWakeups(ctx context.Context, filter func(*nomadjob.NomadEvent) bool, interval time.Duration) <-chan struct{} {
+	wake := make(chan struct{}, 1)
+	events, unsubscribe := NomadEvents.Subscribe(filter, 16)
+	signal := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		defer close(wake)
+		defer unsubscribe()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+				last = time.Now()
+				signal()
+			case <-ticker.C:
+				if NomadEvents.Connected() && time.Since(last) < nomadEventFallback {
+					continue
+				}
+				last = time.Now()
+				signal()
+			}
+		}
+	}()
+	return wake
+}
+
+// StartNomadEventStream 订阅每个集群的nomad事件流，NOMAD_EVENT_STREAM_ENABLED=true时生效
+func StartNomadEventStream() {
+	if os.Getenv("NOMAD_EVENT_STREAM_ENABLED") != "true" {
+		return
+	}
+	for _, cluster := range config.Clusters() {
+		if cluster.Err != nil {
+			continue
+		}
+		NomadEvents.setConnected(cluster.Name, false)
+		go consumeNomadEvents(cluster)
+	}
+	go syncGameStatusFromEvents()
+	go notifyFromEvents()
+	slog.Info("nomad event stream started")
+}
+
+// consumeNomadEvents 消费单个集群的事件流，断开后从上次的index重连
+func consumeNomadEvents(cluster *config.Cluster) {
+	key := fmt.Sprintf(nomadEventIndexKey, cluster.Name)
+	index, _ := config.CahceClient.Get(context.Background(), key).Uint64()
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := cluster.Nomad().EventStream().Stream(ctx, nomadEventTopics, index, nil)
+		if err != nil {
+			cancel()
+			NomadEvents.setConnected(cluster.Name, false)
+			slog.Error("failed to subscribe nomad event stream", "cluster", cluster.Name, "error", err)
+			time.Sleep(nomadEventRetry)
+			continue
+		}
+		NomadEvents.setConnected(cluster.Name, true)
+		slog.Info("nomad event stream connected", "cluster", cluster.Name, "index", index)
+		var saved time.Time
+		for events := range stream {
+			if events.Err != nil {
+				slog.Error("nomad event stream error", "cluster", cluster.Name, "error", events.Err)
+				break
+			}
+			for i := range events.Events {
+				e, err := NormalizeNomadEvent(cluster.Name, &events.Events[i])
+				if err != nil {
+					slog.Warn("failed to normalize nomad event", "cluster", cluster.Name, "error", err)
+					continue
+				}
+				if e != nil {
+					NomadEvents.Publish(e)
+				}
+			}
+			// 下次从后一个index开始，避免重复消费
+			index = events.Index + 1
+			if time.Since(saved) > 5*time.Second {
+				config.CahceClient.Set(context.Background(), key, index, 0)
+				saved = time.Now()
+			}
+		}
+		cancel()
+		config.CahceClient.Set(context.Background(), key, index, 0)
+		NomadEvents.setConnected(cluster.Name, false)
+		time.Sleep(nomadEventRetry)
+	}
+}
+
+// syncGameStatusFromEvents 根据job事件更新游戏服状态，job停止或注销时下线，运行时上线
+// 已到达的事件合并后批量查询所属集群，事件被丢弃时按nomad中job的当前状态全量同步
+func syncGameStatusFromEvents() {
+	events, dropped, _ := NomadEvents.SubscribeResync(func(e *nomadjob.NomadEvent) bool {
+		return e.Topic == string(nomadapi.TopicJob) && gameStatusOfEvent(e) >= 0
+	}, 256)
+	for {
+		select {
+		case e := <-events:
+			// 同一个job只保留最后一个事件
+			batch := map[[2]string]*nomadjob.NomadEvent{{e.Cluster, e.JobID}: e}
+		drain:
+			for len(batch) < gameStatusBatch {
+				select {
+				case e := <-events:
+					batch[[2]string{e.Cluster, e.JobID}] = e
+				default:
+					break drain
+				}
+			}
+			applyGameStatusEvents(batch)
+		case <-dropped:
+			resyncGameStatus()
+		}
+	}
+}
+
+// gameStatusOfEvent 事件对应的游戏服状态，不影响状态时返回-1
+func gameStatusOfEvent(e *nomadjob.NomadEvent) int {
+	switch {
+	case e.Type == "JobDeregistered" || e.Status == "dead":
+		return 0
+	case e.Status == "running":
+		return 1
+	}
+	return -1
+}
+
+// applyGameStatusEvents 一次查询事件涉及的游戏服所属集群后更新状态
+func applyGameStatusEvents(batch map[[2]string]*nomadjob.NomadEvent) {
+	ids := make([]string, 0, len(batch))
+	for key := range batch {
+		ids = append(ids, key[1])
+	}
+	names, err := ClusterNamesOfServers(ids)
+	if err != nil {
+		slog.Error("failed to query cluster of game servers", "error", err)
+		return
+	}
+	for _, e := range batch {
+		// 同名job可能存在于其他集群，只更新属于该集群的游戏服
+		if names[e.JobID] != e.Cluster {
+			continue
+		}
+		SetGameStatus(e.JobID, gameStatusOfEvent(e), fmt.Sprintf("nomad %s, job %s", e.Type, e.Status))
+	}
+}
+
+// resyncGameStatus 按每个集群中job的当前状态同步游戏服状态，nomad中不存在的job不处理
+func resyncGameStatus() {
+	var ids []string
+	if err := config.DB.Table("games").Where("deleted_at IS NULL").Pluck("server_id", &ids).Error; err != nil {
+		slog.Error("failed to query game servers for status resync", "error", err)
+		return
+	}
+	names, err := ClusterNamesOfServers(ids)
+	if err != nil {
+		slog.Error("failed to query cluster of game servers", "error", err)
+		return
+	}
+	for name, servers := range ServersByCluster(names) {
+		cluster, err := config.GetCluster(name)
+		if err != nil {
+			slog.Error("failed to resync game status", "cluster", name, "error", err)
+			continue
+		}
+		jobs, _, err := cluster.Nomad().Jobs().List(nil)
+		if err != nil {
+			slog.Error("failed to resync game status", "cluster", name, "error", err)
+			continue
+		}
+		statusOf := make(map[string]string, len(jobs))
+		for _, job := range jobs {
+			statusOf[job.ID] = job.Status
+		}
+		for _, id := range servers {
+			jobStatus, ok := statusOf[id]
+			if !ok {
+				continue
+			}
+			if status := gameStatusOfEvent(&nomadjob.NomadEvent{Status: jobStatus}); status >= 0 {
+				SetGameStatus(id, status, "nomad resync, job "+jobStatus)
+			}
+		}
+	}
+	slog.Info("game status resynced from nomad after dropped events")
+}
+
+// claimEventNotification 抢占事件通知，返回true时由本实例发送，redis不可用时仍然发送
+func claimEventNotification(cluster, key string) bool {
+	ok, err := config.CahceClient.SetNX(context.Background(), fmt.Sprintf(nomadNotifyKey, cluster, key), operationInstance, nomadNotifyTTL).Result()
+	if err != nil {
+		slog.Warn("failed to claim event notification", "cluster", cluster, "key", key, "error", err)
+		return true
+	}
+	return ok
+}
+
+// watchedDeployments 正在被WatchDeployment跟踪的deployment，由跟踪方负责失败通知
+var watchedDeployments sync.Map
+
+// notifyFromEvents deployment失败、节点下线和恢复时发送通知，多个实例之间按事件去重
+func notifyFromEvents() {
+	events, _ := NomadEvents.Subscribe(func(e *nomadjob.NomadEvent) bool {
+		return e.Topic == string(nomadapi.TopicDeployment) || e.Topic == string(nomadapi.TopicNode)
+	}, 256)
+	nodeStatus := make(map[string]string)
+	for e := range events {
+		switch e.Topic {
+		case string(nomadapi.TopicDeployment):
+			if e.Status != nomadapi.DeploymentStatusFailed {
+				continue
+			}
+			if _, ok := watchedDeployments.Load(e.DeploymentID); ok {
+				continue
+			}
+			names, err := ClusterNamesOfServers([]string{e.JobID})
+			if err != nil || names[e.JobID] != e.Cluster {
+				continue
+			}
+			var count int64
+			config.DB.Table("games").Where("server_id = ? AND deleted_at IS NULL", e.JobID).Count(&count)
+			if count == 0 || !claimEventNotification(e.Cluster, "deployment:"+e.DeploymentID) {
+				continue
+			}
+			ntfy.PublishNotification(notify.EventTypeDeployment, fmt.Sprintf("deployment of %s failed: %s", e.JobID, e.Description), nil, []string{e.JobID}, 0, 1)
+		case string(nomadapi.TopicNode):
+			key := e.Cluster + "/" + e.NodeID
+			before := nodeStatus[key]
+			nodeStatus[key] = e.Status
+			if before == "" || before == e.Status {
+				continue
+			}
+			if (e.Status == "down" || before == "down" && e.Status == "ready") && !claimEventNotification(e.Cluster, fmt.Sprintf("node:%s:%s:%d", e.NodeID, e.Status, e.Index)) {
+				continue
+			}
+			if e.Status == "down" {
+				ntfy.PublishNotification(notify.EventTypeNodeOps, fmt.Sprintf("node %s (%s) is down in cluster %s", e.Description, e.NodeID, e.Cluster), nil, []string{e.Description}, 0, 1)
+			} else if before == "down" && e.Status == "ready" {
+				ntfy.PublishNotification(notify.EventTypeNodeOps, fmt.Sprintf("node %s (%s) is ready in cluster %s", e.Description, e.NodeID, e.Cluster), []string{e.Description}, nil, 1, 0)
+			}
+		}
+	}
+}
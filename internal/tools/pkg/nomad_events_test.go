@@ -0,0 +1,62 @@
+package pkg_test
+
+import (
+	"saurfang/internal/models/nomadjob"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNomadEventBus 测试事件转换和按条件订阅
+func TestNomadEventBus(t *testing.T) {
+	e, err := pkg.NormalizeNomadEvent("sea", &nomadapi.Event{
+		Topic: nomadapi.TopicAllocation,
+		Type:  "AllocationUpdated",
+		Key:   "a1",
+		Index: 10,
+		Payload: map[string]interface{}{
+			"Allocation": map[string]interface{}{
+				"ID":           "a1",
+				"JobID":        "s1",
+				"NodeID":       "n1",
+				"ClientStatus": "failed",
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "sea", e.Cluster)
+	assert.Equal(t, "s1", e.JobID)
+	assert.Equal(t, "failed", e.Status)
+	assert.Equal(t, uint64(10), e.Index)
+
+	e2, err := pkg.NormalizeNomadEvent("sea", &nomadapi.Event{Topic: "ACLToken"})
+	assert.NoError(t, err)
+	assert.Nil(t, e2)
+
+	bus := pkg.NewNomadEventBus()
+	assert.False(t, bus.Connected())
+	ch, unsubscribe := bus.Subscribe(func(e *nomadjob.NomadEvent) bool { return e.JobID == "s1" }, 1)
+	bus.Publish(&nomadjob.NomadEvent{JobID: "s2"})
+	bus.Publish(e)
+	bus.Publish(e) // 缓冲已满时丢弃，不阻塞
+	assert.Len(t, ch, 1)
+	assert.Same(t, e, <-ch)
+	unsubscribe()
+	bus.Publish(e)
+	assert.Len(t, ch, 0)
+}
+
+// TestNomadEventBusResync 测试丢弃事件时发出全量同步信号
+func TestNomadEventBusResync(t *testing.T) {
+	bus := pkg.NewNomadEventBus()
+	ch, dropped, unsubscribe := bus.SubscribeResync(func(e *nomadjob.NomadEvent) bool { return true }, 1)
+	defer unsubscribe()
+	bus.Publish(&nomadjob.NomadEvent{JobID: "s1"})
+	assert.Len(t, dropped, 0)
+	bus.Publish(&nomadjob.NomadEvent{JobID: "s2"})
+	bus.Publish(&nomadjob.NomadEvent{JobID: "s3"}) // 信号已存在时不阻塞
+	assert.Len(t, ch, 1)
+	assert.Len(t, dropped, 1)
+}
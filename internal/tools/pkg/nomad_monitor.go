@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"saurfang/internal/config"
+	"saurfang/internal/models/nomadjob"
 	"saurfang/internal/models/task"
 	"strings"
 	"time"
@@ -28,12 +29,26 @@ func NewNomadMonitor() (*NomadMonitor, error) {
 	}, nil
 }
 
+// nomadClient 执行记录所在集群的nomad客户端，集群为空或不可用时使用默认客户端
+func (m *NomadMonitor) nomadClient(execution *task.CustomTaskExecution) *nomadapi.Client {
+	if execution.Cluster == "" {
+		return m.NomadClient
+	}
+	cluster, err := config.GetCluster(execution.Cluster)
+	if err != nil {
+		slog.Warn("cluster of execution is unavailable", "execution_id", execution.ID, "cluster", execution.Cluster, "error", err)
+		return m.NomadClient
+	}
+	return cluster.Nomad()
+}
+
 // StartMonitoring 开始监控执行记录
 func (m *NomadMonitor) StartMonitoring(execution *task.CustomTaskExecution) {
 	go m.monitorExecution(execution)
 }
 
 // monitorExecution 监控单个执行记录
+// 事件流已连接时由job和分配事件触发检查，定时检查只作为兜底
 func (m *NomadMonitor) monitorExecution(execution *task.CustomTaskExecution) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(execution.MaxCheckCount*execution.CheckInterval)*time.Second)
 	defer cancel()
@@ -41,13 +56,30 @@ func (m *NomadMonitor) monitorExecution(execution *task.CustomTaskExecution) {
 	ticker := time.NewTicker(time.Duration(execution.CheckInterval) * time.Second)
 	defer ticker.Stop()
 
+	events, unsubscribe := NomadEvents.Subscribe(executionEventFilter(execution), 32)
+	defer unsubscribe()
+	lastCheck := time.Now()
+
 	for {
 		select {
 		case <-ctx.Done():
 			// 超时，标记为失败
 			m.updateExecutionStatus(execution, "failed", "Monitoring timeout", -1)
 			return
+		case <-events:
+			// 合并同一时间到达的多个事件，只检查一次
+			for len(events) > 0 {
+				<-events
+			}
+			lastCheck = time.Now()
+			if m.evaluateExecution(execution) {
+				return
+			}
 		case <-ticker.C:
+			if NomadEvents.Connected() && time.Since(lastCheck) < nomadEventFallback {
+				continue
+			}
+			lastCheck = time.Now()
 			// 检查执行状态
 			if m.checkExecutionStatus(execution) {
 				return // 任务完成或失败，停止监控
@@ -56,7 +88,27 @@ func (m *NomadMonitor) monitorExecution(execution *task.CustomTaskExecution) {
 	}
 }
 
-// checkExecutionStatus 检查执行状态
+// executionEventFilter 只关注执行记录所在集群中对应job的job和分配事件
+func executionEventFilter(execution *task.CustomTaskExecution) func(*nomadjob.NomadEvent) bool {
+	cluster := execution.Cluster
+	if cluster == "" {
+		cluster = config.DefaultCluster
+	}
+	jobIDs := make(map[string]bool)
+	for _, id := range strings.Split(execution.NomadJobID, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			jobIDs[id] = true
+		}
+	}
+	return func(e *nomadjob.NomadEvent) bool {
+		if e.Cluster != cluster || !jobIDs[e.JobID] {
+			return false
+		}
+		return e.Topic == string(nomadapi.TopicJob) || e.Topic == string(nomadapi.TopicAllocation)
+	}
+}
+
+// checkExecutionStatus 定时检查执行状态，超过最大检查次数时失败
 func (m *NomadMonitor) checkExecutionStatus(execution *task.CustomTaskExecution) bool {
 	execution.CheckCount++
 	now := time.Now()
@@ -71,7 +123,11 @@ func (m *NomadMonitor) checkExecutionStatus(execution *task.CustomTaskExecution)
 		m.updateExecutionStatus(execution, "failed", "Exceeded maximum check count", -1)
 		return true
 	}
+	return m.evaluateExecution(execution)
+}
 
+// evaluateExecution 查询所有job的状态并更新执行记录，执行结束时返回true
+func (m *NomadMonitor) evaluateExecution(execution *task.CustomTaskExecution) bool {
 	// 解析多个Job ID（用逗号分隔）
 	jobIDs := strings.Split(execution.NomadJobID, ",")
 	if len(jobIDs) == 0 {
@@ -95,7 +151,7 @@ func (m *NomadMonitor) checkExecutionStatus(execution *task.CustomTaskExecution)
 		}
 
 		// 获取 Job 信息
-		job, _, err := m.NomadClient.Jobs().Info(jobID, &nomadapi.QueryOptions{})
+		job, _, err := m.nomadClient(execution).Jobs().Info(jobID, &nomadapi.QueryOptions{})
 
 		if err != nil {
 			slog.Error("Failed to get job info", "job_id", jobID, "error", err)
@@ -191,8 +247,9 @@ func (m *NomadMonitor) checkExecutionStatus(execution *task.CustomTaskExecution)
 
 // handleJobComplete 处理 Job 完成
 func (m *NomadMonitor) handleJobComplete(execution *task.CustomTaskExecution, job *nomadapi.Job) bool {
+	client := m.nomadClient(execution)
 	// 获取 Allocation 信息 - 使用当前检查的job ID
-	allocs, _, err := m.NomadClient.Jobs().Allocations(*job.ID, false, &nomadapi.QueryOptions{})
+	allocs, _, err := client.Jobs().Allocations(*job.ID, false, &nomadapi.QueryOptions{})
 	if err != nil {
 		slog.Error("Failed to get job allocations", "job_id", *job.ID, "error", err)
 		// 不立即失败，继续监控其他job
@@ -205,7 +262,7 @@ func (m *NomadMonitor) handleJobComplete(execution *task.CustomTaskExecution, jo
 	}
 
 	// 获取第一个 Allocation 的详细信息
-	alloc, _, err := m.NomadClient.Allocations().Info(allocs[0].ID, &nomadapi.QueryOptions{})
+	alloc, _, err := client.Allocations().Info(allocs[0].ID, &nomadapi.QueryOptions{})
 	if err != nil {
 		slog.Error("Failed to get allocation info", "alloc_id", allocs[0].ID, "error", err)
 		return false
@@ -238,7 +295,7 @@ func (m *NomadMonitor) handleJobComplete(execution *task.CustomTaskExecution, jo
 // handleAllocationComplete 处理 Allocation 完成
 func (m *NomadMonitor) handleAllocationComplete(execution *task.CustomTaskExecution, alloc *nomadapi.Allocation) bool {
 	// 获取任务日志
-	logs, err := m.getTaskLogs(alloc.ID, "script")
+	logs, err := m.getTaskLogs(m.nomadClient(execution), alloc.ID, "script")
 	if err != nil {
 		slog.Error("Failed to get task logs", "alloc_id", alloc.ID, "error", err)
 		// 即使获取日志失败，也继续处理
@@ -265,17 +322,17 @@ func (m *NomadMonitor) handleAllocationComplete(execution *task.CustomTaskExecut
 }
 
 // getTaskLogs 获取任务日志
-func (m *NomadMonitor) getTaskLogs(allocID, taskName string) (string, error) {
+func (m *NomadMonitor) getTaskLogs(client *nomadapi.Client, allocID, taskName string) (string, error) {
 	var logs []string
 
 	// 首先获取 Allocation 信息
-	alloc, _, err := m.NomadClient.Allocations().Info(allocID, &nomadapi.QueryOptions{})
+	alloc, _, err := client.Allocations().Info(allocID, &nomadapi.QueryOptions{})
 	if err != nil {
 		return "", fmt.Errorf("failed to get allocation info: %v", err)
 	}
 
 	// 获取标准输出日志
-	stdoutChan, errChan := m.NomadClient.AllocFS().Logs(alloc, false, taskName, "stdout", "start", 0, nil, &nomadapi.QueryOptions{})
+	stdoutChan, errChan := client.AllocFS().Logs(alloc, false, taskName, "stdout", "start", 0, nil, &nomadapi.QueryOptions{})
 
 	// 读取标准输出日志
 	for frame := range stdoutChan {
@@ -294,7 +351,7 @@ func (m *NomadMonitor) getTaskLogs(allocID, taskName string) (string, error) {
 	}
 
 	// 获取错误日志
-	stderrChan, stderrErrChan := m.NomadClient.AllocFS().Logs(alloc, false, taskName, "stderr", "start", 0, nil, &nomadapi.QueryOptions{})
+	stderrChan, stderrErrChan := client.AllocFS().Logs(alloc, false, taskName, "stderr", "start", 0, nil, &nomadapi.QueryOptions{})
 
 	logs = append(logs, "\n=== STDERR ===\n")
 	// 读取错误日志
@@ -359,6 +416,7 @@ func (m *NomadMonitor) GetExecutionLogs(executionID uint) (string, error) {
 		return "", fmt.Errorf("no job IDs found for execution %d", executionID)
 	}
 
+	client := m.nomadClient(&execution)
 	// 解析多个Job ID（用逗号分隔）
 	jobIDs := strings.Split(execution.NomadJobID, ",")
 	var allLogs []string
@@ -370,7 +428,7 @@ func (m *NomadMonitor) GetExecutionLogs(executionID uint) (string, error) {
 		}
 
 		// 获取Job的Allocation信息
-		allocs, _, err := m.NomadClient.Jobs().Allocations(jobID, false, &nomadapi.QueryOptions{})
+		allocs, _, err := client.Jobs().Allocations(jobID, false, &nomadapi.QueryOptions{})
 		if err != nil {
 			slog.Error("Failed to get job allocations for logs", "job_id", jobID, "error", err)
 			allLogs = append(allLogs, fmt.Sprintf("=== Job %s ===\nFailed to get allocations: %v\n", jobID, err))
@@ -383,7 +441,7 @@ func (m *NomadMonitor) GetExecutionLogs(executionID uint) (string, error) {
 		}
 
 		// 获取第一个Allocation的日志
-		allocLogs, err := m.getTaskLogs(allocs[0].ID, "script")
+		allocLogs, err := m.getTaskLogs(client, allocs[0].ID, "script")
 		if err != nil {
 			slog.Error("Failed to get task logs", "alloc_id", allocs[0].ID, "error", err)
 			allLogs = append(allLogs, fmt.Sprintf("=== Job %s ===\nFailed to get logs: %v\n", jobID, err))
@@ -418,7 +476,7 @@ func (m *NomadMonitor) StopJob(executionID uint) error {
 		}
 
 		// 停止 Job
-		_, _, err := m.NomadClient.Jobs().Deregister(jobID, false, &nomadapi.WriteOptions{})
+		_, _, err := m.nomadClient(&execution).Jobs().Deregister(jobID, false, &nomadapi.WriteOptions{})
 		if err != nil {
 			slog.Error("Failed to stop job", "job_id", jobID, "error", err)
 			failedJobs = append(failedJobs, jobID)
@@ -0,0 +1,278 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/operation"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// runningOperations 本实例运行中的操作 id -> *OperationRun，结束后移除，之后从数据库读取日志
+var runningOperations sync.Map
+
+const (
+	operationHeartbeat  = 30 * time.Second       // 运行中的操作心跳间隔
+	operationStaleAfter = 3 * operationHeartbeat // 超过该时间没有心跳的操作视为中断
+	operationPoll       = time.Second            // 订阅其他实例运行的操作时轮询数据库的间隔
+)
+
+// operationInstance 当前服务实例的标识，多实例部署时区分操作由哪个实例执行
+var operationInstance = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+// OperationRun 运行中的操作，日志同时保存到数据库和内存，订阅者从内存读取
+type OperationRun struct {
+	Op *operation.Operation
+
+	mu     sync.Mutex
+	logs   []operation.OperationLog
+	done   bool
+	nextID int
+	wakes  map[int]chan struct{}
+}
+
+// StartOperation 保存操作并登记为运行中，调用方在后台执行并在结束时调用Finish
+func StartOperation(op *operation.Operation) (*OperationRun, error) {
+	now := time.Now()
+	op.Status = operation.StatusRunning
+	op.Instance, op.HeartbeatAt = operationInstance, &now
+	if err := config.DB.Create(op).Error; err != nil {
+		return nil, err
+	}
+	run := &OperationRun{Op: op, wakes: make(map[int]chan struct{})}
+	runningOperations.Store(op.ID, run)
+	return run, nil
+}
+
+// Log 追加日志，兼容SSE格式的消息，按行拆分并去掉data:前缀，忽略空行
+func (r *OperationRun) Log(message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(strings.TrimPrefix(line, "data: "), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		l := operation.OperationLog{
+			OperationID: r.Op.ID,
+			Seq:         len(r.logs) + 1,
+			Message:     line,
+			CreatedAt:   time.Now(),
+		}
+		if err := config.DB.Create(&l).Error; err != nil {
+			slog.Error("failed to save operation log", "operation_id", r.Op.ID, "seq", l.Seq, "error", err)
+		}
+		r.logs = append(r.logs, l)
+	}
+	r.notify()
+}
+
+// Consume 将消息写入日志直到channel关闭，然后按results的结果结束操作
+func (r *OperationRun) Consume(messages <-chan string, results func() (success, failed []string)) {
+	for message := range messages {
+		r.Log(message)
+	}
+	r.Finish(results())
+}
+
+// Finish 保存每个目标的结果并结束操作，有失败目标或没有成功目标时为失败
+func (r *OperationRun) Finish(success, failed []string) {
+	status := operation.StatusSuccess
+	if len(failed) > 0 || len(success) == 0 {
+		status = operation.StatusFailed
+	}
+	results := make([]operation.OperationResult, 0, len(success)+len(failed))
+	for _, target := range success {
+		results = append(results, operation.OperationResult{OperationID: r.Op.ID, Target: target, Status: operation.StatusSuccess})
+	}
+	for _, target := range failed {
+		results = append(results, operation.OperationResult{OperationID: r.Op.ID, Target: target, Status: operation.StatusFailed})
+	}
+	if len(results) > 0 {
+		if err := config.DB.Create(&results).Error; err != nil {
+			slog.Error("failed to save operation results", "operation_id", r.Op.ID, "error", err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.Op.Status, r.Op.SuccessCount, r.Op.FailCount = status, len(success), len(failed)
+	r.Op.LastSeq, r.Op.FinishedAt = len(r.logs), &now
+	if err := config.DB.Model(r.Op).Updates(map[string]any{
+		"status":        status,
+		"success_count": len(success),
+		"fail_count":    len(failed),
+		"last_seq":      len(r.logs),
+		"finished_at":   now,
+	}).Error; err != nil {
+		slog.Error("failed to finish operation", "operation_id", r.Op.ID, "error", err)
+	}
+	r.done = true
+	runningOperations.Delete(r.Op.ID)
+	r.notify()
+}
+
+func (r *OperationRun) notify() {
+	for _, wake := range r.wakes {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// follow 依次发送序号大于after的日志直到操作结束，send返回false时停止
+func (r *OperationRun) follow(after int, send func(operation.OperationLog) bool) bool {
+	wake := make(chan struct{}, 1)
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.wakes[id] = wake
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.wakes, id)
+		r.mu.Unlock()
+	}()
+	for {
+		r.mu.Lock()
+		var pending []operation.OperationLog
+		if after < len(r.logs) {
+			pending = append(pending, r.logs[after:]...)
+		}
+		done := r.done
+		r.mu.Unlock()
+		for _, l := range pending {
+			if !send(l) {
+				return false
+			}
+			after = l.Seq
+		}
+		if done {
+			return true
+		}
+		<-wake
+	}
+}
+
+// FollowOperation 发送操作中序号大于after的日志，运行中的操作会等待到结束，send返回false时停止
+// 其他实例运行的操作从数据库轮询日志
+func FollowOperation(id uint, after int, send func(operation.OperationLog) bool) bool {
+	for {
+		if v, ok := runningOperations.Load(id); ok {
+			return v.(*OperationRun).follow(after, send)
+		}
+		// 先读状态再读日志，状态已结束时读到的日志是完整的
+		var op operation.Operation
+		if err := config.DB.Select("status").First(&op, id).Error; err != nil {
+			slog.Error("failed to load operation", "operation_id", id, "error", err)
+			return false
+		}
+		var logs []operation.OperationLog
+		if err := config.DB.Where("operation_id = ? AND seq > ?", id, after).Order("seq").Find(&logs).Error; err != nil {
+			slog.Error("failed to load operation logs", "operation_id", id, "error", err)
+			return false
+		}
+		for _, l := range logs {
+			if !send(l) {
+				return false
+			}
+			after = l.Seq
+		}
+		if op.Status != operation.StatusRunning {
+			return true
+		}
+		time.Sleep(operationPoll)
+	}
+}
+
+// LastEventID 客户端重连时携带的最后一条日志序号，EventSource使用Last-Event-ID请求头，也可以使用last_event_id参数
+func LastEventID(ctx fiber.Ctx) int {
+	v := ctx.Get("Last-Event-ID")
+	if v == "" {
+		v = ctx.Query("last_event_id")
+	}
+	seq, err := strconv.Atoi(v)
+	if err != nil || seq < 0 {
+		return 0
+	}
+	return seq
+}
+
+// StreamOperation 以SSE输出操作日志，事件id为日志序号，操作结束后发送end事件
+func StreamOperation(ctx fiber.Ctx, id uint, after int) error {
+	ctx.Set("X-Operation-ID", strconv.FormatUint(uint64(id), 10))
+	// 流式写入在handler返回后执行，每条事件写入后立即flush，失败说明客户端已断开，停止订阅
+	return ctx.SendStreamWriter(func(w *bufio.Writer) {
+		write := func(message string) bool {
+			if _, err := w.WriteString(message); err != nil {
+				slog.Error("Failed to write SSE message", "error", err)
+				return false
+			}
+			if err := w.Flush(); err != nil {
+				slog.Error("Failed to flush response", "error", err)
+				return false
+			}
+			return true
+		}
+		if !write(fmt.Sprintf("event: operation\ndata: %d\n\n", id)) {
+			return
+		}
+		if !FollowOperation(id, after, func(l operation.OperationLog) bool {
+			return write(fmt.Sprintf("id: %d\ndata: %s\n\n", l.Seq, l.Message))
+		}) {
+			return
+		}
+		var op operation.Operation
+		if err := config.DB.Select("status").First(&op, id).Error; err == nil {
+			write(fmt.Sprintf("event: end\ndata: %s\n\n", op.Status))
+		}
+	})
+}
+
+// InterruptStaleOperations 将超时没有心跳的运行中操作标记为中断，执行的实例已经退出
+// 其他实例运行中的操作会持续更新心跳，不受影响
+func InterruptStaleOperations() {
+	res := config.DB.Model(&operation.Operation{}).
+		Where("status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", operation.StatusRunning, time.Now().Add(-operationStaleAfter)).
+		Updates(map[string]any{"status": operation.StatusInterrupted, "finished_at": time.Now()})
+	if res.Error != nil {
+		slog.Error("failed to mark interrupted operations", "error", res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		slog.Warn("operations interrupted by instance exit", "count", res.RowsAffected)
+	}
+}
+
+// StartOperationHeartbeat 定时更新本实例运行中操作的心跳，并中断心跳超时的操作
+func StartOperationHeartbeat() {
+	ticker := time.NewTicker(operationHeartbeat)
+	defer ticker.Stop()
+	for range ticker.C {
+		var ids []uint
+		runningOperations.Range(func(key, _ any) bool {
+			ids = append(ids, key.(uint))
+			return true
+		})
+		if len(ids) > 0 {
+			if err := config.DB.Model(&operation.Operation{}).
+				Where("id IN ? AND instance = ? AND status = ?", ids, operationInstance, operation.StatusRunning).
+				Update("heartbeat_at", time.Now()).Error; err != nil {
+				slog.Error("failed to update operation heartbeat", "error", err)
+			}
+		}
+		InterruptStaleOperations()
+	}
+}
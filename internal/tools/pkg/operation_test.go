@@ -0,0 +1,87 @@
+package pkg_test
+
+import (
+	"saurfang/internal/config"
+	"saurfang/internal/models/operation"
+	"saurfang/internal/testutils"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOperationFollow 测试按序号续订运行中的操作日志，并在操作结束后返回
+func TestOperationFollow(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+	expectInsert := func(table string, id int64, rows int64) {
+		mockDB.Mock.ExpectBegin()
+		mockDB.Mock.ExpectExec("INSERT INTO `" + table + "`").WillReturnResult(sqlmock.NewResult(id, rows))
+		mockDB.Mock.ExpectCommit()
+	}
+
+	expectInsert("operations", 7, 1)
+	run, err := pkg.StartOperation(&operation.Operation{Kind: operation.KindGameOps, Action: "start", Targets: "s1,s2"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), run.Op.ID)
+
+	expectInsert("operation_logs", 1, 1)
+	expectInsert("operation_logs", 2, 1)
+	run.Log("data: [√] deploy job success. key: s1\n\ndata: [√] deploy job success. key: s2\n\n")
+
+	seqs := make(chan int, 10)
+	done := make(chan bool)
+	go func() {
+		done <- pkg.FollowOperation(7, 1, func(l operation.OperationLog) bool {
+			seqs <- l.Seq
+			return true
+		})
+	}()
+	// 收到第2行后订阅者已登记，之后的日志由运行中的操作推送
+	assert.Equal(t, 2, <-seqs)
+
+	expectInsert("operation_logs", 3, 1)
+	run.Log("data: [√] All operations completed\n\n")
+	expectInsert("operation_results", 1, 2)
+	mockDB.Mock.ExpectBegin()
+	mockDB.Mock.ExpectExec("UPDATE `operations`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.Mock.ExpectCommit()
+	run.Finish([]string{"s1"}, []string{"s2"})
+
+	assert.True(t, <-done)
+	close(seqs)
+	var got []int
+	for seq := range seqs {
+		got = append(got, seq)
+	}
+	assert.Equal(t, []int{3}, got)
+	assert.Equal(t, operation.StatusFailed, run.Op.Status)
+	assert.Equal(t, 3, run.Op.LastSeq)
+	mockDB.ExpectationsWereMet(t)
+}
+
+// TestOperationFollowOtherInstance 测试订阅其他实例运行的操作，从数据库轮询日志直到操作结束
+func TestOperationFollowOtherInstance(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	mockDB.Mock.ExpectQuery("SELECT `status` FROM `operations`").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(operation.StatusRunning))
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `operation_logs`").WithArgs(9, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"operation_id", "seq", "message"}).AddRow(9, 1, "line 1"))
+	mockDB.Mock.ExpectQuery("SELECT `status` FROM `operations`").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(operation.StatusSuccess))
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `operation_logs`").WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"operation_id", "seq", "message"}).AddRow(9, 2, "line 2"))
+
+	var got []int
+	assert.True(t, pkg.FollowOperation(9, 0, func(l operation.OperationLog) bool {
+		got = append(got, l.Seq)
+		return true
+	}))
+	assert.Equal(t, []int{1, 2}, got)
+	mockDB.ExpectationsWereMet(t)
+}
@@ -0,0 +1,210 @@
+package pkg
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/upload"
+	"saurfang/internal/tools"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPackageVersionConflict = errors.New("package version conflict")
+	ErrInvalidPackageStatus   = errors.New("invalid package status")
+	ErrPackageContentMismatch = errors.New("package content mismatch")
+)
+
+// packageTransitions 允许的安装包状态变更，废弃的版本可以重新发布
+var packageTransitions = map[string][]string{
+	upload.PackageUploaded:   {upload.PackageReleased, upload.PackageDeprecated},
+	upload.PackageReleased:   {upload.PackageDeprecated},
+	upload.PackageDeprecated: {upload.PackageReleased},
+}
+
+// ReadPackageManifest 读取压缩包中最外层的manifest.json，没有时返回nil
+func ReadPackageManifest(zipPath string) (*upload.Manifest, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var found *zip.File
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || path.Base(f.Name) != upload.ManifestFile {
+			continue
+		}
+		if found == nil || strings.Count(f.Name, "/") < strings.Count(found.Name, "/") {
+			found = f
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	rc, err := found.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var m upload.Manifest
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", found.Name, err)
+	}
+	return &m, nil
+}
+
+// InspectPackage 计算压缩包的SHA256和大小并读取构建信息，版本号取manifest中的version，
+// 没有时使用文件名加SHA256前12位，避免固定文件名的压缩包重新上传时冲突
+// 已登记的相同构建返回已有记录，同一版本号不同构建返回ErrPackageVersionConflict
+func InspectPackage(zipPath, uploader string) (*upload.Package, error) {
+	f, err := os.Open(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	manifest, err := ReadPackageManifest(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	fileName := filepath.Base(zipPath)
+	p := &upload.Package{
+		Version:  strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "-" + sum[:12],
+		FileName: fileName,
+		SHA256:   sum,
+		Size:     size,
+		Uploader: uploader,
+		Status:   upload.PackageUploaded,
+	}
+	if manifest != nil {
+		if v := strings.TrimSpace(manifest.Version); v != "" {
+			p.Version = v
+		}
+		p.GitCommit, p.BuildNumber, p.Changelog = manifest.GitCommit, manifest.BuildNumber, manifest.Changelog
+	}
+	var existing upload.Package
+	err = config.DB.Where("version = ?", p.Version).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing.SHA256 != sum {
+		return nil, fmt.Errorf("%w: version %s is registered with sha256 %s", ErrPackageVersionConflict, p.Version, existing.SHA256)
+	}
+	return &existing, nil
+}
+
+// RecordPackageSync 登记安装包及其同步到的存储，同一存储再次同步时更新路径和时间
+// contentSum为存储中文件校验和的sha256，同一安装包每次同步的内容必须一致
+func RecordPackageSync(p *upload.Package, datasourceID uint, label, dest, contentSum string) error {
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		switch {
+		case p.ID == 0:
+			p.ContentSum = contentSum
+			if err := tx.Create(p).Error; err != nil {
+				return err
+			}
+		case p.ContentSum == "":
+			p.ContentSum = contentSum
+			if err := tx.Model(p).Update("content_sum", contentSum).Error; err != nil {
+				return err
+			}
+		case p.ContentSum != contentSum:
+			return fmt.Errorf("%w: version %s (sha256 %s) was registered with content %s, got %s", ErrPackageContentMismatch, p.Version, p.SHA256, p.ContentSum, contentSum)
+		}
+		target := upload.PackageTarget{
+			PackageID:    p.ID,
+			DatasourceID: datasourceID,
+			Label:        label,
+			Path:         dest,
+			SyncedAt:     time.Now(),
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "package_id"}, {Name: "datasource_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"label", "path", "synced_at"}),
+		}).Create(&target).Error
+	})
+}
+
+// VerifyPackageUpload 比对同步到存储的文件和本地解压的文件，一致时返回存储中文件校验和的sha256，作为之后发布时的校验依据
+func VerifyPackageUpload(datasourceID uint, localDir string) (string, error) {
+	local, err := tools.LocalChecksums(localDir)
+	if err != nil {
+		return "", fmt.Errorf("checksum local package failed: %v", err)
+	}
+	sums, err := tools.OssChecksums(datasourceID, "")
+	if err != nil {
+		return "", fmt.Errorf("list package in storage failed: %v", err)
+	}
+	if sums == "" || sums != local {
+		return "", errors.New("files in storage do not match the uploaded package")
+	}
+	return checksum(sums), nil
+}
+
+// SetPackageStatus 变更安装包状态
+func SetPackageStatus(id uint, status string) (*upload.Package, error) {
+	var p upload.Package
+	if err := config.DB.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	if p.Status == status {
+		return &p, nil
+	}
+	if !slices.Contains(packageTransitions[p.Status], status) {
+		return &p, fmt.Errorf("%w: %s -> %s", ErrInvalidPackageStatus, p.Status, status)
+	}
+	if err := config.DB.Model(&p).Update("status", status).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPackageDetail 安装包详情，包含同步到的存储和运行该版本的游戏服
+func GetPackageDetail(id uint) (*upload.PackageDetail, error) {
+	var detail upload.PackageDetail
+	if err := config.DB.Preload("Targets").First(&detail.Package, id).Error; err != nil {
+		return nil, err
+	}
+	detail.Servers = []string{}
+	if err := config.DB.Model(&gameserver.Games{}).Where("package_version = ?", detail.Version).Order("id").Pluck("server_id", &detail.Servers).Error; err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// markPackageDeployed 游戏服发布成功后记录运行的版本，首次发布的安装包标记为已发布
+func markPackageDeployed(packageID uint, serverIDs []string) {
+	if packageID == 0 || len(serverIDs) == 0 {
+		return
+	}
+	var p upload.Package
+	if err := config.DB.First(&p, packageID).Error; err != nil {
+		return
+	}
+	config.DB.Model(&gameserver.Games{}).Where("server_id IN ?", serverIDs).Update("package_version", p.Version)
+	if p.Status == upload.PackageUploaded {
+		config.DB.Model(&p).Update("status", upload.PackageReleased)
+	}
+}
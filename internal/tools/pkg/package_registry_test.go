@@ -0,0 +1,102 @@
+package pkg_test
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"saurfang/internal/config"
+	"saurfang/internal/models/upload"
+	"saurfang/internal/testutils"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeZip 生成测试用压缩包
+func writeZip(t *testing.T, files map[string]string) string {
+	zipPath := filepath.Join(t.TempDir(), "server-1.2.0.zip")
+	f, err := os.Create(zipPath)
+	assert.NoError(t, err)
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, f.Close())
+	return zipPath
+}
+
+// TestReadPackageManifest 测试读取最外层的manifest.json
+func TestReadPackageManifest(t *testing.T) {
+	zipPath := writeZip(t, map[string]string{
+		"server/lib/manifest.json": `{"version":"nested"}`,
+		"server/manifest.json":     `{"version":"1.2.0-rc1","git_commit":"abc123","build_number":"42","changelog":"fix login"}`,
+		"server/bin/game":          "binary",
+	})
+	m, err := pkg.ReadPackageManifest(zipPath)
+	assert.NoError(t, err)
+	assert.Equal(t, &upload.Manifest{Version: "1.2.0-rc1", GitCommit: "abc123", BuildNumber: "42", Changelog: "fix login"}, m)
+
+	m, err = pkg.ReadPackageManifest(writeZip(t, map[string]string{"bin/game": "binary"}))
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+}
+
+// TestInspectPackageVersionConflict 测试同一版本号不同构建时拒绝登记
+func TestInspectPackageVersionConflict(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	zipPath := writeZip(t, map[string]string{"manifest.json": `{"version":"1.2.0"}`, "bin/game": "binary"})
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `packages`").
+		WithArgs("1.2.0", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "sha256"}).AddRow(1, "1.2.0", "deadbeef"))
+
+	_, err := pkg.InspectPackage(zipPath, "admin")
+	assert.ErrorIs(t, err, pkg.ErrPackageVersionConflict)
+	mockDB.ExpectationsWereMet(t)
+}
+
+// TestInspectPackageFallbackVersion 测试没有manifest时版本号为文件名加SHA256前缀，同名的不同构建不会冲突
+func TestInspectPackageFallbackVersion(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	zipPath := writeZip(t, map[string]string{"bin/game": "binary"})
+	data, err := os.ReadFile(zipPath)
+	assert.NoError(t, err)
+	sum := sha256.Sum256(data)
+	version := "server-1.2.0-" + hex.EncodeToString(sum[:])[:12]
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `packages`").
+		WithArgs(version, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "sha256"}))
+
+	p, err := pkg.InspectPackage(zipPath, "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, version, p.Version)
+	assert.Equal(t, hex.EncodeToString(sum[:]), p.SHA256)
+	mockDB.ExpectationsWereMet(t)
+}
+
+// TestSetPackageStatus 测试安装包状态只能按生命周期变更
+func TestSetPackageStatus(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+
+	mockDB.Mock.ExpectQuery("SELECT \\* FROM `packages`").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "status"}).AddRow(1, "1.2.0", upload.PackageDeprecated))
+
+	_, err := pkg.SetPackageStatus(1, upload.PackageUploaded)
+	assert.ErrorIs(t, err, pkg.ErrInvalidPackageStatus)
+	mockDB.ExpectationsWereMet(t)
+}
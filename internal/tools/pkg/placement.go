@@ -0,0 +1,240 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gamechannel"
+	"saurfang/internal/models/gamehost"
+	"saurfang/internal/models/placement"
+	"saurfang/internal/tools"
+	"slices"
+	"sort"
+	"strings"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// PlanPlacement 为新游戏服选择主机，已运行其他集群游戏服的主机不作为候选
+func PlanPlacement(req *placement.PlacementRequest) (*placement.PlacementResult, error) {
+	query := config.DB.Model(&gamehost.Hosts{}).Where("deleted_at IS NULL")
+	if req.GroupID != nil {
+		query = query.Where("group_id = ?", *req.GroupID)
+	}
+	var hosts []gamehost.Hosts
+	if err := query.Find(&hosts).Error; err != nil {
+		return nil, err
+	}
+	cluster, err := placementCluster(req)
+	if err != nil {
+		return nil, err
+	}
+	var counts []struct {
+		HostID         uint
+		Cluster        string
+		ChannelCluster string
+		Total          int
+		SameChannel    int
+	}
+	if err := config.DB.Raw(`SELECT gh.host_id, g.cluster, c.cluster AS channel_cluster, COUNT(*) AS total, SUM(CASE WHEN g.channel_id = ? THEN 1 ELSE 0 END) AS same_channel
+		FROM game_hosts gh JOIN games g ON gh.game_id = g.id LEFT JOIN channels c ON c.id = g.channel_id
+		WHERE g.deleted_at IS NULL GROUP BY gh.host_id, g.cluster, c.cluster`, req.ChannelID).Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	gameCounts := make(map[uint][2]int, len(counts))
+	otherCluster := make(map[uint]string)
+	for _, c := range counts {
+		n := gameCounts[c.HostID]
+		gameCounts[c.HostID] = [2]int{n[0] + c.Total, n[1] + c.SameChannel}
+		if name := ResolveCluster(c.Cluster, c.ChannelCluster); name != cluster.Name {
+			otherCluster[c.HostID] = name
+		}
+	}
+	nomad := cluster.Nomad()
+	nodes := make(map[string]*nomadapi.NodeListStub)
+	if nomad != nil {
+		list, _, err := nomad.Nodes().List(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nomad nodes: %v", err)
+		}
+		for _, node := range list {
+			nodes[node.Address] = node
+			nodes[node.Name] = node
+		}
+	}
+
+	result := &placement.PlacementResult{}
+	var candidates []placement.Candidate
+	for _, host := range hosts {
+		if name, ok := otherCluster[host.ID]; ok {
+			result.Excluded = append(result.Excluded, placement.Excluded{HostID: host.ID, Hostname: host.Hostname, Reason: fmt.Sprintf("host runs game servers of cluster %s", name)})
+			continue
+		}
+		if missing := missingLabels(host.Labels, req.Labels); len(missing) > 0 {
+			result.Excluded = append(result.Excluded, placement.Excluded{HostID: host.ID, Hostname: host.Hostname, Reason: fmt.Sprintf("missing labels %s", strings.Join(missing, ","))})
+			continue
+		}
+		c := placement.Candidate{
+			HostID:      host.ID,
+			Hostname:    host.Hostname,
+			PrivateIP:   host.PrivateIP,
+			GameServers: gameCounts[host.ID][0],
+			SameChannel: gameCounts[host.ID][1],
+		}
+		if nomad != nil {
+			node, ok := nodes[host.PrivateIP]
+			if !ok {
+				node, ok = nodes[host.Hostname]
+			}
+			if !ok {
+				result.Excluded = append(result.Excluded, placement.Excluded{HostID: host.ID, Hostname: host.Hostname, Reason: "no matching nomad node"})
+				continue
+			}
+			if reason := nodeUnavailable(node, req.Datacenter); reason != "" {
+				result.Excluded = append(result.Excluded, placement.Excluded{HostID: host.ID, Hostname: host.Hostname, Reason: reason})
+				continue
+			}
+			c.NodeID = node.ID
+			c.NodeName = node.Name
+			collectNodeLoad(nomad, &c)
+		}
+		candidates = append(candidates, c)
+	}
+
+	weights := placement.DefaultWeights
+	if req.Weights != nil {
+		weights = *req.Weights
+	}
+	candidates = RankCandidates(candidates, weights)
+	count := req.Count
+	if count <= 0 {
+		count = 3
+	}
+	result.Candidates = candidates[:min(count, len(candidates))]
+	if len(result.Candidates) == 0 {
+		return result, nil
+	}
+	result.Recommendation = &result.Candidates[0]
+	if nodeName := result.Recommendation.NodeName; nodeName != "" {
+		result.Constraint = tools.NodeConstraintHCL(nodeName)
+		if req.Setting != "" {
+			setting, err := tools.InjectNodeConstraint(req.Setting, nodeName)
+			if err != nil {
+				return nil, err
+			}
+			result.Setting = setting
+		}
+	}
+	return result, nil
+}
+
+// placementCluster 新游戏服所在的集群，请求未指定时使用渠道的集群
+func placementCluster(req *placement.PlacementRequest) (*config.Cluster, error) {
+	name := req.Cluster
+	if name == "" && req.ChannelID != 0 {
+		if err := config.DB.Model(&gamechannel.Channels{}).Where("id = ?", req.ChannelID).Pluck("cluster", &name).Error; err != nil {
+			return nil, err
+		}
+	}
+	return config.GetCluster(ResolveCluster(name, ""))
+}
+
+// RankCandidates 按负载计算分数并排序，分数越高越推荐
+// 各项指标按候选中的最大值归一化，CPU和内存按百分比计算
+func RankCandidates(candidates []placement.Candidate, w placement.Weights) []placement.Candidate {
+	total := w.CPU + w.Memory + w.Allocations + w.GameServers + w.SameChannel
+	if total <= 0 {
+		w, total = placement.DefaultWeights, 1
+	}
+	var maxAllocs, maxGames, maxSame int
+	for _, c := range candidates {
+		maxAllocs = max(maxAllocs, c.Allocations)
+		maxGames = max(maxGames, c.GameServers)
+		maxSame = max(maxSame, c.SameChannel)
+	}
+	ratio := func(v, m int) float64 {
+		if m == 0 {
+			return 0
+		}
+		return float64(v) / float64(m)
+	}
+	for i := range candidates {
+		c := &candidates[i]
+		load := w.CPU*c.CPUUsage/100 +
+			w.Memory*c.MemoryUsage/100 +
+			w.Allocations*ratio(c.Allocations, maxAllocs) +
+			w.GameServers*ratio(c.GameServers, maxGames) +
+			w.SameChannel*ratio(c.SameChannel, maxSame)
+		c.Score = math.Round((1-load/total)*10000) / 100
+		if c.SameChannel > 0 {
+			c.Reasons = append(c.Reasons, fmt.Sprintf("%d servers of the same channel", c.SameChannel))
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		if candidates[i].GameServers != candidates[j].GameServers {
+			return candidates[i].GameServers < candidates[j].GameServers
+		}
+		return candidates[i].Hostname < candidates[j].Hostname
+	})
+	return candidates
+}
+
+// nodeUnavailable 节点不可调度时返回原因
+func nodeUnavailable(node *nomadapi.NodeListStub, datacenter string) string {
+	switch {
+	case node.Status != "ready":
+		return fmt.Sprintf("nomad node %s is %s", node.Name, node.Status)
+	case node.Drain:
+		return fmt.Sprintf("nomad node %s is draining", node.Name)
+	case node.SchedulingEligibility != "eligible":
+		return fmt.Sprintf("nomad node %s is ineligible", node.Name)
+	case datacenter != "" && node.Datacenter != datacenter:
+		return fmt.Sprintf("nomad node %s is in datacenter %s", node.Name, node.Datacenter)
+	}
+	return ""
+}
+
+// collectNodeLoad 查询节点的allocation数量和资源使用率
+func collectNodeLoad(nomad *nomadapi.Client, c *placement.Candidate) {
+	allocs, _, err := nomad.Nodes().Allocations(c.NodeID, nil)
+	if err != nil {
+		c.Reasons = append(c.Reasons, "allocations unavailable")
+	}
+	for _, alloc := range allocs {
+		if alloc.ClientStatus == nomadapi.AllocClientStatusRunning || alloc.ClientStatus == nomadapi.AllocClientStatusPending {
+			c.Allocations++
+		}
+	}
+	stats, err := nomad.Nodes().Stats(c.NodeID, nil)
+	if err != nil || stats == nil {
+		// 无法获取时按半负载计算，避免排在有数据的节点前面
+		c.CPUUsage, c.MemoryUsage = 50, 50
+		c.Reasons = append(c.Reasons, "node stats unavailable")
+		return
+	}
+	if len(stats.CPU) > 0 {
+		var idle float64
+		for _, cpu := range stats.CPU {
+			idle += cpu.Idle
+		}
+		c.CPUUsage = math.Round((100-idle/float64(len(stats.CPU)))*100) / 100
+	}
+	if stats.Memory != nil && stats.Memory.Total > 0 {
+		c.MemoryUsage = math.Round(float64(stats.Memory.Used)/float64(stats.Memory.Total)*10000) / 100
+	}
+}
+
+// missingLabels 返回主机缺少的标签，主机标签以逗号、分号或空格分隔
+func missingLabels(hostLabels string, required []string) []string {
+	labels := strings.FieldsFunc(hostLabels, func(r rune) bool { return r == ',' || r == ';' || r == ' ' })
+	var missing []string
+	for _, label := range required {
+		if !slices.Contains(labels, label) {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,24 @@
+package pkg_test
+
+import (
+	"saurfang/internal/models/placement"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRankCandidates 测试同渠道反亲和以及负载排序
+func TestRankCandidates(t *testing.T) {
+	candidates := []placement.Candidate{
+		{Hostname: "busy", CPUUsage: 80, MemoryUsage: 70, Allocations: 10, GameServers: 8},
+		{Hostname: "same-channel", CPUUsage: 10, MemoryUsage: 10, Allocations: 2, GameServers: 2, SameChannel: 2},
+		{Hostname: "idle", CPUUsage: 10, MemoryUsage: 10, Allocations: 2, GameServers: 2},
+	}
+	ranked := pkg.RankCandidates(candidates, placement.DefaultWeights)
+	assert.Equal(t, "idle", ranked[0].Hostname)
+	assert.Equal(t, "same-channel", ranked[1].Hostname)
+	assert.Equal(t, "busy", ranked[2].Hostname)
+	assert.Greater(t, ranked[0].Score, ranked[1].Score)
+	assert.NotEmpty(t, ranked[1].Reasons)
+}
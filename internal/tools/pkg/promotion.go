@@ -0,0 +1,260 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"saurfang/internal/config"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/models/environment"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/ntfy"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gorm.io/gorm"
+)
+
+// ErrNoNextEnvironment 已经是最后一个环境
+var ErrNoNextEnvironment = errors.New("no next environment")
+
+// NextEnvironment 按Stage取下一个环境
+func NextEnvironment(env *environment.Environment) (*environment.Environment, error) {
+	var next environment.Environment
+	if err := config.DB.Where("stage > ?", env.Stage).Order("stage").First(&next).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoNextEnvironment
+		}
+		return nil, err
+	}
+	return &next, nil
+}
+
+// PromoteConfigContent 把源环境的配置转换为目标环境的配置
+// nomad job替换namespace和region，json格式的配置原样复制
+func PromoteConfigContent(src string, target *environment.Environment) (string, error) {
+	src = strings.ReplaceAll(src, "\r", "")
+	if strings.HasPrefix(strings.TrimSpace(src), "{") {
+		return src, nil
+	}
+	return tools.SetJobPlacement(src, target.NomadNamespace, target.NomadRegion)
+}
+
+// environmentConsul 环境中存放游戏服配置的consul，环境未指定集群时使用游戏服所属集群
+func environmentConsul(env *environment.Environment, serverID string) (*consulapi.Client, error) {
+	var cluster *config.Cluster
+	var err error
+	if env.Cluster != "" {
+		cluster, err = config.GetCluster(env.Cluster)
+	} else {
+		cluster, err = ClusterOfServer(serverID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	consul := cluster.Consul()
+	if consul == nil {
+		return nil, fmt.Errorf("cluster %s of environment %s has no consul client", cluster.Name, env.Name)
+	}
+	return consul, nil
+}
+
+// CanDecidePromotion 审批晋级需要审批权限，申请人不能审批或拒绝自己的晋级
+// 按用户ID判断是否为申请人，operatorID为0时无法确认身份，不允许审批
+func CanDecidePromotion(p *environment.Promotion, operator string, operatorID uint) bool {
+	if operator == "" || operatorID == 0 || operatorID == p.RequesterID || operator == p.Requester {
+		return false
+	}
+	return UserHasPermission(operator, approval.ApprovePermission)
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// PreparePromotion 生成晋级记录，计算与目标环境的差异，不修改目标环境
+func PreparePromotion(payload *environment.PromotePayload, requester string) (*environment.Promotion, *environment.Environment, error) {
+	var source environment.Environment
+	if err := config.DB.First(&source, payload.SourceEnvID).Error; err != nil {
+		return nil, nil, fmt.Errorf("source environment not found: %v", err)
+	}
+	target, err := NextEnvironment(&source)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &environment.Promotion{
+		Kind:        payload.Kind,
+		Artifact:    strings.Trim(payload.Artifact, "/"),
+		SourceEnvID: source.ID,
+		SourceEnv:   source.Name,
+		TargetEnvID: target.ID,
+		TargetEnv:   target.Name,
+		Requester:   requester,
+		Comment:     payload.Comment,
+		Status:      environment.StatusPending,
+	}
+	if p.Artifact == "" {
+		return nil, nil, errors.New("artifact is required")
+	}
+	switch p.Kind {
+	case environment.KindConfig:
+		// 源环境和目标环境的配置可能在不同集群的consul
+		sourceConsul, err := environmentConsul(&source, p.Artifact)
+		if err != nil {
+			return nil, nil, err
+		}
+		targetConsul, err := environmentConsul(target, p.Artifact)
+		if err != nil {
+			return nil, nil, err
+		}
+		pair, _, err := sourceConsul.KV().Get(tools.AddNamespace(p.Artifact, source.ConsulPrefix), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pair == nil {
+			return nil, nil, fmt.Errorf("config %s not found in %s", p.Artifact, source.Name)
+		}
+		content, err := PromoteConfigContent(string(pair.Value), target)
+		if err != nil {
+			return nil, nil, err
+		}
+		var current string
+		existing, _, err := targetConsul.KV().Get(tools.AddNamespace(p.Artifact, target.ConsulPrefix), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if existing != nil {
+			current = strings.ReplaceAll(string(existing.Value), "\r", "")
+			p.TargetModifyIndex = existing.ModifyIndex
+		}
+		p.Content = content
+		p.Checksum = checksum(content)
+		p.Diff = tools.UnifiedDiff(p.Artifact, current, content)
+	case environment.KindPackage:
+		if source.DatasourceID == 0 || target.DatasourceID == 0 {
+			return nil, nil, errors.New("datasource of source or target environment is not set")
+		}
+		sums, err := tools.OssChecksums(source.DatasourceID, p.Artifact)
+		if err != nil {
+			return nil, nil, err
+		}
+		current, err := tools.OssChecksums(target.DatasourceID, p.Artifact)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.Checksum = checksum(sums)
+		p.Diff = tools.UnifiedDiff(p.Artifact, current, sums)
+	default:
+		return nil, nil, fmt.Errorf("unsupported kind %s", p.Kind)
+	}
+	// 血缘: 最近一次把该内容带入源环境的晋级
+	var parent environment.Promotion
+	if err := config.DB.Where("kind = ? AND artifact = ? AND target_env_id = ? AND status = ?", p.Kind, p.Artifact, source.ID, environment.StatusApplied).
+		Order("id DESC").Limit(1).Find(&parent).Error; err == nil && parent.ID > 0 {
+		p.ParentID = &parent.ID
+	}
+	return p, target, nil
+}
+
+// ApplyPromotion 执行晋级并更新状态
+// 配置按申请时目标的ModifyIndex做CAS写入，目标在申请后被修改过时失败，需要重新申请
+// 安装包重新计算源环境的校验和，与申请时不一致时失败
+func ApplyPromotion(p *environment.Promotion) error {
+	var target, source environment.Environment
+	if err := config.DB.First(&target, p.TargetEnvID).Error; err != nil {
+		return err
+	}
+	if err := config.DB.First(&source, p.SourceEnvID).Error; err != nil {
+		return err
+	}
+	applyErr := applyPromotion(p, &source, &target)
+	name := fmt.Sprintf("promote %s %s %s -> %s", p.Kind, p.Artifact, p.SourceEnv, p.TargetEnv)
+	if applyErr != nil {
+		p.Status = environment.StatusFailed
+		p.Error = applyErr.Error()
+		config.DB.Model(p).Updates(map[string]any{"status": p.Status, "error": p.Error, "approver": p.Approver, "approver_id": p.ApproverID})
+		ntfy.PublishNotification(notify.EventTypePromotion, name, nil, []string{p.Artifact}, 0, 1)
+		return applyErr
+	}
+	now := time.Now()
+	p.Status = environment.StatusApplied
+	p.AppliedAt = &now
+	if err := config.DB.Model(p).Updates(map[string]any{"status": p.Status, "applied_at": now, "approver": p.Approver, "approver_id": p.ApproverID}).Error; err != nil {
+		return err
+	}
+	ntfy.PublishNotification(notify.EventTypePromotion, name, []string{p.Artifact}, nil, 1, 0)
+	return nil
+}
+
+// applyPromotion 锁定晋级的内容后写入目标环境，配置锁定游戏服，安装包锁定目标环境中的同名安装包
+func applyPromotion(p *environment.Promotion, source, target *environment.Environment) error {
+	owner := p.Approver
+	if owner == "" {
+		owner = p.Requester
+	}
+	var lease *Lease
+	var err error
+	if p.Kind == environment.KindConfig {
+		lease, err = LockServer(p.Artifact, owner, "promote")
+	} else {
+		lease, err = LockGlobal(fmt.Sprintf("package:%d:%s", target.DatasourceID, p.Artifact), owner, "promote")
+	}
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+	switch p.Kind {
+	case environment.KindConfig:
+		consul, err := environmentConsul(target, p.Artifact)
+		if err != nil {
+			return err
+		}
+		ok, _, err := consul.KV().CAS(&consulapi.KVPair{
+			Key:         tools.AddNamespace(p.Artifact, target.ConsulPrefix),
+			Value:       []byte(p.Content),
+			ModifyIndex: p.TargetModifyIndex,
+		}, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("config %s in %s was modified after the promotion was requested", p.Artifact, target.Name)
+		}
+	case environment.KindPackage:
+		// 只晋级审批时看到的内容，源环境中的安装包在申请后变化时失败
+		sums, err := tools.OssChecksums(source.DatasourceID, p.Artifact)
+		switch {
+		case err != nil:
+			return err
+		case checksum(sums) != p.Checksum:
+			return fmt.Errorf("package %s in %s was modified after the promotion was requested", p.Artifact, source.Name)
+		default:
+			return tools.CopyBetweenOss(source.DatasourceID, target.DatasourceID, p.Artifact)
+		}
+	}
+	return nil
+}
+
+// PromotionLineage 从指定晋级沿ParentID向上追溯，返回从最早到当前的链
+func PromotionLineage(id uint) ([]environment.Promotion, error) {
+	var chain []environment.Promotion
+	seen := make(map[uint]bool)
+	next := &id
+	for next != nil && !seen[*next] {
+		seen[*next] = true
+		var p environment.Promotion
+		if err := config.DB.Omit("content", "diff").First(&p, *next).Error; err != nil {
+			if len(chain) > 0 && errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			return nil, err
+		}
+		chain = append([]environment.Promotion{p}, chain...)
+		next = p.ParentID
+	}
+	return chain, nil
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/tools"
+	"strings"
+	"sync"
+	"time"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// RegisterGameJob 从游戏服所属集群的consul读取配置并重新注册nomad job，返回evalID
+func RegisterGameJob(serverID string) (string, error) {
+	cluster, err := ClusterOfServer(serverID)
+	if err != nil {
+		return "", err
+	}
+	key := tools.AddNamespace(serverID, os.Getenv("GAME_NOMAD_JOB_NAMESPACE"))
+	pair, _, err := cluster.Consul().KV().Get(key, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get config for server %s: %v", serverID, err)
+	}
+	if pair == nil || pair.Value == nil {
+		return "", fmt.Errorf("no config found for server %s", serverID)
+	}
+	source := strings.ReplaceAll(string(pair.Value), "\r", "")
+	job, err := cluster.Nomad().Jobs().ParseHCL(source, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse job hcl config file: %v", err)
+	}
+	res, err := RegisterJobWithSource(cluster.Nomad(), job, source)
+	if err != nil {
+		return "", fmt.Errorf("failed to register job: %v", err)
+	}
+	SetGameStatus(serverID, 1, "redeploy")
+	return res.EvalID, nil
+}
+
+// redeployDeploymentTimeout 重新注册后等待deployment结束的时间
+const redeployDeploymentTimeout = 10 * time.Minute
+
+// waitRedeployed 等待重新注册产生的deployment结束，没有deployment时直接返回，deployment不成功时发送通知并返回错误
+func waitRedeployed(serverID, evalID string) error {
+	cluster, err := ClusterOfServer(serverID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redeployDeploymentTimeout)
+	defer cancel()
+	client := cluster.Nomad()
+	deploymentID, err := EvalDeployment(ctx, client, evalID)
+	if err != nil || deploymentID == "" {
+		return err
+	}
+	d, err := WatchDeployment(ctx, client, deploymentID, func(d *nomadapi.Deployment) {
+		slog.Info("rolling redeploy progress", "server_id", serverID, "deployment", DeploymentSummary(d))
+	})
+	if err != nil {
+		return fmt.Errorf("watch deployment %s failed: %v", deploymentID, err)
+	}
+	if d.Status != nomadapi.DeploymentStatusSuccessful {
+		return errors.New(HandleFailedDeployment(cluster, serverID, d, false))
+	}
+	return nil
+}
+
+// RollingRedeploy 分批重新注册游戏服job，每批等待deployment成功后再等待interval进入下一批
+// 某一批有失败时停止，剩余的游戏服不再重新注册，计入失败
+func RollingRedeploy(serverIDs []string, batchSize int, interval time.Duration) (successJobs, failedJobs []string) {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	var mu sync.Mutex
+	for start := 0; start < len(serverIDs); start += batchSize {
+		end := min(start+batchSize, len(serverIDs))
+		batchFailed := false
+		var wg sync.WaitGroup
+		for _, serverID := range serverIDs[start:end] {
+			wg.Add(1)
+			go func(serverID string) {
+				defer wg.Done()
+				evalID, err := RegisterGameJob(serverID)
+				if err == nil {
+					err = waitRedeployed(serverID, evalID)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					slog.Error("rolling redeploy failed", "server_id", serverID, "error", err)
+					failedJobs = append(failedJobs, serverID)
+					batchFailed = true
+					return
+				}
+				slog.Info("rolling redeploy success", "server_id", serverID, "eval_id", evalID)
+				successJobs = append(successJobs, serverID)
+			}(serverID)
+		}
+		wg.Wait()
+		if batchFailed && end < len(serverIDs) {
+			slog.Warn("rolling redeploy stopped after failed batch", "skipped", strings.Join(serverIDs[end:], ","))
+			failedJobs = append(failedJobs, serverIDs[end:]...)
+			break
+		}
+		if end < len(serverIDs) && interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	return successJobs, failedJobs
+}
@@ -0,0 +1,313 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gamechannel"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/models/serverconfig"
+	"saurfang/internal/models/serverlist"
+	"saurfang/internal/tools"
+	"saurfang/internal/tools/ntfy"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverListTrigger 触发服务器列表重新发布
+var serverListTrigger = make(chan struct{}, 1)
+
+// serverListMu 同一时间只允许一个发布
+var serverListMu sync.Mutex
+
+// TriggerServerListPublish 通知发布器检查服务器列表是否有变化，不会阻塞
+func TriggerServerListPublish() {
+	select {
+	case serverListTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// StartServerListPublisher 定时或收到变更通知时检查各渠道的服务器列表，内容变化时重新发布
+func StartServerListPublisher() {
+	interval, _ := strconv.Atoi(os.Getenv("SERVER_LIST_INTERVAL"))
+	if interval <= 0 {
+		interval = 60 // 默认60秒
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-serverListTrigger:
+			// 合并短时间内的多次变更
+			time.Sleep(3 * time.Second)
+		}
+		var settings []serverlist.ServerListSetting
+		if err := config.DB.Where("enabled = ?", true).Find(&settings).Error; err != nil {
+			slog.Error("failed to query server list settings", "error", err)
+			continue
+		}
+		for _, setting := range settings {
+			if _, err := PublishServerList(setting.ChannelID, serverlist.TriggerChange, false); err != nil {
+				slog.Error("failed to publish server list", "channel_id", setting.ChannelID, "error", err)
+			}
+		}
+	}
+}
+
+// RenderServerList 生成渠道的服务器列表
+func RenderServerList(channelID uint) (*serverlist.ServerList, error) {
+	var channel gamechannel.Channels
+	if err := config.DB.First(&channel, channelID).Error; err != nil {
+		return nil, err
+	}
+	var games []gameserver.Games
+	if err := config.DB.Where("channel_id = ?", channelID).Find(&games).Error; err != nil {
+		return nil, err
+	}
+	var flagRows []serverlist.ServerListFlag
+	if err := config.DB.Find(&flagRows).Error; err != nil {
+		return nil, err
+	}
+	flags := make(map[string]serverlist.ServerListFlag, len(flagRows))
+	for _, f := range flagRows {
+		flags[f.ServerID] = f
+	}
+	var hostRows []struct {
+		ServerID  string
+		PublicIP  string
+		PrivateIP string
+	}
+	if err := config.DB.Raw(`SELECT g.server_id, h.public_ip, h.private_ip FROM game_hosts gh
+		JOIN games g ON gh.game_id = g.id JOIN hosts h ON gh.host_id = h.id WHERE g.channel_id = ? ORDER BY gh.id`, channelID).
+		Scan(&hostRows).Error; err != nil {
+		return nil, err
+	}
+	hostAddr := make(map[string]string)
+	for _, h := range hostRows {
+		if _, ok := hostAddr[h.ServerID]; ok {
+			continue
+		}
+		hostAddr[h.ServerID] = h.PublicIP
+		if h.PublicIP == "" {
+			hostAddr[h.ServerID] = h.PrivateIP
+		}
+	}
+	// 游戏服可能分布在不同集群，按集群读取配置和任务状态
+	names := make(map[string]string, len(games))
+	for _, g := range games {
+		names[g.ServerID] = ResolveCluster(g.Cluster, channel.Cluster)
+	}
+	ns := os.Getenv("GAME_NOMAD_JOB_NAMESPACE")
+	settings := make(map[string]string)
+	jobStatus := make(map[string]string)
+	for name, ids := range ServersByCluster(names) {
+		cluster, err := config.GetCluster(name)
+		if err != nil {
+			slog.Warn("server list cluster unavailable", "channel_id", channelID, "cluster", name, "error", err)
+			continue
+		}
+		members := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			members[id] = true
+		}
+		if consul := cluster.Consul(); consul != nil {
+			pairs, _, err := consul.KV().List(ns, nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, pair := range pairs {
+				if id := tools.RemoveNamespace(pair.Key, ns); members[id] {
+					settings[id] = string(pair.Value)
+				}
+			}
+		}
+		if nomad := cluster.Nomad(); nomad != nil {
+			if jobs, _, err := nomad.Jobs().List(nil); err == nil {
+				for _, job := range jobs {
+					if members[job.ID] {
+						jobStatus[job.ID] = job.Status
+					}
+				}
+			}
+		}
+	}
+	newDays, _ := strconv.Atoi(os.Getenv("SERVER_LIST_NEW_DAYS"))
+	if newDays <= 0 {
+		newDays = 7
+	}
+
+	list := &serverlist.ServerList{ChannelID: channel.ID, Channel: channel.Name, Servers: []serverlist.ServerEntry{}}
+	order := make(map[string]int)
+	for _, g := range games {
+		flag := flags[g.ServerID]
+		if flag.Hidden {
+			continue
+		}
+		entry := serverlist.ServerEntry{
+			ServerID:    g.ServerID,
+			Name:        g.Name,
+			Address:     hostAddr[g.ServerID],
+			Maintenance: flag.Maintenance,
+			Tags:        []string{},
+		}
+		if ip, port := serverEndpoint(settings[g.ServerID]); port > 0 {
+			entry.Port = port
+			if ip != "" {
+				entry.Address = ip
+			}
+		}
+		if flag.Address != "" {
+			entry.Address = flag.Address
+		}
+		if flag.Port > 0 {
+			entry.Port = flag.Port
+		}
+		switch status, ok := jobStatus[g.ServerID]; {
+		case flag.Maintenance:
+			entry.Status = serverlist.StatusMaintenance
+		case ok && status == "running", !ok && g.Status == "1":
+			entry.Status = serverlist.StatusOnline
+		default:
+			entry.Status = serverlist.StatusOffline
+		}
+		if flag.Recommended {
+			entry.Tags = append(entry.Tags, serverlist.TagRecommended)
+		}
+		if flag.New || (g.OpenAt != nil && time.Since(*g.OpenAt) < time.Duration(newDays)*24*time.Hour) {
+			entry.Tags = append(entry.Tags, serverlist.TagNew)
+		}
+		order[g.ServerID] = flag.SortOrder
+		list.Servers = append(list.Servers, entry)
+	}
+	sort.SliceStable(list.Servers, func(i, j int) bool {
+		a, b := list.Servers[i], list.Servers[j]
+		if order[a.ServerID] != order[b.ServerID] {
+			return order[a.ServerID] > order[b.ServerID]
+		}
+		return a.ServerID < b.ServerID
+	})
+	return list, nil
+}
+
+// serverEndpoint 从游戏服配置中取连接地址和端口
+// json格式配置取第一个有端口的服务，nomad job取第一个static端口
+func serverEndpoint(setting string) (string, int) {
+	if setting == "" {
+		return "", 0
+	}
+	var gc serverconfig.GameConfigs
+	if err := json.Unmarshal([]byte(setting), &gc); err == nil {
+		for _, c := range gc.Configs {
+			if c.Port > 0 {
+				return c.IP, c.Port
+			}
+		}
+		return "", 0
+	}
+	ports, err := tools.ExtractJobPorts(strings.ReplaceAll(setting, "\r", ""))
+	if err != nil || len(ports) == 0 {
+		return "", 0
+	}
+	return "", ports[0]
+}
+
+// PublishServerList 发布渠道的服务器列表，内容没有变化且force为false时不会上传，返回nil
+func PublishServerList(channelID uint, trigger string, force bool) (*serverlist.ServerListVersion, error) {
+	serverListMu.Lock()
+	defer serverListMu.Unlock()
+	var setting serverlist.ServerListSetting
+	if err := config.DB.Where("channel_id = ?", channelID).First(&setting).Error; err != nil {
+		return nil, fmt.Errorf("server list setting for channel %d not found: %v", channelID, err)
+	}
+	list, err := RenderServerList(channelID)
+	if err != nil {
+		return nil, err
+	}
+	// 校验和不包含版本号和生成时间
+	body, err := json.Marshal(list.Servers)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	checksum := hex.EncodeToString(sum[:])
+	var latest serverlist.ServerListVersion
+	if err := config.DB.Where("channel_id = ? AND pending = ?", channelID, false).Order("id DESC").Limit(1).Find(&latest).Error; err != nil {
+		return nil, fmt.Errorf("failed to load latest server list version: %v", err)
+	}
+	if !force && latest.Checksum == checksum {
+		return nil, nil
+	}
+	// 先写入上传中的版本记录，使用自增ID作为版本号，多实例或同一秒内多次发布也不会重复
+	// 两次上传都成功后才标记为已发布，中途失败或进程退出时不会被当作最新版本
+	version := serverlist.ServerListVersion{
+		ChannelID: channelID,
+		Checksum:  checksum,
+		Trigger:   trigger,
+		Pending:   true,
+	}
+	if err := config.DB.Create(&version).Error; err != nil {
+		return nil, err
+	}
+	published := false
+	defer func() {
+		if !published {
+			config.DB.Delete(&version)
+		}
+	}()
+	list.Version = strconv.Itoa(int(version.ID))
+	list.GeneratedAt = time.Now()
+	content, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	dir := strings.Trim(setting.Path, "/")
+	if dir == "" {
+		dir = "serverlist"
+	}
+	versionPath := path.Join(dir, "versions", strconv.Itoa(int(channelID)), list.Version+".json")
+	if err := tools.UploadContentToOss(setting.DatasourceID, versionPath, content); err != nil {
+		return nil, fmt.Errorf("failed to upload server list version: %v", err)
+	}
+	if err := tools.UploadContentToOss(setting.DatasourceID, path.Join(dir, strconv.Itoa(int(channelID))+".json"), content); err != nil {
+		ntfy.PublishNotification(notify.EventTypeServerList, fmt.Sprintf("publish server list %s", list.Channel), nil, []string{list.Channel}, 0, 1)
+		return nil, fmt.Errorf("failed to upload server list: %v", err)
+	}
+	version.Version, version.Path, version.Content, version.Pending = list.Version, versionPath, string(content), false
+	if err := config.DB.Model(&version).Updates(map[string]any{"version": version.Version, "path": versionPath, "content": version.Content, "pending": false}).Error; err != nil {
+		return nil, err
+	}
+	published = true
+	pruneServerListVersions(&setting)
+	ntfy.PublishNotification(notify.EventTypeServerList, fmt.Sprintf("publish server list %s", list.Channel), []string{list.Channel}, nil, 1, 0)
+	return &version, nil
+}
+
+// pruneServerListVersions 删除超出保留数量的历史版本
+func pruneServerListVersions(setting *serverlist.ServerListSetting) {
+	keep := setting.KeepVersions
+	if keep <= 0 {
+		keep = 20
+	}
+	var old []serverlist.ServerListVersion
+	if err := config.DB.Select("id", "path").Where("channel_id = ? AND pending = ?", setting.ChannelID, false).
+		Order("id DESC").Offset(keep).Limit(1000).Find(&old).Error; err != nil {
+		return
+	}
+	for _, v := range old {
+		if err := tools.DeleteFromOss(setting.DatasourceID, v.Path); err != nil {
+			slog.Error("failed to delete server list version", "path", v.Path, "error", err)
+		}
+		config.DB.Delete(&v)
+	}
+}
@@ -0,0 +1,262 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// serverLockKey 操作锁的redis key，lock:server:<server_id>、lock:channel:<channel_id>、lock:global:<name>
+	serverLockKey = "lock:%s:%s"
+	// serverLockTTL 锁的租期，持有期间定时续期，进程退出后自动过期
+	serverLockTTL = 2 * time.Minute
+)
+
+// releaseLockScript 只删除自己持有的锁，强制释放后原持有者不会误删新锁
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// renewLockScript 只续期自己持有的锁
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// lockServerScript 所属渠道都未被锁定时才锁定游戏服，检查和加锁在一个脚本中完成
+// KEYS[1]为游戏服锁，其余为渠道锁，返回0表示成功，否则为冲突的key的序号(从1开始)
+var lockServerScript = redis.NewScript(`
+for i = 2, #KEYS do
+	if redis.call("EXISTS", KEYS[i]) == 1 then
+		return i
+	end
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 0
+end
+return 1`)
+
+// LockConflictError 锁已被其他操作持有
+type LockConflictError struct {
+	Info gameserver.LockInfo
+}
+
+func (e *LockConflictError) Error() string {
+	return fmt.Sprintf("%s %s is locked by %s since %s for op %s", e.Info.Kind, e.Info.ID, e.Info.Owner, e.Info.Since.Format(time.DateTime), e.Info.Operation)
+}
+
+// Lease 持有的一组锁，Release后停止续期并释放
+type Lease struct {
+	keys  []string
+	value string
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// Release 释放锁，可以重复调用，nil时不做任何操作
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.stop)
+		for _, key := range l.keys {
+			if err := releaseLockScript.Run(context.Background(), config.CahceClient, []string{key}, l.value).Err(); err != nil {
+				slog.Error("failed to release lock", "key", key, "error", err)
+			}
+		}
+	})
+}
+
+// renew 定时续期直到Release
+func (l *Lease) renew() {
+	ticker := time.NewTicker(serverLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			for _, key := range l.keys {
+				if err := renewLockScript.Run(context.Background(), config.CahceClient, []string{key}, l.value, serverLockTTL.Milliseconds()).Err(); err != nil {
+					slog.Error("failed to renew lock", "key", key, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// lockOf 查询锁的持有者，未加锁时返回nil
+func lockOf(kind, id string) (*gameserver.LockInfo, error) {
+	val, err := config.CahceClient.Get(context.Background(), fmt.Sprintf(serverLockKey, kind, id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info gameserver.LockInfo
+	if err := json.Unmarshal([]byte(val), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// channelsOfServers 游戏服所属的渠道
+func channelsOfServers(serverIDs []string) ([]string, error) {
+	var channels []uint
+	if err := config.DB.Table("games").Distinct("channel_id").
+		Where("server_id IN ? AND channel_id IS NOT NULL AND deleted_at IS NULL", serverIDs).
+		Order("channel_id").Pluck("channel_id", &channels).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(channels))
+	for _, c := range channels {
+		ids = append(ids, strconv.FormatUint(uint64(c), 10))
+	}
+	return ids, nil
+}
+
+// newLease 生成锁的值，包含持有者信息和随机token
+func newLease(owner, op string) *Lease {
+	token := make([]byte, 8)
+	rand.Read(token)
+	value, _ := json.Marshal(gameserver.LockInfo{Owner: owner, Operation: op, Since: time.Now(), Token: hex.EncodeToString(token)})
+	return &Lease{value: string(value), stop: make(chan struct{})}
+}
+
+// lockConflict 加锁失败时查询持有者，返回冲突错误
+func lockConflict(kind, id string) error {
+	info, err := lockOf(kind, id)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		// 刚好过期，视为冲突由调用方重试
+		return fmt.Errorf("%s %s is being released, retry later", kind, id)
+	}
+	info.Kind, info.ID = kind, id
+	return &LockConflictError{Info: *info}
+}
+
+// acquireLocks 按顺序加锁，任意一个冲突时释放已获得的锁
+func acquireLocks(targets [][2]string, owner, op string) (*Lease, error) {
+	lease := newLease(owner, op)
+	for _, t := range targets {
+		key := fmt.Sprintf(serverLockKey, t[0], t[1])
+		ok, err := config.CahceClient.SetNX(context.Background(), key, lease.value, serverLockTTL).Result()
+		if err == nil && !ok {
+			err = lockConflict(t[0], t[1])
+		}
+		if err != nil {
+			lease.Release()
+			return nil, err
+		}
+		lease.keys = append(lease.keys, key)
+	}
+	go lease.renew()
+	return lease, nil
+}
+
+// LockServer 锁定单个游戏服，所属渠道被批量操作锁定时冲突
+func LockServer(serverID, owner, op string) (*Lease, error) {
+	channels, err := channelsOfServers([]string{serverID})
+	if err != nil {
+		return nil, err
+	}
+	targets := [][2]string{{gameserver.LockServer, serverID}}
+	for _, c := range channels {
+		targets = append(targets, [2]string{gameserver.LockChannel, c})
+	}
+	keys := make([]string, 0, len(targets))
+	for _, t := range targets {
+		keys = append(keys, fmt.Sprintf(serverLockKey, t[0], t[1]))
+	}
+	lease := newLease(owner, op)
+	n, err := lockServerScript.Run(context.Background(), config.CahceClient, keys, lease.value, serverLockTTL.Milliseconds()).Int()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && n <= len(targets) {
+		return nil, lockConflict(targets[n-1][0], targets[n-1][1])
+	}
+	lease.keys = keys[:1]
+	go lease.renew()
+	return lease, nil
+}
+
+// LockServers 锁定操作涉及的游戏服，多个游戏服时同时锁定所属渠道，全部成功或全部失败
+func LockServers(serverIDs []string, owner, op string) (*Lease, error) {
+	if len(serverIDs) == 1 {
+		return LockServer(serverIDs[0], owner, op)
+	}
+	channels, err := channelsOfServers(serverIDs)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([][2]string, 0, len(channels)+len(serverIDs))
+	for _, c := range channels {
+		targets = append(targets, [2]string{gameserver.LockChannel, c})
+	}
+	seen := make(map[string]bool, len(serverIDs))
+	for _, id := range serverIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		targets = append(targets, [2]string{gameserver.LockServer, id})
+	}
+	return acquireLocks(targets, owner, op)
+}
+
+// LockGlobal 锁定与游戏服无关的共享资源
+func LockGlobal(name, owner, op string) (*Lease, error) {
+	return acquireLocks([][2]string{{gameserver.LockGlobal, name}}, owner, op)
+}
+
+// ListLocks 当前所有的操作锁
+func ListLocks() ([]gameserver.LockInfo, error) {
+	ctx := context.Background()
+	var locks []gameserver.LockInfo
+	iter := config.CahceClient.Scan(ctx, 0, "lock:*", 100).Iterator()
+	for iter.Next(ctx) {
+		parts := strings.SplitN(iter.Val(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		info, err := lockOf(parts[1], parts[2])
+		if err != nil || info == nil {
+			continue
+		}
+		info.Kind, info.ID, info.Token = parts[1], parts[2], ""
+		info.TTL = config.CahceClient.TTL(ctx, iter.Val()).Val()
+		locks = append(locks, *info)
+	}
+	return locks, iter.Err()
+}
+
+// ForceReleaseLock 强制释放锁，原持有者的续期和释放不再生效
+func ForceReleaseLock(kind, id string) (*gameserver.LockInfo, error) {
+	info, err := lockOf(kind, id)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	info.Kind, info.ID, info.Token = kind, id, ""
+	return info, config.CahceClient.Del(context.Background(), fmt.Sprintf(serverLockKey, kind, id)).Err()
+}
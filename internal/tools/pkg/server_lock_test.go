@@ -0,0 +1,48 @@
+package pkg_test
+
+import (
+	"errors"
+	"fmt"
+	"saurfang/internal/config"
+	"saurfang/internal/testutils"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLockServerConflict 测试游戏服已被其他操作锁定时返回持有者信息
+func TestLockServerConflict(t *testing.T) {
+	mockDB := testutils.SetupMockDB(t)
+	defer mockDB.Close()
+	config.DB = mockDB.DB
+	rdb, rdbmock := redismock.NewClientMock()
+	defer rdb.Close()
+	config.CahceClient = rdb
+
+	mockDB.Mock.ExpectQuery("SELECT DISTINCT channel_id FROM `games`").
+		WillReturnRows(sqlmock.NewRows([]string{"channel_id"}).AddRow(3))
+	// 检查渠道锁和加锁在同一个脚本中执行，返回1表示游戏服锁冲突
+	rdbmock.CustomMatch(func(expected, actual []interface{}) error {
+		if fmt.Sprint(actual[0], actual[2:5]) != "evalsha[2 lock:server:1024 lock:channel:3]" {
+			return errors.New("unexpected command")
+		}
+		return nil
+	}).ExpectEvalSha("", []string{"lock:server:1024", "lock:channel:3"}, "", 0).SetVal(int64(1))
+	rdbmock.ExpectGet("lock:server:1024").SetVal(`{"owner":"alice","operation":"start","since":"2025-08-01T22:00:00+08:00","token":"abc"}`)
+
+	lease, err := pkg.LockServer("1024", "bob", "stop")
+	assert.Nil(t, lease)
+	var conflict *pkg.LockConflictError
+	if !assert.ErrorAs(t, err, &conflict) {
+		return
+	}
+	assert.Equal(t, "alice", conflict.Info.Owner)
+	assert.Equal(t, "server", conflict.Info.Kind)
+	assert.Contains(t, err.Error(), "server 1024 is locked by alice since ")
+	assert.Contains(t, err.Error(), " for op start")
+	mockDB.ExpectationsWereMet(t)
+	assert.NoError(t, rdbmock.ExpectationsWereMet())
+}
@@ -0,0 +1,301 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"saurfang/internal/config"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/notify"
+	"saurfang/internal/tools/ntfy"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"golang.org/x/sync/singleflight"
+)
+
+// serviceHealthTTL 服务健康信息缓存时间，避免列表页每次请求都遍历consul服务目录
+const serviceHealthTTL = 10 * time.Second
+
+var serviceHealthCache struct {
+	sync.Mutex
+	at   time.Time
+	data map[string][]gameserver.ServiceHealth
+	err  error
+}
+
+// serviceHealthGroup 合并同时进行的汇总，缓存过期时只有一个请求访问consul
+var serviceHealthGroup singleflight.Group
+
+// ClusterErrors 部分集群汇总失败时的错误，集群名称 -> 错误
+type ClusterErrors map[string]error
+
+func (e ClusterErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("cluster %s: %v", name, e[name]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ServiceServerID 判断consul服务属于哪个游戏服
+// 依次匹配meta中的server_id、"server_id=xxx"标签、服务ID、服务名，不属于任何游戏服时返回空
+func ServiceServerID(serviceID, service string, tags []string, meta map[string]string, known map[string]bool) string {
+	if id := meta["server_id"]; id != "" && known[id] {
+		return id
+	}
+	for _, tag := range tags {
+		if id, ok := strings.CutPrefix(tag, "server_id="); ok && known[id] {
+			return id
+		}
+	}
+	if known[serviceID] {
+		return serviceID
+	}
+	if known[service] {
+		return service
+	}
+	return ""
+}
+
+// WorstHealth 汇总多个状态，critical > warning > passing，没有状态时为unknown
+func WorstHealth(statuses ...string) string {
+	rank := map[string]int{gameserver.HealthPassing: 1, gameserver.HealthWarning: 2, gameserver.HealthCritical: 3}
+	worst := gameserver.HealthUnknown
+	for _, s := range statuses {
+		if s == consulapi.HealthMaint {
+			s = gameserver.HealthCritical
+		}
+		if rank[s] > rank[worst] {
+			worst = s
+		}
+	}
+	return worst
+}
+
+// knownServerIDs 所有游戏服ID
+func knownServerIDs() (map[string]bool, error) {
+	var ids []string
+	if err := config.DB.Model(&gameserver.Games{}).Pluck("server_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+	return known, nil
+}
+
+// CollectServiceHealth 按游戏服ID汇总所有集群consul中注册的服务和健康检查，结果缓存10秒
+// 部分集群失败时返回其他集群的结果和ClusterErrors，查询游戏服失败时结果为nil
+func CollectServiceHealth() (map[string][]gameserver.ServiceHealth, error) {
+	serviceHealthCache.Lock()
+	if serviceHealthCache.data != nil && time.Since(serviceHealthCache.at) < serviceHealthTTL {
+		data, err := serviceHealthCache.data, serviceHealthCache.err
+		serviceHealthCache.Unlock()
+		return data, err
+	}
+	serviceHealthCache.Unlock()
+	v, err, _ := serviceHealthGroup.Do("collect", func() (any, error) {
+		data, err := collectServiceHealth()
+		if data != nil {
+			serviceHealthCache.Lock()
+			serviceHealthCache.data, serviceHealthCache.err, serviceHealthCache.at = data, err, time.Now()
+			serviceHealthCache.Unlock()
+		}
+		return data, err
+	})
+	data, _ := v.(map[string][]gameserver.ServiceHealth)
+	return data, err
+}
+
+// collectServiceHealth 逐个集群汇总，失败的集群记录到ClusterErrors，不影响其他集群
+func collectServiceHealth() (map[string][]gameserver.ServiceHealth, error) {
+	known, err := knownServerIDs()
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]gameserver.ServiceHealth)
+	errs := make(ClusterErrors)
+	for _, cluster := range config.Clusters() {
+		if cluster.Err != nil {
+			errs[cluster.Name] = cluster.Err
+			continue
+		}
+		// 单个集群的结果完整后才合并，失败时不留下部分服务
+		clusterData := make(map[string][]gameserver.ServiceHealth)
+		if err := collectClusterServiceHealth(cluster.Consul(), known, clusterData); err != nil {
+			errs[cluster.Name] = err
+			continue
+		}
+		for serverID, services := range clusterData {
+			data[serverID] = append(data[serverID], services...)
+		}
+	}
+	for _, services := range data {
+		sort.Slice(services, func(i, j int) bool { return services[i].ServiceID < services[j].ServiceID })
+	}
+	if len(errs) > 0 {
+		return data, errs
+	}
+	return data, nil
+}
+
+// collectClusterServiceHealth 汇总单个集群consul中的服务
+func collectClusterServiceHealth(cli *consulapi.Client, known map[string]bool, data map[string][]gameserver.ServiceHealth) error {
+	names, _, err := cli.Catalog().Services(nil)
+	if err != nil {
+		return err
+	}
+	for name := range names {
+		if name == "consul" {
+			continue
+		}
+		entries, _, err := cli.Health().Service(name, "", false, nil)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			svc := entry.Service
+			serverID := ServiceServerID(svc.ID, svc.Service, svc.Tags, svc.Meta, known)
+			if serverID == "" {
+				continue
+			}
+			address := svc.Address
+			if address == "" && entry.Node != nil {
+				address = entry.Node.Address
+			}
+			sh := gameserver.ServiceHealth{
+				ServiceID: svc.ID,
+				Service:   svc.Service,
+				Address:   address,
+				Port:      svc.Port,
+				Tags:      svc.Tags,
+				Status:    entry.Checks.AggregatedStatus(),
+				Checks:    make([]gameserver.HealthCheck, 0, len(entry.Checks)),
+			}
+			if entry.Node != nil {
+				sh.Node = entry.Node.Node
+			}
+			for _, check := range entry.Checks {
+				sh.Checks = append(sh.Checks, gameserver.HealthCheck{CheckID: check.CheckID, Name: check.Name, Status: check.Status, Output: check.Output})
+			}
+			data[serverID] = append(data[serverID], sh)
+		}
+	}
+	return nil
+}
+
+// GameServerHealth 游戏服的汇总健康状态
+func GameServerHealth(services []gameserver.ServiceHealth) string {
+	statuses := make([]string, 0, len(services))
+	for _, s := range services {
+		statuses = append(statuses, s.Status)
+	}
+	return WorstHealth(statuses...)
+}
+
+// StartServiceHealthWatcher 监听每个集群consul中critical状态的检查，游戏服的检查变为critical或恢复时发送通知
+func StartServiceHealthWatcher() {
+	if os.Getenv("HEALTH_WATCH_ENABLED") != "true" {
+		return
+	}
+	for _, cluster := range config.Clusters() {
+		if cluster.Err != nil {
+			continue
+		}
+		go watchServiceHealth(cluster)
+	}
+	slog.Info("service health watcher started")
+}
+
+// watchServiceHealth 对单个集群执行blocking query
+func watchServiceHealth(cluster *config.Cluster) {
+	var waitIndex uint64
+	var critical map[string]string // node/check_id -> server_id
+	for {
+		checks, meta, err := cluster.Consul().Health().State(consulapi.HealthCritical, &consulapi.QueryOptions{
+			WaitIndex: waitIndex,
+			WaitTime:  5 * time.Minute,
+		})
+		if err != nil {
+			slog.Error("failed to watch consul health state", "cluster", cluster.Name, "error", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		// consul重启等情况index会变小，需要重置
+		if meta.LastIndex < waitIndex {
+			waitIndex = 0
+			continue
+		}
+		waitIndex = meta.LastIndex
+
+		known, err := knownServerIDs()
+		if err != nil {
+			slog.Error("failed to query game servers", "error", err)
+			continue
+		}
+		serverOf := make(map[string]string)
+		// 部分集群失败时仍使用其他集群的结果
+		if services, _ := CollectServiceHealth(); services != nil {
+			for serverID, list := range services {
+				for _, s := range list {
+					serverOf[s.ServiceID] = serverID
+				}
+			}
+		}
+		current := make(map[string]string)
+		outputs := make(map[string]string)
+		for _, check := range checks {
+			serverID := serverOf[check.ServiceID]
+			if serverID == "" {
+				serverID = ServiceServerID(check.ServiceID, check.ServiceName, check.ServiceTags, nil, known)
+			}
+			if serverID == "" {
+				continue
+			}
+			key := check.Node + "/" + check.CheckID
+			current[key] = serverID
+			outputs[serverID] = fmt.Sprintf("%s: %s", check.Name, strings.TrimSpace(check.Output))
+		}
+		if critical != nil {
+			notifyHealthChange(critical, current, outputs)
+		}
+		critical = current
+	}
+}
+
+// notifyHealthChange 对比前后两次critical检查，新变为critical的游戏服作为失败，全部恢复的游戏服作为成功
+func notifyHealthChange(before, after map[string]string, outputs map[string]string) {
+	stillCritical := make(map[string]bool)
+	for _, serverID := range after {
+		stillCritical[serverID] = true
+	}
+	var failed, recovered []string
+	for key, serverID := range after {
+		if _, ok := before[key]; !ok && !slices.Contains(failed, serverID) {
+			failed = append(failed, serverID)
+			slog.Warn("game server health check critical", "server_id", serverID, "check", key, "output", outputs[serverID])
+		}
+	}
+	for _, serverID := range before {
+		if !stillCritical[serverID] && !slices.Contains(recovered, serverID) {
+			recovered = append(recovered, serverID)
+		}
+	}
+	if len(failed) == 0 && len(recovered) == 0 {
+		return
+	}
+	sort.Strings(failed)
+	sort.Strings(recovered)
+	ntfy.PublishNotification(notify.EventTypeServiceHealth, "game server health check", recovered, failed, len(recovered), len(failed))
+}
@@ -0,0 +1,36 @@
+package pkg_test
+
+import (
+	"errors"
+	"saurfang/internal/models/gameserver"
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServiceServerID 测试consul服务与游戏服的匹配顺序
+func TestServiceServerID(t *testing.T) {
+	known := map[string]bool{"s1": true, "s2": true, "s3": true, "s4": true}
+	assert.Equal(t, "s1", pkg.ServiceServerID("game-a", "game", nil, map[string]string{"server_id": "s1"}, known))
+	assert.Equal(t, "s2", pkg.ServiceServerID("game-b", "game", []string{"zone=1", "server_id=s2"}, nil, known))
+	assert.Equal(t, "s3", pkg.ServiceServerID("s3", "game", nil, nil, known))
+	assert.Equal(t, "s4", pkg.ServiceServerID("s4-1", "s4", nil, nil, known))
+	// 不存在的游戏服
+	assert.Equal(t, "", pkg.ServiceServerID("game-c", "game", []string{"server_id=s9"}, map[string]string{"server_id": "s9"}, known))
+}
+
+// TestWorstHealth 测试健康状态汇总
+func TestWorstHealth(t *testing.T) {
+	assert.Equal(t, gameserver.HealthUnknown, pkg.WorstHealth())
+	assert.Equal(t, gameserver.HealthPassing, pkg.WorstHealth("passing", "passing"))
+	assert.Equal(t, gameserver.HealthWarning, pkg.WorstHealth("passing", "warning"))
+	assert.Equal(t, gameserver.HealthCritical, pkg.WorstHealth("warning", "critical", "passing"))
+	assert.Equal(t, gameserver.HealthCritical, pkg.WorstHealth("maintenance"))
+}
+
+// TestClusterErrors 测试部分集群失败的错误按集群名称排序输出
+func TestClusterErrors(t *testing.T) {
+	err := pkg.ClusterErrors{"prod": errors.New("timeout"), "dev": errors.New("connection refused")}
+	assert.Equal(t, "cluster dev: connection refused; cluster prod: timeout", err.Error())
+}
@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	nomadapi "github.com/hashicorp/nomad/api"
+)
+
+// AllowedSignals 允许发送给游戏进程的信号，仅用于热加载，不包含会结束进程的信号
+var AllowedSignals = []string{"SIGHUP", "SIGUSR1", "SIGUSR2"}
+
+// NormalizeSignal 统一信号名称格式，hup/HUP/SIGHUP都转为SIGHUP，不在允许列表时返回错误
+func NormalizeSignal(signal string) (string, error) {
+	sig := strings.ToUpper(strings.TrimSpace(signal))
+	if sig != "" && !strings.HasPrefix(sig, "SIG") {
+		sig = "SIG" + sig
+	}
+	if !slices.Contains(AllowedSignals, sig) {
+		return "", fmt.Errorf("signal %q is not allowed, allowed: %s", signal, strings.Join(AllowedSignals, ","))
+	}
+	return sig, nil
+}
+
+// SignalJobAllocations 向job所有运行中的分配发送信号，taskName为空时发送给分配中的所有task
+// 返回成功的分配ID，部分失败时同时返回错误
+func SignalJobAllocations(client *nomadapi.Client, jobID, taskName, signal string) ([]string, error) {
+	stubs, _, err := client.Jobs().Allocations(jobID, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list allocations of job %s: %v", jobID, err)
+	}
+	var signaled []string
+	var errs []string
+	for _, stub := range stubs {
+		if stub.ClientStatus != nomadapi.AllocClientStatusRunning {
+			continue
+		}
+		if taskName != "" {
+			if _, ok := stub.TaskStates[taskName]; !ok {
+				errs = append(errs, fmt.Sprintf("alloc %s has no task %s", stub.ID, taskName))
+				continue
+			}
+		}
+		if err := client.Allocations().Signal(&nomadapi.Allocation{ID: stub.ID}, nil, taskName, signal); err != nil {
+			errs = append(errs, fmt.Sprintf("alloc %s: %v", stub.ID, err))
+			continue
+		}
+		signaled = append(signaled, stub.ID)
+	}
+	if len(errs) > 0 {
+		return signaled, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	if len(signaled) == 0 {
+		return nil, fmt.Errorf("job %s has no running allocations", jobID)
+	}
+	return signaled, nil
+}
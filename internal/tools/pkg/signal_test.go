@@ -0,0 +1,25 @@
+package pkg_test
+
+import (
+	"saurfang/internal/tools/pkg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNormalizeSignal 测试信号名称格式化和允许列表
+func TestNormalizeSignal(t *testing.T) {
+	for _, in := range []string{"hup", "HUP", "SIGHUP", " sighup "} {
+		sig, err := pkg.NormalizeSignal(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, "SIGHUP", sig)
+	}
+	sig, err := pkg.NormalizeSignal("usr1")
+	assert.NoError(t, err)
+	assert.Equal(t, "SIGUSR1", sig)
+
+	for _, in := range []string{"", "SIGKILL", "term"} {
+		_, err := pkg.NormalizeSignal(in)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"saurfang/internal/models/serverconfig"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// ExtractJobPorts 提取游戏服配置中使用的端口
+// 配置为json格式(serverconfig.GameConfigs)时取Configs.Port，否则取nomad job中port块的static端口
+func ExtractJobPorts(src string) ([]int, error) {
+	var ports []int
+	var gc serverconfig.GameConfigs
+	if err := json.Unmarshal([]byte(src), &gc); err == nil {
+		for _, c := range gc.Configs {
+			if c.Port > 0 {
+				ports = append(ports, c.Port)
+			}
+		}
+		return uniquePorts(ports), nil
+	}
+	f, diags := hclwrite.ParseConfig([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse HCL: %v", diags)
+	}
+	if err := collectStaticPorts(f.Body(), &ports); err != nil {
+		return nil, err
+	}
+	return uniquePorts(ports), nil
+}
+
+// collectStaticPorts 递归查找port块中的static属性
+func collectStaticPorts(body *hclwrite.Body, ports *[]int) error {
+	for _, block := range body.Blocks() {
+		if block.Type() == "port" {
+			if attr := block.Body().GetAttribute("static"); attr != nil {
+				value := strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes()))
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("invalid static port %q", value)
+				}
+				*ports = append(*ports, port)
+			}
+		}
+		if err := collectStaticPorts(block.Body(), ports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uniquePorts(ports []int) []int {
+	slices.Sort(ports)
+	return slices.Compact(ports)
+}
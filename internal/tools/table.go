@@ -0,0 +1,282 @@
+package tools
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 表格导入导出支持的格式
+const (
+	TableFormatCSV  = "csv"
+	TableFormatXLSX = "xlsx"
+)
+
+// ReadTable 读取csv/xlsx表格，第一行为表头，返回表头、数据行和数据行在文件中的行号
+func ReadTable(format string, data []byte) ([]string, [][]string, []int, error) {
+	var rows [][]string
+	var lines []int
+	var err error
+	switch format {
+	case TableFormatCSV:
+		rows, lines, err = readCSV(data)
+	case TableFormatXLSX:
+		rows, lines, err = readXLSX(data)
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported table format: %s", format)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, nil, errors.New("table is empty")
+	}
+	header := make([]string, len(rows[0]))
+	for i, h := range rows[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+	return header, rows[1:], lines[1:], nil
+}
+
+// readCSV 读取csv，同时返回每行在文件中的起始行号，单元格内有换行时行号与行序号不同
+func readCSV(data []byte) ([][]string, []int, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	var rows [][]string
+	var lines []int
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, lines, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := r.FieldPos(0)
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+}
+
+// WriteTable 写出csv/xlsx表格
+func WriteTable(format string, w io.Writer, header []string, rows [][]string) error {
+	switch format {
+	case TableFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case TableFormatXLSX:
+		return writeXLSX(w, append([][]string{header}, rows...))
+	default:
+		return fmt.Errorf("unsupported table format: %s", format)
+	}
+}
+
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"is"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Index int        `xml:"r,attr"`
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+// readXLSX 读取xlsx第一个工作表，只处理字符串和数字，同时返回每行在工作表中的行号
+func readXLSX(data []byte) ([][]string, []int, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid xlsx file: %v", err)
+	}
+	files := make(map[string]*zip.File)
+	var sheets []string
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if path.Dir(f.Name) == "xl/worksheets" && strings.HasSuffix(f.Name, ".xml") {
+			sheets = append(sheets, f.Name)
+		}
+	}
+	if len(sheets) == 0 {
+		return nil, nil, errors.New("invalid xlsx file: no worksheet")
+	}
+	sort.Strings(sheets)
+	sheetFile := sheets[0]
+	if _, ok := files["xl/worksheets/sheet1.xml"]; ok {
+		sheetFile = "xl/worksheets/sheet1.xml"
+	}
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst xlsxSharedStrings
+		if err := decodeZipXML(f, &sst); err != nil {
+			return nil, nil, err
+		}
+		for _, si := range sst.Items {
+			text := si.Text
+			for _, r := range si.Runs {
+				text += r.Text
+			}
+			shared = append(shared, text)
+		}
+	}
+	var sheet xlsxSheet
+	if err := decodeZipXML(files[sheetFile], &sheet); err != nil {
+		return nil, nil, err
+	}
+	rows := make([][]string, 0, len(sheet.Rows))
+	lines := make([]int, 0, len(sheet.Rows))
+	for _, r := range sheet.Rows {
+		// 没有行号属性时按上一行推算
+		line := r.Index
+		if line == 0 {
+			line = 1
+			if len(lines) > 0 {
+				line = lines[len(lines)-1] + 1
+			}
+		}
+		lines = append(lines, line)
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if c.Ref != "" {
+				col = xlsxColumnIndex(c.Ref)
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err != nil || idx >= len(shared) {
+					return nil, nil, fmt.Errorf("invalid shared string index at %s", c.Ref)
+				}
+				row[col] = shared[idx]
+			case "inlineStr":
+				text := c.Inline.Text
+				for _, run := range c.Inline.Runs {
+					text += run.Text
+				}
+				row[col] = text
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, lines, nil
+}
+
+func decodeZipXML(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// xlsxColumnIndex 单元格引用转换为列序号 "AB12" -> 27
+func xlsxColumnIndex(ref string) int {
+	idx := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		idx = idx*26 + int(ch-'A'+1)
+	}
+	return idx - 1
+}
+
+// xlsxColumnName 列序号转换为列名 27 -> "AB"
+func xlsxColumnName(idx int) string {
+	name := ""
+	for idx++; idx > 0; idx = (idx - 1) / 26 {
+		name = string(rune('A'+(idx-1)%26)) + name
+	}
+	return name
+}
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+)
+
+// writeXLSX 写出只有一个工作表的xlsx，单元格全部使用inline string
+func writeXLSX(w io.Writer, rows [][]string) error {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return err
+		}
+	}
+	var sheet bytes.Buffer
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			fmt.Fprintf(&sheet, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">`, xlsxColumnName(j), i+1)
+			if err := xml.EscapeText(&sheet, []byte(cell)); err != nil {
+				return err
+			}
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(sheet.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
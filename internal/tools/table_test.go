@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableRoundTrip(t *testing.T) {
+	header := []string{"Name", "server_id", "setting"}
+	rows := [][]string{
+		{"一服", "10001", "job \"game-10001\" {\n  datacenters = [\"dc1\"]\n}"},
+		{"二服 <&>", "10002", ""},
+	}
+	for _, format := range []string{TableFormatCSV, TableFormatXLSX} {
+		var buf bytes.Buffer
+		assert.NoError(t, WriteTable(format, &buf, header, rows), format)
+		gotHeader, gotRows, gotLines, err := ReadTable(format, buf.Bytes())
+		assert.NoError(t, err, format)
+		assert.Equal(t, []string{"name", "server_id", "setting"}, gotHeader, format)
+		assert.Equal(t, rows[0], gotRows[0], format)
+		assert.Equal(t, rows[1][:2], gotRows[1][:2], format)
+		// csv中第一行的配置跨了3行
+		if format == TableFormatCSV {
+			assert.Equal(t, []int{2, 5}, gotLines, format)
+		} else {
+			assert.Equal(t, []int{2, 3}, gotLines, format)
+		}
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	assert.Equal(t, "A", xlsxColumnName(0))
+	assert.Equal(t, "AB", xlsxColumnName(27))
+	assert.Equal(t, 27, xlsxColumnIndex("AB12"))
+	assert.Equal(t, 0, xlsxColumnIndex("A1"))
+}
+
+func TestReadTableUnsupported(t *testing.T) {
+	_, _, _, err := ReadTable("ods", nil)
+	assert.Error(t, err)
+}
@@ -14,15 +14,24 @@ import (
 	"saurfang/internal/config"
 	"saurfang/internal/handler/taskhandler"
 	"saurfang/internal/middleware"
+	"saurfang/internal/models/approval"
+	"saurfang/internal/models/autodeploy"
+	"saurfang/internal/models/autoscale"
 	"saurfang/internal/models/autosync"
 	"saurfang/internal/models/credential"
+	"saurfang/internal/models/crossserver"
 	"saurfang/internal/models/dashboard"
 	"saurfang/internal/models/datasource"
+	"saurfang/internal/models/environment"
+	"saurfang/internal/models/freeze"
 	"saurfang/internal/models/gamechannel"
 	"saurfang/internal/models/gamegroup"
 	"saurfang/internal/models/gamehost"
 	"saurfang/internal/models/gameserver"
+	"saurfang/internal/models/gmcommand"
 	"saurfang/internal/models/notify"
+	"saurfang/internal/models/operation"
+	"saurfang/internal/models/serverlist"
 	"saurfang/internal/models/task"
 	"saurfang/internal/models/upload"
 	"saurfang/internal/models/user"
@@ -227,6 +236,7 @@ func initializeBasicConfig() {
 	config.InitNtfy()
 	config.InitConsul()
 	config.InitNomad()
+	config.InitClusters()
 }
 
 // startServer 启动服务器
@@ -335,6 +345,12 @@ func registerRoutes(app *fiber.App) {
 		tools.InitPermissionsItems(&modinfo)
 		fmt.Println("路由组:", namespace, "别名:", comment)
 	}
+	// 冻结期间强制变更的权限
+	tools.InitPermissionsItems(&tools.PermissionData{Name: freeze.OverridePermission, Group: "变更冻结"})
+	// 审批策略未指定审批人时的审批权限
+	tools.InitPermissionsItems(&tools.PermissionData{Name: approval.ApprovePermission, Group: "变更审批"})
+	// 强制释放操作锁的权限
+	tools.InitPermissionsItems(&tools.PermissionData{Name: gameserver.ForceReleaseLockPermission, Group: "操作锁"})
 }
 
 // initializeServices 初始化服务
@@ -343,10 +359,27 @@ func initializeServices() {
 	pkg.WarmUpCache()
 	// 加载消息通知配置到缓存
 	pkg.WarmUpNotifyCache()
+	// 执行实例已退出的运维操作标记为中断，并定时更新本实例运行中操作的心跳
+	pkg.InterruptStaleOperations()
+	go pkg.StartOperationHeartbeat()
 	// 启动计划任务管理器
 	go pkg.TaskManagerSetup()
 	// 启动通知订阅监听器
 	go ntfy.StartNotifySubscriber()
+	// 启动consul配置变更监听
+	pkg.StartConfigWatcher()
+	// 启动跨服分组定时发布
+	go pkg.StartCrossGroupScheduler()
+	// 启动客户端服务器列表发布
+	go pkg.StartServerListPublisher()
+	// 监听游戏服健康检查
+	go pkg.StartServiceHealthWatcher()
+	// 订阅nomad事件流
+	pkg.StartNomadEventStream()
+	// 启动任务组自动伸缩
+	pkg.StartAutoscaler()
+	// 过期无人审批的变更
+	pkg.StartApprovalExpirer()
 }
 
 // startWebServer 启动Web服务器
@@ -378,6 +411,7 @@ func startAsynqServer() *asynq.Server {
 	mux := asynq.NewServeMux()
 	mux.HandleFunc("custom_task", taskhandler.CustonCronjobHandler)
 	mux.HandleFunc("server_op", pkg.ServerOperationHandler)
+	mux.HandleFunc("gm_command", pkg.GMCommandCronHandler)
 
 	go func() {
 		if err := synqSrv.Run(mux); err != nil {
@@ -410,10 +444,21 @@ func runDatabaseMigration() {
 	if err := config.DB.AutoMigrate(
 		&credential.UserCredential{}, &upload.UploadRecord{}, &user.User{}, &user.Role{},
 		&gamehost.Hosts{}, &gamechannel.Channels{}, &gamegroup.Groups{}, &gameserver.Games{},
-		&gameserver.GameHosts{}, &datasource.Datasources{}, &task.CronJobs{}, &task.GameDeploymentTask{},
+		&gameserver.GameHosts{}, &datasource.Datasources{}, &task.CronJobs{}, &task.GameDeploymentTask{}, &task.DeploymentRun{}, &task.DeploymentRunResult{},
 		&dashboard.TaskDashboards{}, &dashboard.LoginRecords{}, &dashboard.ResourceStatistics{},
 		&autosync.AutoSync{}, &user.InviteCodes{}, &task.CustomTask{}, &task.CustomTaskExecution{},
-		notify.NotifySubscribe{}, notify.NotifyConfig{},
+		notify.NotifySubscribe{}, notify.NotifyConfig{}, &autodeploy.AutoDeployRecord{},
+		&gameserver.ServerIDRule{}, &gamehost.PortReservation{},
+		&crossserver.CrossSeason{}, &crossserver.CrossGroup{}, &crossserver.CrossGroupAudit{},
+		&serverlist.ServerListSetting{}, &serverlist.ServerListFlag{}, &serverlist.ServerListVersion{},
+		&gmcommand.GMEndpoint{}, &gmcommand.GMTemplate{}, &gmcommand.GMExecution{}, &gmcommand.GMExecutionResult{},
+		&environment.Environment{}, &environment.Promotion{},
+		&autoscale.ScalingPolicy{}, &autoscale.ScalingEvent{},
+		&operation.Operation{}, &operation.OperationLog{}, &operation.OperationResult{},
+		&gameserver.GameOperation{}, &gameserver.GameStatusChange{},
+		&freeze.FreezeWindow{}, &freeze.FreezeOverride{},
+		&approval.Policy{}, &approval.Change{},
+		&upload.Package{}, &upload.PackageTarget{},
 	); err != nil {
 		log.Fatalln("AutoMigrate failed:", err)
 	}